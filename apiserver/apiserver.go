@@ -7,31 +7,51 @@ import (
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/config"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/relayservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/userservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/workerservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attachmentstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attemptstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/campaignstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/fencestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/templatestorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/userstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/campaignhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/taskhandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/templatehandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/userhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/cron"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/faults"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/jwtutils"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/lock"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/middleware"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/passutils"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/postgres"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
 	redisclient "github.com/yigithankarabulut/distributed-mail-queue-service/pkg/redis"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/response"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/validator"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/releaseinfo"
 	"log/slog"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
@@ -41,67 +61,195 @@ import (
 func (s *apiServer) initializeStorages() {
 	s.instances.userStorage = userstorage.New(userstorage.WithUserDB(postgres.DB))
 	s.instances.taskStorage = taskstorage.New(taskstorage.WithTaskDB(postgres.DB))
+	s.instances.outboxStorage = outboxstorage.New(outboxstorage.WithOutboxDB(postgres.DB))
+	s.instances.attemptStorage = attemptstorage.New(attemptstorage.WithAttemptDB(postgres.DB))
+	s.instances.fenceStorage = fencestorage.New(fencestorage.WithFenceDB(postgres.DB))
+	s.instances.attachmentStorage = attachmentstorage.New(attachmentstorage.WithAttachmentDB(postgres.DB))
+	s.instances.templateStorage = templatestorage.New(templatestorage.WithTemplateDB(postgres.DB))
+	s.instances.campaignStorage = campaignstorage.New(campaignstorage.WithCampaignDB(postgres.DB))
+	// The pods share the attachments through the volume mounted at the directory.
+	s.instances.blobStore = blobstore.New(blobstore.WithDir(s.config.Attachments.Dir))
 	s.instances.taskQueue = taskqueue.New(
+		taskqueue.WithBackend(s.config.Queue.Backend),
 		taskqueue.WithTaskChannel(s.taskChannel),
 		taskqueue.WithConsumerCount(constant.QueueConsumerCount),
 		taskqueue.WithQueueName(constant.RedisMailQueueChannel),
+		taskqueue.WithGroupName(constant.RedisMailQueueGroup),
+		taskqueue.WithUserConcurrency(s.config.Queue.UserConcurrency),
 		taskqueue.WithRedisClient(redisclient.GetRedisClient()),
+		taskqueue.WithDB(postgres.DB),
 	)
 }
 
 // initializeServices initializes the services with the given storages and packages.
 func (s *apiServer) initializeServices() {
-	s.instances.cronService = cron.NewCronService()
+	var cronOpts []cron.Option
+	// Without redis every replica runs the cron jobs, the jobs of the postgres
+	// backend only run idempotent updates.
+	if s.config.Queue.Backend != constant.QueueBackendPostgres {
+		cronOpts = append(cronOpts, cron.WithLocker(lock.New(lock.WithRedisClient(redisclient.GetRedisClient()))))
+		// The circuits are shared by the pods through redis, without redis
+		// mails are sent without a breaker.
+		s.instances.breaker = breaker.New(
+			breaker.WithRedisClient(redisclient.GetRedisClient()),
+			breaker.WithThreshold(constant.BreakerThreshold),
+			breaker.WithCooldown(constant.BreakerCooldown),
+			breaker.WithProbeTimeout(constant.BreakerProbeTimeout),
+		)
+	} else {
+		log.Warn("the postgres backend has no redis: SMTP endpoints have no circuit breaker, mails are not rate limited and every replica runs the cron jobs")
+	}
+	s.instances.cronService = cron.NewCronService(cronOpts...)
 	s.instances.cronService.Start()
 	s.instances.userService = userservice.New(
 		userservice.WithUserStorage(s.instances.userStorage),
 		userservice.WithTaskStorage(s.instances.taskStorage),
 		userservice.WithPackages(s.instances.packages),
 		userservice.WithMailService(mailservice.New()),
+		userservice.WithBreaker(s.instances.breaker),
+		userservice.WithProviderPolicy(s.providerPolicy()),
+	)
+	s.instances.attachmentService = attachmentservice.New(
+		attachmentservice.WithAttachmentStorage(s.instances.attachmentStorage),
+		attachmentservice.WithBlobStore(s.instances.blobStore),
+		attachmentservice.WithMaxSize(s.config.Attachments.MaxSize),
+		attachmentservice.WithUserQuota(s.config.Attachments.UserQuota),
+		attachmentservice.WithRetention(s.config.Attachments.Retention),
+	)
+	s.instances.templateService = templateservice.New(
+		templateservice.WithTemplateStorage(s.instances.templateStorage),
 	)
 	s.instances.taskService = taskservice.New(
 		taskservice.WithTaskStorage(s.instances.taskStorage),
 		taskservice.WithUserStorage(s.instances.userStorage),
+		taskservice.WithOutboxStorage(s.instances.outboxStorage),
+		taskservice.WithAttemptStorage(s.instances.attemptStorage),
+		taskservice.WithFenceStorage(s.instances.fenceStorage),
 		taskservice.WithRedisClient(s.instances.taskQueue),
+		taskservice.WithAttachmentService(s.instances.attachmentService),
+		taskservice.WithTemplateService(s.instances.templateService),
+	)
+	s.instances.campaignService = campaignservice.New(
+		campaignservice.WithCampaignStorage(s.instances.campaignStorage),
+		campaignservice.WithTaskStorage(s.instances.taskStorage),
+		campaignservice.WithOutboxStorage(s.instances.outboxStorage),
+		campaignservice.WithTemplateService(s.instances.templateService),
+	)
+	s.instances.relay = relayservice.New(
+		relayservice.WithOutboxStorage(s.instances.outboxStorage),
+		relayservice.WithTaskStorage(s.instances.taskStorage),
+		relayservice.WithTaskQueue(s.instances.taskQueue),
+		relayservice.WithDoneChannel(s.done),
 	)
 	handleUnprocessedJob := cron.CronJob{
-		Name:     "FindUnprocessedTasksAndEnqueue",
+		Name:     constant.CronJobReconcile,
 		Schedule: "@every 5m",
-		Func:     s.instances.taskService.FindUnprocessedTasksAndEnqueue,
+		Fenced:   s.instances.taskService.FindUnprocessedTasksAndEnqueue,
 	}
-	if err := s.instances.cronService.RegisterJob(handleUnprocessedJob); err != nil {
-		s.logger.Error("error registering cron job", "error", err)
+	promoteScheduledJob := cron.CronJob{
+		Name:     constant.CronJobPromote,
+		Schedule: "@every 1s",
+		Fenced:   s.instances.taskService.PromoteScheduledTasks,
+		// A dead replica should not hold up due tasks for long.
+		LockAtMost: 5 * time.Second,
+	}
+	dispatchCampaignsJob := cron.CronJob{
+		Name:     "DispatchCampaigns",
+		Schedule: "@every 1s",
+		Func:     s.instances.campaignService.DispatchCampaigns,
+		// The campaigns are locked while they are dispatched, the lock of the
+		// job only keeps the replicas from racing for them.
+		LockAtMost: 5 * time.Second,
+	}
+	purgeOutboxJob := cron.CronJob{
+		Name:     "PurgeDeliveredOutbox",
+		Schedule: "@every 1h",
+		Func:     s.instances.relay.PurgeDelivered,
+	}
+	cleanupAttachmentsJob := cron.CronJob{
+		Name:     "CleanupAttachments",
+		Schedule: "@every 10m",
+		Func:     s.instances.attachmentService.CleanupAttachments,
+	}
+	jobs := []cron.CronJob{promoteScheduledJob, dispatchCampaignsJob, purgeOutboxJob, cleanupAttachmentsJob}
+	// The postgres backend queues the rows themselves, there is nothing to reconcile.
+	if s.config.Queue.Backend != constant.QueueBackendPostgres {
+		jobs = append(jobs, handleUnprocessedJob)
+	}
+	for _, job := range jobs {
+		if err := s.instances.cronService.RegisterJob(job); err != nil {
+			s.logger.Error("error registering cron job", "job", job.Name, "error", err)
+		}
 	}
 }
 
 // initializeWorkers initializes the queue consumers and workers. It also triggers the workers.
 func (s *apiServer) initializeWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopConsumers = cancel
+	s.consumersDone = make(chan struct{})
+	errCh := s.instances.taskQueue.StartConsume(ctx)
 	go func() {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		errCh := s.instances.taskQueue.StartConsume(ctx)
-		for {
-			select {
-			case <-s.done:
-				log.Info("task queue consumers done")
-				return
-			case err := <-errCh:
+		defer close(s.consumersDone)
+		for err := range errCh {
+			// Consumers return the error of the context once they are stopped.
+			if ctx.Err() == nil {
 				log.Errorf("task queue consumer error: %v", err)
 			}
 		}
+		log.Info("task queue consumers done")
+	}()
+	go func() {
+		if err := s.instances.relay.TriggerRelay(); err != nil {
+			log.Info(err)
+		}
 	}()
+	// Without redis the limits can not be shared by the pods, so mails are not limited.
+	var limiter ratelimit.Limiter
+	if s.config.Queue.Backend != constant.QueueBackendPostgres {
+		limiter = ratelimit.New(
+			ratelimit.WithRedisClient(redisclient.GetRedisClient()),
+			ratelimit.WithHostLimit(s.config.RateLimit.Rate, s.config.RateLimit.Burst, s.config.RateLimit.MaxConns),
+			ratelimit.WithUserLimit(s.config.RateLimit.UserRate, s.config.RateLimit.UserBurst),
+			ratelimit.WithDomainLimit(s.config.RateLimit.DomainRate, s.config.RateLimit.DomainBurst),
+			ratelimit.WithHostRules(hostRules(s.config.RateLimit.Hosts)),
+			ratelimit.WithDomainRules(domainRules(s.config.RateLimit.Domains)),
+			ratelimit.WithPermitTTL(constant.RateLimitPermitTTL),
+		)
+	}
+	// The sessions of the pool hold the connection slots of the hosts.
+	s.instances.smtpPool = smtppool.New(
+		smtppool.WithMaxConns(constant.SmtpPoolMaxConns),
+		smtppool.WithIdleTimeout(constant.SmtpPoolIdleTimeout),
+		smtppool.WithWaitTimeout(constant.SmtpPoolWaitTimeout),
+		smtppool.WithLimiter(limiter),
+	)
+	injector := s.faultInjector()
 	for i := 0; i < constant.WorkerCount; i++ {
 		s.instances.workers[i] = workerservice.New(
 			workerservice.WithID(i+1),
 			workerservice.WithTaskStorage(s.instances.taskStorage),
+			workerservice.WithUserStorage(s.instances.userStorage),
+			workerservice.WithAttemptStorage(s.instances.attemptStorage),
+			workerservice.WithAttachmentStorage(s.instances.attachmentStorage),
+			workerservice.WithTemplateService(s.instances.templateService),
 			workerservice.WithTaskQueue(s.instances.taskQueue),
+			workerservice.WithLimiter(limiter),
+			workerservice.WithBreaker(s.instances.breaker),
 			workerservice.WithChannel(s.taskChannel),
 			workerservice.WithDoneChannel(s.done),
-			workerservice.WithMailService(mailservice.New()),
+			workerservice.WithMailService(mailservice.New(
+				mailservice.WithPool(s.instances.smtpPool),
+				mailservice.WithFaults(injector),
+				mailservice.WithBlobStore(s.instances.blobStore),
+				mailservice.WithProviderPolicy(s.providerPolicy()),
+			)),
 		)
 	}
 	for _, worker := range s.instances.workers {
+		s.workerGroup.Add(1)
 		go func(w workerservice.IWorker) {
+			defer s.workerGroup.Done()
 			if err := w.TriggerWorker(); err != nil {
 				log.Errorf("error triggering worker: %v", err)
 			}
@@ -109,6 +257,31 @@ func (s *apiServer) initializeWorkers() {
 	}
 }
 
+// faultInjector returns the injector of the configured faults, or nil when
+// fault injection is disabled.
+func (s *apiServer) faultInjector() faults.Injector {
+	cfg := s.config.Faults
+	if !cfg.Enabled {
+		return nil
+	}
+	opts := []faults.Option{
+		faults.WithRates(faults.Rates{
+			Connection: cfg.ConnectionRate,
+			Transient:  cfg.TransientRate,
+			Permanent:  cfg.PermanentRate,
+			Timeout:    cfg.TimeoutRate,
+			Slow:       cfg.SlowRate,
+		}),
+		faults.WithDelay(cfg.Delay),
+		faults.WithUsers(cfg.Users...),
+	}
+	if cfg.Recipients != "" {
+		opts = append(opts, faults.WithRecipients(regexp.MustCompile(cfg.Recipients)))
+	}
+	s.logger.Warn("fault injection is enabled", "faults", cfg)
+	return faults.New(opts...)
+}
+
 // initializeHandlers initializes the handlers with the given base http handler and adds the routes to the fiber app.
 func (s *apiServer) initializeHandlers() {
 	baseHttpHandler := basehttphandler.New(
@@ -125,8 +298,17 @@ func (s *apiServer) initializeHandlers() {
 		taskhandler.WithBaseHttpHandler(baseHttpHandler),
 		taskhandler.WithTaskService(s.instances.taskService),
 		taskhandler.WithUserService(s.instances.userService),
+		taskhandler.WithAttachmentService(s.instances.attachmentService),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithBaseHttpHandler(baseHttpHandler),
+		templatehandler.WithTemplateService(s.instances.templateService),
+	)
+	campaignHandler := campaignhandler.New(
+		campaignhandler.WithBaseHttpHandler(baseHttpHandler),
+		campaignhandler.WithCampaignService(s.instances.campaignService),
 	)
-	s.handlers = append(s.handlers, userHandler, taskHandler)
+	s.handlers = append(s.handlers, userHandler, taskHandler, templateHandler, campaignHandler)
 	for _, handler := range s.handlers {
 		handler.AddRoutes(s.app)
 	}
@@ -177,6 +359,7 @@ func (s *apiServer) initializeApp() {
 		ReadTimeout:  constant.ServerReadTimeout,
 		WriteTimeout: constant.ServerWriteTimeout,
 		IdleTimeout:  constant.ServerIdleTimeout,
+		BodyLimit:    constant.ServerBodyLimit,
 	})
 	corsConfig.AllowOrigins = constant.AllowedOrigins
 	corsConfig.AllowCredentials = false
@@ -213,6 +396,9 @@ func (s *apiServer) connectStorages() error {
 	if _, err := postgres.ConnectPQ(s.config.Database); err != nil {
 		return fmt.Errorf("error connecting to postgres: %w", err)
 	}
+	if s.config.Queue.Backend == constant.QueueBackendPostgres {
+		return nil
+	}
 	if _, err := redisclient.New(s.config.Redis); err != nil {
 		return fmt.Errorf("error connecting to redis: %w", err)
 	}
@@ -249,28 +435,92 @@ func (s *apiServer) listenAndServe() error {
 			apiErr <- err
 		}
 	}()
-	closeChan := func() {
-		close(s.done)
-		close(shutdown)
-		close(apiErr)
-		close(s.taskChannel)
-	}
+	defer signal.Stop(shutdown)
 
 	select {
 	case err := <-apiErr:
-		closeChan()
+		s.drain()
 		return fmt.Errorf("error listening api server: %w", err)
 	case <-shutdown:
 		s.logger.Info("starting shutdown", "pid", os.Getpid())
-		s.done <- struct{}{}
 		ctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
 		defer cancel()
-		if err := s.app.ShutdownWithContext(ctx); err != nil {
+		err := s.app.ShutdownWithContext(ctx)
+		s.drain()
+		if err != nil {
 			return fmt.Errorf("error shutting down server: %w", err)
 		}
-		closeChan()
-		time.Sleep(constant.ShutdownTimeout)
 		s.logger.Info("shutdown complete", "pid", os.Getpid())
 	}
 	return nil
 }
+
+// drain stops the pipeline of the pod in order, so no task is lost or left in
+// an unknown status when the pod is stopped, for example on a scale-down:
+//  1. the consumers stop taking tasks from the queue,
+//  2. the relay and the workers are told to stop, workers finish the task they
+//     are sending and do not take another one,
+//  3. the tasks buffered for the workers are returned to the queue,
+//  4. the workers get up to the drain timeout to persist the status of their
+//     tasks,
+//  5. the tasks that are still leased, whose workers missed the deadline, are
+//     returned to the queue and the leases of the pod are released.
+func (s *apiServer) drain() {
+	s.stopConsumers()
+	<-s.consumersDone
+	s.instances.cronService.Stop()
+	close(s.done)
+	ctx := context.Background()
+	returned := 0
+	close(s.taskChannel)
+	for task := range s.taskChannel {
+		if err := s.instances.taskQueue.Nack(ctx, task); err != nil {
+			s.logger.Error("error returning buffered task", "task", task.ID, "error", err)
+			continue
+		}
+		returned++
+	}
+	s.logger.Info("buffered tasks returned to the queue", "tasks", returned)
+	finished := make(chan struct{})
+	go func() {
+		s.workerGroup.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		s.logger.Info("workers finished their tasks")
+	case <-time.After(s.config.Shutdown.DrainTimeout):
+		s.logger.Warn("workers did not finish their tasks before the drain timeout", "timeout", s.config.Shutdown.DrainTimeout)
+	}
+	if err := s.instances.taskQueue.Close(ctx); err != nil {
+		s.logger.Error("error releasing task leases", "error", err)
+	}
+	s.instances.smtpPool.Close()
+}
+
+// providerPolicy returns the providers the operator allows the users to send with.
+func (s *apiServer) providerPolicy() mailservice.ProviderPolicy {
+	return mailservice.ProviderPolicy{
+		DevProviders: s.config.Providers.DevEnabled,
+		FileDir:      s.config.Providers.FileDir,
+		HTTPHosts:    s.config.Providers.HTTPHosts,
+	}
+}
+
+// hostRules converts the configured limits of SMTP hosts to the rules of the rate limiter.
+func hostRules(hosts map[string]config.HostLimit) map[string]ratelimit.HostLimit {
+	rules := make(map[string]ratelimit.HostLimit, len(hosts))
+	for host, limit := range hosts {
+		rules[host] = ratelimit.HostLimit{Rate: limit.Rate, Burst: limit.Burst, MaxConns: limit.MaxConns}
+	}
+	return rules
+}
+
+// domainRules converts the configured limits of recipient domains to the rules of the rate limiter.
+func domainRules(domains map[string]config.DomainLimit) map[string]ratelimit.DomainLimit {
+	rules := make(map[string]ratelimit.DomainLimit, len(domains))
+	for domain, limit := range domains {
+		rules[domain] = ratelimit.DomainLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	return rules
+}
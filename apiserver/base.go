@@ -1,21 +1,38 @@
 package apiserver
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v2"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/config"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/relayservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/userservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/workerservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attachmentstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attemptstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/campaignstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/fencestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/templatestorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/userstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/campaignhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/taskhandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/templatehandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/userhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/cron"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
 	"log/slog"
+	"sync"
 )
 
 type HttpEndpoints interface {
@@ -27,17 +44,32 @@ type ApiServer interface {
 }
 
 type Instances struct {
-	packages        *pkg.Packages
-	taskQueue       taskqueue.TaskQueue
-	userStorage     userstorage.UserStorer
-	taskStorage     taskstorage.TaskStorer
-	cronService     *cron.CronService
-	userService     userservice.UserService
-	taskService     taskservice.TaskService
-	workers         []workerservice.IWorker
-	basehttphandler *basehttphandler.BaseHttpHandler
-	userHandler     userhandler.UserHandler
-	taskHandler     taskhandler.TaskHandler
+	packages          *pkg.Packages
+	taskQueue         taskqueue.TaskQueue
+	userStorage       userstorage.UserStorer
+	taskStorage       taskstorage.TaskStorer
+	outboxStorage     outboxstorage.OutboxStorer
+	fenceStorage      fencestorage.FenceStorer
+	attemptStorage    attemptstorage.AttemptStorer
+	attachmentStorage attachmentstorage.AttachmentStorer
+	templateStorage   templatestorage.TemplateStorer
+	campaignStorage   campaignstorage.CampaignStorer
+	blobStore         blobstore.Store
+	cronService       *cron.CronService
+	userService       userservice.UserService
+	taskService       taskservice.TaskService
+	attachmentService attachmentservice.AttachmentService
+	templateService   templateservice.TemplateService
+	campaignService   campaignservice.CampaignService
+	relay             relayservice.IRelay
+	smtpPool          smtppool.Pool
+	breaker           breaker.Breaker
+	workers           []workerservice.IWorker
+	basehttphandler   *basehttphandler.BaseHttpHandler
+	userHandler       userhandler.UserHandler
+	taskHandler       taskhandler.TaskHandler
+	templateHandler   templatehandler.TemplateHandler
+	campaignHandler   campaignhandler.CampaignHandler
 }
 
 type apiServer struct {
@@ -50,6 +82,11 @@ type apiServer struct {
 	instances   *Instances
 	done        chan struct{}
 	taskChannel chan model.MailTaskQueue
+	// stopConsumers stops the queue consumers, consumersDone is closed once
+	// they stopped. workerGroup waits for the workers.
+	stopConsumers context.CancelFunc
+	consumersDone chan struct{}
+	workerGroup   sync.WaitGroup
 }
 
 type Option func(*apiServer)
@@ -2,15 +2,26 @@ package config
 
 import (
 	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config struct stores the configuration of the application
 type Config struct {
-	Database Database `mapstructure:"database"`
-	Redis    Redis    `mapstructure:"redis"`
-	Port     string   `mapstructure:"port"`
+	Database    Database    `mapstructure:"database"`
+	Redis       Redis       `mapstructure:"redis"`
+	Queue       Queue       `mapstructure:"queue"`
+	RateLimit   RateLimit   `mapstructure:"rate_limit"`
+	Shutdown    Shutdown    `mapstructure:"shutdown"`
+	Faults      Faults      `mapstructure:"faults"`
+	Attachments Attachments `mapstructure:"attachments"`
+	Providers   Providers   `mapstructure:"providers"`
+	Port        string      `mapstructure:"port"`
 }
 
 // Database struct stores the configuration of the database
@@ -28,6 +39,86 @@ type Redis struct {
 	Port string `mapstructure:"port"`
 }
 
+// Queue struct stores the configuration of the task queue
+type Queue struct {
+	Backend         string `mapstructure:"backend"`
+	UserConcurrency int    `mapstructure:"user_concurrency"`
+}
+
+// Shutdown struct stores how long the workers of a stopping pod may take to
+// finish the mails they are sending.
+type Shutdown struct {
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// Attachments struct stores where the attachments of mails are kept and their
+// limits in bytes. Uploads that are not enqueued with a task are deleted after
+// the retention.
+type Attachments struct {
+	Dir       string        `mapstructure:"dir"`
+	MaxSize   int64         `mapstructure:"max_size"`
+	UserQuota int64         `mapstructure:"user_quota"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// Providers struct stores the providers the users can send with. The file and
+// log providers are only allowed when DevEnabled is set, the directories of
+// the file provider are relative to FileDir. The http provider posts to the
+// HTTPHosts, or to any public address when there are none.
+type Providers struct {
+	DevEnabled bool     `mapstructure:"dev_enabled"`
+	FileDir    string   `mapstructure:"file_dir"`
+	HTTPHosts  []string `mapstructure:"http_hosts"`
+}
+
+// Faults struct stores the faults injected into the sends of mails for chaos
+// drills. Rates are the chances of a send to fail with each fault, faults are
+// only injected into the mails of Users and to Recipients when they are set.
+type Faults struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	ConnectionRate float64       `mapstructure:"connection_rate"`
+	TransientRate  float64       `mapstructure:"transient_rate"`
+	PermanentRate  float64       `mapstructure:"permanent_rate"`
+	TimeoutRate    float64       `mapstructure:"timeout_rate"`
+	SlowRate       float64       `mapstructure:"slow_rate"`
+	Delay          time.Duration `mapstructure:"delay"`
+	Users          []uint        `mapstructure:"users"`
+	Recipients     string        `mapstructure:"recipients"`
+}
+
+// RateLimit struct stores the limits of the mails sent to every SMTP host, by
+// every user and to every recipient domain, a rate of 0 is unlimited.
+type RateLimit struct {
+	Rate        float64                `mapstructure:"rate"`
+	Burst       int                    `mapstructure:"burst"`
+	MaxConns    int                    `mapstructure:"max_conns"`
+	UserRate    float64                `mapstructure:"user_rate"`
+	UserBurst   int                    `mapstructure:"user_burst"`
+	DomainRate  float64                `mapstructure:"domain_rate"`
+	DomainBurst int                    `mapstructure:"domain_burst"`
+	Domains     map[string]DomainLimit `mapstructure:"domains"`
+	Hosts       map[string]HostLimit   `mapstructure:"hosts"`
+}
+
+// Enabled reports whether any limit is set.
+func (r RateLimit) Enabled() bool {
+	return r.Rate > 0 || r.MaxConns > 0 || r.UserRate > 0 || r.DomainRate > 0 ||
+		len(r.Domains) > 0 || len(r.Hosts) > 0
+}
+
+// HostLimit struct stores the limit of a single SMTP host.
+type HostLimit struct {
+	Rate     float64 `mapstructure:"rate"`
+	Burst    int     `mapstructure:"burst"`
+	MaxConns int     `mapstructure:"max_conns"`
+}
+
+// DomainLimit struct stores the limit of a single recipient domain.
+type DomainLimit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
 func LoadDatabase() (Database, error) {
 	var db Database
 	db.Name = os.Getenv("DB_NAME")
@@ -56,13 +147,278 @@ func LoadRedis() (Redis, error) {
 	return redis, nil
 }
 
+func LoadQueue() (Queue, error) {
+	var queue Queue
+	queue.Backend = os.Getenv("QUEUE_BACKEND")
+	switch queue.Backend {
+	case "":
+		queue.Backend = constant.QueueBackendList
+	case constant.QueueBackendList, constant.QueueBackendStream, constant.QueueBackendPostgres:
+	default:
+		return queue, errors.New("QUEUE_BACKEND must be one of list, stream, postgres")
+	}
+	queue.UserConcurrency = constant.QueueUserConcurrency
+	if n := os.Getenv("QUEUE_USER_CONCURRENCY"); n != "" {
+		concurrency, err := strconv.Atoi(n)
+		if err != nil || concurrency < 0 {
+			return queue, errors.New("QUEUE_USER_CONCURRENCY must be a non-negative number")
+		}
+		queue.UserConcurrency = concurrency
+	}
+	// Only the list backend keeps a sub-queue and an in-flight count per user.
+	if queue.UserConcurrency > 0 && queue.Backend != constant.QueueBackendList {
+		return queue, errors.New("QUEUE_USER_CONCURRENCY is only supported by the list backend")
+	}
+	return queue, nil
+}
+
+func LoadRateLimit() (RateLimit, error) {
+	var limit RateLimit
+	for env, rate := range map[string]*float64{"SMTP_RATE_LIMIT": &limit.Rate, "SMTP_USER_RATE_LIMIT": &limit.UserRate, "SMTP_DOMAIN_RATE_LIMIT": &limit.DomainRate} {
+		if n := os.Getenv(env); n != "" {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil || value < 0 {
+				return limit, errors.New(env + " must be a non-negative number")
+			}
+			*rate = value
+		}
+	}
+	for env, count := range map[string]*int{"SMTP_BURST": &limit.Burst, "SMTP_MAX_CONNS": &limit.MaxConns, "SMTP_USER_BURST": &limit.UserBurst, "SMTP_DOMAIN_BURST": &limit.DomainBurst} {
+		if n := os.Getenv(env); n != "" {
+			value, err := strconv.Atoi(n)
+			if err != nil || value < 0 {
+				return limit, errors.New(env + " must be a non-negative number")
+			}
+			*count = value
+		}
+	}
+	domains, err := parseDomainLimits(os.Getenv("SMTP_DOMAIN_LIMITS"))
+	if err != nil {
+		return limit, err
+	}
+	limit.Domains = domains
+	hosts, err := parseHostLimits(os.Getenv("SMTP_HOST_LIMITS"))
+	if err != nil {
+		return limit, err
+	}
+	limit.Hosts = hosts
+	return limit, nil
+}
+
+// parseHostLimits parses the limits of single SMTP hosts, a comma separated
+// list of <host>=<rate>[:<burst>[:<conns>]] rules such as
+// "smtp.gmail.com=20:40:10,smtp.office365.com=10".
+func parseHostLimits(rules string) (map[string]HostLimit, error) {
+	errInvalid := errors.New("SMTP_HOST_LIMITS must be a comma separated list of <host>=<rate>[:<burst>[:<conns>]] rules")
+	hosts := make(map[string]HostLimit)
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		host, value, ok := strings.Cut(rule, "=")
+		if !ok || host == "" {
+			return nil, errInvalid
+		}
+		fields := strings.Split(value, ":")
+		if len(fields) > 3 {
+			return nil, errInvalid
+		}
+		var limit HostLimit
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(fields[0], 64); err != nil || limit.Rate < 0 {
+			return nil, errInvalid
+		}
+		for i, count := range []*int{&limit.Burst, &limit.MaxConns} {
+			if i+1 < len(fields) {
+				if *count, err = strconv.Atoi(fields[i+1]); err != nil || *count < 0 {
+					return nil, errInvalid
+				}
+			}
+		}
+		hosts[strings.ToLower(strings.TrimSpace(host))] = limit
+	}
+	return hosts, nil
+}
+
+// parseDomainLimits parses the limits of single recipient domains, a comma
+// separated list of <domain>=<rate>[:<burst>] rules such as
+// "gmail.com=20:40,outlook.com=10".
+func parseDomainLimits(rules string) (map[string]DomainLimit, error) {
+	errInvalid := errors.New("SMTP_DOMAIN_LIMITS must be a comma separated list of <domain>=<rate>[:<burst>] rules")
+	domains := make(map[string]DomainLimit)
+	for _, rule := range strings.Split(rules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		domain, value, ok := strings.Cut(rule, "=")
+		if !ok || domain == "" {
+			return nil, errInvalid
+		}
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		var limit DomainLimit
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate < 0 {
+			return nil, errInvalid
+		}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 0 {
+				return nil, errInvalid
+			}
+		}
+		domains[strings.ToLower(strings.TrimSpace(domain))] = limit
+	}
+	return domains, nil
+}
+
+func LoadShutdown() (Shutdown, error) {
+	shutdown := Shutdown{DrainTimeout: constant.ShutdownDrainTimeout}
+	if d := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); d != "" {
+		timeout, err := time.ParseDuration(d)
+		if err != nil || timeout < 0 {
+			return shutdown, errors.New("SHUTDOWN_DRAIN_TIMEOUT must be a non-negative duration such as 25s")
+		}
+		shutdown.DrainTimeout = timeout
+	}
+	return shutdown, nil
+}
+
+// LoadAttachments reads where the attachments are stored and their limits, the
+// sizes are in bytes.
+func LoadAttachments() (Attachments, error) {
+	attachments := Attachments{
+		Dir:       "attachments",
+		MaxSize:   constant.AttachmentMaxSize,
+		UserQuota: constant.AttachmentUserQuota,
+		Retention: constant.AttachmentRetention,
+	}
+	if dir := os.Getenv("ATTACHMENT_DIR"); dir != "" {
+		attachments.Dir = dir
+	}
+	for env, size := range map[string]*int64{
+		"ATTACHMENT_MAX_SIZE":   &attachments.MaxSize,
+		"ATTACHMENT_USER_QUOTA": &attachments.UserQuota,
+	} {
+		if n := os.Getenv(env); n != "" {
+			value, err := strconv.ParseInt(n, 10, 64)
+			if err != nil || value <= 0 {
+				return attachments, errors.New(env + " must be a positive number of bytes")
+			}
+			*size = value
+		}
+	}
+	if d := os.Getenv("ATTACHMENT_RETENTION"); d != "" {
+		retention, err := time.ParseDuration(d)
+		if err != nil || retention <= 0 {
+			return attachments, errors.New("ATTACHMENT_RETENTION must be a positive duration such as 24h")
+		}
+		attachments.Retention = retention
+	}
+	return attachments, nil
+}
+
+// LoadProviders reads the provider policy, only the smtp and http providers
+// are allowed unless PROVIDER_DEV_ENABLED is true.
+func LoadProviders() (Providers, error) {
+	providers := Providers{
+		DevEnabled: os.Getenv("PROVIDER_DEV_ENABLED") == "true",
+		FileDir:    os.Getenv("PROVIDER_FILE_DIR"),
+	}
+	if providers.FileDir != "" && !filepath.IsAbs(providers.FileDir) {
+		return providers, errors.New("PROVIDER_FILE_DIR must be an absolute path")
+	}
+	for _, host := range strings.Split(os.Getenv("PROVIDER_HTTP_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			providers.HTTPHosts = append(providers.HTTPHosts, strings.ToLower(host))
+		}
+	}
+	return providers, nil
+}
+
+// LoadFaults reads the fault injection, it is disabled unless FAULTS_ENABLED is true.
+func LoadFaults() (Faults, error) {
+	faults := Faults{Enabled: os.Getenv("FAULTS_ENABLED") == "true", Delay: constant.FaultDelay}
+	total := 0.0
+	for env, rate := range map[string]*float64{
+		"FAULT_CONNECTION_RATE": &faults.ConnectionRate,
+		"FAULT_4XX_RATE":        &faults.TransientRate,
+		"FAULT_5XX_RATE":        &faults.PermanentRate,
+		"FAULT_TIMEOUT_RATE":    &faults.TimeoutRate,
+		"FAULT_SLOW_RATE":       &faults.SlowRate,
+	} {
+		if n := os.Getenv(env); n != "" {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil || value < 0 || value > 1 {
+				return faults, errors.New(env + " must be a number between 0 and 1")
+			}
+			*rate = value
+			total += value
+		}
+	}
+	if total > 1 {
+		return faults, errors.New("the FAULT_*_RATE rates must not add up to more than 1")
+	}
+	if d := os.Getenv("FAULT_DELAY"); d != "" {
+		delay, err := time.ParseDuration(d)
+		if err != nil || delay < 0 {
+			return faults, errors.New("FAULT_DELAY must be a non-negative duration such as 5s")
+		}
+		faults.Delay = delay
+	}
+	for _, id := range strings.Split(os.Getenv("FAULT_USERS"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		user, err := strconv.ParseUint(id, 10, 0)
+		if err != nil {
+			return faults, errors.New("FAULT_USERS must be a comma separated list of user IDs")
+		}
+		faults.Users = append(faults.Users, uint(user))
+	}
+	faults.Recipients = os.Getenv("FAULT_RECIPIENTS")
+	if _, err := regexp.Compile(faults.Recipients); err != nil {
+		return faults, errors.New("FAULT_RECIPIENTS must be a regular expression")
+	}
+	return faults, nil
+}
+
 func LoadConfig() (*Config, error) {
 	var Config Config
 	db, err := LoadDatabase()
 	if err != nil {
 		return nil, err
 	}
-	redis, err := LoadRedis()
+	queue, err := LoadQueue()
+	if err != nil {
+		return nil, err
+	}
+	// The postgres backend does not need redis.
+	var redis Redis
+	if queue.Backend != constant.QueueBackendPostgres {
+		if redis, err = LoadRedis(); err != nil {
+			return nil, err
+		}
+	}
+	rateLimit, err := LoadRateLimit()
+	if err != nil {
+		return nil, err
+	}
+	// The limits are shared by the pods through redis.
+	if queue.Backend == constant.QueueBackendPostgres && rateLimit.Enabled() {
+		return nil, errors.New("SMTP rate limits need redis and are not supported by the postgres backend")
+	}
+	shutdown, err := LoadShutdown()
+	if err != nil {
+		return nil, err
+	}
+	faults, err := LoadFaults()
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := LoadAttachments()
+	if err != nil {
+		return nil, err
+	}
+	providers, err := LoadProviders()
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +435,12 @@ func LoadConfig() (*Config, error) {
 	}
 	Config.Database = db
 	Config.Redis = redis
+	Config.Queue = queue
+	Config.RateLimit = rateLimit
+	Config.Shutdown = shutdown
+	Config.Faults = faults
+	Config.Attachments = attachments
+	Config.Providers = providers
 	Config.Port = port
 	return &Config, nil
 }
@@ -0,0 +1,56 @@
+package dtoreq
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"io"
+	"time"
+)
+
+// CreateCampaignRequest creates a campaign that sends a template to every row
+// of a recipient list. The fields are read from a multipart form, the list is
+// its file: a CSV with a header or NDJSON, one object per line. The email of a
+// row is its recipient, every column or field is a variable of the template.
+type CreateCampaignRequest struct {
+	UserID          uint   `json:"-" form:"-" query:"-" validate:"required,numeric"`
+	Name            string `json:"name" form:"name" query:"-" validate:"required,max=100"`
+	TemplateID      uint   `json:"template_id" form:"template_id" query:"-" validate:"required,numeric"`
+	TemplateVersion int    `json:"template_version" form:"template_version" query:"-" validate:"omitempty,min=1"`
+	ScheduledAt     string `json:"scheduled_at" form:"scheduled_at" query:"-" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	FromName        string `json:"from_name" form:"from_name" query:"-" validate:"omitempty,max=255"`
+	ReplyTo         string `json:"reply_to" form:"reply_to" query:"-" validate:"omitempty,email"`
+	// Filename and ContentType tell the format of the list, CSV unless the
+	// file is .ndjson or .jsonl or of an NDJSON content type.
+	Filename    string    `json:"-" form:"-" query:"-"`
+	ContentType string    `json:"-" form:"-" query:"-"`
+	Content     io.Reader `json:"-" form:"-" query:"-"`
+}
+
+// ConvertToMailCampaign converts the request to a campaign. ScheduledAt is
+// validated as RFC 3339, an empty value leaves the campaign unscheduled.
+func (r CreateCampaignRequest) ConvertToMailCampaign() model.MailCampaign {
+	scheduledAt, _ := time.Parse(time.RFC3339, r.ScheduledAt)
+	return model.MailCampaign{
+		UserID:          r.UserID,
+		Name:            r.Name,
+		TemplateID:      r.TemplateID,
+		TemplateVersion: r.TemplateVersion,
+		ScheduledAt:     scheduledAt,
+		FromName:        r.FromName,
+		ReplyTo:         r.ReplyTo,
+	}
+}
+
+type GetCampaignRequest struct {
+	UserID     uint `json:"-" query:"-" validate:"required,numeric"`
+	CampaignID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+type GetCampaignsRequest struct {
+	UserID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+// CampaignActionRequest pauses, resumes or cancels a campaign.
+type CampaignActionRequest struct {
+	UserID     uint `json:"-" query:"-" validate:"required,numeric"`
+	CampaignID uint `json:"-" query:"-" validate:"required,numeric"`
+}
@@ -1,13 +1,75 @@
 package dtoreq
 
-import "github.com/yigithankarabulut/distributed-mail-queue-service/model"
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"io"
+	"strings"
+	"time"
+)
 
 type TaskEnqueueRequest struct {
-	RecipientEmail string `json:"recipient_email" query:"-" validate:"required,email"`
-	Subject        string `json:"subject" query:"-" validate:"required"`
-	Body           string `json:"body" query:"-" validate:"required"`
-	ScheduledAt    string `json:"scheduled_at" query:"-" validate:"omitempty"`
-	UserID         uint   `json:"-" query:"-" validate:"required,numeric"`
+	// RecipientEmail is a To recipient, kept for the clients that do not send
+	// To. A task needs one of them.
+	RecipientEmail string   `json:"recipient_email" query:"-" validate:"required_without=To,omitempty,email"`
+	To             []string `json:"to" query:"-" validate:"omitempty,max=50,dive,email"`
+	CC             []string `json:"cc" query:"-" validate:"omitempty,max=50,dive,email"`
+	BCC            []string `json:"bcc" query:"-" validate:"omitempty,max=50,dive,email"`
+	ReplyTo        string   `json:"reply_to" query:"-" validate:"omitempty,email"`
+	FromName       string   `json:"from_name" query:"-" validate:"omitempty,max=255"`
+	Subject        string   `json:"subject" query:"-" validate:"required_without=TemplateID,excluded_with=TemplateID"`
+	// Body is the text body of the mail, kept for the clients that do not
+	// send TextBody. One of the bodies is required, a mail with only an HTML
+	// body gets a text body generated from it.
+	Body        string `json:"body" query:"-" validate:"required_without_all=TextBody HTMLBody TemplateID,excluded_with=TextBody TemplateID,max=1048576"`
+	TextBody    string `json:"text_body" query:"-" validate:"omitempty,excluded_with=TemplateID,max=1048576"`
+	HTMLBody    string `json:"html_body" query:"-" validate:"omitempty,excluded_with=TemplateID,html,max=1048576"`
+	ScheduledAt string `json:"scheduled_at" query:"-" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Priority    int    `json:"priority" query:"-" validate:"omitempty,oneof=0 1 2"`
+	UserID      uint   `json:"-" query:"-" validate:"required,numeric"`
+	// Attachments are sent inline in base64, AttachmentIDs reference the
+	// attachments uploaded before the task.
+	Attachments   []AttachmentRequest `json:"attachments" query:"-" validate:"omitempty,max=10,dive"`
+	AttachmentIDs []uint              `json:"attachment_ids" query:"-" validate:"omitempty,max=10,unique,dive,required"`
+	// TemplateID is a template the subject and bodies are rendered from with
+	// Variables instead, at TemplateVersion or its latest version.
+	TemplateID      uint                   `json:"template_id" query:"-" validate:"omitempty,numeric"`
+	TemplateVersion int                    `json:"template_version" query:"-" validate:"excluded_without=TemplateID,omitempty,min=1"`
+	Variables       map[string]interface{} `json:"variables" query:"-" validate:"excluded_without=TemplateID"`
+	RetryPolicy
+}
+
+// AttachmentRequest is an attachment sent inline with a task. The content type
+// is detected from the filename or the content when it is omitted.
+type AttachmentRequest struct {
+	Filename    string `json:"filename" query:"-" validate:"required,max=255"`
+	ContentType string `json:"content_type" query:"-" validate:"omitempty,max=255"`
+	Content     string `json:"content" query:"-" validate:"required,base64"`
+}
+
+// UploadAttachmentRequest is a file uploaded before the task it is attached to.
+// It is read from the multipart form, the body is not bound to it.
+type UploadAttachmentRequest struct {
+	UserID      uint
+	Filename    string
+	ContentType string
+	Size        int64
+	Content     io.Reader
+}
+
+// RetryPolicy overrides the retry policy of the service, omitted values inherit
+// it. Delays are in seconds.
+type RetryPolicy struct {
+	MaxAttempts    int `json:"max_attempts" query:"-" validate:"omitempty,min=1"`
+	RetryBaseDelay int `json:"retry_base_delay" query:"-" validate:"omitempty,min=1"`
+	RetryMaxDelay  int `json:"retry_max_delay" query:"-" validate:"omitempty,min=1"`
+}
+
+func (r RetryPolicy) ConvertToRetryPolicy() model.RetryPolicy {
+	return model.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		RetryBaseDelay: r.RetryBaseDelay,
+		RetryMaxDelay:  r.RetryMaxDelay,
+	}
 }
 
 type GetAllQueuedTasksRequest struct {
@@ -18,11 +80,86 @@ type GetAllFailedTasksRequest struct {
 	UserID uint `json:"-" query:"-" validate:"required,numeric"`
 }
 
+type GetDeadLettersRequest struct {
+	UserID uint `json:"-" query:"-" validate:"required,numeric"`
+	Offset int  `json:"-" query:"offset" validate:"omitempty,min=0"`
+	Limit  int  `json:"-" query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+type GetDeadLetterRequest struct {
+	UserID uint `json:"-" query:"-" validate:"required,numeric"`
+	TaskID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+type GetTaskAttemptsRequest struct {
+	UserID uint `json:"-" query:"-" validate:"required,numeric"`
+	TaskID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+// ReplayDeadLettersRequest selects the dead-letter entries to replay, no task IDs select every entry.
+type ReplayDeadLettersRequest struct {
+	UserID  uint   `json:"-" query:"-" validate:"required,numeric"`
+	TaskIDs []uint `json:"task_ids" query:"-" validate:"omitempty,dive,required"`
+}
+
+// PurgeDeadLettersRequest selects the dead-letter entries to purge, All selects
+// every entry and is only used without task IDs.
+type PurgeDeadLettersRequest struct {
+	UserID  uint   `json:"-" query:"-" validate:"required,numeric"`
+	TaskIDs []uint `json:"task_ids" query:"-" validate:"omitempty,dive,required"`
+	All     bool   `json:"all" query:"-"`
+}
+
+// ConvertToMailTaskQueue converts the request to a task. ScheduledAt is validated
+// as RFC 3339, an empty value leaves the task unscheduled.
 func (r TaskEnqueueRequest) ConvertToMailTaskQueue() model.MailTaskQueue {
+	scheduledAt, _ := time.Parse(time.RFC3339, r.ScheduledAt)
+	recipients := r.recipients()
+	var recipientEmail string
+	if len(recipients) > 0 {
+		recipientEmail = recipients[0].Email
+	}
 	return model.MailTaskQueue{
-		RecipientEmail: r.RecipientEmail,
+		RecipientEmail: recipientEmail,
+		Recipients:     recipients,
+		FromName:       r.FromName,
+		ReplyTo:        r.ReplyTo,
 		Subject:        r.Subject,
 		Body:           r.Body,
+		TextBody:       r.TextBody,
+		HTMLBody:       r.HTMLBody,
 		UserID:         r.UserID,
+		ScheduledAt:    scheduledAt,
+		Priority:       r.Priority,
+		RetryPolicy:    r.RetryPolicy.ConvertToRetryPolicy(),
+		// The version is resolved by the task service when it is omitted.
+		TemplateID:        r.TemplateID,
+		TemplateVersion:   r.TemplateVersion,
+		TemplateVariables: r.Variables,
+	}
+}
+
+// recipients returns the To, CC and BCC recipients of the request in this
+// order, RecipientEmail first. An address is only sent to once, in the first
+// list it is in.
+func (r TaskEnqueueRequest) recipients() []model.Recipient {
+	var (
+		recipients []model.Recipient
+		seen       = make(map[string]bool)
+	)
+	add := func(kind string, emails ...string) {
+		for _, email := range emails {
+			key := strings.ToLower(email)
+			if email == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			recipients = append(recipients, model.Recipient{Email: email, Kind: kind})
+		}
 	}
+	add(model.RecipientTo, r.RecipientEmail)
+	add(model.RecipientTo, r.To...)
+	add(model.RecipientCC, r.CC...)
+	add(model.RecipientBCC, r.BCC...)
+	return recipients
 }
@@ -0,0 +1,72 @@
+package dtoreq
+
+import "github.com/yigithankarabulut/distributed-mail-queue-service/model"
+
+// CreateTemplateRequest creates a template, or a partial that other templates
+// include by its name. A template needs a subject and one of the bodies.
+type CreateTemplateRequest struct {
+	UserID   uint   `json:"-" query:"-" validate:"required,numeric"`
+	Name     string `json:"name" query:"-" validate:"required,max=100"`
+	Partial  bool   `json:"partial" query:"-"`
+	Layout   string `json:"layout" query:"-" validate:"omitempty,max=100"`
+	Subject  string `json:"subject" query:"-" validate:"required_if=Partial false,max=998"`
+	HTMLBody string `json:"html_body" query:"-" validate:"max=1048576"`
+	TextBody string `json:"text_body" query:"-" validate:"required_without=HTMLBody,max=1048576"`
+}
+
+func (r CreateTemplateRequest) ConvertToMailTemplate() model.MailTemplate {
+	return model.MailTemplate{
+		UserID:   r.UserID,
+		Name:     r.Name,
+		Partial:  r.Partial,
+		Layout:   r.Layout,
+		Subject:  r.Subject,
+		HTMLBody: r.HTMLBody,
+		TextBody: r.TextBody,
+	}
+}
+
+// UpdateTemplateRequest replaces the content of a template with a new version,
+// the name of a template and whether it is a partial can not be changed.
+type UpdateTemplateRequest struct {
+	UserID     uint   `json:"-" query:"-" validate:"required,numeric"`
+	TemplateID uint   `json:"-" query:"-" validate:"required,numeric"`
+	Layout     string `json:"layout" query:"-" validate:"omitempty,max=100"`
+	Subject    string `json:"subject" query:"-" validate:"max=998"`
+	HTMLBody   string `json:"html_body" query:"-" validate:"max=1048576"`
+	TextBody   string `json:"text_body" query:"-" validate:"required_without=HTMLBody,max=1048576"`
+}
+
+func (r UpdateTemplateRequest) ConvertToMailTemplate() model.MailTemplate {
+	template := model.MailTemplate{
+		UserID:   r.UserID,
+		Layout:   r.Layout,
+		Subject:  r.Subject,
+		HTMLBody: r.HTMLBody,
+		TextBody: r.TextBody,
+	}
+	template.ID = r.TemplateID
+	return template
+}
+
+// GetTemplateRequest returns a template at its latest version, or at the
+// given version.
+type GetTemplateRequest struct {
+	UserID     uint `json:"-" query:"-" validate:"required,numeric"`
+	TemplateID uint `json:"-" query:"-" validate:"required,numeric"`
+	Version    int  `json:"-" query:"version" validate:"omitempty,min=1"`
+}
+
+type GetTemplatesRequest struct {
+	UserID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+type GetTemplateVersionsRequest struct {
+	UserID     uint `json:"-" query:"-" validate:"required,numeric"`
+	TemplateID uint `json:"-" query:"-" validate:"required,numeric"`
+}
+
+type DeleteTemplateRequest struct {
+	UserID     uint `json:"-" query:"-" validate:"required,numeric"`
+	TemplateID uint `json:"-" query:"-" validate:"required,numeric"`
+}
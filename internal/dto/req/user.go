@@ -3,12 +3,19 @@ package dtoreq
 import "github.com/yigithankarabulut/distributed-mail-queue-service/model"
 
 type RegisterRequest struct {
-	Email        string `json:"email" query:"-" validate:"required,email"`
-	Password     string `json:"password" query:"-" validate:"required"`
-	SmtpHost     string `json:"smtp_host" query:"-" validate:"required"`
-	SmtpPort     int    `json:"smtp_port" query:"-" validate:"required"`
-	SmtpUsername string `json:"smtp-username" query:"-" validate:"required"`
-	SmtpPassword string `json:"smtp-password" query:"-" validate:"required"`
+	Email    string `json:"email" query:"-" validate:"required,email"`
+	Password string `json:"password" query:"-" validate:"required"`
+	// The smtp settings are required by the smtp provider, the provider when
+	// none is set, the provider settings by the http and file providers.
+	SmtpHost       string `json:"smtp_host" query:"-" validate:"required_if=Provider smtp,required_if=Provider ''"`
+	SmtpPort       int    `json:"smtp_port" query:"-" validate:"required_if=Provider smtp,required_if=Provider ''"`
+	SmtpUsername   string `json:"smtp-username" query:"-" validate:"required_if=Provider smtp,required_if=Provider ''"`
+	SmtpPassword   string `json:"smtp-password" query:"-" validate:"required_if=Provider smtp,required_if=Provider ''"`
+	Provider       string `json:"provider" query:"-" validate:"omitempty,oneof=smtp http file log"`
+	ProviderURL    string `json:"provider_url" query:"-" validate:"required_if=Provider http,omitempty,url"`
+	ProviderAPIKey string `json:"provider_api_key" query:"-"`
+	ProviderDir    string `json:"provider_dir" query:"-" validate:"required_if=Provider file"`
+	RetryPolicy
 }
 
 func (r RegisterRequest) ConvertToUser() model.User {
@@ -19,6 +26,13 @@ func (r RegisterRequest) ConvertToUser() model.User {
 		SmtpPort:     r.SmtpPort,
 		SmtpUsername: r.SmtpUsername,
 		SmtpPassword: r.SmtpPassword,
+		Provider:     r.Provider,
+		ProviderSettings: model.ProviderSettings{
+			URL:    r.ProviderURL,
+			APIKey: r.ProviderAPIKey,
+			Dir:    r.ProviderDir,
+		},
+		RetryPolicy: r.RetryPolicy.ConvertToRetryPolicy(),
 	}
 }
 
@@ -36,6 +50,26 @@ type UpdateUserRequest struct {
 	SmtpPassword string `json:"smtp-password" validate:"required"`
 }
 
+// UpdateSmtpSettingsRequest sets the SMTP settings of the logged in user.
+type UpdateSmtpSettingsRequest struct {
+	UserID       uint   `json:"-" query:"-" validate:"required,numeric"`
+	SmtpHost     string `json:"smtp_host" query:"-" validate:"required"`
+	SmtpPort     int    `json:"smtp_port" query:"-" validate:"required,min=1,max=65535"`
+	SmtpUsername string `json:"smtp-username" query:"-" validate:"required"`
+	SmtpPassword string `json:"smtp-password" query:"-" validate:"required"`
+}
+
+func (r UpdateSmtpSettingsRequest) ConvertToUser() model.User {
+	user := model.User{
+		SmtpHost:     r.SmtpHost,
+		SmtpPort:     r.SmtpPort,
+		SmtpUsername: r.SmtpUsername,
+		SmtpPassword: r.SmtpPassword,
+	}
+	user.ID = r.UserID
+	return user
+}
+
 type GetUserRequest struct {
 	UserID uint `json:"-" query:"-" validate:"required,numeric"`
 }
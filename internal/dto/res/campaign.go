@@ -0,0 +1,69 @@
+package dtores
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"time"
+)
+
+// CampaignResponse is a campaign with the progress of its tasks. Total is the
+// number of recipients and Dispatched the number of them that have a task.
+type CampaignResponse struct {
+	CampaignID      uint             `json:"campaign_id"`
+	Name            string           `json:"name"`
+	TemplateID      uint             `json:"template_id"`
+	TemplateVersion int              `json:"template_version"`
+	Status          int              `json:"status"`
+	ScheduledAt     time.Time        `json:"scheduled_at"`
+	Total           int              `json:"total"`
+	Dispatched      int              `json:"dispatched"`
+	Progress        CampaignProgress `json:"progress"`
+	CreatedAt       time.Time        `json:"created_at"`
+	CompletedAt     time.Time        `json:"completed_at"`
+}
+
+// CampaignProgress is the number of tasks of a campaign by their status.
+// Scheduled tasks wait for a retry and are counted as failed.
+type CampaignProgress struct {
+	Queued     int `json:"queued"`
+	Processing int `json:"processing"`
+	Sent       int `json:"sent"`
+	Failed     int `json:"failed"`
+	Rejected   int `json:"rejected"`
+	Cancelled  int `json:"cancelled"`
+}
+
+type GetCampaignsResponse struct {
+	Campaigns []CampaignResponse `json:"campaigns"`
+}
+
+func (r *GetCampaignsResponse) ToCampaigns(campaigns []model.MailCampaign) {
+	r.Campaigns = make([]CampaignResponse, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		r.Campaigns = append(r.Campaigns, ToCampaign(campaign, nil))
+	}
+}
+
+// ToCampaign returns a campaign with the progress of the given task counts by status.
+func ToCampaign(campaign model.MailCampaign, counts map[int]int) CampaignResponse {
+	return CampaignResponse{
+		CampaignID:      campaign.ID,
+		Name:            campaign.Name,
+		TemplateID:      campaign.TemplateID,
+		TemplateVersion: campaign.TemplateVersion,
+		Status:          campaign.Status,
+		ScheduledAt:     campaign.ScheduledAt,
+		Total:           campaign.Total,
+		Dispatched:      campaign.Dispatched,
+		Progress: CampaignProgress{
+			Queued:     counts[constant.StatusQueued],
+			Processing: counts[constant.StatusProcessing],
+			Sent:       counts[constant.StatusSuccess],
+			Failed:     counts[constant.StatusFailed] + counts[constant.StatusScheduled],
+			Rejected:   counts[constant.StatusRejected],
+			Cancelled:  counts[constant.StatusCancelled],
+		},
+		CreatedAt:   campaign.CreatedAt,
+		CompletedAt: campaign.CompletedAt,
+	}
+}
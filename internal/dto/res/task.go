@@ -1,20 +1,67 @@
 package dtores
 
-import "github.com/yigithankarabulut/distributed-mail-queue-service/model"
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"time"
+)
 
 type BaseTaskResponse struct {
-	TaskID         uint   `json:"task_id"`
-	Status         int    `json:"status"`
-	TryCount       int    `json:"try_count"`
-	RecipientEmail string `json:"recipient_email"`
-	Subject        string `json:"subject"`
-	Body           string `json:"body"`
+	TaskID         uint       `json:"task_id"`
+	Status         int        `json:"status"`
+	TryCount       int        `json:"try_count"`
+	RecipientEmail string     `json:"recipient_email"`
+	Subject        string     `json:"subject"`
+	Body           string     `json:"body"`
+	TextBody       string     `json:"text_body,omitempty"`
+	HTMLBody       string     `json:"html_body,omitempty"`
+	ScheduledAt    *time.Time `json:"scheduled_at,omitempty"`
+	Priority       int        `json:"priority"`
+	LastError      string     `json:"last_error,omitempty"`
+	FailureReason  string     `json:"failure_reason,omitempty"`
+	ProcessingBy   string     `json:"processing_by,omitempty"`
+	ProcessingAt   *time.Time `json:"processing_started_at,omitempty"`
+
+	// Recipients is the delivery outcome per recipient, empty for the tasks
+	// enqueued with only a recipient_email.
+	Recipients []RecipientResponse `json:"recipients,omitempty"`
+	ReplyTo    string              `json:"reply_to,omitempty"`
+	FromName   string              `json:"from_name,omitempty"`
+
+	Template *TaskTemplateResponse `json:"template,omitempty"`
+
+	// CampaignID is the campaign the task was fanned out from.
+	CampaignID uint `json:"campaign_id,omitempty"`
+}
+
+// TaskTemplateResponse is the template version the mail of a task is rendered from.
+type TaskTemplateResponse struct {
+	TemplateID uint `json:"template_id"`
+	Version    int  `json:"version"`
+}
+
+// RecipientResponse is a recipient of a task, Status is the task status the
+// recipient reached and Code the SMTP reply code of its RCPT TO if known.
+type RecipientResponse struct {
+	Email  string `json:"email"`
+	Kind   string `json:"kind"`
+	Status int    `json:"status"`
+	Code   int    `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 type TaskEnqueueResponse struct {
 	TaskID uint `json:"task_id"`
 }
 
+// AttachmentResponse is an uploaded attachment, its ID is referenced from the
+// attachment_ids of the task it is sent with.
+type AttachmentResponse struct {
+	AttachmentID uint   `json:"attachment_id"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+}
+
 type GetAllQueuedTasksResponse struct {
 	Tasks []BaseTaskResponse `json:"tasks"`
 }
@@ -23,28 +70,147 @@ type GetAllFailedTasksResponse struct {
 	Tasks []BaseTaskResponse `json:"tasks"`
 }
 
+type DeadLetterResponse struct {
+	TaskID   uint      `json:"task_id"`
+	Payload  string    `json:"payload"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	DeadAt   time.Time `json:"dead_at"`
+}
+
+type GetDeadLettersResponse struct {
+	DeadLetters []DeadLetterResponse `json:"dead_letters"`
+}
+
+type GetDeadLetterResponse struct {
+	DeadLetter DeadLetterResponse `json:"dead_letter"`
+	Task       BaseTaskResponse   `json:"task"`
+}
+
+type TaskAttemptResponse struct {
+	WorkerID        string    `json:"worker_id"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationMs      int64     `json:"duration_ms"`
+	Transport       string    `json:"transport"`
+	ResponseCode    int       `json:"response_code,omitempty"`
+	ResponseMessage string    `json:"response_message,omitempty"`
+	ErrorClass      string    `json:"error_class,omitempty"`
+}
+
+type GetTaskAttemptsResponse struct {
+	Task     BaseTaskResponse      `json:"task"`
+	Attempts []TaskAttemptResponse `json:"attempts"`
+}
+
+type ReplayDeadLettersResponse struct {
+	Replayed []uint `json:"replayed"`
+	Failed   []uint `json:"failed"`
+}
+
+type PurgeDeadLettersResponse struct {
+	Purged int `json:"purged"`
+}
+
 func (r *GetAllQueuedTasksResponse) ToMailTaskQueue(tasks []model.MailTaskQueue) {
 	for _, task := range tasks {
-		r.Tasks = append(r.Tasks, BaseTaskResponse{
-			TaskID:         task.ID,
-			Status:         task.Status,
-			TryCount:       task.TryCount,
-			RecipientEmail: task.RecipientEmail,
-			Subject:        task.Subject,
-			Body:           task.Body,
-		})
+		r.Tasks = append(r.Tasks, ToBaseTask(task))
 	}
 }
 
 func (r *GetAllFailedTasksResponse) ToMailTaskQueue(tasks []model.MailTaskQueue) {
 	for _, task := range tasks {
-		r.Tasks = append(r.Tasks, BaseTaskResponse{
-			TaskID:         task.ID,
-			Status:         task.Status,
-			TryCount:       task.TryCount,
-			RecipientEmail: task.RecipientEmail,
-			Subject:        task.Subject,
-			Body:           task.Body,
+		r.Tasks = append(r.Tasks, ToBaseTask(task))
+	}
+}
+
+func (r *GetTaskAttemptsResponse) ToTaskAttempts(task model.MailTaskQueue, attempts []model.MailTaskAttempt) {
+	r.Task = ToBaseTask(task)
+	r.Attempts = make([]TaskAttemptResponse, 0, len(attempts))
+	for _, attempt := range attempts {
+		r.Attempts = append(r.Attempts, TaskAttemptResponse{
+			WorkerID:        attempt.WorkerID,
+			StartedAt:       attempt.StartedAt,
+			FinishedAt:      attempt.FinishedAt,
+			DurationMs:      attempt.DurationMs,
+			Transport:       attempt.Transport,
+			ResponseCode:    attempt.ResponseCode,
+			ResponseMessage: attempt.ResponseMessage,
+			ErrorClass:      attempt.ErrorClass,
 		})
 	}
 }
+
+func ToAttachment(attachment model.MailAttachment) AttachmentResponse {
+	return AttachmentResponse{
+		AttachmentID: attachment.ID,
+		Filename:     attachment.Filename,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+	}
+}
+
+func ToBaseTask(task model.MailTaskQueue) BaseTaskResponse {
+	return BaseTaskResponse{
+		TaskID:         task.ID,
+		Status:         task.Status,
+		TryCount:       task.TryCount,
+		RecipientEmail: task.RecipientEmail,
+		Subject:        task.Subject,
+		Body:           task.Body,
+		TextBody:       task.TextBody,
+		HTMLBody:       task.HTMLBody,
+		ScheduledAt:    scheduledAt(task),
+		Priority:       task.Priority,
+		LastError:      task.LastError,
+		FailureReason:  task.FailureReason,
+		ProcessingBy:   task.ProcessingBy,
+		ProcessingAt:   processingAt(task),
+		Recipients:     toRecipients(task.Recipients),
+		ReplyTo:        task.ReplyTo,
+		FromName:       task.FromName,
+		Template:       taskTemplate(task),
+		CampaignID:     task.CampaignID,
+	}
+}
+
+// taskTemplate returns the template of a task, or nil if it is not rendered from a template.
+func taskTemplate(task model.MailTaskQueue) *TaskTemplateResponse {
+	if task.TemplateID == 0 {
+		return nil
+	}
+	return &TaskTemplateResponse{TemplateID: task.TemplateID, Version: task.TemplateVersion}
+}
+
+func toRecipients(recipients []model.Recipient) []RecipientResponse {
+	if len(recipients) == 0 {
+		return nil
+	}
+	res := make([]RecipientResponse, 0, len(recipients))
+	for _, recipient := range recipients {
+		res = append(res, RecipientResponse{
+			Email:  recipient.Email,
+			Kind:   recipient.Kind,
+			Status: recipient.Status,
+			Code:   recipient.Code,
+			Error:  recipient.Error,
+		})
+	}
+	return res
+}
+
+// processingAt returns the start of the last attempt of a task, or nil if it was never sent.
+func processingAt(task model.MailTaskQueue) *time.Time {
+	if task.ProcessingStartedAt.IsZero() {
+		return nil
+	}
+	return &task.ProcessingStartedAt
+}
+
+// scheduledAt returns the send time of a scheduled task, or nil if the task is not scheduled.
+func scheduledAt(task model.MailTaskQueue) *time.Time {
+	if task.ScheduledAt.IsZero() {
+		return nil
+	}
+	return &task.ScheduledAt
+}
@@ -0,0 +1,84 @@
+package dtores
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"time"
+)
+
+type TemplateResponse struct {
+	TemplateID uint      `json:"template_id"`
+	Name       string    `json:"name"`
+	Partial    bool      `json:"partial"`
+	Version    int       `json:"version"`
+	Layout     string    `json:"layout,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	HTMLBody   string    `json:"html_body,omitempty"`
+	TextBody   string    `json:"text_body,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type GetTemplatesResponse struct {
+	Templates []TemplateResponse `json:"templates"`
+}
+
+type TemplateVersionResponse struct {
+	Version   int       `json:"version"`
+	Layout    string    `json:"layout,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+	TextBody  string    `json:"text_body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GetTemplateVersionsResponse struct {
+	TemplateID uint                      `json:"template_id"`
+	Versions   []TemplateVersionResponse `json:"versions"`
+}
+
+func (r *GetTemplatesResponse) ToTemplates(templates []model.MailTemplate) {
+	r.Templates = make([]TemplateResponse, 0, len(templates))
+	for _, template := range templates {
+		r.Templates = append(r.Templates, ToTemplate(template))
+	}
+}
+
+func (r *GetTemplateVersionsResponse) ToTemplateVersions(templateID uint, versions []model.MailTemplateVersion) {
+	r.TemplateID = templateID
+	r.Versions = make([]TemplateVersionResponse, 0, len(versions))
+	for _, version := range versions {
+		r.Versions = append(r.Versions, TemplateVersionResponse{
+			Version:   version.Version,
+			Layout:    version.Layout,
+			Subject:   version.Subject,
+			HTMLBody:  version.HTMLBody,
+			TextBody:  version.TextBody,
+			CreatedAt: version.CreatedAt,
+		})
+	}
+}
+
+func ToTemplate(template model.MailTemplate) TemplateResponse {
+	return TemplateResponse{
+		TemplateID: template.ID,
+		Name:       template.Name,
+		Partial:    template.Partial,
+		Version:    template.Version,
+		Layout:     template.Layout,
+		Subject:    template.Subject,
+		HTMLBody:   template.HTMLBody,
+		TextBody:   template.TextBody,
+		UpdatedAt:  template.UpdatedAt,
+	}
+}
+
+// ToTemplateAt returns a template with the content of one of its versions.
+func ToTemplateAt(template model.MailTemplate, version model.MailTemplateVersion) TemplateResponse {
+	res := ToTemplate(template)
+	res.Version = version.Version
+	res.Layout = version.Layout
+	res.Subject = version.Subject
+	res.HTMLBody = version.HTMLBody
+	res.TextBody = version.TextBody
+	res.UpdatedAt = version.CreatedAt
+	return res
+}
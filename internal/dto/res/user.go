@@ -1,6 +1,10 @@
 package dtores
 
-import "github.com/yigithankarabulut/distributed-mail-queue-service/model"
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
+	"time"
+)
 
 type RegisterUserResponse struct {
 }
@@ -19,6 +23,28 @@ type GetUserResponse struct {
 	SmtpHost     string `json:"smtp_host"`
 	SmtpPort     int    `json:"smtp_port"`
 	SmtpUsername string `json:"smtp-username"`
+	// Provider is the transport the mails of the user are sent with, the api
+	// key of the http provider is not returned.
+	Provider       string `json:"provider"`
+	ProviderURL    string `json:"provider_url,omitempty"`
+	ProviderDir    string `json:"provider_dir,omitempty"`
+	MaxAttempts    int    `json:"max_attempts,omitempty"`
+	RetryBaseDelay int    `json:"retry_base_delay,omitempty"`
+	RetryMaxDelay  int    `json:"retry_max_delay,omitempty"`
+	// SmtpAuthError is set while the SMTP server rejects the settings of the
+	// user, mails are not sent before SmtpPausedUntil.
+	SmtpAuthError   string     `json:"smtp_auth_error,omitempty"`
+	SmtpPausedUntil *time.Time `json:"smtp_paused_until,omitempty"`
+	// SmtpCircuit is the circuit breaker of the SMTP endpoint of the user, its
+	// mails are parked while the circuit is open.
+	SmtpCircuit *SmtpCircuitResponse `json:"smtp_circuit,omitempty"`
+}
+
+type SmtpCircuitResponse struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
 }
 
 func (r *GetUserResponse) FromUser(user model.User) {
@@ -27,4 +53,25 @@ func (r *GetUserResponse) FromUser(user model.User) {
 	r.SmtpHost = user.SmtpHost
 	r.SmtpPort = user.SmtpPort
 	r.SmtpUsername = user.SmtpUsername
+	r.Provider = user.Provider
+	r.ProviderURL = user.ProviderSettings.URL
+	r.ProviderDir = user.ProviderSettings.Dir
+	r.MaxAttempts = user.MaxAttempts
+	r.RetryBaseDelay = user.RetryBaseDelay
+	r.RetryMaxDelay = user.RetryMaxDelay
+	if user.SmtpAuthError != "" {
+		r.SmtpAuthError = user.SmtpAuthError
+		r.SmtpPausedUntil = &user.SmtpPausedUntil
+	}
+}
+
+func (r *GetUserResponse) FromCircuit(status breaker.Status) {
+	r.SmtpCircuit = &SmtpCircuitResponse{
+		State:     status.State,
+		Failures:  status.Failures,
+		LastError: status.LastError,
+	}
+	if !status.OpenUntil.IsZero() {
+		r.SmtpCircuit.OpenUntil = &status.OpenUntil
+	}
 }
@@ -0,0 +1,93 @@
+package attachmentservice
+
+import (
+	"context"
+	"errors"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attachmentstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AttachmentService stores the attachments of mail tasks in the blob store.
+type AttachmentService interface {
+	Upload(ctx context.Context, request dtoreq.UploadAttachmentRequest) (dtores.AttachmentResponse, error)
+	Attach(ctx context.Context, task model.MailTaskQueue, inline []dtoreq.AttachmentRequest, ids []uint, tx *gorm.DB) ([]model.MailAttachment, error)
+	Discard(ctx context.Context, attachments []model.MailAttachment)
+	CleanupAttachments()
+}
+
+var (
+	// ErrAttachmentTooLarge is returned when an attachment or the attachments of a task are over their limit.
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+	// ErrQuotaExceeded is returned when an attachment does not fit in the storage quota of the user.
+	ErrQuotaExceeded = errors.New("attachment storage quota exceeded")
+	// ErrTooManyAttachments is returned when a task has more attachments than allowed.
+	ErrTooManyAttachments = errors.New("too many attachments")
+	// ErrAttachmentNotFound is returned when an attachment does not exist, belongs
+	// to another user or is already attached to a task.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrInvalidAttachment is returned for attachments without a filename or with invalid content.
+	ErrInvalidAttachment = errors.New("invalid attachment")
+)
+
+type attachmentService struct {
+	attachmentStorage attachmentstorage.AttachmentStorer
+	blobs             blobstore.Store
+	maxSize           int64
+	maxTaskSize       int64
+	userQuota         int64
+	retention         time.Duration
+}
+
+type Option func(*attachmentService)
+
+func WithAttachmentStorage(storage attachmentstorage.AttachmentStorer) Option {
+	return func(s *attachmentService) {
+		s.attachmentStorage = storage
+	}
+}
+
+func WithBlobStore(store blobstore.Store) Option {
+	return func(s *attachmentService) {
+		s.blobs = store
+	}
+}
+
+// WithMaxSize sets the size limit of an attachment in bytes.
+func WithMaxSize(size int64) Option {
+	return func(s *attachmentService) {
+		s.maxSize = size
+	}
+}
+
+// WithUserQuota sets the size of the attachments a user can store in bytes.
+func WithUserQuota(quota int64) Option {
+	return func(s *attachmentService) {
+		s.userQuota = quota
+	}
+}
+
+// WithRetention sets how long an upload is kept before it is enqueued with a task.
+func WithRetention(retention time.Duration) Option {
+	return func(s *attachmentService) {
+		s.retention = retention
+	}
+}
+
+func New(opts ...Option) AttachmentService {
+	s := &attachmentService{
+		maxSize:     constant.AttachmentMaxSize,
+		maxTaskSize: constant.AttachmentMaxTaskSize,
+		userQuota:   constant.AttachmentUserQuota,
+		retention:   constant.AttachmentRetention,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
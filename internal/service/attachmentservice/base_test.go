@@ -0,0 +1,69 @@
+package attachmentservice_test
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type mockAttachmentStorer struct {
+	errInsert            error
+	errGetAllByIDs       error
+	errAttachToTask      error
+	errTotalSizeByUserID error
+	errGetAllExpired     error
+	errCommitTx          error
+	attachments          []model.MailAttachment
+	inserted             []model.MailAttachment
+	deleted              []uint
+	locked               []uint
+	attached             int
+	totalSize            int64
+}
+
+func (m *mockAttachmentStorer) Insert(ctx context.Context, attachment model.MailAttachment, tx ...*gorm.DB) (model.MailAttachment, error) {
+	attachment.ID = uint(len(m.inserted) + 1)
+	m.inserted = append(m.inserted, attachment)
+	return attachment, m.errInsert
+}
+
+func (m *mockAttachmentStorer) GetAllByIDs(ctx context.Context, userID uint, ids []uint, tx ...*gorm.DB) ([]model.MailAttachment, error) {
+	return m.attachments, m.errGetAllByIDs
+}
+
+func (m *mockAttachmentStorer) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailAttachment, error) {
+	return m.attachments, nil
+}
+
+func (m *mockAttachmentStorer) AttachToTask(ctx context.Context, taskID uint, ids []uint, tx ...*gorm.DB) (int, error) {
+	return m.attached, m.errAttachToTask
+}
+
+func (m *mockAttachmentStorer) TotalSizeByUserID(ctx context.Context, userID uint, tx ...*gorm.DB) (int64, error) {
+	return m.totalSize, m.errTotalSizeByUserID
+}
+
+func (m *mockAttachmentStorer) LockQuota(ctx context.Context, userID uint, tx *gorm.DB) error {
+	m.locked = append(m.locked, userID)
+	return nil
+}
+
+func (m *mockAttachmentStorer) GetAllExpired(ctx context.Context, unattachedBefore time.Time, limit int) ([]model.MailAttachment, error) {
+	return m.attachments, m.errGetAllExpired
+}
+
+func (m *mockAttachmentStorer) Delete(ctx context.Context, id uint) error {
+	m.deleted = append(m.deleted, id)
+	return nil
+}
+
+func (m *mockAttachmentStorer) CreateTx() *gorm.DB {
+	return nil
+}
+
+func (m *mockAttachmentStorer) CommitTx(tx *gorm.DB) error {
+	return m.errCommitTx
+}
+
+func (m *mockAttachmentStorer) RollbackTx(tx *gorm.DB) {}
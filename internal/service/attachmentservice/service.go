@@ -0,0 +1,266 @@
+package attachmentservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gofiber/fiber/v2/log"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Upload stores an attachment that is not attached to a task yet, it is deleted
+// when it is not enqueued with a task within the retention. The declared size
+// is checked against the quota before the content is stored, the stored size
+// again when the attachment is inserted.
+func (s *attachmentService) Upload(ctx context.Context, request dtoreq.UploadAttachmentRequest) (dtores.AttachmentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.AttachmentResponse{}, ctx.Err()
+	default:
+		if request.Size > s.maxSize {
+			return dtores.AttachmentResponse{}, ErrAttachmentTooLarge
+		}
+		if err := s.checkQuota(ctx, request.UserID, request.Size); err != nil {
+			return dtores.AttachmentResponse{}, err
+		}
+		attachment, err := s.put(ctx, model.MailAttachment{
+			UserID:      request.UserID,
+			Filename:    request.Filename,
+			ContentType: request.ContentType,
+		}, request.Content)
+		if err != nil {
+			return dtores.AttachmentResponse{}, err
+		}
+		tx := s.attachmentStorage.CreateTx()
+		defer s.attachmentStorage.RollbackTx(tx)
+		err = s.checkQuota(ctx, request.UserID, attachment.Size, tx)
+		if err == nil {
+			attachment, err = s.attachmentStorage.Insert(ctx, attachment, tx)
+		}
+		if err == nil {
+			err = s.attachmentStorage.CommitTx(tx)
+		}
+		if err != nil {
+			s.Discard(ctx, []model.MailAttachment{attachment})
+			return dtores.AttachmentResponse{}, err
+		}
+		return dtores.ToAttachment(attachment), nil
+	}
+}
+
+// Attach stores the inline attachments of a task and attaches the uploaded ones
+// to it in the transaction of the task. The limits of the task cover both. It
+// returns the attachments it stored, their blobs must be discarded when the
+// transaction is not committed.
+func (s *attachmentService) Attach(ctx context.Context, task model.MailTaskQueue, inline []dtoreq.AttachmentRequest, ids []uint, tx *gorm.DB) ([]model.MailAttachment, error) {
+	if len(inline)+len(ids) > constant.AttachmentMaxPerTask {
+		return nil, ErrTooManyAttachments
+	}
+	var total int64
+	if len(ids) > 0 {
+		uploaded, err := s.attachmentStorage.GetAllByIDs(ctx, task.UserID, ids, tx)
+		if err != nil {
+			return nil, err
+		}
+		if len(uploaded) != len(ids) {
+			return nil, ErrAttachmentNotFound
+		}
+		for _, attachment := range uploaded {
+			if attachment.TaskID != 0 {
+				return nil, ErrAttachmentNotFound
+			}
+			total += attachment.Size
+		}
+	}
+	contents := make([][]byte, len(inline))
+	var added int64
+	for i, attachment := range inline {
+		content, err := base64.StdEncoding.DecodeString(attachment.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidAttachment, attachment.Filename, err)
+		}
+		if int64(len(content)) > s.maxSize {
+			return nil, ErrAttachmentTooLarge
+		}
+		contents[i] = content
+		added += int64(len(content))
+	}
+	if total+added > s.maxTaskSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	if err := s.checkQuota(ctx, task.UserID, added, tx); err != nil {
+		return nil, err
+	}
+	stored := make([]model.MailAttachment, 0, len(inline))
+	for i, attachment := range inline {
+		a, err := s.store(ctx, model.MailAttachment{
+			UserID:      task.UserID,
+			TaskID:      task.ID,
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+		}, bytes.NewReader(contents[i]), tx)
+		if err != nil {
+			s.Discard(ctx, stored)
+			return nil, err
+		}
+		stored = append(stored, a)
+	}
+	if len(ids) > 0 {
+		// An upload attached by a concurrent task since it was read is not attached again.
+		n, err := s.attachmentStorage.AttachToTask(ctx, task.ID, ids, tx)
+		if err == nil && n != len(ids) {
+			err = ErrAttachmentNotFound
+		}
+		if err != nil {
+			s.Discard(ctx, stored)
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// Discard deletes the blobs of attachments whose rows were rolled back.
+func (s *attachmentService) Discard(ctx context.Context, attachments []model.MailAttachment) {
+	for _, attachment := range attachments {
+		if err := s.blobs.Delete(context.WithoutCancel(ctx), attachment.BlobKey); err != nil {
+			log.Errorf("error deleting blob of attachment %s: %v", attachment.Filename, err)
+		}
+	}
+}
+
+// CleanupAttachments deletes the attachments of the finished tasks and the
+// uploads that were not enqueued with a task within the retention. The blob is
+// deleted before the row, so a failed run leaves the row to be deleted again.
+func (s *attachmentService) CleanupAttachments() {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.TaskCancelTimeout)
+	defer cancel()
+	attachments, err := s.attachmentStorage.GetAllExpired(ctx, time.Now().Add(-s.retention), constant.AttachmentCleanupSize)
+	if err != nil {
+		log.Errorf("error finding expired attachments: %v", err)
+		return
+	}
+	deleted := 0
+	for _, attachment := range attachments {
+		if err := s.blobs.Delete(ctx, attachment.BlobKey); err != nil {
+			log.Errorf("error deleting blob of attachment %d: %v", attachment.ID, err)
+			continue
+		}
+		if err := s.attachmentStorage.Delete(ctx, attachment.ID); err != nil {
+			log.Errorf("error deleting attachment %d: %v", attachment.ID, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Infof("%d expired attachments deleted", deleted)
+	}
+}
+
+// checkQuota returns ErrQuotaExceeded when adding size bytes to the attachments
+// of the user exceeds the quota. In a transaction the quota of the user is
+// locked until the transaction ends, so concurrent attachments of the user can
+// not fit in the same free space.
+func (s *attachmentService) checkQuota(ctx context.Context, userID uint, size int64, tx ...*gorm.DB) error {
+	if size == 0 {
+		return nil
+	}
+	if len(tx) > 0 {
+		if err := s.attachmentStorage.LockQuota(ctx, userID, tx[0]); err != nil {
+			return err
+		}
+	}
+	used, err := s.attachmentStorage.TotalSizeByUserID(ctx, userID, tx...)
+	if err != nil {
+		return err
+	}
+	if used+size > s.userQuota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// store writes the content to the blob store and inserts the attachment, the
+// blob is deleted when the insert fails.
+func (s *attachmentService) store(ctx context.Context, attachment model.MailAttachment, content io.Reader, tx ...*gorm.DB) (model.MailAttachment, error) {
+	attachment, err := s.put(ctx, attachment, content)
+	if err != nil {
+		return attachment, err
+	}
+	stored, err := s.attachmentStorage.Insert(ctx, attachment, tx...)
+	if err != nil {
+		s.Discard(ctx, []model.MailAttachment{attachment})
+		return attachment, err
+	}
+	return stored, nil
+}
+
+// put writes the content to the blob store. The content is read up to the size
+// limit, the blob is deleted when the content is over the limit.
+func (s *attachmentService) put(ctx context.Context, attachment model.MailAttachment, content io.Reader) (model.MailAttachment, error) {
+	attachment.Filename = sanitizeFilename(attachment.Filename)
+	if attachment.Filename == "" || content == nil {
+		return attachment, ErrInvalidAttachment
+	}
+	r := bufio.NewReaderSize(content, 512)
+	attachment.ContentType = contentTypeOf(attachment.Filename, attachment.ContentType, r)
+	attachment.BlobKey = newBlobKey()
+	size, err := s.blobs.Put(ctx, attachment.BlobKey, io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return attachment, err
+	}
+	if size > s.maxSize {
+		s.Discard(ctx, []model.MailAttachment{attachment})
+		return attachment, ErrAttachmentTooLarge
+	}
+	attachment.Size = size
+	return attachment, nil
+}
+
+// sanitizeFilename drops the directories and the control characters of a filename.
+func sanitizeFilename(filename string) string {
+	filename = filename[strings.LastIndexAny(filename, `/\`)+1:]
+	filename = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename))
+	if filename == "." || filename == ".." {
+		return ""
+	}
+	return filename
+}
+
+// contentTypeOf returns the given content type when it is valid, otherwise the
+// type of the extension of the filename or the type detected from the content.
+func contentTypeOf(filename, contentType string, r *bufio.Reader) string {
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/octet-stream" {
+		return mime.FormatMediaType(mediaType, params)
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+		return byExt
+	}
+	head, _ := r.Peek(512)
+	return http.DetectContentType(head)
+}
+
+func newBlobKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
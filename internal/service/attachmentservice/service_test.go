@@ -0,0 +1,300 @@
+package attachmentservice_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"os"
+	"strings"
+	"testing"
+)
+
+// blobs returns the names of the files of a blob store directory.
+func blobs(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func Test_attachmentService_Upload(t *testing.T) {
+	dir := t.TempDir()
+	mockAttachmentStorer := &mockAttachmentStorer{}
+	mockService := attachmentservice.New(
+		attachmentservice.WithAttachmentStorage(mockAttachmentStorer),
+		attachmentservice.WithBlobStore(blobstore.New(blobstore.WithDir(dir))),
+		attachmentservice.WithMaxSize(8),
+		attachmentservice.WithUserQuota(20),
+	)
+	{
+		tc := "Case 1: Context is done and returns context error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := mockService.Upload(ctx, dtoreq.UploadAttachmentRequest{})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: expected %v but got %v", tc, context.Canceled, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Upload over size limit returns too large error"
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "report.csv", Size: 9, Content: strings.NewReader("id,total\n"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrAttachmentTooLarge) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrAttachmentTooLarge, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Upload over quota of user returns quota error"
+		mockAttachmentStorer.totalSize = 15
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "report.csv", Size: 8, Content: strings.NewReader("id,total"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrQuotaExceeded) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrQuotaExceeded, err)
+			}
+		})
+		mockAttachmentStorer.totalSize = 0
+	}
+	{
+		tc := "Case 4: Content longer than its size is cut at the limit and no blob is left"
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "report.csv", Size: 1, Content: strings.NewReader("id,total\n1,10\n"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrAttachmentTooLarge) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrAttachmentTooLarge, err)
+			}
+			if files := blobs(t, dir); len(files) != 0 || len(mockAttachmentStorer.inserted) != 0 {
+				t.Errorf("%s: expected no blob and no attachment but got %v", tc, files)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Storage Insert returns error and the blob is deleted"
+		mockAttachmentStorer.errInsert = errors.New("insert error")
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "report.csv", Size: 8, Content: strings.NewReader("id,total"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockAttachmentStorer.errInsert) {
+				t.Errorf("%s: expected %v but got %v", tc, mockAttachmentStorer.errInsert, err)
+			}
+			if files := blobs(t, dir); len(files) != 0 {
+				t.Errorf("%s: expected no blob but got %v", tc, files)
+			}
+		})
+		mockAttachmentStorer.errInsert = nil
+		mockAttachmentStorer.inserted = nil
+	}
+	{
+		tc := "Case 6: Success, path dropped from filename and content type taken from extension"
+		res, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: `C:\reports\report.csv`, Size: 8, Content: strings.NewReader("id,total"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if res.AttachmentID != 1 || res.Filename != "report.csv" || !strings.HasPrefix(res.ContentType, "text/csv") || res.Size != 8 {
+				t.Errorf("%s: expected csv attachment of 8 bytes but got %+v", tc, res)
+			}
+			stored := mockAttachmentStorer.inserted[0]
+			if files := blobs(t, dir); len(files) != 1 || files[0] != stored.BlobKey || stored.UserID != 1 || stored.TaskID != 0 {
+				t.Errorf("%s: expected unattached blob of user 1 but got %v %+v", tc, files, stored)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Content type detected from content without known extension"
+		res, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "scan", ContentType: "application/octet-stream", Size: 8,
+			Content: strings.NewReader("%PDF-1.4"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || res.ContentType != "application/pdf" {
+				t.Errorf("%s: expected pdf attachment but got %+v %v", tc, res, err)
+			}
+		})
+	}
+	{
+		tc := "Case 8: Stored content over quota of user returns quota error under the lock of the user and the blob is deleted"
+		mockAttachmentStorer.totalSize = 15
+		mockAttachmentStorer.inserted = nil
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 2, Filename: "report.csv", Size: 1, Content: strings.NewReader("id,total"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrQuotaExceeded) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrQuotaExceeded, err)
+			}
+			if locked := mockAttachmentStorer.locked; len(locked) == 0 || locked[len(locked)-1] != 2 {
+				t.Errorf("%s: expected quota of user 2 locked but got %v", tc, locked)
+			}
+			if len(mockAttachmentStorer.inserted) != 0 {
+				t.Errorf("%s: expected no attachment but got %+v", tc, mockAttachmentStorer.inserted)
+			}
+		})
+		mockAttachmentStorer.totalSize = 0
+	}
+	{
+		tc := "Case 9: Commit returns error and the blob is deleted"
+		mockAttachmentStorer.errCommitTx = errors.New("commit error")
+		before := len(blobs(t, dir))
+		_, err := mockService.Upload(context.Background(), dtoreq.UploadAttachmentRequest{
+			UserID: 1, Filename: "report.csv", Size: 8, Content: strings.NewReader("id,total"),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockAttachmentStorer.errCommitTx) {
+				t.Errorf("%s: expected %v but got %v", tc, mockAttachmentStorer.errCommitTx, err)
+			}
+			if files := blobs(t, dir); len(files) != before {
+				t.Errorf("%s: expected %d blobs but got %v", tc, before, files)
+			}
+		})
+		mockAttachmentStorer.errCommitTx = nil
+	}
+}
+
+func Test_attachmentService_Attach(t *testing.T) {
+	dir := t.TempDir()
+	mockAttachmentStorer := &mockAttachmentStorer{}
+	mockService := attachmentservice.New(
+		attachmentservice.WithAttachmentStorage(mockAttachmentStorer),
+		attachmentservice.WithBlobStore(blobstore.New(blobstore.WithDir(dir))),
+		attachmentservice.WithUserQuota(30<<20),
+	)
+	task := model.MailTaskQueue{UserID: 1}
+	task.ID = 7
+	invoice := dtoreq.AttachmentRequest{
+		Filename:    "invoice.pdf",
+		ContentType: "application/pdf",
+		Content:     base64.StdEncoding.EncodeToString([]byte("%PDF-1.4")),
+	}
+	{
+		tc := "Case 1: More attachments than allowed returns too many attachments error"
+		_, err := mockService.Attach(context.Background(), task, make([]dtoreq.AttachmentRequest, 6), make([]uint, 5), nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrTooManyAttachments) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrTooManyAttachments, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Upload of other user or already attached returns not found error"
+		for _, attachments := range [][]model.MailAttachment{nil, {{UserID: 1, TaskID: 3}}} {
+			mockAttachmentStorer.attachments = attachments
+			_, err := mockService.Attach(context.Background(), task, nil, []uint{1}, nil)
+			t.Run(tc, func(t *testing.T) {
+				if !errors.Is(err, attachmentservice.ErrAttachmentNotFound) {
+					t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrAttachmentNotFound, err)
+				}
+			})
+		}
+		mockAttachmentStorer.attachments = nil
+	}
+	{
+		tc := "Case 3: Attachments of task over task limit returns too large error"
+		mockAttachmentStorer.attachments = []model.MailAttachment{{UserID: 1, Size: 25 << 20}}
+		_, err := mockService.Attach(context.Background(), task, []dtoreq.AttachmentRequest{invoice}, []uint{1}, nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrAttachmentTooLarge) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrAttachmentTooLarge, err)
+			}
+		})
+		mockAttachmentStorer.attachments = nil
+	}
+	{
+		tc := "Case 4: Inline attachment over quota of user returns quota error"
+		mockAttachmentStorer.totalSize = 30 << 20
+		_, err := mockService.Attach(context.Background(), task, []dtoreq.AttachmentRequest{invoice}, nil, nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrQuotaExceeded) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrQuotaExceeded, err)
+			}
+		})
+		mockAttachmentStorer.totalSize = 0
+	}
+	{
+		tc := "Case 5: Upload attached by another task meanwhile and stored inline blobs discarded"
+		mockAttachmentStorer.attachments = []model.MailAttachment{{UserID: 1, Size: 8}}
+		_, err := mockService.Attach(context.Background(), task, []dtoreq.AttachmentRequest{invoice}, []uint{1}, nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, attachmentservice.ErrAttachmentNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, attachmentservice.ErrAttachmentNotFound, err)
+			}
+			if files := blobs(t, dir); len(files) != 0 {
+				t.Errorf("%s: expected no blob but got %v", tc, files)
+			}
+		})
+		mockAttachmentStorer.inserted = nil
+	}
+	var stored []model.MailAttachment
+	{
+		tc := "Case 6: Success, inline attachment stored for task and upload attached"
+		mockAttachmentStorer.attached = 1
+		var err error
+		stored, err = mockService.Attach(context.Background(), task, []dtoreq.AttachmentRequest{invoice}, []uint{1}, nil)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if len(stored) != 1 || stored[0].TaskID != 7 || stored[0].UserID != 1 || stored[0].Size != 8 ||
+				stored[0].ContentType != "application/pdf" {
+				t.Fatalf("%s: expected pdf attachment of task 7 but got %+v", tc, stored)
+			}
+			if files := blobs(t, dir); len(files) != 1 || files[0] != stored[0].BlobKey {
+				t.Errorf("%s: expected blob of attachment but got %v", tc, files)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Discard deletes the blobs of the stored attachments"
+		mockService.Discard(context.Background(), stored)
+		t.Run(tc, func(t *testing.T) {
+			if files := blobs(t, dir); len(files) != 0 {
+				t.Errorf("%s: expected no blob but got %v", tc, files)
+			}
+		})
+	}
+}
+
+func Test_attachmentService_CleanupAttachments(t *testing.T) {
+	store := blobstore.New(blobstore.WithDir(t.TempDir()))
+	mockAttachmentStorer := &mockAttachmentStorer{}
+	mockService := attachmentservice.New(
+		attachmentservice.WithAttachmentStorage(mockAttachmentStorer),
+		attachmentservice.WithBlobStore(store),
+	)
+	{
+		tc := "Case 1: Expired attachments deleted with their blobs, missing blobs included"
+		store.Put(context.Background(), "key", strings.NewReader("content"))
+		mockAttachmentStorer.attachments = []model.MailAttachment{{BlobKey: "key"}, {BlobKey: "missing"}}
+		mockAttachmentStorer.attachments[0].ID = 1
+		mockAttachmentStorer.attachments[1].ID = 2
+		mockService.CleanupAttachments()
+		_, err := store.Open(context.Background(), "key")
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, blobstore.ErrNotFound) {
+				t.Errorf("%s: expected deleted blob but got %v", tc, err)
+			}
+			if len(mockAttachmentStorer.deleted) != 2 {
+				t.Errorf("%s: expected 2 deleted attachments but got %v", tc, mockAttachmentStorer.deleted)
+			}
+		})
+	}
+}
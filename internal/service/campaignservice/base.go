@@ -0,0 +1,90 @@
+package campaignservice
+
+import (
+	"context"
+	"errors"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/campaignstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+)
+
+// CampaignService sends a template to the recipient list of a campaign. Due
+// campaigns are fanned out into bulk tasks a batch at a time by
+// DispatchCampaigns, the next batch is dispatched once the tasks of the
+// previous ones are mostly sent.
+type CampaignService interface {
+	CreateCampaign(ctx context.Context, request dtoreq.CreateCampaignRequest) (dtores.CampaignResponse, error)
+	GetCampaign(ctx context.Context, request dtoreq.GetCampaignRequest) (dtores.CampaignResponse, error)
+	GetCampaigns(ctx context.Context, request dtoreq.GetCampaignsRequest) (dtores.GetCampaignsResponse, error)
+	PauseCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error)
+	ResumeCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error)
+	CancelCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error)
+	DispatchCampaigns()
+}
+
+var (
+	// ErrCampaignNotFound is returned when a campaign does not exist or belongs to another user.
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrInvalidRecipients is returned for recipient lists that can not be
+	// read, have no recipients or have a row without a valid email.
+	ErrInvalidRecipients = errors.New("invalid recipient list")
+	// ErrCampaignState is returned when a campaign can not be paused, resumed
+	// or cancelled in its status, such as a completed campaign.
+	ErrCampaignState = errors.New("campaign can not be changed in its status")
+)
+
+type campaignService struct {
+	campaignStorage campaignstorage.CampaignStorer
+	taskStorage     taskstorage.TaskStorer
+	outboxStorage   outboxstorage.OutboxStorer
+	templates       templateservice.TemplateService
+	batchSize       int
+}
+
+type Option func(*campaignService)
+
+func WithCampaignStorage(storage campaignstorage.CampaignStorer) Option {
+	return func(s *campaignService) {
+		s.campaignStorage = storage
+	}
+}
+
+func WithTaskStorage(storage taskstorage.TaskStorer) Option {
+	return func(s *campaignService) {
+		s.taskStorage = storage
+	}
+}
+
+func WithOutboxStorage(storage outboxstorage.OutboxStorer) Option {
+	return func(s *campaignService) {
+		s.outboxStorage = storage
+	}
+}
+
+func WithTemplateService(service templateservice.TemplateService) Option {
+	return func(s *campaignService) {
+		s.templates = service
+	}
+}
+
+// WithBatchSize sets how many recipients are fanned out into tasks at once,
+// it defaults to constant.CampaignBatchSize.
+func WithBatchSize(size int) Option {
+	return func(s *campaignService) {
+		s.batchSize = size
+	}
+}
+
+func New(opts ...Option) CampaignService {
+	s := &campaignService{
+		batchSize: constant.CampaignBatchSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
@@ -0,0 +1,230 @@
+package campaignservice_test
+
+import (
+	"context"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type mockCampaignStorer struct {
+	errInsert     error
+	errGetByID    error
+	errLock       error
+	errSetStatus  error
+	campaign      model.MailCampaign
+	campaigns     []model.MailCampaign
+	recipients    []model.MailCampaignRecipient
+	inserted      model.MailCampaign
+	insertedRcpts []model.MailCampaignRecipient
+	updated       []model.MailCampaign
+	setStatusRes  int
+	setStatusTo   []int
+	committed     bool
+}
+
+func (m *mockCampaignStorer) Insert(ctx context.Context, campaign model.MailCampaign, recipients []model.MailCampaignRecipient) (model.MailCampaign, error) {
+	campaign.ID = 1
+	m.inserted, m.insertedRcpts = campaign, recipients
+	return campaign, m.errInsert
+}
+
+func (m *mockCampaignStorer) GetByID(ctx context.Context, userID, id uint) (model.MailCampaign, error) {
+	return m.campaign, m.errGetByID
+}
+
+func (m *mockCampaignStorer) GetAllByUserID(ctx context.Context, userID uint) ([]model.MailCampaign, error) {
+	return m.campaigns, nil
+}
+
+func (m *mockCampaignStorer) GetAllDue(ctx context.Context, now time.Time) ([]model.MailCampaign, error) {
+	return m.campaigns, nil
+}
+
+func (m *mockCampaignStorer) Lock(ctx context.Context, id uint, now time.Time, tx *gorm.DB) (model.MailCampaign, error) {
+	return m.campaign, m.errLock
+}
+
+// GetRecipients returns the recipients after the given one, at most limit of them.
+func (m *mockCampaignStorer) GetRecipients(ctx context.Context, campaignID, afterID uint, limit int, tx ...*gorm.DB) ([]model.MailCampaignRecipient, error) {
+	var recipients []model.MailCampaignRecipient
+	for _, recipient := range m.recipients {
+		if recipient.ID > afterID && len(recipients) < limit {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients, nil
+}
+
+func (m *mockCampaignStorer) Update(ctx context.Context, campaign model.MailCampaign, tx ...*gorm.DB) error {
+	m.updated = append(m.updated, campaign)
+	return nil
+}
+
+func (m *mockCampaignStorer) SetStatus(ctx context.Context, userID, id uint, from []int, to int, tx ...*gorm.DB) (int, error) {
+	m.setStatusTo = append(m.setStatusTo, to)
+	return m.setStatusRes, m.errSetStatus
+}
+
+func (m *mockCampaignStorer) CreateTx() *gorm.DB {
+	m.committed = false
+	return nil
+}
+
+func (m *mockCampaignStorer) CommitTx(tx *gorm.DB) error {
+	m.committed = true
+	return nil
+}
+
+func (m *mockCampaignStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+type mockTaskStorer struct {
+	errInsertBatch  error
+	counts          map[int]int
+	inserted        []model.MailTaskQueue
+	cancelledRes    int
+	cancelCampaigns []uint
+}
+
+func (m *mockTaskStorer) Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTaskStorer) GetByID(ctx context.Context, id uint) (model.MailTaskQueue, error) {
+	return model.MailTaskQueue{}, nil
+}
+
+func (m *mockTaskStorer) GetAll(ctx context.Context, userID uint) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) GetAllByStatusWithUserID(ctx context.Context, state int, userID uint) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) Update(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockTaskStorer) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *mockTaskStorer) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	for i := range tasks {
+		tasks[i].ID = uint(len(m.inserted) + i + 1)
+	}
+	m.inserted = append(m.inserted, tasks...)
+	return tasks, m.errInsertBatch
+}
+
+func (m *mockTaskStorer) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	return m.counts, nil
+}
+
+func (m *mockTaskStorer) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	m.cancelCampaigns = append(m.cancelCampaigns, campaignID)
+	return m.cancelledRes, nil
+}
+
+func (m *mockTaskStorer) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+type mockOutboxStorer struct {
+	errInsertBatch error
+	inserted       []model.TaskOutbox
+}
+
+func (m *mockOutboxStorer) Insert(ctx context.Context, entry model.TaskOutbox, tx ...*gorm.DB) (model.TaskOutbox, error) {
+	return entry, nil
+}
+
+func (m *mockOutboxStorer) InsertBatch(ctx context.Context, entries []model.TaskOutbox, tx ...*gorm.DB) error {
+	if m.errInsertBatch != nil {
+		return m.errInsertBatch
+	}
+	m.inserted = append(m.inserted, entries...)
+	return nil
+}
+
+func (m *mockOutboxStorer) GetUndelivered(ctx context.Context, limit int, tx ...*gorm.DB) ([]model.TaskOutbox, error) {
+	return nil, nil
+}
+
+func (m *mockOutboxStorer) MarkDelivered(ctx context.Context, id uint, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockOutboxStorer) MarkFailed(ctx context.Context, id uint, reason string, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockOutboxStorer) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOutboxStorer) CreateTx() *gorm.DB {
+	return nil
+}
+
+func (m *mockOutboxStorer) CommitTx(tx *gorm.DB) error {
+	return nil
+}
+
+func (m *mockOutboxStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+type mockTemplateService struct {
+	errResolve error
+	version    int
+	variables  []map[string]interface{}
+}
+
+func (m *mockTemplateService) CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error) {
+	return dtores.GetTemplatesResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error) {
+	return dtores.GetTemplateVersionsResponse{}, nil
+}
+
+func (m *mockTemplateService) DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error {
+	return nil
+}
+
+func (m *mockTemplateService) Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	task.TemplateVersion = m.version
+	return task, m.errResolve
+}
+
+func (m *mockTemplateService) ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error) {
+	m.variables = variables
+	task.TemplateVersion = m.version
+	return task, m.errResolve
+}
+
+func (m *mockTemplateService) Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	return task, nil
+}
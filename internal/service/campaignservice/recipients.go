@@ -0,0 +1,147 @@
+package campaignservice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"io"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"strings"
+)
+
+// emailField is the column or field of a recipient list that holds the email
+// of a row.
+const emailField = "email"
+
+// recipientList collects the rows of a recipient list. A row with an email
+// that is already in the list is skipped, emails are compared case-insensitively.
+type recipientList struct {
+	recipients []model.MailCampaignRecipient
+	seen       map[string]bool
+}
+
+func (l *recipientList) add(line int, email string, variables map[string]interface{}) error {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("%w: line %d: invalid email %q", ErrInvalidRecipients, line, email)
+	}
+	key := strings.ToLower(email)
+	if l.seen[key] {
+		return nil
+	}
+	if len(l.recipients) == constant.CampaignMaxRecipients {
+		return fmt.Errorf("%w: more than %d recipients", ErrInvalidRecipients, constant.CampaignMaxRecipients)
+	}
+	l.seen[key] = true
+	l.recipients = append(l.recipients, model.MailCampaignRecipient{Email: email, Variables: variables})
+	return nil
+}
+
+// parseRecipients reads a recipient list. Lists named .ndjson or .jsonl or of
+// an NDJSON content type are read as NDJSON, other lists as CSV.
+func parseRecipients(filename, contentType string, content io.Reader) ([]model.MailCampaignRecipient, error) {
+	if content == nil {
+		return nil, fmt.Errorf("%w: a recipient list is required", ErrInvalidRecipients)
+	}
+	list := &recipientList{seen: make(map[string]bool)}
+	var err error
+	if isNDJSON(filename, contentType) {
+		err = list.readNDJSON(content)
+	} else {
+		err = list.readCSV(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(list.recipients) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", ErrInvalidRecipients)
+	}
+	return list.recipients, nil
+}
+
+func isNDJSON(filename, contentType string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ndjson", ".jsonl":
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true
+	}
+	return false
+}
+
+// readCSV reads a CSV list with a header. The email column is required, every
+// column is a variable of the rows.
+func (l *recipientList) readCSV(content io.Reader) error {
+	r := csv.NewReader(bufio.NewReader(content))
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipients, err)
+	}
+	emailColumn := -1
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		header[i] = name
+		if strings.EqualFold(name, emailField) {
+			emailColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		return fmt.Errorf("%w: the header has no %s column", ErrInvalidRecipients, emailField)
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecipients, err)
+		}
+		line, _ := r.FieldPos(0)
+		variables := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			variables[name] = record[i]
+		}
+		if err := l.add(line, record[emailColumn], variables); err != nil {
+			return err
+		}
+	}
+}
+
+// readNDJSON reads an NDJSON list, one object per line. The email field is
+// required, every field is a variable of the rows. Blank lines are skipped.
+func (l *recipientList) readNDJSON(content io.Reader) error {
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		row := bytes.TrimSpace(scanner.Bytes())
+		if len(row) == 0 {
+			continue
+		}
+		var variables map[string]interface{}
+		if err := json.Unmarshal(row, &variables); err != nil || variables == nil {
+			return fmt.Errorf("%w: line %d: not a JSON object", ErrInvalidRecipients, line)
+		}
+		email, _ := variables[emailField].(string)
+		if err := l.add(line, email, variables); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipients, err)
+	}
+	return nil
+}
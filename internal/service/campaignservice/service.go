@@ -0,0 +1,302 @@
+package campaignservice
+
+import (
+	"context"
+	"errors"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/traceid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// CreateCampaign stores a campaign with its recipient list. The template is
+// rendered with the variables of every row, so a list that can not be sent is
+// rejected before any mail is sent, and the campaign is pinned to the version
+// and partials it was rendered with. A campaign without a schedule is due at once.
+func (s *campaignService) CreateCampaign(ctx context.Context, request dtoreq.CreateCampaignRequest) (dtores.CampaignResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.CampaignResponse{}, ctx.Err()
+	default:
+		recipients, err := parseRecipients(request.Filename, request.ContentType, request.Content)
+		if err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		campaign := request.ConvertToMailCampaign()
+		variables := make([]map[string]interface{}, len(recipients))
+		for i, recipient := range recipients {
+			variables[i] = recipient.Variables
+		}
+		resolved, err := s.templates.ResolveAll(ctx, model.MailTaskQueue{
+			UserID:          campaign.UserID,
+			TemplateID:      campaign.TemplateID,
+			TemplateVersion: campaign.TemplateVersion,
+		}, variables)
+		if err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		campaign.TemplateVersion, campaign.TemplatePartials = resolved.TemplateVersion, resolved.TemplatePartials
+		campaign.Status = constant.CampaignStatusScheduled
+		campaign.Total = len(recipients)
+		if campaign.ScheduledAt.IsZero() {
+			campaign.ScheduledAt = time.Now()
+		}
+		campaign, err = s.campaignStorage.Insert(ctx, campaign, recipients)
+		if err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		return dtores.ToCampaign(campaign, nil), nil
+	}
+}
+
+// GetCampaign returns a campaign with the progress of its tasks.
+func (s *campaignService) GetCampaign(ctx context.Context, request dtoreq.GetCampaignRequest) (dtores.CampaignResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.CampaignResponse{}, ctx.Err()
+	default:
+		return s.campaign(ctx, request.UserID, request.CampaignID)
+	}
+}
+
+func (s *campaignService) GetCampaigns(ctx context.Context, request dtoreq.GetCampaignsRequest) (dtores.GetCampaignsResponse, error) {
+	var (
+		res dtores.GetCampaignsResponse
+	)
+	select {
+	case <-ctx.Done():
+		return dtores.GetCampaignsResponse{}, ctx.Err()
+	default:
+		campaigns, err := s.campaignStorage.GetAllByUserID(ctx, request.UserID)
+		if err != nil {
+			return dtores.GetCampaignsResponse{}, err
+		}
+		res.ToCampaigns(campaigns)
+		return res, nil
+	}
+}
+
+// PauseCampaign stops a scheduled or running campaign from dispatching its
+// next batches. The tasks that are already queued are still sent.
+func (s *campaignService) PauseCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.CampaignResponse{}, ctx.Err()
+	default:
+		from := []int{constant.CampaignStatusScheduled, constant.CampaignStatusRunning}
+		if err := s.setStatus(ctx, request, from, constant.CampaignStatusPaused); err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		return s.campaign(ctx, request.UserID, request.CampaignID)
+	}
+}
+
+// ResumeCampaign continues a paused campaign from its next batch, a campaign
+// paused before its first batch waits for its schedule again.
+func (s *campaignService) ResumeCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.CampaignResponse{}, ctx.Err()
+	default:
+		campaign, err := s.campaignStorage.GetByID(ctx, request.UserID, request.CampaignID)
+		if err != nil {
+			return dtores.CampaignResponse{}, notFound(err)
+		}
+		to := constant.CampaignStatusRunning
+		if campaign.Dispatched == 0 {
+			to = constant.CampaignStatusScheduled
+		}
+		if err := s.setStatus(ctx, request, []int{constant.CampaignStatusPaused}, to); err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		return s.campaign(ctx, request.UserID, request.CampaignID)
+	}
+}
+
+// CancelCampaign stops a campaign for good and cancels its tasks that are not
+// sent yet, in one transaction. The tasks being sent are not cancelled.
+func (s *campaignService) CancelCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	var (
+		tx = s.campaignStorage.CreateTx()
+	)
+	defer s.campaignStorage.RollbackTx(tx)
+	select {
+	case <-ctx.Done():
+		return dtores.CampaignResponse{}, ctx.Err()
+	default:
+		from := []int{constant.CampaignStatusScheduled, constant.CampaignStatusRunning, constant.CampaignStatusPaused}
+		if err := s.setStatus(ctx, request, from, constant.CampaignStatusCancelled, tx); err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		n, err := s.taskStorage.CancelByCampaignID(ctx, request.CampaignID, tx)
+		if err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		if err := s.campaignStorage.CommitTx(tx); err != nil {
+			return dtores.CampaignResponse{}, err
+		}
+		log.Printf("campaign %d cancelled with %d tasks", request.CampaignID, n)
+		return s.campaign(ctx, request.UserID, request.CampaignID)
+	}
+}
+
+// DispatchCampaigns fans the next batch of every due campaign out into tasks
+// and completes the campaigns whose tasks are all finished.
+func (s *campaignService) DispatchCampaigns() {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.TaskCancelTimeout)
+	defer cancel()
+	now := time.Now()
+	campaigns, err := s.campaignStorage.GetAllDue(ctx, now)
+	if err != nil {
+		log.Printf("error finding due campaigns: %v", err)
+		return
+	}
+	for _, campaign := range campaigns {
+		n, err := s.dispatch(ctx, campaign.ID, now)
+		if err != nil {
+			log.Printf("error dispatching campaign %d: %v", campaign.ID, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("%d tasks of campaign %d dispatched", n, campaign.ID)
+		}
+	}
+}
+
+// dispatch fans the next batch of recipients of a campaign out into tasks and
+// reports how many tasks were dispatched. The campaign is locked while its
+// batch is inserted, campaigns locked by another pod are skipped. No batch is
+// dispatched while a batch worth of tasks is unfinished, so a campaign never
+// floods the queue ahead of the mail of other users.
+//
+// The tasks and their outbox entries are inserted in the transaction of the
+// batch, the outbox relay publishes the tasks once it commits.
+func (s *campaignService) dispatch(ctx context.Context, id uint, now time.Time) (int, error) {
+	var (
+		tx = s.campaignStorage.CreateTx()
+	)
+	defer s.campaignStorage.RollbackTx(tx)
+	campaign, err := s.campaignStorage.Lock(ctx, id, now, tx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	counts, err := s.taskStorage.CountByCampaignID(ctx, campaign.ID, tx)
+	if err != nil {
+		return 0, err
+	}
+	unfinished := counts[constant.StatusQueued] + counts[constant.StatusProcessing] +
+		counts[constant.StatusScheduled] + counts[constant.StatusFailed]
+	if campaign.Dispatched >= campaign.Total {
+		if unfinished > 0 {
+			return 0, nil
+		}
+		campaign.Status = constant.CampaignStatusCompleted
+		campaign.CompletedAt = now
+		if err := s.campaignStorage.Update(ctx, campaign, tx); err != nil {
+			return 0, err
+		}
+		return 0, s.campaignStorage.CommitTx(tx)
+	}
+	if unfinished >= s.batchSize {
+		return 0, nil
+	}
+	recipients, err := s.campaignStorage.GetRecipients(ctx, campaign.ID, campaign.LastRecipientID, s.batchSize, tx)
+	if err != nil {
+		return 0, err
+	}
+	campaign.Status = constant.CampaignStatusRunning
+	if len(recipients) == 0 {
+		// The list is shorter than its total, there is nothing left to send.
+		campaign.Dispatched = campaign.Total
+		if err := s.campaignStorage.Update(ctx, campaign, tx); err != nil {
+			return 0, err
+		}
+		return 0, s.campaignStorage.CommitTx(tx)
+	}
+	tasks := make([]model.MailTaskQueue, 0, len(recipients))
+	for _, recipient := range recipients {
+		tasks = append(tasks, taskOf(campaign, recipient))
+	}
+	if tasks, err = s.taskStorage.InsertBatch(ctx, tasks, tx); err != nil {
+		return 0, err
+	}
+	entries := make([]model.TaskOutbox, 0, len(tasks))
+	for _, task := range tasks {
+		entries = append(entries, model.TaskOutbox{TaskID: task.ID, TraceID: traceid.New()})
+	}
+	if err := s.outboxStorage.InsertBatch(ctx, entries, tx); err != nil {
+		return 0, err
+	}
+	campaign.Dispatched += len(recipients)
+	campaign.LastRecipientID = recipients[len(recipients)-1].ID
+	if err := s.campaignStorage.Update(ctx, campaign, tx); err != nil {
+		return 0, err
+	}
+	if err := s.campaignStorage.CommitTx(tx); err != nil {
+		return 0, err
+	}
+	return len(tasks), nil
+}
+
+// taskOf returns the task of a recipient of a campaign, it is sent in the
+// bulk lane with the variables of the recipient.
+func taskOf(campaign model.MailCampaign, recipient model.MailCampaignRecipient) model.MailTaskQueue {
+	return model.MailTaskQueue{
+		UserID:            campaign.UserID,
+		RecipientEmail:    recipient.Email,
+		Recipients:        []model.Recipient{{Email: recipient.Email, Kind: model.RecipientTo}},
+		FromName:          campaign.FromName,
+		ReplyTo:           campaign.ReplyTo,
+		Status:            constant.StatusQueued,
+		Priority:          constant.PriorityBulk,
+		TemplateID:        campaign.TemplateID,
+		TemplateVersion:   campaign.TemplateVersion,
+		TemplateVariables: recipient.Variables,
+		TemplatePartials:  campaign.TemplatePartials,
+		CampaignID:        campaign.ID,
+	}
+}
+
+// campaign returns a campaign of a user with the progress of its tasks.
+func (s *campaignService) campaign(ctx context.Context, userID, id uint) (dtores.CampaignResponse, error) {
+	campaign, err := s.campaignStorage.GetByID(ctx, userID, id)
+	if err != nil {
+		return dtores.CampaignResponse{}, notFound(err)
+	}
+	counts, err := s.taskStorage.CountByCampaignID(ctx, campaign.ID)
+	if err != nil {
+		return dtores.CampaignResponse{}, err
+	}
+	return dtores.ToCampaign(campaign, counts), nil
+}
+
+// setStatus moves a campaign from one of the given statuses to another. A
+// campaign in another status returns ErrCampaignState.
+func (s *campaignService) setStatus(ctx context.Context, request dtoreq.CampaignActionRequest, from []int, to int, tx ...*gorm.DB) error {
+	n, err := s.campaignStorage.SetStatus(ctx, request.UserID, request.CampaignID, from, to, tx...)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := s.campaignStorage.GetByID(ctx, request.UserID, request.CampaignID); err != nil {
+		return notFound(err)
+	}
+	return ErrCampaignState
+}
+
+// notFound maps a missing record to ErrCampaignNotFound.
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCampaignNotFound
+	}
+	return err
+}
@@ -0,0 +1,442 @@
+package campaignservice_test
+
+import (
+	"context"
+	"errors"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+)
+
+func newCampaignRequest(filename, content string) dtoreq.CreateCampaignRequest {
+	return dtoreq.CreateCampaignRequest{
+		UserID:     1,
+		Name:       "newsletter",
+		TemplateID: 3,
+		Filename:   filename,
+		Content:    strings.NewReader(content),
+	}
+}
+
+func Test_campaignService_CreateCampaign(t *testing.T) {
+	{
+		tc := "Case 1: Campaign without a recipient list returns invalid recipients error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{}),
+			campaignservice.WithTemplateService(&mockTemplateService{version: 2}),
+		)
+		_, err := mockService.CreateCampaign(context.Background(), dtoreq.CreateCampaignRequest{UserID: 1, TemplateID: 3})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrInvalidRecipients) {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrInvalidRecipients, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: CSV without an email column returns invalid recipients error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{}),
+			campaignservice.WithTemplateService(&mockTemplateService{version: 2}),
+		)
+		_, err := mockService.CreateCampaign(context.Background(), newCampaignRequest("list.csv", "name\nAda\n"))
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrInvalidRecipients) {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrInvalidRecipients, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: CSV row with an invalid email returns invalid recipients error naming its line"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{}),
+			campaignservice.WithTemplateService(&mockTemplateService{version: 2}),
+		)
+		_, err := mockService.CreateCampaign(context.Background(), newCampaignRequest("list.csv", "email,name\nada@example.com,Ada\nnot an email,Bob\n"))
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrInvalidRecipients) || !strings.Contains(err.Error(), "line 3") {
+				t.Errorf("%s: expected %v of line 3 but got %v", tc, campaignservice.ErrInvalidRecipients, err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: CSV rows stored with their columns as variables and duplicate emails skipped"
+		mockCampaignStorer := &mockCampaignStorer{}
+		mockTemplateService := &mockTemplateService{version: 2}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTemplateService(mockTemplateService),
+		)
+		res, err := mockService.CreateCampaign(context.Background(),
+			newCampaignRequest("list.csv", "\ufeffEmail,name\nada@example.com,Ada\ngrace@example.com,Grace\nADA@example.com,Ada\n"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			recipients := mockCampaignStorer.insertedRcpts
+			if len(recipients) != 2 || recipients[1].Email != "grace@example.com" || recipients[1].Variables["name"] != "Grace" {
+				t.Errorf("%s: expected ada and grace with their names but got %v", tc, recipients)
+			}
+			if len(mockTemplateService.variables) != 2 {
+				t.Errorf("%s: expected the template rendered for 2 rows but got %d", tc, len(mockTemplateService.variables))
+			}
+			inserted := mockCampaignStorer.inserted
+			if inserted.Total != 2 || inserted.TemplateVersion != 2 || inserted.Status != constant.CampaignStatusScheduled || inserted.ScheduledAt.IsZero() {
+				t.Errorf("%s: expected a campaign of 2 recipients at version 2 due now but got %v", tc, inserted)
+			}
+			if res.CampaignID != 1 || res.Total != 2 {
+				t.Errorf("%s: expected campaign 1 of 2 recipients but got %v", tc, res)
+			}
+		})
+	}
+	{
+		tc := "Case 5: NDJSON list read by its extension with its fields as variables"
+		mockCampaignStorer := &mockCampaignStorer{}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTemplateService(&mockTemplateService{version: 1}),
+		)
+		_, err := mockService.CreateCampaign(context.Background(),
+			newCampaignRequest("list.ndjson", "{\"email\":\"ada@example.com\",\"plan\":{\"name\":\"pro\"}}\n\n{\"email\":\"grace@example.com\"}\n"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			recipients := mockCampaignStorer.insertedRcpts
+			if len(recipients) != 2 {
+				t.Fatalf("%s: expected 2 recipients but got %v", tc, recipients)
+			}
+			if plan, _ := recipients[0].Variables["plan"].(map[string]interface{}); plan["name"] != "pro" {
+				t.Errorf("%s: expected the nested plan variable but got %v", tc, recipients[0].Variables)
+			}
+		})
+	}
+	{
+		tc := "Case 6: NDJSON line that is not an object returns invalid recipients error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{}),
+			campaignservice.WithTemplateService(&mockTemplateService{version: 1}),
+		)
+		request := newCampaignRequest("list", "{\"email\":\"ada@example.com\"}\n[1,2]\n")
+		request.ContentType = "application/x-ndjson"
+		_, err := mockService.CreateCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrInvalidRecipients) || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("%s: expected %v of line 2 but got %v", tc, campaignservice.ErrInvalidRecipients, err)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Row the template can not be rendered with returns render error"
+		mockCampaignStorer := &mockCampaignStorer{}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTemplateService(&mockTemplateService{errResolve: templateservice.ErrRender}),
+		)
+		_, err := mockService.CreateCampaign(context.Background(), newCampaignRequest("list.csv", "email\nada@example.com\n"))
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrRender) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrRender, err)
+			}
+			if mockCampaignStorer.inserted.UserID != 0 {
+				t.Errorf("%s: expected no campaign stored but got %v", tc, mockCampaignStorer.inserted)
+			}
+		})
+	}
+}
+
+func Test_campaignService_GetCampaign(t *testing.T) {
+	{
+		tc := "Case 1: Campaign returned with the progress of its tasks"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{campaign: model.MailCampaign{Model: gorm.Model{ID: 1}, Total: 10, Dispatched: 10}}),
+			campaignservice.WithTaskStorage(&mockTaskStorer{counts: map[int]int{
+				constant.StatusQueued:    2,
+				constant.StatusSuccess:   5,
+				constant.StatusFailed:    1,
+				constant.StatusScheduled: 1,
+				constant.StatusRejected:  1,
+			}}),
+		)
+		res, err := mockService.GetCampaign(context.Background(), dtoreq.GetCampaignRequest{UserID: 1, CampaignID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if res.Progress.Queued != 2 || res.Progress.Sent != 5 || res.Progress.Failed != 2 || res.Progress.Rejected != 1 {
+				t.Errorf("%s: expected 2 queued, 5 sent, 2 failed and 1 rejected but got %v", tc, res.Progress)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Campaign of another user returns not found error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{errGetByID: gorm.ErrRecordNotFound}),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.GetCampaign(context.Background(), dtoreq.GetCampaignRequest{UserID: 2, CampaignID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrCampaignNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrCampaignNotFound, err)
+			}
+		})
+	}
+}
+
+func Test_campaignService_PauseCampaign(t *testing.T) {
+	request := dtoreq.CampaignActionRequest{UserID: 1, CampaignID: 1}
+	{
+		tc := "Case 1: Running campaign paused"
+		mockCampaignStorer := &mockCampaignStorer{setStatusRes: 1}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.PauseCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || len(mockCampaignStorer.setStatusTo) != 1 || mockCampaignStorer.setStatusTo[0] != constant.CampaignStatusPaused {
+				t.Errorf("%s: expected campaign paused but got %v, %v", tc, mockCampaignStorer.setStatusTo, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Completed campaign returns campaign state error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{campaign: model.MailCampaign{Status: constant.CampaignStatusCompleted}}),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.PauseCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrCampaignState) {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrCampaignState, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Campaign of another user returns not found error"
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{errGetByID: gorm.ErrRecordNotFound}),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.PauseCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrCampaignNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrCampaignNotFound, err)
+			}
+		})
+	}
+}
+
+func Test_campaignService_ResumeCampaign(t *testing.T) {
+	request := dtoreq.CampaignActionRequest{UserID: 1, CampaignID: 1}
+	{
+		tc := "Case 1: Campaign paused after its first batch resumed as running"
+		mockCampaignStorer := &mockCampaignStorer{setStatusRes: 1, campaign: model.MailCampaign{Status: constant.CampaignStatusPaused, Dispatched: 500}}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.ResumeCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || mockCampaignStorer.setStatusTo[0] != constant.CampaignStatusRunning {
+				t.Errorf("%s: expected campaign running but got %v, %v", tc, mockCampaignStorer.setStatusTo, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Campaign paused before its first batch waits for its schedule again"
+		mockCampaignStorer := &mockCampaignStorer{setStatusRes: 1, campaign: model.MailCampaign{Status: constant.CampaignStatusPaused}}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+		)
+		_, err := mockService.ResumeCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || mockCampaignStorer.setStatusTo[0] != constant.CampaignStatusScheduled {
+				t.Errorf("%s: expected campaign scheduled but got %v, %v", tc, mockCampaignStorer.setStatusTo, err)
+			}
+		})
+	}
+}
+
+func Test_campaignService_CancelCampaign(t *testing.T) {
+	request := dtoreq.CampaignActionRequest{UserID: 1, CampaignID: 1}
+	{
+		tc := "Case 1: Campaign cancelled with its unsent tasks in one transaction"
+		mockCampaignStorer := &mockCampaignStorer{setStatusRes: 1}
+		mockTaskStorer := &mockTaskStorer{cancelledRes: 20}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+		)
+		_, err := mockService.CancelCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || mockCampaignStorer.setStatusTo[0] != constant.CampaignStatusCancelled {
+				t.Errorf("%s: expected campaign cancelled but got %v, %v", tc, mockCampaignStorer.setStatusTo, err)
+			}
+			if len(mockTaskStorer.cancelCampaigns) != 1 || !mockCampaignStorer.committed {
+				t.Errorf("%s: expected the tasks of the campaign cancelled and committed", tc)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Cancelled campaign returns campaign state error and its tasks are left alone"
+		mockTaskStorer := &mockTaskStorer{}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{campaign: model.MailCampaign{Status: constant.CampaignStatusCancelled}}),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+		)
+		_, err := mockService.CancelCampaign(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, campaignservice.ErrCampaignState) || len(mockTaskStorer.cancelCampaigns) != 0 {
+				t.Errorf("%s: expected %v but got %v", tc, campaignservice.ErrCampaignState, err)
+			}
+		})
+	}
+}
+
+func newRecipients(n int) []model.MailCampaignRecipient {
+	recipients := make([]model.MailCampaignRecipient, n)
+	for i := range recipients {
+		recipients[i] = model.MailCampaignRecipient{
+			ID:         uint(i + 1),
+			CampaignID: 1,
+			Email:      "user@example.com",
+			Variables:  map[string]interface{}{"row": i + 1},
+		}
+	}
+	return recipients
+}
+
+func Test_campaignService_DispatchCampaigns(t *testing.T) {
+	campaign := model.MailCampaign{
+		Model:           gorm.Model{ID: 1},
+		UserID:          1,
+		TemplateID:      3,
+		TemplateVersion: 2,
+		Status:          constant.CampaignStatusScheduled,
+		Total:           5,
+	}
+	{
+		tc := "Case 1: Next batch fanned out into bulk tasks added to the outbox"
+		mockCampaignStorer := &mockCampaignStorer{campaigns: []model.MailCampaign{campaign}, campaign: campaign, recipients: newRecipients(5)}
+		mockTaskStorer := &mockTaskStorer{}
+		mockOutboxStorer := &mockOutboxStorer{}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+			campaignservice.WithOutboxStorage(mockOutboxStorer),
+			campaignservice.WithBatchSize(2),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			if len(mockTaskStorer.inserted) != 2 || len(mockOutboxStorer.inserted) != 2 {
+				t.Fatalf("%s: expected 2 tasks and outbox entries inserted but got %d, %d", tc, len(mockTaskStorer.inserted), len(mockOutboxStorer.inserted))
+			}
+			task := mockTaskStorer.inserted[1]
+			if task.CampaignID != 1 || task.Priority != constant.PriorityBulk || task.TemplateVersion != 2 ||
+				task.TemplateVariables["row"] != 2 || len(task.Recipients) != 1 {
+				t.Errorf("%s: expected a bulk task of the second recipient but got %v", tc, task)
+			}
+			if entry := mockOutboxStorer.inserted[1]; entry.TaskID != task.ID || entry.TraceID == "" {
+				t.Errorf("%s: expected the outbox entry of task %d with a trace id but got %v", tc, task.ID, entry)
+			}
+			updated := mockCampaignStorer.updated
+			if len(updated) != 1 || updated[0].Status != constant.CampaignStatusRunning || updated[0].Dispatched != 2 || updated[0].LastRecipientID != 2 {
+				t.Errorf("%s: expected the campaign running with 2 dispatched but got %v", tc, updated)
+			}
+			if !mockCampaignStorer.committed {
+				t.Errorf("%s: expected the batch committed", tc)
+			}
+		})
+	}
+	{
+		tc := "Case 2: No batch dispatched while a batch worth of tasks is unfinished"
+		running := campaign
+		running.Status, running.Dispatched, running.LastRecipientID = constant.CampaignStatusRunning, 2, 2
+		mockTaskStorer := &mockTaskStorer{counts: map[int]int{constant.StatusQueued: 1, constant.StatusProcessing: 1}}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{campaigns: []model.MailCampaign{running}, campaign: running, recipients: newRecipients(5)}),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+			campaignservice.WithOutboxStorage(&mockOutboxStorer{}),
+			campaignservice.WithBatchSize(2),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			if len(mockTaskStorer.inserted) != 0 {
+				t.Errorf("%s: expected no tasks but got %d", tc, len(mockTaskStorer.inserted))
+			}
+		})
+	}
+	{
+		tc := "Case 3: Last batch of recipients dispatched after the previous one is sent"
+		running := campaign
+		running.Status, running.Dispatched, running.LastRecipientID = constant.CampaignStatusRunning, 4, 4
+		mockCampaignStorer := &mockCampaignStorer{campaigns: []model.MailCampaign{running}, campaign: running, recipients: newRecipients(5)}
+		mockTaskStorer := &mockTaskStorer{counts: map[int]int{constant.StatusSuccess: 4}}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+			campaignservice.WithOutboxStorage(&mockOutboxStorer{}),
+			campaignservice.WithBatchSize(2),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			if len(mockTaskStorer.inserted) != 1 || mockCampaignStorer.updated[0].Dispatched != 5 {
+				t.Errorf("%s: expected the last recipient dispatched but got %v", tc, mockCampaignStorer.updated)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Campaign completed once all its tasks are finished"
+		running := campaign
+		running.Status, running.Dispatched, running.LastRecipientID = constant.CampaignStatusRunning, 5, 5
+		mockCampaignStorer := &mockCampaignStorer{campaigns: []model.MailCampaign{running}, campaign: running}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(&mockTaskStorer{counts: map[int]int{constant.StatusSuccess: 4, constant.StatusRejected: 1}}),
+			campaignservice.WithOutboxStorage(&mockOutboxStorer{}),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			updated := mockCampaignStorer.updated
+			if len(updated) != 1 || updated[0].Status != constant.CampaignStatusCompleted || updated[0].CompletedAt.IsZero() {
+				t.Errorf("%s: expected the campaign completed but got %v", tc, updated)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Campaign locked by another pod skipped"
+		mockTaskStorer := &mockTaskStorer{}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(&mockCampaignStorer{campaigns: []model.MailCampaign{campaign}, errLock: gorm.ErrRecordNotFound}),
+			campaignservice.WithTaskStorage(mockTaskStorer),
+			campaignservice.WithOutboxStorage(&mockOutboxStorer{}),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			if len(mockTaskStorer.inserted) != 0 {
+				t.Errorf("%s: expected no tasks but got %d", tc, len(mockTaskStorer.inserted))
+			}
+		})
+	}
+	{
+		tc := "Case 6: Batch rolled back when its outbox entries can not be inserted"
+		mockCampaignStorer := &mockCampaignStorer{campaigns: []model.MailCampaign{campaign}, campaign: campaign, recipients: newRecipients(5)}
+		mockService := campaignservice.New(
+			campaignservice.WithCampaignStorage(mockCampaignStorer),
+			campaignservice.WithTaskStorage(&mockTaskStorer{}),
+			campaignservice.WithOutboxStorage(&mockOutboxStorer{errInsertBatch: errors.New("database down")}),
+		)
+		mockService.DispatchCampaigns()
+		t.Run(tc, func(t *testing.T) {
+			if mockCampaignStorer.committed || len(mockCampaignStorer.updated) != 0 {
+				t.Errorf("%s: expected the batch rolled back but got %v", tc, mockCampaignStorer.updated)
+			}
+		})
+	}
+}
@@ -2,43 +2,154 @@ package mailservice
 
 import (
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/faults"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
 	"gopkg.in/gomail.v2"
+	"io"
+	"net/http"
 )
 
 type Dialer interface {
 	Dial() (gomail.SendCloser, error)
 }
 
+// Transport is an interface for the providers mails are delivered with.
+type Transport interface {
+	Send(mail Mail) error
+}
+
+// Mail is a mail to deliver. Message is the mail as an RFC 5322 message for the
+// transports that deliver messages, the others take its fields. Body is the text
+// body, HTMLBody is empty for text only mails. To, CC and BCC are the recipients
+// the mail is still to be delivered to, the headers of Message list every To
+// and CC recipient.
+type Mail struct {
+	From        string
+	FromName    string
+	To          []string
+	CC          []string
+	BCC         []string
+	ReplyTo     string
+	Subject     string
+	Body        string
+	HTMLBody    string
+	Attachments []Attachment
+	Message     *gomail.Message
+}
+
+// HasRecipients reports whether the mail has recipients left to deliver to.
+func (m Mail) HasRecipients() bool {
+	return len(m.To)+len(m.CC)+len(m.BCC) > 0
+}
+
+// Attachment is a file attached to a mail, Open returns a reader of its content.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Open        func() (io.ReadCloser, error)
+}
+
+// The providers a user can send mails with.
+const (
+	ProviderSMTP = "smtp"
+	ProviderHTTP = "http"
+	ProviderFile = "file"
+	ProviderLog  = "log"
+)
+
 type MailService interface {
 	AddTask(task model.MailTaskQueue) error
 	NewDialer() *gomail.Dialer
 	NewMessage() *gomail.Message
-	SendMail(d Dialer, m *gomail.Message) error
+	NewMail() Mail
+	NewTransport() Transport
+	SendMail(t Transport, mail Mail) error
 }
 
 type mailService struct {
+	UserID       uint
 	From         string
+	FromName     string
 	To           string
+	Recipients   []model.Recipient
+	ReplyTo      string
 	Subject      string
 	Body         string
+	HTMLBody     string
 	SmtpHost     string
 	SmtpPort     int
 	SmtpUsername string
 	SmtpPassword string
+	Provider     string
+	Settings     model.ProviderSettings
+	Attachments  []model.MailAttachment
+	blobs        blobstore.Store
+	pool         smtppool.Pool
+	faults       faults.Injector
+	client       *http.Client
+	policy       ProviderPolicy
 }
 
 type Option func(*mailService)
 
 func WithTask(task model.MailTaskQueue) Option {
 	return func(m *mailService) {
+		m.UserID = task.UserID
 		m.From = task.User.Email
+		m.FromName = task.FromName
 		m.To = task.RecipientEmail
+		m.Recipients = Recipients(task)
+		m.ReplyTo = task.ReplyTo
 		m.Subject = task.Subject
-		m.Body = task.Body
+		m.Body = TextBody(task)
+		m.HTMLBody = task.HTMLBody
 		m.SmtpHost = task.User.SmtpHost
 		m.SmtpPort = task.User.SmtpPort
 		m.SmtpUsername = task.User.SmtpUsername
 		m.SmtpPassword = task.User.SmtpPassword
+		m.Provider = task.User.Provider
+		m.Settings = task.User.ProviderSettings
+		m.Attachments = task.Attachments
+	}
+}
+
+// WithPool sends the mails over the sessions of the given pool, so the
+// connection to the SMTP server of a user is reused by the following mails.
+func WithPool(pool smtppool.Pool) Option {
+	return func(m *mailService) {
+		m.pool = pool
+	}
+}
+
+// WithFaults fails the sends with the faults of the injector, for chaos drills
+// against the retry logic. No faults are injected without it.
+func WithFaults(injector faults.Injector) Option {
+	return func(m *mailService) {
+		m.faults = injector
+	}
+}
+
+// WithHTTPClient sets the client of the http provider, by default a client
+// with a 30 seconds timeout that dials the addresses the provider policy allows.
+func WithHTTPClient(client *http.Client) Option {
+	return func(m *mailService) {
+		m.client = client
+	}
+}
+
+// WithProviderPolicy sets the providers the users can send with, by default
+// only the smtp provider and the http provider to public addresses.
+func WithProviderPolicy(policy ProviderPolicy) Option {
+	return func(m *mailService) {
+		m.policy = policy
+	}
+}
+
+// WithBlobStore sets the store the attachments of the mails are read from.
+func WithBlobStore(store blobstore.Store) Option {
+	return func(m *mailService) {
+		m.blobs = store
 	}
 }
 
@@ -47,5 +158,8 @@ func New(opts ...Option) MailService {
 	for _, opt := range opts {
 		opt(mail)
 	}
+	if mail.client == nil {
+		mail.client = mail.policy.client()
+	}
 	return mail
 }
@@ -23,12 +23,39 @@ func (m *mockDialer) Dial() (gomail.SendCloser, error) {
 type mockSender struct {
 	errSend  error
 	errClose error
+	to       []string
 }
 
 func (m *mockSender) Send(from string, to []string, msg io.WriterTo) error {
+	m.to = to
 	return m.errSend
 }
 
 func (m *mockSender) Close() error {
 	return m.errClose
 }
+
+type mockPool struct {
+	errGet error
+	sender mockSender
+	gets   int
+	closed int
+}
+
+func (m *mockPool) Get(d *gomail.Dialer) (gomail.SendCloser, error) {
+	m.gets++
+	return &pooledSender{pool: m, mockSender: &m.sender}, m.errGet
+}
+
+func (m *mockPool) Close() {}
+
+// pooledSender counts the sessions returned to the mock pool.
+type pooledSender struct {
+	*mockSender
+	pool *mockPool
+}
+
+func (s *pooledSender) Close() error {
+	s.pool.closed++
+	return nil
+}
@@ -0,0 +1,107 @@
+package mailservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Failure classes of a send error. Transient, connection and TLS failures are
+// retried, permanent failures reject the task and auth failures pause the user.
+const (
+	FailureTransient  = "transient"
+	FailurePermanent  = "permanent"
+	FailureAuth       = "auth"
+	FailureConnection = "connection"
+	FailureTLS        = "tls"
+)
+
+// SendError is a classified error of SendMail. Code is the SMTP reply code of
+// the server or the HTTP status of the http provider, 0 when the mail failed
+// before the server replied.
+type SendError struct {
+	Class string
+	Code  int
+	Err   error
+}
+
+func (e *SendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// authCodes are the SMTP reply codes of rejected credentials.
+var authCodes = map[int]bool{530: true, 534: true, 535: true, 538: true}
+
+// replyCode matches the SMTP reply code of an error whose reply was formatted
+// into the message, gomail does not wrap the errors of the servers.
+var replyCode = regexp.MustCompile(`(?:^|: )([45]\d\d)[ -]`)
+
+// Classify sorts an error of SendMail into its failure class. Errors that can
+// not be classified are transient, so they are retried.
+func Classify(err error) *SendError {
+	if err == nil {
+		return nil
+	}
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr
+	}
+	code := 0
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		code = protoErr.Code
+	} else if m := replyCode.FindStringSubmatch(err.Error()); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+	return &SendError{Class: classOf(err, code), Code: code, Err: err}
+}
+
+func classOf(err error, code int) string {
+	if errors.Is(err, ErrProviderNotAllowed) {
+		return FailurePermanent
+	}
+	switch {
+	case authCodes[code]:
+		return FailureAuth
+	case code >= 500:
+		return FailurePermanent
+	case code >= 400:
+		return FailureTransient
+	}
+	var (
+		recordErr tls.RecordHeaderError
+		alertErr  tls.AlertError
+		verifyErr *tls.CertificateVerificationError
+		unknownCA x509.UnknownAuthorityError
+		hostErr   x509.HostnameError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownCA) || errors.As(err, &hostErr) || strings.Contains(err.Error(), "tls:") {
+		return FailureTLS
+	}
+	var (
+		dnsErr *net.DNSError
+		opErr  *net.OpError
+	)
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) {
+		return FailureConnection
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "gomail: invalid address"), strings.Contains(msg, `"From" field is absent`):
+		return FailurePermanent
+	case strings.HasPrefix(msg, "smtp: server doesn't support AUTH"), strings.Contains(msg, "unencrypted connection"),
+		strings.Contains(msg, "wrong host name"), strings.Contains(msg, "unexpected server challenge"):
+		return FailureAuth
+	}
+	return FailureTransient
+}
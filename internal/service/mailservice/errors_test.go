@@ -0,0 +1,62 @@
+package mailservice_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+func Test_Classify(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+		code  int
+	}{
+		{&textproto.Error{Code: 450, Msg: "4.2.1 Mailbox busy"}, mailservice.FailureTransient, 450},
+		{&textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}, mailservice.FailurePermanent, 550},
+		{&textproto.Error{Code: 535, Msg: "5.7.8 Authentication credentials invalid"}, mailservice.FailureAuth, 535},
+		{fmt.Errorf("gomail: could not send email 1: %v", &textproto.Error{Code: 552, Msg: "5.3.4 Message too big"}), mailservice.FailurePermanent, 552},
+		{fmt.Errorf("gomail: could not send email 1: %v", &textproto.Error{Code: 421, Msg: "4.7.0 Try again later"}), mailservice.FailureTransient, 421},
+		{errors.New("smtp: server doesn't support AUTH"), mailservice.FailureAuth, 0},
+		{errors.New(`gomail: invalid address "To": mail: no angle-addr`), mailservice.FailurePermanent, 0},
+		{&net.DNSError{Err: "no such host", Name: "smtp.example.com", IsNotFound: true}, mailservice.FailureConnection, 0},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, mailservice.FailureConnection, 0},
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, mailservice.FailureTLS, 0},
+		{errors.New("Random error"), mailservice.FailureTransient, 0},
+	}
+	for i, c := range cases {
+		tc := fmt.Sprintf("Case %d: %v Is Classified As %s", i+1, c.err, c.class)
+		err := mailservice.Classify(c.err)
+		t.Run(tc, func(t *testing.T) {
+			if err.Class != c.class || err.Code != c.code {
+				t.Errorf("Expected class %s with code %d, got %s with code %d", c.class, c.code, err.Class, err.Code)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("Expected classified error to wrap %v", c.err)
+			}
+		})
+	}
+	{
+		tc := fmt.Sprintf("Case %d: Nil Error Is Not Classified", len(cases)+1)
+		err := mailservice.Classify(nil)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := fmt.Sprintf("Case %d: Classified Error Is Kept", len(cases)+2)
+		classified := &mailservice.SendError{Class: mailservice.FailureAuth, Err: errors.New("error")}
+		err := mailservice.Classify(fmt.Errorf("send: %w", classified))
+		t.Run(tc, func(t *testing.T) {
+			if err != classified {
+				t.Errorf("Expected %v, got %v", classified, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package mailservice
+
+import "net/http"
+
+// Client returns the client of the http provider of the policy.
+func (p ProviderPolicy) Client() *http.Client {
+	return p.client()
+}
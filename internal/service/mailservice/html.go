@@ -0,0 +1,64 @@
+package mailservice
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// TextBody returns the text body of the mail of a task: its text body, the body
+// of the tasks enqueued before text bodies or the text of its HTML body.
+func TextBody(task model.MailTaskQueue) string {
+	switch {
+	case task.TextBody != "":
+		return task.TextBody
+	case task.Body != "":
+		return task.Body
+	}
+	return TextFromHTML(task.HTMLBody)
+}
+
+var (
+	// hiddenTags match the elements whose content is not shown.
+	hiddenTags = regexp.MustCompile(`(?is)<(script|style|head|title)\b[^>]*>.*?</(script|style|head|title)\s*>|<!--.*?-->`)
+	// links match the anchors with a target, written as "text (target)".
+	links = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a\s*>`)
+	// breaks match the tags that start or end a line.
+	breaks = regexp.MustCompile(`(?i)<br\s*/?>|</?(p|div|h[1-6]|tr|table|ul|ol|blockquote|pre)\b[^>]*>`)
+	// items match the start of the list items, written as "* ".
+	items = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	tags  = regexp.MustCompile(`(?s)<[^>]*>`)
+	// spaces match the runs of whitespace in a line.
+	spaces = regexp.MustCompile(`[ \t\r\f\v]+`)
+	// blankLines match more than one empty line.
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// TextFromHTML generates the plain text fallback of an HTML body. Hidden
+// elements are dropped, block elements end lines, list items are bulleted and
+// links keep their target after their text.
+func TextFromHTML(body string) string {
+	text := hiddenTags.ReplaceAllString(body, "")
+	text = links.ReplaceAllStringFunc(text, func(a string) string {
+		m := links.FindStringSubmatch(a)
+		label := strings.TrimSpace(tags.ReplaceAllString(m[2], ""))
+		switch {
+		case label == "":
+			return m[1]
+		case label == m[1] || strings.HasPrefix(m[1], "#") || strings.HasPrefix(m[1], "mailto:"):
+			return label
+		}
+		return label + " (" + m[1] + ")"
+	})
+	text = strings.NewReplacer("\r\n", " ", "\n", " ").Replace(text)
+	text = breaks.ReplaceAllString(text, "\n")
+	text = items.ReplaceAllString(text, "\n* ")
+	text = html.UnescapeString(tags.ReplaceAllString(text, ""))
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(strings.ReplaceAll(line, " ", " "), " "))
+	}
+	text = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
@@ -0,0 +1,62 @@
+package mailservice_test
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"testing"
+)
+
+func Test_TextFromHTML(t *testing.T) {
+	{
+		tc := "Case 1: Blocks, Lists And Links Converted To Text"
+		text := mailservice.TextFromHTML(`<html><head><title>Ignored</title><style>p { color: red; }</style></head>
+<body><h1>Welcome &amp; hello</h1><p>Reset your
+  password <a href="https://ex.com/reset">here</a>.</p>
+<ul><li>One</li><li>Two</li></ul><script>alert(1)</script><p>Bye<br>Team</p></body></html>`)
+		want := "Welcome & hello\n\nReset your password here (https://ex.com/reset).\n\n* One\n* Two\n\nBye\nTeam"
+		t.Run(tc, func(t *testing.T) {
+			if text != want {
+				t.Errorf("Expected %q, got %q", want, text)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Link Whose Text Is Its Target Written Once"
+		text := mailservice.TextFromHTML(`<a href="https://ex.com">https://ex.com</a>`)
+		t.Run(tc, func(t *testing.T) {
+			if text != "https://ex.com" {
+				t.Errorf("Expected link once, got %q", text)
+			}
+		})
+	}
+}
+
+func Test_TextBody(t *testing.T) {
+	{
+		tc := "Case 1: Text Body Preferred Over Body And HTML Body"
+		text := mailservice.TextBody(model.MailTaskQueue{Body: "Body", TextBody: "Text", HTMLBody: "<p>HTML</p>"})
+		t.Run(tc, func(t *testing.T) {
+			if text != "Text" {
+				t.Errorf("Expected Text, got %q", text)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Body Of Tasks Without Text Body Used"
+		text := mailservice.TextBody(model.MailTaskQueue{Body: "Body", HTMLBody: "<p>HTML</p>"})
+		t.Run(tc, func(t *testing.T) {
+			if text != "Body" {
+				t.Errorf("Expected Body, got %q", text)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Text Generated From HTML Body"
+		text := mailservice.TextBody(model.MailTaskQueue{HTMLBody: "<p>HTML</p>"})
+		t.Run(tc, func(t *testing.T) {
+			if text != "HTML" {
+				t.Errorf("Expected HTML, got %q", text)
+			}
+		})
+	}
+}
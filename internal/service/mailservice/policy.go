@@ -0,0 +1,114 @@
+package mailservice
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrProviderNotAllowed is returned for the providers and provider settings
+// the operator does not allow.
+var ErrProviderNotAllowed = errors.New("provider not allowed")
+
+// ProviderPolicy restricts the providers of the users. The file and log
+// providers are for development and are only allowed with DevProviders, the
+// directories of the file provider are relative to FileDir. The http provider
+// posts to the hosts of HTTPHosts, or to any public address without them.
+type ProviderPolicy struct {
+	DevProviders bool
+	FileDir      string
+	HTTPHosts    []string
+}
+
+// Check returns an error wrapping ErrProviderNotAllowed for a user whose
+// provider or provider settings the policy does not allow.
+func (p ProviderPolicy) Check(user model.User) error {
+	switch provider := ProviderOf(user); provider {
+	case ProviderLog:
+		if !p.DevProviders {
+			return fmt.Errorf("%w: %s is disabled", ErrProviderNotAllowed, provider)
+		}
+	case ProviderFile:
+		if !p.DevProviders || p.FileDir == "" {
+			return fmt.Errorf("%w: %s is disabled", ErrProviderNotAllowed, provider)
+		}
+		if !filepath.IsLocal(user.ProviderSettings.Dir) {
+			return fmt.Errorf("%w: provider_dir must be a relative path without ..", ErrProviderNotAllowed)
+		}
+	case ProviderHTTP:
+		return p.checkURL(user.ProviderSettings.URL)
+	}
+	return nil
+}
+
+// checkURL allows the http and https URLs of the allowed hosts. Without
+// allowed hosts the URLs of addresses that are not public are refused, the
+// addresses of host names are checked when they are dialed.
+func (p ProviderPolicy) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: provider_url must be an http or https URL", ErrProviderNotAllowed)
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(p.HTTPHosts) > 0 {
+		for _, allowed := range p.HTTPHosts {
+			if strings.EqualFold(host, allowed) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s is not an allowed provider host", ErrProviderNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is not a public address", ErrProviderNotAllowed, host)
+	}
+	return nil
+}
+
+// fileDir returns the directory of the file provider of a user inside the
+// directory of the operator.
+func (p ProviderPolicy) fileDir(dir string) string {
+	return filepath.Join(p.FileDir, dir)
+}
+
+// client returns the client of the http provider. It does not follow
+// redirects, and without allowed hosts it only dials public addresses, so a
+// host name can not be pointed at the internal network.
+func (p ProviderPolicy) client() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if len(p.HTTPHosts) == 0 {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrProviderNotAllowed, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicIP reports whether an address is public, loopback, private,
+// link-local and unspecified addresses are not.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
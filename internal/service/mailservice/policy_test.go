@@ -0,0 +1,81 @@
+package mailservice_test
+
+import (
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ProviderPolicy_Check(t *testing.T) {
+	dev := mailservice.ProviderPolicy{DevProviders: true, FileDir: "/var/lib/dmqs/mails"}
+	allowlist := mailservice.ProviderPolicy{HTTPHosts: []string{"api.sendgrid.com"}}
+	httpUser := func(url string) model.User {
+		return model.User{Provider: mailservice.ProviderHTTP, ProviderSettings: model.ProviderSettings{URL: url}}
+	}
+	fileUser := func(dir string) model.User {
+		return model.User{Provider: mailservice.ProviderFile, ProviderSettings: model.ProviderSettings{Dir: dir}}
+	}
+	for _, c := range []struct {
+		tc      string
+		policy  mailservice.ProviderPolicy
+		user    model.User
+		allowed bool
+	}{
+		{"Case 1: Smtp User Allowed", mailservice.ProviderPolicy{}, model.User{SmtpHost: "smtp.test.com"}, true},
+		{"Case 2: Log User Without Dev Providers Not Allowed", mailservice.ProviderPolicy{}, model.User{Provider: mailservice.ProviderLog}, false},
+		{"Case 3: Log User With Dev Providers Allowed", dev, model.User{Provider: mailservice.ProviderLog}, true},
+		{"Case 4: File User Without Provider Directory Not Allowed", mailservice.ProviderPolicy{DevProviders: true}, fileUser("mails"), false},
+		{"Case 5: File User With Relative Directory Allowed", dev, fileUser("user-1/mails"), true},
+		{"Case 6: File User With Absolute Directory Not Allowed", dev, fileUser("/etc"), false},
+		{"Case 7: File User Escaping Provider Directory Not Allowed", dev, fileUser("../../etc"), false},
+		{"Case 8: Http User Of Public Host Allowed", mailservice.ProviderPolicy{}, httpUser("https://api.sendgrid.com/v3/mail/send"), true},
+		{"Case 9: Http User Of Loopback Address Not Allowed", mailservice.ProviderPolicy{}, httpUser("http://127.0.0.1:6379/"), false},
+		{"Case 10: Http User Of Link Local Address Not Allowed", mailservice.ProviderPolicy{}, httpUser("http://169.254.169.254/latest/meta-data"), false},
+		{"Case 11: Http User Of Private Address Not Allowed", mailservice.ProviderPolicy{}, httpUser("http://10.0.0.5/send"), false},
+		{"Case 12: Http User Of Localhost Not Allowed", mailservice.ProviderPolicy{}, httpUser("http://localhost:8080/send"), false},
+		{"Case 13: Http User Of Other Scheme Not Allowed", mailservice.ProviderPolicy{}, httpUser("file:///etc/passwd"), false},
+		{"Case 14: Http User Of Allowed Host Allowed", allowlist, httpUser("https://API.sendgrid.com/v3/mail/send"), true},
+		{"Case 15: Http User Of Host Not In Allowlist Not Allowed", allowlist, httpUser("https://mail.example.com/send"), false},
+	} {
+		err := c.policy.Check(c.user)
+		t.Run(c.tc, func(t *testing.T) {
+			if c.allowed && err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !c.allowed && !errors.Is(err, mailservice.ErrProviderNotAllowed) {
+				t.Errorf("Expected ErrProviderNotAllowed, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_ProviderPolicy_client(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	{
+		tc := "Case 1: Address That Is Not Public Not Dialed"
+		_, err := mailservice.ProviderPolicy{}.Client().Post(server.URL, "application/json", nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mailservice.ErrProviderNotAllowed) {
+				t.Errorf("Expected ErrProviderNotAllowed, got %v", err)
+			}
+			if sendErr := mailservice.Classify(err); sendErr == nil || sendErr.Class != mailservice.FailurePermanent {
+				t.Errorf("Expected permanent error, got %v", sendErr)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Allowed Host Dialed Whatever Its Address"
+		res, err := mailservice.ProviderPolicy{HTTPHosts: []string{"127.0.0.1"}}.Client().Post(server.URL, "application/json", nil)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || res.StatusCode != http.StatusAccepted {
+				t.Errorf("Expected accepted post, got %v %v", res, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package mailservice
+
+import (
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+)
+
+// Recipients returns the recipients of a task, the tasks enqueued before
+// recipients were tracked are sent to their RecipientEmail.
+func Recipients(task model.MailTaskQueue) []model.Recipient {
+	if len(task.Recipients) > 0 {
+		return task.Recipients
+	}
+	if task.RecipientEmail == "" {
+		return nil
+	}
+	return []model.Recipient{{Email: task.RecipientEmail, Kind: model.RecipientTo}}
+}
+
+// Pending reports whether a mail is still to be delivered to a recipient, the
+// recipients that accepted or permanently rejected it are not sent to again.
+func Pending(r model.Recipient) bool {
+	return r.Status != constant.StatusSuccess && r.Status != constant.StatusRejected
+}
+
+// Outcome returns the recipients of a task with the status of the pending ones
+// set by the error of SendMail, and the error of the task. The error is nil
+// once no recipient is pending and the mail reached at least one of them.
+//
+// Only the pooled SMTP sessions report the recipients rejected one by one,
+// the error of any other send is the error of every pending recipient.
+func Outcome(task model.MailTaskQueue, err error) ([]model.Recipient, error) {
+	recipients := append([]model.Recipient(nil), Recipients(task)...)
+	var recipientsErr *smtppool.RecipientsError
+	partial := errors.As(err, &recipientsErr)
+	var (
+		pendingErr, rejectedErr *SendError
+		delivered               bool
+	)
+	for i, r := range recipients {
+		if !Pending(r) {
+			delivered = delivered || r.Status == constant.StatusSuccess
+			continue
+		}
+		rcptErr := err
+		if partial {
+			rcptErr = recipientsErr.Rejected[r.Email]
+		}
+		if rcptErr == nil {
+			recipients[i] = model.Recipient{Email: r.Email, Kind: r.Kind, Status: constant.StatusSuccess}
+			delivered = true
+			continue
+		}
+		sendErr := Classify(rcptErr)
+		r.Status, r.Code, r.Error = constant.StatusFailed, sendErr.Code, sendErr.Error()
+		if sendErr.Class == FailurePermanent {
+			r.Status = constant.StatusRejected
+			if rejectedErr == nil {
+				rejectedErr = sendErr
+			}
+		} else if pendingErr == nil {
+			pendingErr = sendErr
+		}
+		recipients[i] = r
+	}
+	switch {
+	case !partial:
+		return recipients, err
+	case pendingErr != nil:
+		return recipients, pendingErr
+	case !delivered:
+		return recipients, rejectedErr
+	}
+	return recipients, nil
+}
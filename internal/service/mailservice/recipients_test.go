@@ -0,0 +1,88 @@
+package mailservice_test
+
+import (
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+	"net/textproto"
+	"testing"
+)
+
+func Test_Outcome(t *testing.T) {
+	task := model.MailTaskQueue{
+		RecipientEmail: "a@ex.com",
+		Recipients: []model.Recipient{
+			{Email: "a@ex.com", Kind: model.RecipientTo},
+			{Email: "b@ex.com", Kind: model.RecipientCC},
+		},
+	}
+	unknown := &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+	{
+		tc := "Case 1: Sent Mail Delivered To Every Pending Recipient"
+		recipients, err := mailservice.Outcome(task, nil)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || recipients[0].Status != constant.StatusSuccess || recipients[1].Status != constant.StatusSuccess {
+				t.Errorf("Expected delivered recipients, got %v %v", recipients, err)
+			}
+			if task.Recipients[0].Status != constant.StatusQueued {
+				t.Errorf("Expected recipients of task to be kept, got %v", task.Recipients)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Recipient Rejected For Good While Mail Sent To Others Should Return Nil"
+		sendErr := mailservice.Classify(&smtppool.RecipientsError{Sent: true, Rejected: map[string]error{"b@ex.com": unknown}})
+		recipients, err := mailservice.Outcome(task, sendErr)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || recipients[0].Status != constant.StatusSuccess {
+				t.Errorf("Expected mail to be delivered, got %v %v", recipients, err)
+			}
+			if r := recipients[1]; r.Status != constant.StatusRejected || r.Code != 550 {
+				t.Errorf("Expected rejected recipient with 550, got %v", r)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Every Recipient Rejected For Good Should Return Permanent Error"
+		sendErr := mailservice.Classify(&smtppool.RecipientsError{Rejected: map[string]error{"a@ex.com": unknown, "b@ex.com": unknown}})
+		recipients, err := mailservice.Outcome(task, sendErr)
+		t.Run(tc, func(t *testing.T) {
+			var classified *mailservice.SendError
+			if !errors.As(err, &classified) || classified.Class != mailservice.FailurePermanent || classified.Code != 550 {
+				t.Errorf("Expected permanent error, got %v", err)
+			}
+			if recipients[0].Status != constant.StatusRejected || recipients[1].Status != constant.StatusRejected {
+				t.Errorf("Expected rejected recipients, got %v", recipients)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Failed Send Fails Only Pending Recipients"
+		delivered := task
+		delivered.Recipients = []model.Recipient{
+			{Email: "a@ex.com", Kind: model.RecipientTo, Status: constant.StatusSuccess},
+			{Email: "b@ex.com", Kind: model.RecipientCC, Status: constant.StatusFailed},
+		}
+		sendErr := mailservice.Classify(&textproto.Error{Code: 421, Msg: "4.7.0 Try again later"})
+		recipients, err := mailservice.Outcome(delivered, sendErr)
+		t.Run(tc, func(t *testing.T) {
+			if err != sendErr || recipients[0].Status != constant.StatusSuccess {
+				t.Errorf("Expected send error and delivered recipient to be kept, got %v %v", recipients, err)
+			}
+			if r := recipients[1]; r.Status != constant.StatusFailed || r.Code != 421 {
+				t.Errorf("Expected failed recipient with 421, got %v", r)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Task Without Recipients Sent To Its Recipient Email"
+		recipients, err := mailservice.Outcome(model.MailTaskQueue{RecipientEmail: "a@ex.com"}, nil)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || len(recipients) != 1 || recipients[0].Email != "a@ex.com" || recipients[0].Status != constant.StatusSuccess {
+				t.Errorf("Expected delivered recipient email, got %v %v", recipients, err)
+			}
+		})
+	}
+}
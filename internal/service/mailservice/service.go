@@ -1,57 +1,89 @@
 package mailservice
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
 	"gopkg.in/gomail.v2"
-	"log"
-	"reflect"
+	"io"
+	"mime"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
 func (s *mailService) AddTask(task model.MailTaskQueue) error {
-	v := reflect.ValueOf(task)
-	t := reflect.TypeOf(task)
-	var missingFields []string
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		if field.Kind() == reflect.Struct {
-			if t.Field(i).Name == "Model" || t.Field(i).Name == "ScheduledAt" {
-				continue
-			}
-			for j := 0; j < field.NumField(); j++ {
-				if t.Field(j).Name == "Model" {
-					continue
-				}
-				if field.Field(j).IsZero() {
-					missingFields = append(missingFields, t.Field(i).Name+"."+field.Type().Field(j).Name)
-				}
-			}
-		} else {
-			if t.Field(i).Name == "Status" || t.Field(i).Name == "TryCount" || t.Field(i).Name == "CreatedAt" || t.Field(i).Name == "UpdatedAt" || t.Field(i).Name == "UserID" {
-				continue
-			}
-			if field.IsZero() {
-				missingFields = append(missingFields, t.Field(i).Name)
-			}
+	provider := ProviderOf(task.User)
+	missingFields := missingFieldsOf(task, provider)
+	switch provider {
+	case ProviderSMTP, ProviderLog:
+	case ProviderHTTP:
+		if task.User.ProviderSettings.URL == "" {
+			missingFields = append(missingFields, "User.ProviderSettings.URL")
+		}
+	case ProviderFile:
+		if task.User.ProviderSettings.Dir == "" {
+			missingFields = append(missingFields, "User.ProviderSettings.Dir")
 		}
+	default:
+		return errors.New("Unknown provider: " + provider)
 	}
 	if len(missingFields) > 0 {
 		return errors.New("Missing fields: " + strings.Join(missingFields, ", "))
 	}
+	if err := s.policy.Check(task.User); err != nil {
+		return err
+	}
+	if len(task.Attachments) > 0 && s.blobs == nil {
+		return errors.New("No blob store for the attachments")
+	}
+	s.UserID = task.UserID
 	s.From = task.User.Email
+	s.FromName = task.FromName
 	s.To = task.RecipientEmail
+	s.Recipients = Recipients(task)
+	s.ReplyTo = task.ReplyTo
 	s.Subject = task.Subject
-	s.Body = task.Body
+	s.Body = TextBody(task)
+	s.HTMLBody = task.HTMLBody
 	s.SmtpHost = task.User.SmtpHost
 	s.SmtpPort = task.User.SmtpPort
 	s.SmtpUsername = task.User.SmtpUsername
 	s.SmtpPassword = task.User.SmtpPassword
+	s.Provider = provider
+	s.Settings = task.User.ProviderSettings
+	s.Attachments = task.Attachments
 	return nil
 }
 
+// missingFieldsOf returns the fields a task can not be sent without that are
+// empty. The SMTP settings of the user are only needed by the smtp provider.
+func missingFieldsOf(task model.MailTaskQueue, provider string) []string {
+	var missing []string
+	need := func(name string, empty bool) {
+		if empty {
+			missing = append(missing, name)
+		}
+	}
+	need("User.Email", task.User.Email == "")
+	need("User.Password", task.User.Password == "")
+	if provider == ProviderSMTP {
+		need("User.SmtpHost", task.User.SmtpHost == "")
+		need("User.SmtpPort", task.User.SmtpPort == 0)
+		need("User.SmtpUsername", task.User.SmtpUsername == "")
+		need("User.SmtpPassword", task.User.SmtpPassword == "")
+	}
+	need("RecipientEmail", task.RecipientEmail == "")
+	need("Subject", task.Subject == "")
+	// A task needs one of its bodies, the text body is generated from the
+	// HTML body when it has none.
+	need("Body", task.Body == "" && task.TextBody == "" && task.HTMLBody == "")
+	return missing
+}
+
 func (s *mailService) NewDialer() *gomail.Dialer {
 	d := gomail.NewDialer(s.SmtpHost, s.SmtpPort, s.SmtpUsername, s.SmtpPassword)
 	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
@@ -60,71 +92,214 @@ func (s *mailService) NewDialer() *gomail.Dialer {
 
 func (s *mailService) NewMessage() *gomail.Message {
 	m := gomail.NewMessage()
-	m.SetHeader("From", s.From)
-	m.SetHeader("To", s.To)
+	if s.FromName != "" {
+		m.SetHeader("From", m.FormatAddress(s.From, s.FromName))
+	} else {
+		m.SetHeader("From", s.From)
+	}
+	// The BCC recipients are only in the envelope.
+	m.SetHeader("To", s.recipients(model.RecipientTo, false)...)
+	if cc := s.recipients(model.RecipientCC, false); len(cc) > 0 {
+		m.SetHeader("Cc", cc...)
+	}
+	if s.ReplyTo != "" {
+		m.SetHeader("Reply-To", s.ReplyTo)
+	}
 	m.SetHeader("Subject", s.Subject)
 	m.SetBody("text/plain", s.Body)
+	if s.HTMLBody != "" {
+		m.AddAlternative("text/html", s.HTMLBody)
+	}
+	// The attachments are streamed from the blob store as the message is written.
+	for _, a := range s.attachments() {
+		open := a.Open
+		m.Attach(a.Filename,
+			gomail.SetHeader(map[string][]string{
+				"Content-Type":        {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Filename})},
+				"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				r, err := open()
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				_, err = io.Copy(w, r)
+				return err
+			}),
+		)
+	}
 	return m
 }
 
-func (s *mailService) throwRandomError() error {
-	num := time.Now().Nanosecond()
-	if num%2 == 0 {
-		return nil
+// recipients returns the addresses of the recipients of a kind, only the ones
+// the mail is still to be delivered to when pending is set.
+func (s *mailService) recipients(kind string, pending bool) []string {
+	var addrs []string
+	for _, r := range s.Recipients {
+		if r.Kind == kind && (!pending || Pending(r)) {
+			addrs = append(addrs, r.Email)
+		}
 	}
-	return errors.New("Random error")
+	return addrs
 }
 
-func (s *mailService) SendMail(d Dialer, m *gomail.Message) error {
-	if err := s.throwRandomError(); err != nil {
-		return err
+// attachments returns the attachments of the mail, read from the blob store.
+func (s *mailService) attachments() []Attachment {
+	var attachments []Attachment
+	for _, a := range s.Attachments {
+		key := a.BlobKey
+		attachments = append(attachments, Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Open: func() (io.ReadCloser, error) {
+				return s.blobs.Open(context.Background(), key)
+			},
+		})
 	}
-	ch := make(chan *gomail.Message)
-	errChan := make(chan error, 1)
-	go func() {
-		var s gomail.SendCloser
-		var err error
-		open := false
-		for {
-			select {
-			case m, ok := <-ch:
-				if !ok {
-					close(errChan)
-					return
-				}
-				if !open {
-					if s, err = d.Dial(); err != nil {
-						log.Print(err)
-						errChan <- err
-						close(errChan)
-						return
-					}
-					open = true
-				}
-				if err := gomail.Send(s, m); err != nil {
-					log.Print(err)
-					errChan <- err
-				}
-			// Close the connection to the SMTP server if no email was sent in
-			// the last 30 seconds.
-			case <-time.After(30 * time.Second):
-				if open {
-					if err := s.Close(); err != nil {
-						log.Print(err)
-						errChan <- err
-						close(errChan)
-						return
-					}
-					open = false
-				}
+	return attachments
+}
+
+// NewMail returns the mail of the task with its message and attachments.
+func (s *mailService) NewMail() Mail {
+	return Mail{
+		From:        s.From,
+		FromName:    s.FromName,
+		To:          s.recipients(model.RecipientTo, true),
+		CC:          s.recipients(model.RecipientCC, true),
+		BCC:         s.recipients(model.RecipientBCC, true),
+		ReplyTo:     s.ReplyTo,
+		Subject:     s.Subject,
+		Body:        s.Body,
+		HTMLBody:    s.HTMLBody,
+		Attachments: s.attachments(),
+		Message:     s.NewMessage(),
+	}
+}
+
+// NewTransport returns the transport of the provider of the user. The smtp
+// transport takes its sessions from the pool of the service when it has one,
+// closing a session returns it to the pool.
+func (s *mailService) NewTransport() Transport {
+	switch s.Provider {
+	case ProviderHTTP:
+		return HTTPTransport(s.client, s.Settings.URL, s.Settings.APIKey)
+	case ProviderFile:
+		return FileTransport(s.policy.fileDir(s.Settings.Dir))
+	case ProviderLog:
+		return LogTransport()
+	}
+	dialer := s.NewDialer()
+	if s.pool != nil {
+		return SMTPTransport(pooledDialer{pool: s.pool, dialer: dialer})
+	}
+	return SMTPTransport(dialer)
+}
+
+// pooledDialer takes the sessions of a dialer from a pool.
+type pooledDialer struct {
+	pool   smtppool.Pool
+	dialer *gomail.Dialer
+}
+
+func (d pooledDialer) Dial() (gomail.SendCloser, error) {
+	return d.pool.Get(d.dialer)
+}
+
+// SendMail delivers a mail with the transport and classifies its errors. A
+// mail without recipients left to deliver to is not sent. The recipients that
+// get an injected fault are left out of the send and reported as rejected, as
+// a pooled session reports the recipients the server rejected.
+func (s *mailService) SendMail(t Transport, mail Mail) error {
+	if !mail.HasRecipients() {
+		return nil
+	}
+	var faulted map[string]error
+	if s.faults != nil {
+		mail, faulted = s.injectFaults(mail)
+	}
+	var err error
+	if mail.HasRecipients() {
+		err = t.Send(mail)
+	}
+	if len(faulted) > 0 {
+		err = withFaults(err, faulted, mail.HasRecipients())
+	}
+	if err != nil {
+		return Classify(err)
+	}
+	return nil
+}
+
+// injectFaults rolls for a fault of every recipient of a mail. It returns the
+// mail without the recipients that got a fault and their faults.
+func (s *mailService) injectFaults(mail Mail) (Mail, map[string]error) {
+	faulted := make(map[string]error)
+	keep := func(addrs []string) []string {
+		var kept []string
+		for _, addr := range addrs {
+			if err := s.faults.Inject(s.UserID, addr); err != nil {
+				faulted[addr] = err
+				continue
 			}
+			kept = append(kept, addr)
 		}
-	}()
+		return kept
+	}
+	mail.To, mail.CC, mail.BCC = keep(mail.To), keep(mail.CC), keep(mail.BCC)
+	return mail, faulted
+}
 
-	// Use the channel in your program to send emails.
-	ch <- m
-	// Close the channel to stop the mail daemon.
-	close(ch)
-	err := <-errChan
+// withFaults adds the faults of recipients to the error of the send to the
+// other recipients. A send that failed as a whole keeps its error, it is the
+// error of every recipient.
+func withFaults(err error, faulted map[string]error, sent bool) error {
+	var recipientsErr *smtppool.RecipientsError
+	switch {
+	case err == nil:
+		return &smtppool.RecipientsError{Rejected: faulted, Sent: sent}
+	case errors.As(err, &recipientsErr):
+		for addr, fault := range faulted {
+			recipientsErr.Rejected[addr] = fault
+		}
+	}
 	return err
 }
+
+// ProviderOf returns the provider of a user, smtp when it is not set.
+func ProviderOf(user model.User) string {
+	if user.Provider == "" {
+		return ProviderSMTP
+	}
+	return user.Provider
+}
+
+// Endpoint returns the endpoint of a user, the host and port its mails are sent
+// to. The endpoints of the file and log providers are the provider and its directory.
+func Endpoint(user model.User) string {
+	switch ProviderOf(user) {
+	case ProviderHTTP:
+		if u, err := url.Parse(user.ProviderSettings.URL); err == nil && u.Host != "" {
+			return u.Host
+		}
+		return user.ProviderSettings.URL
+	case ProviderFile:
+		return ProviderFile + ":" + user.ProviderSettings.Dir
+	case ProviderLog:
+		return ProviderLog
+	}
+	return net.JoinHostPort(user.SmtpHost, strconv.Itoa(user.SmtpPort))
+}
+
+// Host returns the host the mails of a user are rate limited by.
+func Host(user model.User) string {
+	switch ProviderOf(user) {
+	case ProviderSMTP:
+		return user.SmtpHost
+	case ProviderHTTP:
+		if u, err := url.Parse(user.ProviderSettings.URL); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	return Endpoint(user)
+}
@@ -1,8 +1,20 @@
 package mailservice_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/faults"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+	"net/textproto"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -72,6 +84,118 @@ func Test_mailService_AddTask(t *testing.T) {
 			}
 		})
 	}
+	{
+		tc := "Case 4: Provider Without Smtp Fields Should Return Success"
+		mockService := mailservice.New()
+		task := model.MailTaskQueue{
+			User: model.User{
+				Password:         "test",
+				Email:            "test@test.com",
+				Provider:         mailservice.ProviderHTTP,
+				ProviderSettings: model.ProviderSettings{URL: "http://mail.test.com/v3/mail/send"},
+			},
+			UserID:         1,
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			Body:           "Test",
+		}
+		err := mockService.AddTask(task)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected error to be nil but got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Missing Provider Settings And Should Return Error"
+		mockService := mailservice.New()
+		task := model.MailTaskQueue{
+			User: model.User{
+				Password: "test",
+				Email:    "test@test.com",
+				Provider: mailservice.ProviderFile,
+			},
+			UserID:         1,
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			Body:           "Test",
+		}
+		err := mockService.AddTask(task)
+		want := "Missing fields: User.ProviderSettings.Dir"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || err.Error() != want {
+				t.Errorf("Expected error to be %s but got %v", want, err)
+			}
+		})
+	}
+	{
+		tc := "Case 6: Unknown Provider And Should Return Error"
+		mockService := mailservice.New()
+		task := model.MailTaskQueue{
+			User: model.User{
+				Password: "test",
+				Email:    "test@test.com",
+				Provider: "carrier-pigeon",
+			},
+			UserID:         1,
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			Body:           "Test",
+		}
+		err := mockService.AddTask(task)
+		want := "Unknown provider: carrier-pigeon"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || err.Error() != want {
+				t.Errorf("Expected error to be %s but got %v", want, err)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Task With Only HTML Body Should Return Success"
+		mockService := mailservice.New()
+		task := model.MailTaskQueue{
+			User: model.User{
+				Password:     "test",
+				Email:        "test@test.com",
+				SmtpHost:     "smtp.test.com",
+				SmtpPort:     587,
+				SmtpUsername: "test",
+				SmtpPassword: "test",
+			},
+			UserID:         1,
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			HTMLBody:       "<p>Test</p>",
+		}
+		err := mockService.AddTask(task)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected error to be nil but got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 8: Task With Attachments And No Blob Store Should Return Error"
+		mockService := mailservice.New(mailservice.WithProviderPolicy(mailservice.ProviderPolicy{DevProviders: true}))
+		task := model.MailTaskQueue{
+			User: model.User{
+				Password: "test",
+				Email:    "test@test.com",
+				Provider: mailservice.ProviderLog,
+			},
+			UserID:         1,
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			Body:           "Test",
+			Attachments:    []model.MailAttachment{{Filename: "invoice.pdf", BlobKey: "key"}},
+		}
+		err := mockService.AddTask(task)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error but got nil")
+			}
+		})
+	}
 }
 
 func Test_mailService_NewDialer(t *testing.T) {
@@ -127,6 +251,120 @@ func Test_mailService_NewMessage(t *testing.T) {
 			}
 		})
 	}
+	{
+		tc := "Case 4: New message of text task should return text only message"
+		var buf bytes.Buffer
+		mockService.NewMessage().WriteTo(&buf)
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), "Content-Type: text/plain") || strings.Contains(buf.String(), "multipart") {
+				t.Errorf("expected text only message, got %s", buf.String())
+			}
+		})
+	}
+	{
+		tc := "Case 5: New message of HTML task should return multipart alternative message with text fallback"
+		htmlService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com"},
+				RecipientEmail: "example@ex.com",
+				Subject:        "Test",
+				HTMLBody:       "<p>Hello <b>there</b></p>",
+			}),
+		)
+		var buf bytes.Buffer
+		htmlService.NewMessage().WriteTo(&buf)
+		msg := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(msg, "Content-Type: multipart/alternative") {
+				t.Errorf("expected multipart alternative message, got %s", msg)
+			}
+			text, html := strings.Index(msg, "Content-Type: text/plain"), strings.Index(msg, "Content-Type: text/html")
+			if text < 0 || html < text || !strings.Contains(msg, "Hello there") || !strings.Contains(msg, "<p>Hello <b>there</b></p>") {
+				t.Errorf("expected text fallback before HTML body, got %s", msg)
+			}
+		})
+	}
+	{
+		tc := "Case 6: New message with attachment should stream it from blob store with its content type"
+		store := blobstore.New(blobstore.WithDir(t.TempDir()))
+		store.Put(context.Background(), "key", strings.NewReader("id,total\n1,10\n"))
+		attachmentService := mailservice.New(
+			mailservice.WithBlobStore(store),
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com"},
+				RecipientEmail: "example@ex.com",
+				Subject:        "Test",
+				Body:           "Report attached",
+				Attachments:    []model.MailAttachment{{Filename: "report.csv", ContentType: "text/csv", BlobKey: "key"}},
+			}),
+		)
+		var buf bytes.Buffer
+		_, err := attachmentService.NewMessage().WriteTo(&buf)
+		msg := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || !strings.Contains(msg, "Content-Type: multipart/mixed") {
+				t.Fatalf("expected multipart mixed message, got %s %v", msg, err)
+			}
+			if !strings.Contains(msg, "Content-Type: text/csv; name=report.csv") ||
+				!strings.Contains(msg, "Content-Disposition: attachment; filename=report.csv") {
+				t.Errorf("expected csv attachment headers, got %s", msg)
+			}
+			if !strings.Contains(msg, base64.StdEncoding.EncodeToString([]byte("id,total\n1,10\n"))) {
+				t.Errorf("expected attachment content, got %s", msg)
+			}
+		})
+	}
+	{
+		tc := "Case 7: New message with missing attachment blob should fail to write"
+		attachmentService := mailservice.New(
+			mailservice.WithBlobStore(blobstore.New(blobstore.WithDir(t.TempDir()))),
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com"},
+				RecipientEmail: "example@ex.com",
+				Subject:        "Test",
+				Body:           "Report attached",
+				Attachments:    []model.MailAttachment{{Filename: "report.csv", ContentType: "text/csv", BlobKey: "missing"}},
+			}),
+		)
+		_, err := attachmentService.NewMessage().WriteTo(&bytes.Buffer{})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, blobstore.ErrNotFound) {
+				t.Errorf("expected blob not found, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 8: New message should list to and cc recipients with display name and reply to but hide bcc"
+		recipientsService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com"},
+				RecipientEmail: "a@ex.com",
+				Recipients: []model.Recipient{
+					{Email: "a@ex.com", Kind: model.RecipientTo, Status: constant.StatusSuccess},
+					{Email: "b@ex.com", Kind: model.RecipientTo},
+					{Email: "c@ex.com", Kind: model.RecipientCC},
+					{Email: "d@ex.com", Kind: model.RecipientBCC},
+				},
+				FromName: "Billing Team",
+				ReplyTo:  "support@test.com",
+				Subject:  "Test",
+				Body:     "Test",
+			}),
+		)
+		var buf bytes.Buffer
+		recipientsService.NewMessage().WriteTo(&buf)
+		msg := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			for _, header := range []string{`From: "Billing Team" <test@test.com>`, "To: a@ex.com, b@ex.com", "Cc: c@ex.com", "Reply-To: support@test.com"} {
+				if !strings.Contains(msg, header) {
+					t.Errorf("expected header %s, got %s", header, msg)
+				}
+			}
+			if strings.Contains(msg, "d@ex.com") {
+				t.Errorf("expected bcc recipient to be hidden, got %s", msg)
+			}
+		})
+	}
 }
 
 func Test_mailService_SendMail(t *testing.T) {
@@ -147,7 +385,7 @@ func Test_mailService_SendMail(t *testing.T) {
 		)
 		tc := "Case 1: Send mail should return error when dialer returns error"
 		dialer := &mockDialer{errDial: ErrorDialer, sender: mockSender{}}
-		err := mockService.SendMail(dialer, mockService.NewMessage())
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
 		t.Run(tc, func(t *testing.T) {
 			if err == nil {
 				t.Errorf("Expected error to be not nil but got nil")
@@ -171,7 +409,7 @@ func Test_mailService_SendMail(t *testing.T) {
 		)
 		tc := "Case 2: Send mail should return error when sender returns error"
 		dialer := &mockDialer{errDial: nil, sender: mockSender{errSend: ErrorSenders, errClose: ErrorSenders}}
-		err := mockService.SendMail(dialer, mockService.NewMessage())
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
 		t.Run(tc, func(t *testing.T) {
 			if err == nil {
 				t.Errorf("Expected error to be not nil but got nil")
@@ -195,9 +433,9 @@ func Test_mailService_SendMail(t *testing.T) {
 		)
 		tc := "Case 3: Send mail should return nil when dialer and sender are successful"
 		dialer := &mockDialer{errDial: nil, sender: mockSender{}}
-		err := mockService.SendMail(dialer, mockService.NewMessage())
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
 		t.Run(tc, func(t *testing.T) {
-			if err != nil && err.Error() != "Random error" {
+			if err != nil {
 				t.Errorf("Expected error to be nil but got %v", err)
 			}
 		})
@@ -219,11 +457,175 @@ func Test_mailService_SendMail(t *testing.T) {
 		)
 		tc := "Case 4: Send mail should return error when Close returns error"
 		dialer := &mockDialer{errDial: nil, sender: mockSender{errClose: ErrorSenders, errSend: ErrorSenders}}
-		err := mockService.SendMail(dialer, mockService.NewMessage())
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
 		t.Run(tc, func(t *testing.T) {
 			if err == nil {
 				t.Errorf("Expected error to be not nil but got nil")
 			}
 		})
 	}
+	{
+		pool := &mockPool{}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User: model.User{
+					Email:        "test@test.com",
+					SmtpHost:     "smtp.test.com",
+					SmtpPort:     587,
+					SmtpUsername: "test",
+					SmtpPassword: "test",
+				},
+				RecipientEmail: "example@ex.com",
+				Subject:        "Test",
+				Body:           "Test",
+			}),
+			mailservice.WithPool(pool),
+		)
+		tc := "Case 5: Send mail should take session of new smtp transport from pool and return it"
+		err := mockService.SendMail(mockService.NewTransport(), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected error to be nil but got %v", err)
+			}
+			if pool.gets != 1 || pool.closed != 1 {
+				t.Errorf("Expected session to be taken from pool and returned, got %d gets and %d returns", pool.gets, pool.closed)
+			}
+		})
+	}
+	{
+		pool := &mockPool{sender: mockSender{errSend: &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}}}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User: model.User{
+					Email:        "test@test.com",
+					SmtpHost:     "smtp.test.com",
+					SmtpPort:     587,
+					SmtpUsername: "test",
+					SmtpPassword: "test",
+				},
+				RecipientEmail: "example@ex.com",
+				Subject:        "Test",
+				Body:           "Test",
+			}),
+			mailservice.WithPool(pool),
+		)
+		tc := "Case 6: Send mail should classify error of pooled session and return session"
+		err := mockService.SendMail(mockService.NewTransport(), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			var sendErr *mailservice.SendError
+			if !errors.As(err, &sendErr) || sendErr.Class != mailservice.FailurePermanent || pool.closed != 1 {
+				t.Errorf("Expected permanent error and returned session but got %v", err)
+			}
+		})
+	}
+	{
+		pool := &mockPool{}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User: model.User{
+					Email:        "test@test.com",
+					SmtpHost:     "smtp.test.com",
+					SmtpPort:     587,
+					SmtpUsername: "test",
+					SmtpPassword: "test",
+				},
+				UserID:         1,
+				RecipientEmail: "example@chaos.test",
+				Subject:        "Test",
+				Body:           "Test",
+			}),
+			mailservice.WithPool(pool),
+			mailservice.WithFaults(faults.New(
+				faults.WithRates(faults.Rates{Transient: 1}),
+				faults.WithUsers(1),
+				faults.WithRecipients(regexp.MustCompile(`@chaos\.test$`)),
+			)),
+		)
+		tc := "Case 7: Send mail should fail with injected fault of targeted mail before taking a session"
+		err := mockService.SendMail(mockService.NewTransport(), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			var sendErr *mailservice.SendError
+			if !errors.As(err, &sendErr) || sendErr.Class != mailservice.FailureTransient || sendErr.Code != 451 || !errors.Is(err, faults.ErrInjected) {
+				t.Errorf("Expected injected transient error but got %v", err)
+			}
+			if pool.gets != 0 {
+				t.Errorf("Expected no session to be taken, got %d gets", pool.gets)
+			}
+		})
+	}
+	{
+		dialer := &mockDialer{}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com", SmtpHost: "smtp.test.com", SmtpPort: 587},
+				RecipientEmail: "a@ex.com",
+				Recipients: []model.Recipient{
+					{Email: "a@ex.com", Kind: model.RecipientTo, Status: constant.StatusSuccess},
+					{Email: "b@ex.com", Kind: model.RecipientTo, Status: constant.StatusFailed},
+					{Email: "c@ex.com", Kind: model.RecipientCC, Status: constant.StatusRejected},
+					{Email: "d@ex.com", Kind: model.RecipientBCC},
+				},
+				Subject: "Test",
+				Body:    "Test",
+			}),
+		)
+		tc := "Case 8: Send mail should deliver to pending recipients including bcc"
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || !reflect.DeepEqual(dialer.sender.to, []string{"b@ex.com", "d@ex.com"}) {
+				t.Errorf("Expected mail to b@ex.com and d@ex.com but got %v %v", dialer.sender.to, err)
+			}
+		})
+	}
+	{
+		pool := &mockPool{}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com", SmtpHost: "smtp.test.com", SmtpPort: 587},
+				RecipientEmail: "a@ex.com",
+				Recipients:     []model.Recipient{{Email: "a@ex.com", Kind: model.RecipientTo, Status: constant.StatusSuccess}},
+				Subject:        "Test",
+				Body:           "Test",
+			}),
+			mailservice.WithPool(pool),
+		)
+		tc := "Case 9: Send mail should not send mail without pending recipients"
+		err := mockService.SendMail(mockService.NewTransport(), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || pool.gets != 0 {
+				t.Errorf("Expected no session to be taken, got %d gets %v", pool.gets, err)
+			}
+		})
+	}
+	{
+		dialer := &mockDialer{}
+		mockService := mailservice.New(
+			mailservice.WithTask(model.MailTaskQueue{
+				User:           model.User{Email: "test@test.com", SmtpHost: "smtp.test.com", SmtpPort: 587},
+				UserID:         1,
+				RecipientEmail: "a@ex.com",
+				Recipients: []model.Recipient{
+					{Email: "a@ex.com", Kind: model.RecipientTo},
+					{Email: "b@chaos.test", Kind: model.RecipientCC},
+				},
+				Subject: "Test",
+				Body:    "Test",
+			}),
+			mailservice.WithFaults(faults.New(
+				faults.WithRates(faults.Rates{Permanent: 1}),
+				faults.WithRecipients(regexp.MustCompile(`@chaos\.test$`)),
+			)),
+		)
+		tc := "Case 10: Send mail should reject targeted recipient with injected fault and deliver to the others"
+		err := mockService.SendMail(mailservice.SMTPTransport(dialer), mockService.NewMail())
+		t.Run(tc, func(t *testing.T) {
+			if !reflect.DeepEqual(dialer.sender.to, []string{"a@ex.com"}) {
+				t.Errorf("Expected mail to a@ex.com but got %v", dialer.sender.to)
+			}
+			var recipientsErr *smtppool.RecipientsError
+			if !errors.As(err, &recipientsErr) || !recipientsErr.Sent || len(recipientsErr.Rejected) != 1 || !errors.Is(recipientsErr.Rejected["b@chaos.test"], faults.ErrInjected) {
+				t.Errorf("Expected b@chaos.test rejected with injected fault but got %v", err)
+			}
+		})
+	}
 }
@@ -0,0 +1,254 @@
+package mailservice
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpTransport sends the messages over the sessions of a dialer.
+type smtpTransport struct {
+	dialer Dialer
+}
+
+// SMTPTransport returns a transport that sends the messages over a session of the dialer.
+func SMTPTransport(d Dialer) Transport {
+	return smtpTransport{dialer: d}
+}
+
+// Send sends the message to the To, CC and BCC recipients of the mail, the
+// envelope is not taken from the headers so the BCC recipients are reached and
+// the recipients that already got the mail are not sent it again.
+func (t smtpTransport) Send(mail Mail) error {
+	sender, err := t.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	if err := sender.Send(mail.From, envelopeOf(mail), mail.Message); err != nil {
+		sender.Close()
+		return err
+	}
+	// The mail is sent, a failing close must not send it again.
+	if err := sender.Close(); err != nil {
+		log.Print(err)
+	}
+	return nil
+}
+
+// envelopeOf returns the addresses a mail is delivered to.
+func envelopeOf(mail Mail) []string {
+	envelope := make([]string, 0, len(mail.To)+len(mail.CC)+len(mail.BCC))
+	envelope = append(envelope, mail.To...)
+	envelope = append(envelope, mail.CC...)
+	return append(envelope, mail.BCC...)
+}
+
+// httpTransport posts the mails to the JSON API of a provider.
+type httpTransport struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+// HTTPTransport returns a transport that posts the mails to the url in the
+// shape of the SendGrid v3 mail send API, the api key is sent as a bearer token.
+func HTTPTransport(client *http.Client, url, apiKey string) Transport {
+	return httpTransport{client: client, url: url, apiKey: apiKey}
+}
+
+// httpMail is the body of a request of the http transport.
+type httpMail struct {
+	Personalizations []httpPersonalization `json:"personalizations"`
+	From             httpAddress           `json:"from"`
+	ReplyTo          *httpAddress          `json:"reply_to,omitempty"`
+	Subject          string                `json:"subject"`
+	Content          []httpContent         `json:"content"`
+	Attachments      []httpAttachment      `json:"attachments,omitempty"`
+}
+
+type httpPersonalization struct {
+	To  []httpAddress `json:"to"`
+	CC  []httpAddress `json:"cc,omitempty"`
+	BCC []httpAddress `json:"bcc,omitempty"`
+}
+
+type httpAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type httpContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// httpAttachment is an attachment with its content in base64.
+type httpAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+}
+
+// Send posts the mail, any 2xx status is a sent mail. The other statuses are
+// returned as classified errors with the status as their code and without the
+// body of the response.
+func (t httpTransport) Send(mail Mail) error {
+	attachments, err := attachmentsOf(mail)
+	if err != nil {
+		return err
+	}
+	personalization := httpPersonalization{
+		To:  addressesOf(mail.To),
+		CC:  addressesOf(mail.CC),
+		BCC: addressesOf(mail.BCC),
+	}
+	var replyTo *httpAddress
+	if mail.ReplyTo != "" {
+		replyTo = &httpAddress{Email: mail.ReplyTo}
+	}
+	body, err := json.Marshal(httpMail{
+		Personalizations: []httpPersonalization{personalization},
+		From:             httpAddress{Email: mail.From, Name: mail.FromName},
+		ReplyTo:          replyTo,
+		Subject:          mail.Subject,
+		Content:          contentOf(mail),
+		Attachments:      attachments,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	// The body is not kept, it is the provider's and may echo the request.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	return &SendError{
+		Class: httpClassOf(res.StatusCode),
+		Code:  res.StatusCode,
+		Err:   fmt.Errorf("http provider: %s", res.Status),
+	}
+}
+
+// addressesOf returns the addresses of the API, nil for no address.
+func addressesOf(emails []string) []httpAddress {
+	var addresses []httpAddress
+	for _, email := range emails {
+		addresses = append(addresses, httpAddress{Email: email})
+	}
+	return addresses
+}
+
+// contentOf returns the bodies of a mail, the text body first as the providers require.
+func contentOf(mail Mail) []httpContent {
+	content := []httpContent{{Type: "text/plain", Value: mail.Body}}
+	if mail.HTMLBody != "" {
+		content = append(content, httpContent{Type: "text/html", Value: mail.HTMLBody})
+	}
+	return content
+}
+
+// attachmentsOf reads the attachments of a mail, the API takes their content inline.
+func attachmentsOf(mail Mail) ([]httpAttachment, error) {
+	var attachments []httpAttachment
+	for _, a := range mail.Attachments {
+		r, err := a.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, httpAttachment{
+			Content:     base64.StdEncoding.EncodeToString(content),
+			Type:        a.ContentType,
+			Filename:    a.Filename,
+			Disposition: "attachment",
+		})
+	}
+	return attachments, nil
+}
+
+// httpClassOf returns the failure class of an HTTP status. Rejected keys pause
+// the user like rejected SMTP credentials, throttling and server errors are retried.
+func httpClassOf(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return FailureAuth
+	case status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500:
+		return FailureTransient
+	}
+	return FailurePermanent
+}
+
+// fileTransport writes the messages to a directory.
+type fileTransport struct {
+	dir string
+}
+
+// FileTransport returns a transport that writes the messages as RFC 5322 .eml
+// files to the directory, the directory is created when it does not exist.
+func FileTransport(dir string) Transport {
+	return fileTransport{dir: dir}
+}
+
+// Send writes the message to a temporary file that is renamed once it is
+// complete, so readers of the directory never see a partial message.
+func (t fileTransport) Send(mail Mail) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(t.dir, time.Now().UTC().Format("20060102T150405")+"-*.eml.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := mail.Message.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), strings.TrimSuffix(f.Name(), ".tmp"))
+}
+
+// logTransport logs the mails instead of sending them.
+type logTransport struct{}
+
+// LogTransport returns a transport that only logs the mails, for development.
+func LogTransport() Transport {
+	return logTransport{}
+}
+
+func (logTransport) Send(mail Mail) error {
+	log.Printf("mail from %s to %s with subject %q:\n%s", mail.From, strings.Join(envelopeOf(mail), ", "), mail.Subject, mail.Body)
+	for _, a := range mail.Attachments {
+		log.Printf("attachment %s (%s)", a.Filename, a.ContentType)
+	}
+	return nil
+}
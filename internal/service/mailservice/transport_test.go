@@ -0,0 +1,250 @@
+package mailservice_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newMail() mailservice.Mail {
+	return mailservice.New(
+		mailservice.WithTask(model.MailTaskQueue{
+			User:           model.User{Email: "test@test.com"},
+			RecipientEmail: "example@ex.com",
+			Subject:        "Test",
+			Body:           "Hello",
+		}),
+	).NewMail()
+}
+
+func Test_HTTPTransport_Send(t *testing.T) {
+	var (
+		status  int
+		auth    string
+		payload map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		payload = nil
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+		w.Write([]byte(`{"errors":[{"message":"error"}]}`))
+	}))
+	defer server.Close()
+	transport := mailservice.HTTPTransport(server.Client(), server.URL, "key")
+	{
+		tc := "Case 1: Accepted Mail Posted With Key And Should Return Nil"
+		status = http.StatusAccepted
+		err := transport.Send(newMail())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if auth != "Bearer key" {
+				t.Errorf("Expected bearer key, got %s", auth)
+			}
+			body, _ := json.Marshal(payload)
+			want := `{"content":[{"type":"text/plain","value":"Hello"}],"from":{"email":"test@test.com"},` +
+				`"personalizations":[{"to":[{"email":"example@ex.com"}]}],"subject":"Test"}`
+			if string(body) != want {
+				t.Errorf("Expected %s, got %s", want, body)
+			}
+		})
+	}
+	for _, c := range []struct {
+		tc     string
+		status int
+		class  string
+	}{
+		{"Case 2: Throttled Request Should Return Transient Error", http.StatusTooManyRequests, mailservice.FailureTransient},
+		{"Case 3: Rejected Key Should Return Auth Error", http.StatusUnauthorized, mailservice.FailureAuth},
+		{"Case 4: Rejected Mail Should Return Permanent Error", http.StatusBadRequest, mailservice.FailurePermanent},
+	} {
+		status = c.status
+		err := transport.Send(newMail())
+		t.Run(c.tc, func(t *testing.T) {
+			var sendErr *mailservice.SendError
+			if !errors.As(err, &sendErr) || sendErr.Class != c.class || sendErr.Code != c.status {
+				t.Errorf("Expected %s error with code %d, got %v", c.class, c.status, err)
+			}
+			if err != nil && strings.Contains(err.Error(), "errors") {
+				t.Errorf("Expected error without the response body, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Unreachable Provider Should Return Connection Error"
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		err := mailservice.HTTPTransport(http.DefaultClient, closed.URL, "").Send(newMail())
+		t.Run(tc, func(t *testing.T) {
+			if sendErr := mailservice.Classify(err); sendErr == nil || sendErr.Class != mailservice.FailureConnection {
+				t.Errorf("Expected connection error, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 6: HTML Mail Posted With Text And HTML Content"
+		status = http.StatusAccepted
+		mail := newMail()
+		mail.HTMLBody = "<p>Hello</p>"
+		err := transport.Send(mail)
+		t.Run(tc, func(t *testing.T) {
+			body, _ := json.Marshal(payload["content"])
+			want := `[{"type":"text/plain","value":"Hello"},{"type":"text/html","value":"\u003cp\u003eHello\u003c/p\u003e"}]`
+			if err != nil || string(body) != want {
+				t.Errorf("Expected %s, got %s %v", want, body, err)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Mail Posted With Attachments In Base64"
+		status = http.StatusAccepted
+		mail := newMail()
+		mail.Attachments = []mailservice.Attachment{{
+			Filename:    "report.csv",
+			ContentType: "text/csv",
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader("id")), nil
+			},
+		}}
+		err := transport.Send(mail)
+		t.Run(tc, func(t *testing.T) {
+			body, _ := json.Marshal(payload["attachments"])
+			want := `[{"content":"aWQ=","disposition":"attachment","filename":"report.csv","type":"text/csv"}]`
+			if err != nil || string(body) != want {
+				t.Errorf("Expected %s, got %s %v", want, body, err)
+			}
+		})
+	}
+	{
+		tc := "Case 8: Mail Posted With Cc, Bcc, Reply To And Sender Name"
+		status = http.StatusAccepted
+		mail := newMail()
+		mail.FromName = "Billing Team"
+		mail.CC = []string{"cc@ex.com"}
+		mail.BCC = []string{"bcc@ex.com"}
+		mail.ReplyTo = "support@test.com"
+		err := transport.Send(mail)
+		t.Run(tc, func(t *testing.T) {
+			body, _ := json.Marshal(payload)
+			for _, want := range []string{
+				`"from":{"email":"test@test.com","name":"Billing Team"}`,
+				`"personalizations":[{"bcc":[{"email":"bcc@ex.com"}],"cc":[{"email":"cc@ex.com"}],"to":[{"email":"example@ex.com"}]}]`,
+				`"reply_to":{"email":"support@test.com"}`,
+			} {
+				if err != nil || !strings.Contains(string(body), want) {
+					t.Errorf("Expected %s, got %s %v", want, body, err)
+				}
+			}
+		})
+	}
+}
+
+func Test_FileTransport_Send(t *testing.T) {
+	{
+		tc := "Case 1: Message Written As Eml File To Created Directory"
+		dir := filepath.Join(t.TempDir(), "mails")
+		err := mailservice.FileTransport(dir).Send(newMail())
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			if len(files) != 1 || filepath.Ext(files[0]) != ".eml" {
+				t.Fatalf("Expected a single eml file, got %v", files)
+			}
+			data, _ := os.ReadFile(files[0])
+			if !strings.Contains(string(data), "Subject: Test") || !strings.Contains(string(data), "To: example@ex.com") {
+				t.Errorf("Expected message headers in file, got %s", data)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Directory That Can Not Be Created Should Return Error"
+		file := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(file, nil, 0o644)
+		err := mailservice.FileTransport(filepath.Join(file, "mails")).Send(newMail())
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func Test_LogTransport_Send(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	tc := "Case 1: Mail Logged And Should Return Nil"
+	err := mailservice.LogTransport().Send(newMail())
+	t.Run(tc, func(t *testing.T) {
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if !strings.Contains(buf.String(), "mail from test@test.com to example@ex.com") {
+			t.Errorf("Expected mail to be logged, got %s", buf.String())
+		}
+	})
+}
+
+func Test_mailService_NewTransport(t *testing.T) {
+	tc := "Case 1: Mail Of File Provider User Written To Its Directory Inside The Provider Directory"
+	dir := t.TempDir()
+	mockService := mailservice.New(mailservice.WithProviderPolicy(mailservice.ProviderPolicy{DevProviders: true, FileDir: dir}))
+	err := mockService.AddTask(model.MailTaskQueue{
+		User: model.User{
+			Password:         "test",
+			Email:            "test@test.com",
+			Provider:         mailservice.ProviderFile,
+			ProviderSettings: model.ProviderSettings{Dir: "user-1"},
+		},
+		UserID:         1,
+		RecipientEmail: "example@ex.com",
+		Subject:        "Test",
+		Body:           "Test",
+	})
+	if err == nil {
+		err = mockService.SendMail(mockService.NewTransport(), mockService.NewMail())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "user-1", "*.eml"))
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected a single eml file, got %v %v", files, err)
+		}
+	})
+}
+
+func Test_Endpoint(t *testing.T) {
+	for _, c := range []struct {
+		tc       string
+		user     model.User
+		endpoint string
+		host     string
+	}{
+		{"Case 1: Smtp User", model.User{SmtpHost: "smtp.test.com", SmtpPort: 587}, "smtp.test.com:587", "smtp.test.com"},
+		{"Case 2: Http User", model.User{Provider: mailservice.ProviderHTTP,
+			ProviderSettings: model.ProviderSettings{URL: "https://api.test.com:8443/v3/mail/send"}}, "api.test.com:8443", "api.test.com"},
+		{"Case 3: File User", model.User{Provider: mailservice.ProviderFile,
+			ProviderSettings: model.ProviderSettings{Dir: "/var/mail"}}, "file:/var/mail", "file:/var/mail"},
+		{"Case 4: Log User", model.User{Provider: mailservice.ProviderLog}, "log", "log"},
+	} {
+		endpoint, host := mailservice.Endpoint(c.user), mailservice.Host(c.user)
+		t.Run(c.tc, func(t *testing.T) {
+			if endpoint != c.endpoint || host != c.host {
+				t.Errorf("Expected %s and %s, got %s and %s", c.endpoint, c.host, endpoint, host)
+			}
+		})
+	}
+}
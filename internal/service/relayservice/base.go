@@ -0,0 +1,77 @@
+package relayservice
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"time"
+)
+
+// IRelay hands the tasks of the outbox off to the queue.
+type IRelay interface {
+	TriggerRelay() error
+	RelayOutbox(ctx context.Context) (int, error)
+	PurgeDelivered()
+}
+
+type relay struct {
+	outboxStorage outboxstorage.OutboxStorer
+	taskStorage   taskstorage.TaskStorer
+	taskqueue     taskqueue.TaskQueue
+	interval      time.Duration
+	batchSize     int
+	done          chan struct{}
+}
+
+type Option func(*relay)
+
+func WithOutboxStorage(storage outboxstorage.OutboxStorer) Option {
+	return func(r *relay) {
+		r.outboxStorage = storage
+	}
+}
+
+func WithTaskStorage(storage taskstorage.TaskStorer) Option {
+	return func(r *relay) {
+		r.taskStorage = storage
+	}
+}
+
+func WithTaskQueue(queue taskqueue.TaskQueue) Option {
+	return func(r *relay) {
+		r.taskqueue = queue
+	}
+}
+
+// WithInterval sets how often the outbox is relayed.
+func WithInterval(interval time.Duration) Option {
+	return func(r *relay) {
+		r.interval = interval
+	}
+}
+
+// WithBatchSize sets the number of entries relayed in one transaction.
+func WithBatchSize(size int) Option {
+	return func(r *relay) {
+		r.batchSize = size
+	}
+}
+
+func WithDoneChannel(ch chan struct{}) Option {
+	return func(r *relay) {
+		r.done = ch
+	}
+}
+
+func New(opts ...Option) IRelay {
+	r := &relay{
+		interval:  constant.OutboxRelayInterval,
+		batchSize: constant.OutboxBatchSize,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
@@ -0,0 +1,176 @@
+package relayservice_test
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type mockTaskStorer struct {
+	errGetByID error
+	taskModel  model.MailTaskQueue
+}
+
+func (m *mockTaskStorer) Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTaskStorer) GetByID(ctx context.Context, id uint) (model.MailTaskQueue, error) {
+	return m.taskModel, m.errGetByID
+}
+
+func (m *mockTaskStorer) GetAll(ctx context.Context, userID uint) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) GetAllByStatusWithUserID(ctx context.Context, state int, userID uint) ([]model.MailTaskQueue, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) Update(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockTaskStorer) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *mockTaskStorer) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return tasks, nil
+}
+
+func (m *mockTaskStorer) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskStorer) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+type mockOutboxStorer struct {
+	errGetUndelivered  error
+	errMarkDelivered   error
+	errMarkFailed      error
+	errDeleteDelivered error
+	errCommitTx        error
+	entries            []model.TaskOutbox
+	deleteDeliveredRes int
+	delivered          []uint
+	failed             []uint
+	committed          bool
+}
+
+func (m *mockOutboxStorer) Insert(ctx context.Context, entry model.TaskOutbox, tx ...*gorm.DB) (model.TaskOutbox, error) {
+	return entry, nil
+}
+
+func (m *mockOutboxStorer) InsertBatch(ctx context.Context, entries []model.TaskOutbox, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockOutboxStorer) GetUndelivered(ctx context.Context, limit int, tx ...*gorm.DB) ([]model.TaskOutbox, error) {
+	return m.entries, m.errGetUndelivered
+}
+
+func (m *mockOutboxStorer) MarkDelivered(ctx context.Context, id uint, tx ...*gorm.DB) error {
+	m.delivered = append(m.delivered, id)
+	return m.errMarkDelivered
+}
+
+func (m *mockOutboxStorer) MarkFailed(ctx context.Context, id uint, reason string, tx ...*gorm.DB) error {
+	m.failed = append(m.failed, id)
+	return m.errMarkFailed
+}
+
+func (m *mockOutboxStorer) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	return m.deleteDeliveredRes, m.errDeleteDelivered
+}
+
+func (m *mockOutboxStorer) CreateTx() *gorm.DB {
+	m.delivered, m.failed, m.committed = nil, nil, false
+	return nil
+}
+
+func (m *mockOutboxStorer) CommitTx(tx *gorm.DB) error {
+	m.committed = m.errCommitTx == nil
+	return m.errCommitTx
+}
+
+func (m *mockOutboxStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+type mockTaskQueue struct {
+	errPublishTask  error
+	errScheduleTask error
+	publishedTask   interface{}
+	scheduledTask   model.MailTaskQueue
+}
+
+func (m *mockTaskQueue) PublishTask(ctx context.Context, task interface{}) error {
+	m.publishedTask = task
+	return m.errPublishTask
+}
+
+func (m *mockTaskQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
+	return m.errPublishTask
+}
+
+func (m *mockTaskQueue) SubscribeTask(ctx context.Context, consumerID int) error {
+	return nil
+}
+
+func (m *mockTaskQueue) StartConsume(ctx context.Context) <-chan error {
+	return nil
+}
+
+func (m *mockTaskQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	return nil
+}
+
+func (m *mockTaskQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	return nil
+}
+
+func (m *mockTaskQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	return nil
+}
+
+func (m *mockTaskQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskQueue) ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	m.scheduledTask = task
+	return m.errScheduleTask
+}
+
+func (m *mockTaskQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	return nil
+}
+
+func (m *mockTaskQueue) DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]taskqueue.DeadLetter, error) {
+	return nil, nil
+}
+
+func (m *mockTaskQueue) RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]taskqueue.DeadLetter, error) {
+	return nil, nil
+}
+
+func (m *mockTaskQueue) Close(ctx context.Context) error {
+	return nil
+}
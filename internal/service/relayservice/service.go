@@ -0,0 +1,97 @@
+package relayservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"time"
+)
+
+// TriggerRelay relays the outbox on every interval until the done channel is closed.
+func (r *relay) TriggerRelay() error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return fmt.Errorf("outbox relay done")
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), constant.TaskCancelTimeout)
+			n, err := r.RelayOutbox(ctx)
+			cancel()
+			if err != nil {
+				log.Errorf("error relaying outbox: %v", err)
+			} else if n > 0 {
+				log.Infof("%d tasks relayed from outbox", n)
+			}
+		}
+	}
+}
+
+// RelayOutbox publishes a batch of undelivered entries and marks them delivered
+// in one transaction. Entries that can not be published stay in the outbox
+// with their error and are relayed again on the next run. It reports how many
+// entries were delivered.
+func (r *relay) RelayOutbox(ctx context.Context) (int, error) {
+	tx := r.outboxStorage.CreateTx()
+	defer r.outboxStorage.RollbackTx(tx)
+	entries, err := r.outboxStorage.GetUndelivered(ctx, r.batchSize, tx)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, entry := range entries {
+		if err := r.publish(ctx, entry); err != nil {
+			log.Errorf("error relaying task %d: %v", entry.TaskID, err)
+			if err := r.outboxStorage.MarkFailed(ctx, entry.ID, err.Error(), tx); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := r.outboxStorage.MarkDelivered(ctx, entry.ID, tx); err != nil {
+			return 0, err
+		}
+		delivered++
+	}
+	if err := r.outboxStorage.CommitTx(tx); err != nil {
+		return 0, err
+	}
+	return delivered, nil
+}
+
+// publish hands the task of an entry off to the queue, scheduled tasks are
+// added to the schedule. Entries of deleted tasks have nothing to publish.
+func (r *relay) publish(ctx context.Context, entry model.TaskOutbox) error {
+	task, err := r.taskStorage.GetByID(ctx, entry.TaskID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Infof("task %d of outbox entry %d deleted", entry.TaskID, entry.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	task.TraceID = entry.TraceID
+	if task.Status == constant.StatusScheduled {
+		return r.taskqueue.ScheduleTask(ctx, task, task.ScheduledAt)
+	}
+	return r.taskqueue.PublishTask(ctx, task)
+}
+
+// PurgeDelivered removes the entries of finished tasks delivered longer than
+// OutboxRetention ago.
+func (r *relay) PurgeDelivered() {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.TaskCancelTimeout)
+	defer cancel()
+	n, err := r.outboxStorage.DeleteDelivered(ctx, time.Now().Add(-constant.OutboxRetention))
+	if err != nil {
+		log.Errorf("error purging outbox: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Infof("%d delivered outbox entries purged", n)
+	}
+}
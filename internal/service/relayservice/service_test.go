@@ -0,0 +1,205 @@
+package relayservice_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/relayservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_relay_TriggerRelay(t *testing.T) {
+	{
+		tc := "Case 1: Done channel is closed and returns error"
+		done := make(chan struct{})
+		relay := relayservice.New(
+			relayservice.WithOutboxStorage(&mockOutboxStorer{}),
+			relayservice.WithTaskStorage(&mockTaskStorer{}),
+			relayservice.WithTaskQueue(&mockTaskQueue{}),
+			relayservice.WithDoneChannel(done),
+		)
+		close(done)
+		err := relay.TriggerRelay()
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || err.Error() != "outbox relay done" {
+				t.Errorf("%s: expected outbox relay done but got %v", tc, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Outbox relayed on interval and print logs"
+		done := make(chan struct{})
+		mockOutboxStorer := &mockOutboxStorer{entries: []model.TaskOutbox{{TaskID: 1}}}
+		relay := relayservice.New(
+			relayservice.WithOutboxStorage(mockOutboxStorer),
+			relayservice.WithTaskStorage(&mockTaskStorer{}),
+			relayservice.WithTaskQueue(&mockTaskQueue{}),
+			relayservice.WithInterval(time.Millisecond),
+			relayservice.WithDoneChannel(done),
+		)
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		time.AfterFunc(20*time.Millisecond, func() { close(done) })
+		_ = relay.TriggerRelay()
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, "1 tasks relayed from outbox") {
+				t.Errorf("Expected relay log not found in log contents:\n%s", logContents)
+			}
+		})
+	}
+}
+
+func Test_relay_RelayOutbox(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockOutboxStorer := &mockOutboxStorer{}
+	mockTaskQueue := &mockTaskQueue{}
+	relay := relayservice.New(
+		relayservice.WithOutboxStorage(mockOutboxStorer),
+		relayservice.WithTaskStorage(mockTaskStorer),
+		relayservice.WithTaskQueue(mockTaskQueue),
+	)
+	{
+		tc := "Case 1: OutboxStorage GetUndelivered returns error"
+		mockOutboxStorer.errGetUndelivered = errors.New("get undelivered error")
+		_, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockOutboxStorer.errGetUndelivered) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errGetUndelivered, err)
+			}
+		})
+		mockOutboxStorer.errGetUndelivered = nil
+	}
+	{
+		tc := "Case 2: TaskQueue PublishTask returns error and entry marked failed"
+		mockOutboxStorer.entries = []model.TaskOutbox{{Model: gorm.Model{ID: 1}, TaskID: 1}}
+		mockTaskQueue.errPublishTask = errors.New("publish task error")
+		n, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 0 {
+				t.Errorf("%s: expected 0 delivered entries but got %d, %v", tc, n, err)
+			}
+			if len(mockOutboxStorer.failed) != 1 || len(mockOutboxStorer.delivered) != 0 {
+				t.Errorf("%s: expected entry to be marked failed", tc)
+			}
+			if !mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction to be committed", tc)
+			}
+		})
+		mockTaskQueue.errPublishTask = nil
+	}
+	{
+		tc := "Case 3: OutboxStorage MarkDelivered returns error and nothing committed"
+		mockOutboxStorer.errMarkDelivered = errors.New("mark delivered error")
+		_, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockOutboxStorer.errMarkDelivered) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errMarkDelivered, err)
+			}
+			if mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction not to be committed", tc)
+			}
+		})
+		mockOutboxStorer.errMarkDelivered = nil
+	}
+	{
+		tc := "Case 4: OutboxStorage CommitTx returns error"
+		mockOutboxStorer.errCommitTx = errors.New("commit error")
+		_, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockOutboxStorer.errCommitTx) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errCommitTx, err)
+			}
+		})
+		mockOutboxStorer.errCommitTx = nil
+	}
+	{
+		tc := "Case 5: Entry of deleted task marked delivered without publishing"
+		mockTaskStorer.errGetByID = gorm.ErrRecordNotFound
+		mockTaskQueue.publishedTask = nil
+		n, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 1 {
+				t.Errorf("%s: expected 1 delivered entry but got %d, %v", tc, n, err)
+			}
+			if mockTaskQueue.publishedTask != nil {
+				t.Errorf("%s: expected nothing to be published but got %v", tc, mockTaskQueue.publishedTask)
+			}
+		})
+		mockTaskStorer.errGetByID = nil
+	}
+	{
+		tc := "Case 6: Scheduled task added to the schedule with the trace of its entry"
+		mockOutboxStorer.entries = []model.TaskOutbox{{Model: gorm.Model{ID: 1}, TaskID: 1, TraceID: "trace"}}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, Status: constant.StatusScheduled}
+		mockTaskQueue.publishedTask = nil
+		n, err := relay.RelayOutbox(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 1 {
+				t.Errorf("%s: expected 1 delivered entry but got %d, %v", tc, n, err)
+			}
+			if mockTaskQueue.scheduledTask.ID != 1 || mockTaskQueue.scheduledTask.TraceID != "trace" || mockTaskQueue.publishedTask != nil {
+				t.Errorf("%s: expected task 1 to be scheduled but got %v", tc, mockTaskQueue.scheduledTask)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Success, tasks published and entries marked delivered"
+		mockOutboxStorer.entries = []model.TaskOutbox{
+			{Model: gorm.Model{ID: 1}, TaskID: 1, TraceID: "trace"},
+			{Model: gorm.Model{ID: 2}, TaskID: 2, TraceID: "trace"},
+		}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		n, err := relay.RelayOutbox(context.Background())
+		task, _ := mockTaskQueue.publishedTask.(model.MailTaskQueue)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 2 {
+				t.Errorf("%s: expected 2 delivered entries but got %d, %v", tc, n, err)
+			}
+			if len(mockOutboxStorer.delivered) != 2 || !mockOutboxStorer.committed {
+				t.Errorf("%s: expected entries to be marked delivered and committed", tc)
+			}
+			if task.TraceID != "trace" {
+				t.Errorf("%s: expected published task with trace but got %v", tc, task)
+			}
+		})
+	}
+}
+
+func Test_relay_PurgeDelivered(t *testing.T) {
+	mockOutboxStorer := &mockOutboxStorer{}
+	relay := relayservice.New(relayservice.WithOutboxStorage(mockOutboxStorer))
+	{
+		tc := "Case 1: OutboxStorage DeleteDelivered returns error and print logs"
+		mockOutboxStorer.errDeleteDelivered = errors.New("delete delivered error")
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		relay.PurgeDelivered()
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, "error purging outbox: delete delivered error") {
+				t.Errorf("Expected error log not found in log contents:\n%s", logContents)
+			}
+		})
+		mockOutboxStorer.errDeleteDelivered = nil
+	}
+	{
+		tc := "Case 2: Delivered entries purged and print logs"
+		mockOutboxStorer.deleteDeliveredRes = 3
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		relay.PurgeDelivered()
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, "3 delivered outbox entries purged") {
+				t.Errorf("Expected purge log not found in log contents:\n%s", logContents)
+			}
+		})
+	}
+}
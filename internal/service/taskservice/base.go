@@ -2,8 +2,14 @@ package taskservice
 
 import (
 	"context"
+	"errors"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attemptstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/fencestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/userstorage"
@@ -13,13 +19,33 @@ type TaskService interface {
 	EnqueueMailTask(ctx context.Context, request dtoreq.TaskEnqueueRequest) (dtores.TaskEnqueueResponse, error)
 	GetAllQueuedTasks(ctx context.Context, request dtoreq.GetAllQueuedTasksRequest) (dtores.GetAllQueuedTasksResponse, error)
 	GetAllFailedQueuedTasks(ctx context.Context, request dtoreq.GetAllFailedTasksRequest) (dtores.GetAllFailedTasksResponse, error)
-	FindUnprocessedTasksAndEnqueue()
+	GetTaskAttempts(ctx context.Context, request dtoreq.GetTaskAttemptsRequest) (dtores.GetTaskAttemptsResponse, error)
+	GetDeadLetters(ctx context.Context, request dtoreq.GetDeadLettersRequest) (dtores.GetDeadLettersResponse, error)
+	GetDeadLetter(ctx context.Context, request dtoreq.GetDeadLetterRequest) (dtores.GetDeadLetterResponse, error)
+	ReplayDeadLetters(ctx context.Context, request dtoreq.ReplayDeadLettersRequest) (dtores.ReplayDeadLettersResponse, error)
+	PurgeDeadLetters(ctx context.Context, request dtoreq.PurgeDeadLettersRequest) (dtores.PurgeDeadLettersResponse, error)
+	FindUnprocessedTasksAndEnqueue(ctx context.Context, token int64)
+	PromoteScheduledTasks(ctx context.Context, token int64)
 }
 
+// ErrDeadLetterNotFound is returned when the requested dead-letter entries do not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// ErrDeadLetterSelection is returned when a purge selects neither task IDs nor every entry.
+var ErrDeadLetterSelection = errors.New("task_ids or all is required")
+
+// ErrTaskNotFound is returned when the requested task does not exist or belongs to another user.
+var ErrTaskNotFound = errors.New("task not found")
+
 type taskService struct {
-	taskStorage taskstorage.TaskStorer
-	userStorage userstorage.UserStorer
-	redisClient taskqueue.TaskQueue
+	taskStorage    taskstorage.TaskStorer
+	userStorage    userstorage.UserStorer
+	outboxStorage  outboxstorage.OutboxStorer
+	attemptStorage attemptstorage.AttemptStorer
+	fenceStorage   fencestorage.FenceStorer
+	redisClient    taskqueue.TaskQueue
+	attachments    attachmentservice.AttachmentService
+	templates      templateservice.TemplateService
 }
 
 type Option func(*taskService)
@@ -36,12 +62,44 @@ func WithUserStorage(userStorage userstorage.UserStorer) Option {
 	}
 }
 
+func WithOutboxStorage(outboxStorage outboxstorage.OutboxStorer) Option {
+	return func(t *taskService) {
+		t.outboxStorage = outboxStorage
+	}
+}
+
+func WithAttemptStorage(attemptStorage attemptstorage.AttemptStorer) Option {
+	return func(t *taskService) {
+		t.attemptStorage = attemptStorage
+	}
+}
+
+// WithFenceStorage sets the storage of the fencing tokens the cron jobs of the
+// service write with.
+func WithFenceStorage(fenceStorage fencestorage.FenceStorer) Option {
+	return func(t *taskService) {
+		t.fenceStorage = fenceStorage
+	}
+}
+
 func WithRedisClient(redisClient taskqueue.TaskQueue) Option {
 	return func(t *taskService) {
 		t.redisClient = redisClient
 	}
 }
 
+func WithAttachmentService(service attachmentservice.AttachmentService) Option {
+	return func(t *taskService) {
+		t.attachments = service
+	}
+}
+
+func WithTemplateService(service templateservice.TemplateService) Option {
+	return func(t *taskService) {
+		t.templates = service
+	}
+}
+
 func New(opts ...Option) TaskService {
 	service := &taskService{}
 	for _, opt := range opts {
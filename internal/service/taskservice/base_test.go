@@ -2,8 +2,12 @@ package taskservice_test
 
 import (
 	"context"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"gorm.io/gorm"
+	"time"
 )
 
 type mockTaskStorer struct {
@@ -15,21 +19,26 @@ type mockTaskStorer struct {
 	errUpdate                   error
 	errDelete                   error
 	taskModelArr                []model.MailTaskQueue
+	taskModel                   model.MailTaskQueue
+	insertedTask                model.MailTaskQueue
+	errQueueScheduled           error
 }
 
 func (m *mockTaskStorer) Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error) {
+	task.ID = 1
+	m.insertedTask = task
 	return task, m.errInsert
 }
 
 func (m *mockTaskStorer) GetByID(ctx context.Context, id uint) (model.MailTaskQueue, error) {
-	return model.MailTaskQueue{}, m.errGetByID
+	return m.taskModel, m.errGetByID
 }
 
 func (m *mockTaskStorer) GetAll(ctx context.Context, userID uint) ([]model.MailTaskQueue, error) {
 	return nil, m.errGetAll
 }
 
-func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context) ([]model.MailTaskQueue, error) {
+func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
 	return m.taskModelArr, m.errGetAllByUnprocessedTasks
 }
 
@@ -45,6 +54,22 @@ func (m *mockTaskStorer) Delete(ctx context.Context, id uint) error {
 	return m.errDelete
 }
 
+func (m *mockTaskStorer) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return tasks, nil
+}
+
+func (m *mockTaskStorer) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskStorer) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	return 0, m.errQueueScheduled
+}
+
 type mockUserStorer struct {
 	errInsert     error
 	errGetByID    error
@@ -69,6 +94,14 @@ func (m *mockUserStorer) Update(ctx context.Context, user model.User, tx ...*gor
 	return m.errUpdate
 }
 
+func (m *mockUserStorer) SetSmtpPause(ctx context.Context, id uint, authError string, until time.Time) error {
+	return nil
+}
+
+func (m *mockUserStorer) UpdateSmtpSettings(ctx context.Context, user model.User) error {
+	return m.errUpdate
+}
+
 func (m *mockUserStorer) Delete(ctx context.Context, id uint) error {
 	return m.errDelete
 }
@@ -89,13 +122,122 @@ func (m *mockUserStorer) SetTx(tx ...*gorm.DB) *gorm.DB {
 	return nil
 }
 
+type mockOutboxStorer struct {
+	errInsert          error
+	errGetUndelivered  error
+	errMarkDelivered   error
+	errMarkFailed      error
+	errDeleteDelivered error
+	errCommitTx        error
+	errInsertBatch     error
+	insertedEntry      model.TaskOutbox
+	insertedEntries    []model.TaskOutbox
+	committed          bool
+}
+
+func (m *mockOutboxStorer) Insert(ctx context.Context, entry model.TaskOutbox, tx ...*gorm.DB) (model.TaskOutbox, error) {
+	m.insertedEntry = entry
+	return entry, m.errInsert
+}
+
+func (m *mockOutboxStorer) InsertBatch(ctx context.Context, entries []model.TaskOutbox, tx ...*gorm.DB) error {
+	m.insertedEntries = entries
+	return m.errInsertBatch
+}
+
+func (m *mockOutboxStorer) GetUndelivered(ctx context.Context, limit int, tx ...*gorm.DB) ([]model.TaskOutbox, error) {
+	return nil, m.errGetUndelivered
+}
+
+func (m *mockOutboxStorer) MarkDelivered(ctx context.Context, id uint, tx ...*gorm.DB) error {
+	return m.errMarkDelivered
+}
+
+func (m *mockOutboxStorer) MarkFailed(ctx context.Context, id uint, reason string, tx ...*gorm.DB) error {
+	return m.errMarkFailed
+}
+
+func (m *mockOutboxStorer) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	return 0, m.errDeleteDelivered
+}
+
+func (m *mockOutboxStorer) CreateTx() *gorm.DB {
+	m.committed = false
+	return nil
+}
+
+func (m *mockOutboxStorer) CommitTx(tx *gorm.DB) error {
+	m.committed = m.errCommitTx == nil
+	return m.errCommitTx
+}
+
+func (m *mockOutboxStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+type mockFenceStorer struct {
+	errAdvance error
+	tokens     []int64
+	committed  bool
+}
+
+func (m *mockFenceStorer) Advance(ctx context.Context, name string, token int64, tx ...*gorm.DB) error {
+	m.tokens = append(m.tokens, token)
+	return m.errAdvance
+}
+
+func (m *mockFenceStorer) CreateTx() *gorm.DB {
+	m.committed = false
+	return nil
+}
+
+func (m *mockFenceStorer) CommitTx(tx *gorm.DB) error {
+	m.committed = true
+	return nil
+}
+
+func (m *mockFenceStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+type mockAttemptStorer struct {
+	errGetAllByTaskID error
+	attempts          []model.MailTaskAttempt
+}
+
+func (m *mockAttemptStorer) Insert(ctx context.Context, attempt model.MailTaskAttempt) (model.MailTaskAttempt, error) {
+	return attempt, nil
+}
+
+func (m *mockAttemptStorer) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailTaskAttempt, error) {
+	return m.attempts, m.errGetAllByTaskID
+}
+
 type mockTaskQueue struct {
-	errPublishTask   error
-	errSubscribeTask error
-	errStartConsume  <-chan error
+	errPublishTask       error
+	errSubscribeTask     error
+	errStartConsume      <-chan error
+	errAck               error
+	errNack              error
+	errRetry             error
+	errReapExpiredLeases error
+	errScheduleTask      error
+	errPromoteDueTasks   error
+	promoteDueTasksRes   int
+	errDeadLetter        error
+	errDeadLetters       error
+	deadLettersRes       []taskqueue.DeadLetter
+	errRemoveDeadLetters error
+	removeDeadLettersRes []taskqueue.DeadLetter
+	publishedTask        interface{}
 }
 
 func (m *mockTaskQueue) PublishTask(ctx context.Context, task interface{}) error {
+	m.publishedTask = task
+	return m.errPublishTask
+}
+
+func (m *mockTaskQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
 	return m.errPublishTask
 }
 
@@ -106,3 +248,111 @@ func (m *mockTaskQueue) SubscribeTask(ctx context.Context, consumerID int) error
 func (m *mockTaskQueue) StartConsume(ctx context.Context) <-chan error {
 	return m.errStartConsume
 }
+
+func (m *mockTaskQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	return m.errAck
+}
+
+func (m *mockTaskQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	return m.errNack
+}
+
+func (m *mockTaskQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	return m.errRetry
+}
+
+func (m *mockTaskQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	return 0, m.errReapExpiredLeases
+}
+
+func (m *mockTaskQueue) ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	return m.errScheduleTask
+}
+
+func (m *mockTaskQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	return m.promoteDueTasksRes, m.errPromoteDueTasks
+}
+
+func (m *mockTaskQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	return m.errDeadLetter
+}
+
+func (m *mockTaskQueue) DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]taskqueue.DeadLetter, error) {
+	return m.deadLettersRes, m.errDeadLetters
+}
+
+func (m *mockTaskQueue) RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]taskqueue.DeadLetter, error) {
+	return m.removeDeadLettersRes, m.errRemoveDeadLetters
+}
+
+func (m *mockTaskQueue) Close(ctx context.Context) error {
+	return nil
+}
+
+type mockAttachmentService struct {
+	errAttach    error
+	attachedTask model.MailTaskQueue
+	attached     []model.MailAttachment
+	discarded    []model.MailAttachment
+}
+
+func (m *mockAttachmentService) Upload(ctx context.Context, request dtoreq.UploadAttachmentRequest) (dtores.AttachmentResponse, error) {
+	return dtores.AttachmentResponse{}, nil
+}
+
+func (m *mockAttachmentService) Attach(ctx context.Context, task model.MailTaskQueue, inline []dtoreq.AttachmentRequest, ids []uint, tx *gorm.DB) ([]model.MailAttachment, error) {
+	m.attachedTask = task
+	if m.errAttach != nil {
+		return nil, m.errAttach
+	}
+	return m.attached, nil
+}
+
+func (m *mockAttachmentService) Discard(ctx context.Context, attachments []model.MailAttachment) {
+	m.discarded = attachments
+}
+
+func (m *mockAttachmentService) CleanupAttachments() {}
+
+type mockTemplateService struct {
+	errResolve error
+	version    int
+}
+
+func (m *mockTemplateService) CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error) {
+	return dtores.GetTemplatesResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error) {
+	return dtores.GetTemplateVersionsResponse{}, nil
+}
+
+func (m *mockTemplateService) DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error {
+	return nil
+}
+
+func (m *mockTemplateService) Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	task.TemplateVersion = m.version
+	return task, m.errResolve
+}
+
+func (m *mockTemplateService) ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error) {
+	task.TemplateVersion = m.version
+	return task, m.errResolve
+}
+
+func (m *mockTemplateService) Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	return task, nil
+}
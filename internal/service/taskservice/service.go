@@ -2,32 +2,61 @@ package taskservice
 
 import (
 	"context"
+	"errors"
 	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
 	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/traceid"
+	"gorm.io/gorm"
 	"log"
+	"time"
 )
 
+// EnqueueMailTask inserts the task and its outbox entry in one transaction, the
+// outbox relay publishes the task to the queue once the transaction commits.
+// The attachments of the task are stored in the same transaction. A task sent
+// with a template is enqueued with the version of the template it renders.
 func (s *taskService) EnqueueMailTask(ctx context.Context, request dtoreq.TaskEnqueueRequest) (dtores.TaskEnqueueResponse, error) {
 	var (
 		task model.MailTaskQueue
 		res  dtores.TaskEnqueueResponse
+		tx   = s.outboxStorage.CreateTx()
 	)
+	defer s.outboxStorage.RollbackTx(tx)
 	select {
 	case <-ctx.Done():
 		return dtores.TaskEnqueueResponse{}, ctx.Err()
 	default:
 		task = request.ConvertToMailTaskQueue()
-		task, err := s.taskStorage.Insert(ctx, task)
+		if task.ScheduledAt.After(time.Now()) {
+			task.Status = constant.StatusScheduled
+		}
+		if task.TemplateID != 0 {
+			resolved, err := s.templates.Resolve(ctx, task)
+			if err != nil {
+				return dtores.TaskEnqueueResponse{}, err
+			}
+			task = resolved
+		}
+		task, err := s.taskStorage.Insert(ctx, task, tx)
 		if err != nil {
 			return dtores.TaskEnqueueResponse{}, err
 		}
-		task.User, err = s.userStorage.GetByID(ctx, task.UserID)
-		if err != nil {
+		if _, err := s.outboxStorage.Insert(ctx, model.TaskOutbox{TaskID: task.ID, TraceID: traceid.New()}, tx); err != nil {
 			return dtores.TaskEnqueueResponse{}, err
 		}
-		if err := s.redisClient.PublishTask(ctx, task); err != nil {
+		var attachments []model.MailAttachment
+		if len(request.Attachments)+len(request.AttachmentIDs) > 0 {
+			if attachments, err = s.attachments.Attach(ctx, task, request.Attachments, request.AttachmentIDs, tx); err != nil {
+				return dtores.TaskEnqueueResponse{}, err
+			}
+		}
+		if err := s.outboxStorage.CommitTx(tx); err != nil {
+			if len(attachments) > 0 {
+				s.attachments.Discard(ctx, attachments)
+			}
 			return dtores.TaskEnqueueResponse{}, err
 		}
 		res.TaskID = task.ID
@@ -60,32 +89,293 @@ func (s *taskService) GetAllFailedQueuedTasks(ctx context.Context, request dtore
 	case <-ctx.Done():
 		return dtores.GetAllFailedTasksResponse{}, ctx.Err()
 	default:
-		tasks, err := s.taskStorage.GetAllByStatusWithUserID(ctx, constant.StatusCancelled, request.UserID)
-		if err != nil {
-			return dtores.GetAllFailedTasksResponse{}, err
+		// Failed tasks are the ones that exhausted their tries and the ones
+		// the SMTP server rejected for good.
+		for _, status := range []int{constant.StatusCancelled, constant.StatusRejected} {
+			tasks, err := s.taskStorage.GetAllByStatusWithUserID(ctx, status, request.UserID)
+			if err != nil {
+				return dtores.GetAllFailedTasksResponse{}, err
+			}
+			res.ToMailTaskQueue(tasks)
 		}
-		res.ToMailTaskQueue(tasks)
 		return res, nil
 	}
 }
 
-func (s *taskService) FindUnprocessedTasksAndEnqueue() {
+// GetTaskAttempts returns a task of the user with the history of its send attempts.
+func (s *taskService) GetTaskAttempts(ctx context.Context, request dtoreq.GetTaskAttemptsRequest) (dtores.GetTaskAttemptsResponse, error) {
 	var (
-		tasks []model.MailTaskQueue
-		err   error
+		res dtores.GetTaskAttemptsResponse
 	)
+	select {
+	case <-ctx.Done():
+		return dtores.GetTaskAttemptsResponse{}, ctx.Err()
+	default:
+		task, err := s.taskStorage.GetByID(ctx, request.TaskID)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && task.UserID != request.UserID) {
+			return dtores.GetTaskAttemptsResponse{}, ErrTaskNotFound
+		}
+		if err != nil {
+			return dtores.GetTaskAttemptsResponse{}, err
+		}
+		attempts, err := s.attemptStorage.GetAllByTaskID(ctx, task.ID)
+		if err != nil {
+			return dtores.GetTaskAttemptsResponse{}, err
+		}
+		res.ToTaskAttempts(task, attempts)
+		return res, nil
+	}
+}
+
+// FindUnprocessedTasksAndEnqueue adds the unprocessed tasks to the outbox, such
+// as tasks enqueued before it existed and tasks the queue lost, for example
+// after redis was flushed. Tasks whose outbox entry is pending or was delivered
+// lately are left to the relay and the lease of their worker. The tasks are
+// found and added in the transaction of the fencing token of the tick, so a
+// replica whose lock expired does not add them again after the next holder.
+func (s *taskService) FindUnprocessedTasksAndEnqueue(ctx context.Context, token int64) {
 	log.Println("Finding unprocessed tasks and enqueueing...")
-	ctx, cancel := context.WithTimeout(context.Background(), constant.TaskCancelTimeout)
+	ctx, cancel := context.WithTimeout(ctx, constant.TaskCancelTimeout)
 	defer cancel()
-	tasks, err = s.taskStorage.GetAllByUnprocessedTasks(ctx)
+	tx := s.fenceStorage.CreateTx()
+	defer s.fenceStorage.RollbackTx(tx)
+	if err := s.fence(ctx, constant.CronJobReconcile, token, tx); err != nil {
+		log.Printf("error fencing unprocessed tasks: %v", err)
+		return
+	}
+	tasks, err := s.taskStorage.GetAllByUnprocessedTasks(ctx, tx)
 	if err != nil {
 		log.Printf("error finding unprocessed tasks: %v", err)
 		return
 	}
+	entries := make([]model.TaskOutbox, 0, len(tasks))
 	for _, task := range tasks {
-		if err := s.redisClient.PublishTask(ctx, task); err != nil {
-			log.Printf("error publishing task: %v", err)
+		entries = append(entries, model.TaskOutbox{TaskID: task.ID, TraceID: traceid.New()})
+	}
+	if len(entries) > 0 {
+		if err := s.outboxStorage.InsertBatch(ctx, entries, tx); err != nil {
+			log.Printf("error adding tasks to outbox: %v", err)
+			return
 		}
 	}
+	if err := s.fenceStorage.CommitTx(tx); err != nil {
+		log.Printf("error adding tasks to outbox: %v", err)
+		return
+	}
 	log.Printf("%d unprocessed tasks enqueued", len(tasks))
 }
+
+// PromoteScheduledTasks moves the due scheduled tasks to the queue and sets
+// their rows to queued, so the reconciler does not publish them again. The
+// rows are set in the transaction of the fencing token of the tick.
+func (s *taskService) PromoteScheduledTasks(ctx context.Context, token int64) {
+	ctx, cancel := context.WithTimeout(ctx, constant.TaskCancelTimeout)
+	defer cancel()
+	now := time.Now()
+	tx := s.fenceStorage.CreateTx()
+	defer s.fenceStorage.RollbackTx(tx)
+	if err := s.fence(ctx, constant.CronJobPromote, token, tx); err != nil {
+		log.Printf("error fencing scheduled tasks: %v", err)
+		return
+	}
+	n, err := s.redisClient.PromoteDueTasks(ctx)
+	if err != nil {
+		log.Printf("error promoting scheduled tasks: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("%d scheduled tasks promoted", n)
+		if _, err := s.taskStorage.QueueScheduled(ctx, now, tx); err != nil {
+			log.Printf("error queueing promoted tasks: %v", err)
+			return
+		}
+	}
+	if err := s.fenceStorage.CommitTx(tx); err != nil {
+		log.Printf("error queueing promoted tasks: %v", err)
+	}
+}
+
+// fence advances the fencing token of a cron job in the transaction its tick
+// writes in. A token lower than the last one the job wrote with returns
+// fencestorage.ErrStaleToken, ticks without a lock have no token and are not
+// fenced.
+func (s *taskService) fence(ctx context.Context, job string, token int64, tx *gorm.DB) error {
+	if token == 0 {
+		return nil
+	}
+	return s.fenceStorage.Advance(ctx, job, token, tx)
+}
+
+func (s *taskService) GetDeadLetters(ctx context.Context, request dtoreq.GetDeadLettersRequest) (dtores.GetDeadLettersResponse, error) {
+	var (
+		res dtores.GetDeadLettersResponse
+	)
+	select {
+	case <-ctx.Done():
+		return dtores.GetDeadLettersResponse{}, ctx.Err()
+	default:
+		limit := request.Limit
+		if limit == 0 {
+			limit = constant.DeadLetterPageSize
+		}
+		entries, err := s.redisClient.DeadLetters(ctx, request.UserID, request.Offset, limit)
+		if err != nil {
+			return dtores.GetDeadLettersResponse{}, err
+		}
+		res.DeadLetters = make([]dtores.DeadLetterResponse, 0, len(entries))
+		for _, entry := range entries {
+			res.DeadLetters = append(res.DeadLetters, toDeadLetter(entry))
+		}
+		return res, nil
+	}
+}
+
+func (s *taskService) GetDeadLetter(ctx context.Context, request dtoreq.GetDeadLetterRequest) (dtores.GetDeadLetterResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.GetDeadLetterResponse{}, ctx.Err()
+	default:
+		entries, err := s.redisClient.DeadLetters(ctx, request.UserID, 0, 0)
+		if err != nil {
+			return dtores.GetDeadLetterResponse{}, err
+		}
+		for _, entry := range entries {
+			if entry.TaskID != request.TaskID {
+				continue
+			}
+			task, err := s.taskStorage.GetByID(ctx, entry.TaskID)
+			if err != nil {
+				return dtores.GetDeadLetterResponse{}, err
+			}
+			return dtores.GetDeadLetterResponse{
+				DeadLetter: toDeadLetter(entry),
+				Task:       dtores.ToBaseTask(task),
+			}, nil
+		}
+		return dtores.GetDeadLetterResponse{}, ErrDeadLetterNotFound
+	}
+}
+
+// ReplayDeadLetters removes the selected entries from the dead-letter queue and
+// enqueues their tasks again with a fresh try count. Entries whose task can not
+// be replayed are added back to the dead-letter queue.
+func (s *taskService) ReplayDeadLetters(ctx context.Context, request dtoreq.ReplayDeadLettersRequest) (dtores.ReplayDeadLettersResponse, error) {
+	var (
+		res dtores.ReplayDeadLettersResponse
+	)
+	select {
+	case <-ctx.Done():
+		return dtores.ReplayDeadLettersResponse{}, ctx.Err()
+	default:
+		taskIDs := request.TaskIDs
+		if len(taskIDs) == 0 {
+			// Poison payloads have no task to replay, they are only purged.
+			all, err := s.redisClient.DeadLetters(ctx, request.UserID, 0, 0)
+			if err != nil {
+				return dtores.ReplayDeadLettersResponse{}, err
+			}
+			for _, entry := range all {
+				if entry.TaskID != 0 {
+					taskIDs = append(taskIDs, entry.TaskID)
+				}
+			}
+			if len(taskIDs) == 0 {
+				return res, nil
+			}
+		}
+		entries, err := s.redisClient.RemoveDeadLetters(ctx, request.UserID, taskIDs...)
+		if err != nil {
+			return dtores.ReplayDeadLettersResponse{}, err
+		}
+		if len(request.TaskIDs) > 0 && len(entries) == 0 {
+			return dtores.ReplayDeadLettersResponse{}, ErrDeadLetterNotFound
+		}
+		for _, entry := range entries {
+			if err := s.replay(ctx, entry); err != nil {
+				log.Printf("error replaying task %d: %v", entry.TaskID, err)
+				res.Failed = append(res.Failed, entry.TaskID)
+				continue
+			}
+			res.Replayed = append(res.Replayed, entry.TaskID)
+		}
+		return res, nil
+	}
+}
+
+// replay resets a dead-lettered task and adds it to the outbox in the same
+// transaction, the relay publishes it again. The entry of a task that can not
+// be reset is added back to the dead-letter queue.
+func (s *taskService) replay(ctx context.Context, entry taskqueue.DeadLetter) error {
+	task, err := s.taskStorage.GetByID(ctx, entry.TaskID)
+	if err != nil {
+		return err
+	}
+	tx := s.outboxStorage.CreateTx()
+	defer s.outboxStorage.RollbackTx(tx)
+	task.Status = constant.StatusQueued
+	task.TryCount = 0
+	task.DeadLettered = false
+	task.LastError = ""
+	err = s.taskStorage.Update(ctx, task, tx)
+	if err == nil {
+		_, err = s.outboxStorage.Insert(ctx, model.TaskOutbox{TaskID: task.ID, TraceID: traceid.New()}, tx)
+	}
+	if err == nil {
+		err = s.outboxStorage.CommitTx(tx)
+	}
+	if err != nil {
+		task.TryCount = entry.Attempts
+		if err := s.redisClient.DeadLetter(ctx, task, errors.New(entry.Error)); err != nil {
+			log.Printf("error restoring dead letter of task %d: %v", task.ID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// PurgeDeadLetters removes the selected entries from the dead-letter queue for
+// good. Their tasks stay cancelled. Every entry is only purged when All is set,
+// a request without task IDs is rejected otherwise.
+func (s *taskService) PurgeDeadLetters(ctx context.Context, request dtoreq.PurgeDeadLettersRequest) (dtores.PurgeDeadLettersResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.PurgeDeadLettersResponse{}, ctx.Err()
+	default:
+		if len(request.TaskIDs) == 0 && !request.All {
+			return dtores.PurgeDeadLettersResponse{}, ErrDeadLetterSelection
+		}
+		entries, err := s.redisClient.RemoveDeadLetters(ctx, request.UserID, request.TaskIDs...)
+		if err != nil {
+			return dtores.PurgeDeadLettersResponse{}, err
+		}
+		if len(request.TaskIDs) > 0 && len(entries) == 0 {
+			return dtores.PurgeDeadLettersResponse{}, ErrDeadLetterNotFound
+		}
+		for _, entry := range entries {
+			if entry.TaskID == 0 {
+				continue
+			}
+			task, err := s.taskStorage.GetByID(ctx, entry.TaskID)
+			if err != nil {
+				log.Printf("error finding purged task %d: %v", entry.TaskID, err)
+				continue
+			}
+			task.DeadLettered = false
+			if err := s.taskStorage.Update(ctx, task); err != nil {
+				log.Printf("error updating purged task %d: %v", entry.TaskID, err)
+			}
+		}
+		return dtores.PurgeDeadLettersResponse{Purged: len(entries)}, nil
+	}
+}
+
+// toDeadLetter returns the response of a dead-letter entry.
+func toDeadLetter(entry taskqueue.DeadLetter) dtores.DeadLetterResponse {
+	return dtores.DeadLetterResponse{
+		TaskID:   entry.TaskID,
+		Payload:  entry.Payload,
+		Error:    entry.Error,
+		Attempts: entry.Attempts,
+		DeadAt:   entry.DeadAt,
+	}
+}
@@ -6,21 +6,32 @@ import (
 	"errors"
 	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/fencestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
 	"log"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_taskService_EnqueueMailTask(t *testing.T) {
 	mockTaskStorer := &mockTaskStorer{}
 	mockUserStorer := &mockUserStorer{}
+	mockOutboxStorer := &mockOutboxStorer{}
 	mockTaskQueue := &mockTaskQueue{}
+	mockAttachmentService := &mockAttachmentService{}
+	mockTemplateService := &mockTemplateService{version: 3}
 	mockTaskService := taskservice.New(
 		taskservice.WithTaskStorage(mockTaskStorer),
 		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithOutboxStorage(mockOutboxStorer),
 		taskservice.WithRedisClient(mockTaskQueue),
+		taskservice.WithAttachmentService(mockAttachmentService),
+		taskservice.WithTemplateService(mockTemplateService),
 	)
 	{
 		tc := "Case 1: Context is done and returns context error"
@@ -41,38 +52,158 @@ func Test_taskService_EnqueueMailTask(t *testing.T) {
 			if !errors.Is(err, mockTaskStorer.errInsert) {
 				t.Errorf("%s: expected %v but got %v", tc, mockTaskStorer.errInsert, err)
 			}
+			if mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction not to be committed", tc)
+			}
 		})
 		mockTaskStorer.errInsert = nil
 	}
 	{
-		tc := "Case 3: UserStorage GetByID returns error"
-		mockUserStorer.errGetByID = errors.New("get by id error")
+		tc := "Case 3: OutboxStorage Insert returns error and task is not committed"
+		mockOutboxStorer.errInsert = errors.New("outbox insert error")
 		_, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{})
 		t.Run(tc, func(t *testing.T) {
-			if !errors.Is(err, mockUserStorer.errGetByID) {
-				t.Errorf("%s: expected %v but got %v", tc, mockUserStorer.errGetByID, err)
+			if !errors.Is(err, mockOutboxStorer.errInsert) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errInsert, err)
+			}
+			if mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction not to be committed", tc)
 			}
 		})
-		mockUserStorer.errGetByID = nil
+		mockOutboxStorer.errInsert = nil
 	}
 	{
-		tc := "Case 4: RedisClient PublishTask returns error"
-		mockTaskQueue.errPublishTask = errors.New("publish task error")
+		tc := "Case 4: OutboxStorage CommitTx returns error"
+		mockOutboxStorer.errCommitTx = errors.New("commit error")
 		_, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{})
 		t.Run(tc, func(t *testing.T) {
-			if !errors.Is(err, mockTaskQueue.errPublishTask) {
-				t.Errorf("%s: expected %v but got %v", tc, mockTaskQueue.errPublishTask, err)
+			if !errors.Is(err, mockOutboxStorer.errCommitTx) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errCommitTx, err)
 			}
 		})
-		mockTaskQueue.errPublishTask = nil
+		mockOutboxStorer.errCommitTx = nil
 	}
 	{
-		tc := "Case 5: Success"
-		_, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{})
+		tc := "Case 5: Future scheduled time inserts a scheduled task"
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{
+			ScheduledAt: time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
 		t.Run(tc, func(t *testing.T) {
 			if err != nil {
 				t.Errorf("%s: expected nil but got %v", tc, err)
 			}
+			if mockTaskStorer.insertedTask.Status != constant.StatusScheduled {
+				t.Errorf("%s: expected scheduled task but got status %d", tc, mockTaskStorer.insertedTask.Status)
+			}
+		})
+	}
+	{
+		tc := "Case 6: Past scheduled time inserts a queued task"
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{
+			ScheduledAt: time.Now().Add(-time.Hour).Format(time.RFC3339),
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if mockTaskStorer.insertedTask.Status != constant.StatusQueued {
+				t.Errorf("%s: expected queued task but got status %d", tc, mockTaskStorer.insertedTask.Status)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Success, outbox entry committed with the task and nothing published"
+		mockTaskQueue.publishedTask = nil
+		res, err := mockTaskService.EnqueueMailTask(context.Background(), dtoreq.TaskEnqueueRequest{})
+		entry := mockOutboxStorer.insertedEntry
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if res.TaskID != 1 || entry.TaskID != 1 || entry.TraceID == "" {
+				t.Errorf("%s: expected outbox entry of task 1 with trace id but got %v", tc, entry)
+			}
+			if !mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction to be committed", tc)
+			}
+			if mockTaskQueue.publishedTask != nil {
+				t.Errorf("%s: expected nothing to be published but got %v", tc, mockTaskQueue.publishedTask)
+			}
+		})
+	}
+	withAttachments := dtoreq.TaskEnqueueRequest{AttachmentIDs: []uint{1}}
+	{
+		tc := "Case 8: AttachmentService Attach returns error and task is not committed"
+		mockAttachmentService.errAttach = errors.New("attach error")
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), withAttachments)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockAttachmentService.errAttach) {
+				t.Errorf("%s: expected %v but got %v", tc, mockAttachmentService.errAttach, err)
+			}
+			if mockOutboxStorer.committed {
+				t.Errorf("%s: expected transaction not to be committed", tc)
+			}
+		})
+		mockAttachmentService.errAttach = nil
+	}
+	{
+		tc := "Case 9: OutboxStorage CommitTx returns error and stored attachments are discarded"
+		mockAttachmentService.attached = []model.MailAttachment{{BlobKey: "key"}}
+		mockOutboxStorer.errCommitTx = errors.New("commit error")
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), withAttachments)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockOutboxStorer.errCommitTx) {
+				t.Errorf("%s: expected %v but got %v", tc, mockOutboxStorer.errCommitTx, err)
+			}
+			if len(mockAttachmentService.discarded) != 1 {
+				t.Errorf("%s: expected stored attachment to be discarded but got %v", tc, mockAttachmentService.discarded)
+			}
+		})
+		mockOutboxStorer.errCommitTx = nil
+		mockAttachmentService.discarded = nil
+	}
+	{
+		tc := "Case 10: Success, attachments attached to the inserted task"
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), withAttachments)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if mockAttachmentService.attachedTask.ID != 1 || !mockOutboxStorer.committed {
+				t.Errorf("%s: expected attachments of committed task 1 but got task %d", tc, mockAttachmentService.attachedTask.ID)
+			}
+			if mockAttachmentService.discarded != nil {
+				t.Errorf("%s: expected nothing to be discarded but got %v", tc, mockAttachmentService.discarded)
+			}
+		})
+	}
+	withTemplate := dtoreq.TaskEnqueueRequest{TemplateID: 2, Variables: map[string]interface{}{"name": "Ada"}}
+	{
+		tc := "Case 11: TemplateService Resolve returns error and task is not inserted"
+		mockTaskStorer.insertedTask = model.MailTaskQueue{}
+		mockTemplateService.errResolve = errors.New("resolve error")
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), withTemplate)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockTemplateService.errResolve) {
+				t.Errorf("%s: expected %v but got %v", tc, mockTemplateService.errResolve, err)
+			}
+			if mockTaskStorer.insertedTask.ID != 0 {
+				t.Errorf("%s: expected task not to be inserted", tc)
+			}
+		})
+		mockTemplateService.errResolve = nil
+	}
+	{
+		tc := "Case 12: Success, task is inserted with the resolved version of its template"
+		_, err := mockTaskService.EnqueueMailTask(context.Background(), withTemplate)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			task := mockTaskStorer.insertedTask
+			if task.TemplateID != 2 || task.TemplateVersion != 3 || task.TemplateVariables["name"] != "Ada" {
+				t.Errorf("%s: expected template 2 at version 3 but got %d at %d", tc, task.TemplateID, task.TemplateVersion)
+			}
 		})
 	}
 }
@@ -161,21 +292,98 @@ func Test_taskService_GetAllFailedQueuedTasks(t *testing.T) {
 	}
 }
 
-func Test_taskService_FindUnprocessedTasksAndEnqueue(t *testing.T) {
+func Test_taskService_GetTaskAttempts(t *testing.T) {
 	mockTaskStorer := &mockTaskStorer{}
 	mockUserStorer := &mockUserStorer{}
+	mockAttemptStorer := &mockAttemptStorer{}
 	mockTaskQueue := &mockTaskQueue{}
 	mockTaskService := taskservice.New(
 		taskservice.WithTaskStorage(mockTaskStorer),
 		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithAttemptStorage(mockAttemptStorer),
 		taskservice.WithRedisClient(mockTaskQueue),
 	)
+	request := dtoreq.GetTaskAttemptsRequest{UserID: 1, TaskID: 1}
+	{
+		tc := "Case 1: Context is done and returns context error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := mockTaskService.GetTaskAttempts(ctx, request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: expected %v but got %v", tc, context.Canceled, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Task does not exist and returns not found"
+		mockTaskStorer.errGetByID = gorm.ErrRecordNotFound
+		_, err := mockTaskService.GetTaskAttempts(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrTaskNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrTaskNotFound, err)
+			}
+		})
+		mockTaskStorer.errGetByID = nil
+	}
+	{
+		tc := "Case 3: Task of another user returns not found"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, UserID: 2}
+		_, err := mockTaskService.GetTaskAttempts(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrTaskNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrTaskNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: AttemptStorage GetAllByTaskID returns error"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, UserID: 1}
+		mockAttemptStorer.errGetAllByTaskID = errors.New("get all by task id error")
+		_, err := mockTaskService.GetTaskAttempts(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockAttemptStorer.errGetAllByTaskID) {
+				t.Errorf("%s: expected %v but got %v", tc, mockAttemptStorer.errGetAllByTaskID, err)
+			}
+		})
+		mockAttemptStorer.errGetAllByTaskID = nil
+	}
+	{
+		tc := "Case 5: Success"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, UserID: 1, Status: constant.StatusProcessing, ProcessingBy: "pod-1/1"}
+		mockAttemptStorer.attempts = []model.MailTaskAttempt{
+			{TaskID: 1, WorkerID: "pod-1/2", ResponseCode: 421, ErrorClass: "transient"},
+			{TaskID: 1, WorkerID: "pod-1/1"},
+		}
+		res, err := mockTaskService.GetTaskAttempts(context.Background(), request)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if res.Task.ProcessingBy != "pod-1/1" || len(res.Attempts) != 2 || res.Attempts[0].ResponseCode != 421 {
+				t.Errorf("%s: expected task processed by pod-1/1 with 2 attempts but got %v", tc, res)
+			}
+		})
+	}
+}
+
+func Test_taskService_FindUnprocessedTasksAndEnqueue(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
+	mockOutboxStorer := &mockOutboxStorer{}
+	mockFenceStorer := &mockFenceStorer{}
+	mockTaskService := taskservice.New(
+		taskservice.WithTaskStorage(mockTaskStorer),
+		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithOutboxStorage(mockOutboxStorer),
+		taskservice.WithFenceStorage(mockFenceStorer),
+	)
 	{
 		tc := "Case 1: TaskStorage GetAllByUnprocessedTasks returns error"
 		mockTaskStorer.errGetAllByUnprocessedTasks = errors.New("get all by unprocessed tasks error")
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
-		mockTaskService.FindUnprocessedTasksAndEnqueue()
+		mockTaskService.FindUnprocessedTasksAndEnqueue(context.Background(), 0)
 
 		logContents := buf.String()
 		firstLog := " Finding unprocessed tasks and enqueueing...\n"
@@ -191,41 +399,44 @@ func Test_taskService_FindUnprocessedTasksAndEnqueue(t *testing.T) {
 		mockTaskStorer.errGetAllByUnprocessedTasks = nil
 	}
 	{
-		tc := "Case 2: RedisClient PublishTask returns error"
-		mockTaskQueue.errPublishTask = errors.New("throw error")
+		tc := "Case 2: OutboxStorage InsertBatch returns error and nothing is committed"
+		mockOutboxStorer.errInsertBatch = errors.New("throw error")
 		mockTaskStorer.taskModelArr = []model.MailTaskQueue{{
 			UserID: 1,
 		}}
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
-		mockTaskService.FindUnprocessedTasksAndEnqueue()
+		mockTaskService.FindUnprocessedTasksAndEnqueue(context.Background(), 0)
 
 		logContents := buf.String()
 		firstLog := " Finding unprocessed tasks and enqueueing...\n"
-		expectedLog := " error publishing task: " + mockTaskQueue.errPublishTask.Error() + "\n"
-		lastLog := " 1 unprocessed tasks enqueued"
-		fullLog := firstLog + expectedLog + lastLog
+		expectedLog := " error adding tasks to outbox: " + mockOutboxStorer.errInsertBatch.Error() + "\n"
+		fullLog := firstLog + expectedLog
 
 		logContents = removeTimeInfo(logContents)
 		t.Run(tc, func(t *testing.T) {
-			if !strings.Contains(logContents, fullLog) {
+			if !strings.Contains(logContents, fullLog) || strings.Contains(logContents, "enqueued") {
 				t.Errorf("%s: expected log:\n%s but got:\n%s", tc, fullLog, logContents)
 			}
+			if mockFenceStorer.committed {
+				t.Errorf("%s: expected nothing to be committed", tc)
+			}
 		})
-		mockTaskQueue.errPublishTask = nil
+		mockOutboxStorer.errInsertBatch = nil
 	}
 	{
-		tc := "Case 3: 5 unprocessed task found and enqueued"
+		tc := "Case 3: 5 unprocessed task found and added to outbox with the fencing token of the tick"
 		mockTaskStorer.taskModelArr = []model.MailTaskQueue{
 			{UserID: 1},
 			{UserID: 2},
 			{UserID: 3},
 			{UserID: 4},
-			{UserID: 5},
+			{UserID: 5, Model: gorm.Model{ID: 5}},
 		}
+		mockFenceStorer.tokens = nil
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
-		mockTaskService.FindUnprocessedTasksAndEnqueue()
+		mockTaskService.FindUnprocessedTasksAndEnqueue(context.Background(), 7)
 
 		logContents := buf.String()
 		firstLog := " Finding unprocessed tasks and enqueueing...\n"
@@ -237,10 +448,340 @@ func Test_taskService_FindUnprocessedTasksAndEnqueue(t *testing.T) {
 			if !strings.Contains(logContents, fullLog) {
 				t.Errorf("%s: expected log:\n%s but got:\n%s", tc, fullLog, logContents)
 			}
+			entries := mockOutboxStorer.insertedEntries
+			if len(entries) != 5 || entries[4].TaskID != 5 || entries[4].TraceID == "" {
+				t.Errorf("%s: expected outbox entries of the 5 tasks but got %v", tc, entries)
+			}
+			if len(mockFenceStorer.tokens) != 1 || mockFenceStorer.tokens[0] != 7 || !mockFenceStorer.committed {
+				t.Errorf("%s: expected the entries committed with token 7 but got %v", tc, mockFenceStorer.tokens)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Stale fencing token and no task added to outbox"
+		mockFenceStorer.errAdvance = fencestorage.ErrStaleToken
+		mockOutboxStorer.insertedEntries = nil
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		mockTaskService.FindUnprocessedTasksAndEnqueue(context.Background(), 6)
+
+		want := "error fencing unprocessed tasks: " + fencestorage.ErrStaleToken.Error()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: expected log %q but got:\n%s", tc, want, buf.String())
+			}
+			if mockOutboxStorer.insertedEntries != nil || mockFenceStorer.committed {
+				t.Errorf("%s: expected no outbox entries but got %v", tc, mockOutboxStorer.insertedEntries)
+			}
+		})
+		mockFenceStorer.errAdvance = nil
+		mockTaskStorer.taskModelArr = nil
+	}
+}
+
+func Test_taskService_PromoteScheduledTasks(t *testing.T) {
+	mockTaskQueue := &mockTaskQueue{}
+	mockTaskStorage := &mockTaskStorer{}
+	mockFenceStorer := &mockFenceStorer{}
+	mockTaskService := taskservice.New(
+		taskservice.WithRedisClient(mockTaskQueue),
+		taskservice.WithTaskStorage(mockTaskStorage),
+		taskservice.WithFenceStorage(mockFenceStorer),
+	)
+	{
+		tc := "Case 1: PromoteDueTasks returns error and print logs"
+		mockTaskQueue.errPromoteDueTasks = errors.New("promote error")
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		mockTaskService.PromoteScheduledTasks(context.Background(), 0)
+
+		want := "error promoting scheduled tasks: promote error"
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: expected log %q but got:\n%s", tc, want, buf.String())
+			}
+		})
+		mockTaskQueue.errPromoteDueTasks = nil
+	}
+	{
+		tc := "Case 2: Due tasks promoted with the fencing token of the tick and print logs"
+		mockTaskQueue.promoteDueTasksRes = 3
+		mockFenceStorer.tokens = nil
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		mockTaskService.PromoteScheduledTasks(context.Background(), 7)
+
+		want := "3 scheduled tasks promoted"
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: expected log %q but got:\n%s", tc, want, buf.String())
+			}
+			if len(mockFenceStorer.tokens) != 1 || mockFenceStorer.tokens[0] != 7 || !mockFenceStorer.committed {
+				t.Errorf("%s: expected the rows committed with token 7 but got %v", tc, mockFenceStorer.tokens)
+			}
+		})
+		mockTaskQueue.promoteDueTasksRes = 0
+	}
+	{
+		tc := "Case 3: QueueScheduled returns error and print logs"
+		mockTaskStorage.errQueueScheduled = errors.New("update error")
+		mockTaskQueue.promoteDueTasksRes = 1
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		mockTaskService.PromoteScheduledTasks(context.Background(), 0)
+
+		want := "error queueing promoted tasks: update error"
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: expected log %q but got:\n%s", tc, want, buf.String())
+			}
 		})
+		mockTaskStorage.errQueueScheduled = nil
+		mockTaskQueue.promoteDueTasksRes = 0
+	}
+	{
+		tc := "Case 4: Stale fencing token and no task promoted"
+		mockFenceStorer.errAdvance = fencestorage.ErrStaleToken
+		mockTaskQueue.promoteDueTasksRes = 2
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		mockTaskService.PromoteScheduledTasks(context.Background(), 6)
+
+		want := "error fencing scheduled tasks: " + fencestorage.ErrStaleToken.Error()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(buf.String(), want) || strings.Contains(buf.String(), "promoted") {
+				t.Errorf("%s: expected log %q but got:\n%s", tc, want, buf.String())
+			}
+		})
+		mockFenceStorer.errAdvance = nil
+		mockTaskQueue.promoteDueTasksRes = 0
 	}
 }
 
 func removeTimeInfo(logContents string) string {
 	return regexp.MustCompile(`\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}`).ReplaceAllString(logContents, "")
 }
+
+func Test_taskService_GetDeadLetters(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
+	mockTaskQueue := &mockTaskQueue{}
+	mockTaskService := taskservice.New(
+		taskservice.WithTaskStorage(mockTaskStorer),
+		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithRedisClient(mockTaskQueue),
+	)
+	{
+		tc := "Case 1: TaskQueue DeadLetters returns error"
+		mockTaskQueue.errDeadLetters = errors.New("dead letters error")
+		_, err := mockTaskService.GetDeadLetters(context.Background(), dtoreq.GetDeadLettersRequest{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockTaskQueue.errDeadLetters) {
+				t.Errorf("%s: expected %v but got %v", tc, mockTaskQueue.errDeadLetters, err)
+			}
+		})
+		mockTaskQueue.errDeadLetters = nil
+	}
+	{
+		tc := "Case 2: Success"
+		mockTaskQueue.deadLettersRes = []taskqueue.DeadLetter{{TaskID: 1}, {TaskID: 2}}
+		res, err := mockTaskService.GetDeadLetters(context.Background(), dtoreq.GetDeadLettersRequest{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if len(res.DeadLetters) != 2 {
+				t.Errorf("%s: expected 2 dead letters but got %d", tc, len(res.DeadLetters))
+			}
+		})
+		mockTaskQueue.deadLettersRes = nil
+	}
+}
+
+func Test_taskService_GetDeadLetter(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
+	mockTaskQueue := &mockTaskQueue{}
+	mockTaskService := taskservice.New(
+		taskservice.WithTaskStorage(mockTaskStorer),
+		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithRedisClient(mockTaskQueue),
+	)
+	{
+		tc := "Case 1: Task not in dead-letter queue returns not found"
+		mockTaskQueue.deadLettersRes = []taskqueue.DeadLetter{{TaskID: 2}}
+		_, err := mockTaskService.GetDeadLetter(context.Background(), dtoreq.GetDeadLetterRequest{UserID: 1, TaskID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrDeadLetterNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrDeadLetterNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: TaskStorage GetByID returns error"
+		mockTaskQueue.deadLettersRes = []taskqueue.DeadLetter{{TaskID: 1}}
+		mockTaskStorer.errGetByID = errors.New("get by id error")
+		_, err := mockTaskService.GetDeadLetter(context.Background(), dtoreq.GetDeadLetterRequest{UserID: 1, TaskID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockTaskStorer.errGetByID) {
+				t.Errorf("%s: expected %v but got %v", tc, mockTaskStorer.errGetByID, err)
+			}
+		})
+		mockTaskStorer.errGetByID = nil
+	}
+	{
+		tc := "Case 3: Success"
+		mockTaskQueue.deadLettersRes = []taskqueue.DeadLetter{{TaskID: 1, Error: "error"}}
+		res, err := mockTaskService.GetDeadLetter(context.Background(), dtoreq.GetDeadLetterRequest{UserID: 1, TaskID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if res.DeadLetter.TaskID != 1 || res.DeadLetter.Error != "error" {
+				t.Errorf("%s: expected dead letter of task 1 but got %v", tc, res.DeadLetter)
+			}
+		})
+		mockTaskQueue.deadLettersRes = nil
+	}
+}
+
+func Test_taskService_ReplayDeadLetters(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
+	mockTaskQueue := &mockTaskQueue{}
+	mockOutboxStorer := &mockOutboxStorer{}
+	mockTaskService := taskservice.New(
+		taskservice.WithTaskStorage(mockTaskStorer),
+		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithRedisClient(mockTaskQueue),
+		taskservice.WithOutboxStorage(mockOutboxStorer),
+	)
+	{
+		tc := "Case 1: TaskQueue DeadLetters returns error"
+		mockTaskQueue.errDeadLetters = errors.New("dead letters error")
+		_, err := mockTaskService.ReplayDeadLetters(context.Background(), dtoreq.ReplayDeadLettersRequest{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockTaskQueue.errDeadLetters) {
+				t.Errorf("%s: expected %v but got %v", tc, mockTaskQueue.errDeadLetters, err)
+			}
+		})
+		mockTaskQueue.errDeadLetters = nil
+	}
+	{
+		tc := "Case 2: Unknown task returns not found"
+		_, err := mockTaskService.ReplayDeadLetters(context.Background(), dtoreq.ReplayDeadLettersRequest{UserID: 1, TaskIDs: []uint{1}})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrDeadLetterNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrDeadLetterNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Only poison payloads in queue and nothing replayed"
+		mockTaskQueue.deadLettersRes = []taskqueue.DeadLetter{{Payload: "invalid json"}}
+		res, err := mockTaskService.ReplayDeadLetters(context.Background(), dtoreq.ReplayDeadLettersRequest{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if len(res.Replayed) != 0 || len(res.Failed) != 0 {
+				t.Errorf("%s: expected nothing replayed but got %v", tc, res)
+			}
+		})
+		mockTaskQueue.deadLettersRes = nil
+	}
+	{
+		tc := "Case 4: OutboxStorage CommitTx returns error and task reported as failed"
+		mockTaskQueue.removeDeadLettersRes = []taskqueue.DeadLetter{{TaskID: 1, Error: "error"}}
+		mockOutboxStorer.errCommitTx = errors.New("commit error")
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		res, err := mockTaskService.ReplayDeadLetters(context.Background(), dtoreq.ReplayDeadLettersRequest{UserID: 1, TaskIDs: []uint{1}})
+		expectedLog := " error replaying task 1: commit error\n"
+		logContents := removeTimeInfo(buf.String())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if len(res.Failed) != 1 || res.Failed[0] != 1 {
+				t.Errorf("%s: expected task 1 failed but got %v", tc, res)
+			}
+			if !strings.Contains(logContents, expectedLog) {
+				t.Errorf("%s: expected log:\n%s but got:\n%s", tc, expectedLog, logContents)
+			}
+		})
+		mockOutboxStorer.errCommitTx = nil
+	}
+	{
+		tc := "Case 5: Success"
+		res, err := mockTaskService.ReplayDeadLetters(context.Background(), dtoreq.ReplayDeadLettersRequest{UserID: 1, TaskIDs: []uint{1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if len(res.Replayed) != 1 || res.Replayed[0] != 1 {
+				t.Errorf("%s: expected task 1 replayed but got %v", tc, res)
+			}
+			if !mockOutboxStorer.committed {
+				t.Errorf("%s: expected task added to outbox", tc)
+			}
+		})
+		mockTaskQueue.removeDeadLettersRes = nil
+	}
+}
+
+func Test_taskService_PurgeDeadLetters(t *testing.T) {
+	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
+	mockTaskQueue := &mockTaskQueue{}
+	mockTaskService := taskservice.New(
+		taskservice.WithTaskStorage(mockTaskStorer),
+		taskservice.WithUserStorage(mockUserStorer),
+		taskservice.WithRedisClient(mockTaskQueue),
+	)
+	{
+		tc := "Case 1: TaskQueue RemoveDeadLetters returns error"
+		mockTaskQueue.errRemoveDeadLetters = errors.New("remove dead letters error")
+		_, err := mockTaskService.PurgeDeadLetters(context.Background(), dtoreq.PurgeDeadLettersRequest{UserID: 1, All: true})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mockTaskQueue.errRemoveDeadLetters) {
+				t.Errorf("%s: expected %v but got %v", tc, mockTaskQueue.errRemoveDeadLetters, err)
+			}
+		})
+		mockTaskQueue.errRemoveDeadLetters = nil
+	}
+	{
+		tc := "Case 2: Unknown task returns not found"
+		_, err := mockTaskService.PurgeDeadLetters(context.Background(), dtoreq.PurgeDeadLettersRequest{UserID: 1, TaskIDs: []uint{1}})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrDeadLetterNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrDeadLetterNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: No task IDs without all returns error"
+		mockTaskQueue.removeDeadLettersRes = []taskqueue.DeadLetter{{TaskID: 1}}
+		_, err := mockTaskService.PurgeDeadLetters(context.Background(), dtoreq.PurgeDeadLettersRequest{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, taskservice.ErrDeadLetterSelection) {
+				t.Errorf("%s: expected %v but got %v", tc, taskservice.ErrDeadLetterSelection, err)
+			}
+		})
+		mockTaskQueue.removeDeadLettersRes = nil
+	}
+	{
+		tc := "Case 4: Success"
+		mockTaskQueue.removeDeadLettersRes = []taskqueue.DeadLetter{{TaskID: 1}, {Payload: "invalid json"}}
+		res, err := mockTaskService.PurgeDeadLetters(context.Background(), dtoreq.PurgeDeadLettersRequest{UserID: 1, All: true})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if res.Purged != 2 {
+				t.Errorf("%s: expected 2 purged but got %d", tc, res.Purged)
+			}
+		})
+		mockTaskQueue.removeDeadLettersRes = nil
+	}
+}
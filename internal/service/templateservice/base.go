@@ -0,0 +1,58 @@
+package templateservice
+
+import (
+	"context"
+	"errors"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/templatestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+)
+
+// TemplateService stores the mail templates of users and renders the mails of
+// the tasks enqueued with a template.
+type TemplateService interface {
+	CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error)
+	UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error)
+	GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error)
+	GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error)
+	GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error)
+	DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error
+	Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error)
+	ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error)
+	Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error)
+}
+
+var (
+	// ErrTemplateNotFound is returned when a template or one of its versions
+	// does not exist or belongs to another user.
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrTemplateExists is returned when the user has a template with the same name.
+	ErrTemplateExists = errors.New("template already exists")
+	// ErrInvalidTemplate is returned for templates that can not be parsed,
+	// have an invalid name or use a layout that is not a partial of the user.
+	ErrInvalidTemplate = errors.New("invalid template")
+	// ErrRender is returned when a template can not be rendered with the
+	// variables of a task, such as a variable that is missing.
+	ErrRender = errors.New("template render failed")
+)
+
+type templateService struct {
+	templateStorage templatestorage.TemplateStorer
+}
+
+type Option func(*templateService)
+
+func WithTemplateStorage(storage templatestorage.TemplateStorer) Option {
+	return func(s *templateService) {
+		s.templateStorage = storage
+	}
+}
+
+func New(opts ...Option) TemplateService {
+	s := &templateService{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
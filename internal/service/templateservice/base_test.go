@@ -0,0 +1,103 @@
+package templateservice_test
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+)
+
+type mockTemplateStorer struct {
+	errInsert error
+	errUpdate error
+	templates []model.MailTemplate
+	versions  []model.MailTemplateVersion
+	inserted  []model.MailTemplate
+	updated   []model.MailTemplate
+}
+
+func (m *mockTemplateStorer) Insert(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error) {
+	template.ID = uint(len(m.templates) + 1)
+	template.Version = 1
+	m.inserted = append(m.inserted, template)
+	return template, m.errInsert
+}
+
+func (m *mockTemplateStorer) Update(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error) {
+	template.Version++
+	m.updated = append(m.updated, template)
+	return template, m.errUpdate
+}
+
+func (m *mockTemplateStorer) GetByID(ctx context.Context, userID, id uint) (model.MailTemplate, error) {
+	for _, template := range m.templates {
+		if template.ID == id && template.UserID == userID {
+			return template, nil
+		}
+	}
+	return model.MailTemplate{}, gorm.ErrRecordNotFound
+}
+
+func (m *mockTemplateStorer) GetByName(ctx context.Context, userID uint, name string) (model.MailTemplate, error) {
+	for _, template := range m.templates {
+		if template.Name == name && template.UserID == userID {
+			return template, nil
+		}
+	}
+	return model.MailTemplate{}, gorm.ErrRecordNotFound
+}
+
+func (m *mockTemplateStorer) GetAllByUserID(ctx context.Context, userID uint) ([]model.MailTemplate, error) {
+	var templates []model.MailTemplate
+	for _, template := range m.templates {
+		if template.UserID == userID {
+			templates = append(templates, template)
+		}
+	}
+	return templates, nil
+}
+
+func (m *mockTemplateStorer) GetAllPartials(ctx context.Context, userID uint) ([]model.MailTemplate, error) {
+	var partials []model.MailTemplate
+	for _, template := range m.templates {
+		if template.UserID == userID && template.Partial {
+			partials = append(partials, template)
+		}
+	}
+	return partials, nil
+}
+
+func (m *mockTemplateStorer) GetVersion(ctx context.Context, templateID uint, version int) (model.MailTemplateVersion, error) {
+	for _, v := range m.versions {
+		if v.TemplateID == templateID && v.Version == version {
+			return v, nil
+		}
+	}
+	return model.MailTemplateVersion{}, gorm.ErrRecordNotFound
+}
+
+func (m *mockTemplateStorer) GetAllVersions(ctx context.Context, templateID uint) ([]model.MailTemplateVersion, error) {
+	var versions []model.MailTemplateVersion
+	for _, v := range m.versions {
+		if v.TemplateID == templateID {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *mockTemplateStorer) GetPartialVersions(ctx context.Context, partials []model.MailTemplatePartial) ([]model.MailTemplateVersion, error) {
+	var versions []model.MailTemplateVersion
+	for _, partial := range partials {
+		if v, err := m.GetVersion(ctx, partial.TemplateID, partial.Version); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *mockTemplateStorer) Delete(ctx context.Context, userID, id uint) error {
+	if _, err := m.GetByID(ctx, userID, id); err != nil {
+		return err
+	}
+	return nil
+}
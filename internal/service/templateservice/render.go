@@ -0,0 +1,187 @@
+package templateservice
+
+import (
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+)
+
+// contentTemplate is the name the bodies of a template are parsed as, layouts
+// include them with {{template "content" .}}.
+const contentTemplate = "content"
+
+// errOutputTooLarge stops the render of a template whose output is over
+// constant.TemplateMaxOutputSize, such as a range over a large number.
+var errOutputTooLarge = fmt.Errorf("output is larger than %d bytes", constant.TemplateMaxOutputSize)
+
+// limitedBuilder builds the output of a template and fails the writes over
+// constant.TemplateMaxOutputSize, which stops the execution of the template.
+type limitedBuilder struct {
+	buf strings.Builder
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > constant.TemplateMaxOutputSize {
+		return 0, errOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuilder) String() string {
+	return b.buf.String()
+}
+
+// rendered is the subject and bodies of a mail rendered from a template.
+type rendered struct {
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// compiled is a version of a template parsed with the partials of its user,
+// it is parsed once to render the mails of many sets of variables. The bodies
+// are executed as their layout when the layout has a body of the same kind.
+type compiled struct {
+	subject  *texttemplate.Template
+	text     *texttemplate.Template
+	html     *htmltemplate.Template
+	textName string
+	htmlName string
+}
+
+// compile parses a version of a template with the partials of its user.
+func compile(version model.MailTemplateVersion, partials []model.MailTemplate) (*compiled, error) {
+	var (
+		c   = &compiled{textName: contentTemplate, htmlName: contentTemplate}
+		err error
+	)
+	if version.Layout != "" && !hasPartial(partials, version.Layout) {
+		return nil, fmt.Errorf("layout %s not found", version.Layout)
+	}
+	if c.subject, err = texttemplate.New(contentTemplate).Option("missingkey=error").Parse(version.Subject); err != nil {
+		return nil, fmt.Errorf("subject: %v", err)
+	}
+	if version.TextBody != "" {
+		if c.text, err = parseText(version.TextBody, partials); err != nil {
+			return nil, fmt.Errorf("text body: %v", err)
+		}
+		if version.Layout != "" && c.text.Lookup(version.Layout) != nil {
+			c.textName = version.Layout
+		}
+	}
+	if version.HTMLBody != "" {
+		if c.html, err = parseHTML(version.HTMLBody, partials); err != nil {
+			return nil, fmt.Errorf("html body: %v", err)
+		}
+		if version.Layout != "" && c.html.Lookup(version.Layout) != nil {
+			c.htmlName = version.Layout
+		}
+	}
+	return c, nil
+}
+
+// render renders the subject and bodies with a set of variables, a variable
+// that is not in the variables fails the render.
+func (c *compiled) render(variables map[string]interface{}) (rendered, error) {
+	var (
+		out rendered
+		err error
+	)
+	if out.Subject, err = execute(c.subject, contentTemplate, variables); err != nil {
+		return out, fmt.Errorf("subject: %v", err)
+	}
+	// A subject is a single line.
+	out.Subject = strings.Join(strings.Fields(out.Subject), " ")
+	if c.text != nil {
+		if out.TextBody, err = execute(c.text, c.textName, variables); err != nil {
+			return out, fmt.Errorf("text body: %v", err)
+		}
+	}
+	if c.html != nil {
+		if out.HTMLBody, err = execute(c.html, c.htmlName, variables); err != nil {
+			return out, fmt.Errorf("html body: %v", err)
+		}
+	}
+	return out, nil
+}
+
+// render renders a version of a template with the partials of its user.
+func render(version model.MailTemplateVersion, partials []model.MailTemplate, variables map[string]interface{}) (rendered, error) {
+	c, err := compile(version, partials)
+	if err != nil {
+		return rendered{}, err
+	}
+	return c.render(variables)
+}
+
+func parseText(body string, partials []model.MailTemplate) (*texttemplate.Template, error) {
+	t, err := texttemplate.New(contentTemplate).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		if partial.TextBody == "" {
+			continue
+		}
+		if _, err := t.New(partial.Name).Parse(partial.TextBody); err != nil {
+			return nil, fmt.Errorf("partial %s: %v", partial.Name, err)
+		}
+	}
+	return t, nil
+}
+
+// parseHTML parses an HTML body, the variables are escaped by their context.
+func parseHTML(body string, partials []model.MailTemplate) (*htmltemplate.Template, error) {
+	t, err := htmltemplate.New(contentTemplate).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		if partial.HTMLBody == "" {
+			continue
+		}
+		if _, err := t.New(partial.Name).Parse(partial.HTMLBody); err != nil {
+			return nil, fmt.Errorf("partial %s: %v", partial.Name, err)
+		}
+	}
+	return t, nil
+}
+
+// executor is a parsed text or HTML template.
+type executor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+func execute(t executor, name string, variables map[string]interface{}) (string, error) {
+	var buf limitedBuilder
+	if err := t.ExecuteTemplate(&buf, name, variables); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parse checks the syntax of the subject and bodies of a template.
+func parse(template model.MailTemplate) error {
+	for field, body := range map[string]string{"subject": template.Subject, "text body": template.TextBody} {
+		if _, err := texttemplate.New(template.Name).Parse(body); err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+	}
+	if _, err := htmltemplate.New(template.Name).Parse(template.HTMLBody); err != nil {
+		return fmt.Errorf("html body: %v", err)
+	}
+	return nil
+}
+
+func hasPartial(partials []model.MailTemplate, name string) bool {
+	for _, partial := range partials {
+		if partial.Name == name {
+			return true
+		}
+	}
+	return false
+}
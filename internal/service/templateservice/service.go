@@ -0,0 +1,281 @@
+package templateservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"regexp"
+)
+
+// namePattern is the pattern of template names, a name is how layouts and
+// other templates include a partial.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// CreateTemplate stores the first version of a template.
+func (s *templateService) CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.TemplateResponse{}, ctx.Err()
+	default:
+		template := request.ConvertToMailTemplate()
+		if !namePattern.MatchString(template.Name) || template.Name == contentTemplate {
+			return dtores.TemplateResponse{}, fmt.Errorf("%w: name %q is not allowed", ErrInvalidTemplate, template.Name)
+		}
+		if err := s.check(ctx, template); err != nil {
+			return dtores.TemplateResponse{}, err
+		}
+		_, err := s.templateStorage.GetByName(ctx, template.UserID, template.Name)
+		if err == nil {
+			return dtores.TemplateResponse{}, ErrTemplateExists
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return dtores.TemplateResponse{}, err
+		}
+		template, err = s.templateStorage.Insert(ctx, template)
+		if err != nil {
+			return dtores.TemplateResponse{}, err
+		}
+		return dtores.ToTemplate(template), nil
+	}
+}
+
+// UpdateTemplate stores a new version of a template. The tasks enqueued before
+// keep the version they were enqueued with.
+func (s *templateService) UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.TemplateResponse{}, ctx.Err()
+	default:
+		current, err := s.templateStorage.GetByID(ctx, request.UserID, request.TemplateID)
+		if err != nil {
+			return dtores.TemplateResponse{}, notFound(err)
+		}
+		template := request.ConvertToMailTemplate()
+		template.Name, template.Partial = current.Name, current.Partial
+		if err := s.check(ctx, template); err != nil {
+			return dtores.TemplateResponse{}, err
+		}
+		template, err = s.templateStorage.Update(ctx, template)
+		if err != nil {
+			return dtores.TemplateResponse{}, notFound(err)
+		}
+		return dtores.ToTemplate(template), nil
+	}
+}
+
+func (s *templateService) GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error) {
+	select {
+	case <-ctx.Done():
+		return dtores.TemplateResponse{}, ctx.Err()
+	default:
+		template, err := s.templateStorage.GetByID(ctx, request.UserID, request.TemplateID)
+		if err != nil {
+			return dtores.TemplateResponse{}, notFound(err)
+		}
+		if request.Version == 0 || request.Version == template.Version {
+			return dtores.ToTemplate(template), nil
+		}
+		version, err := s.templateStorage.GetVersion(ctx, template.ID, request.Version)
+		if err != nil {
+			return dtores.TemplateResponse{}, notFound(err)
+		}
+		return dtores.ToTemplateAt(template, version), nil
+	}
+}
+
+func (s *templateService) GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error) {
+	var (
+		res dtores.GetTemplatesResponse
+	)
+	select {
+	case <-ctx.Done():
+		return dtores.GetTemplatesResponse{}, ctx.Err()
+	default:
+		templates, err := s.templateStorage.GetAllByUserID(ctx, request.UserID)
+		if err != nil {
+			return dtores.GetTemplatesResponse{}, err
+		}
+		res.ToTemplates(templates)
+		return res, nil
+	}
+}
+
+func (s *templateService) GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error) {
+	var (
+		res dtores.GetTemplateVersionsResponse
+	)
+	select {
+	case <-ctx.Done():
+		return dtores.GetTemplateVersionsResponse{}, ctx.Err()
+	default:
+		template, err := s.templateStorage.GetByID(ctx, request.UserID, request.TemplateID)
+		if err != nil {
+			return dtores.GetTemplateVersionsResponse{}, notFound(err)
+		}
+		versions, err := s.templateStorage.GetAllVersions(ctx, template.ID)
+		if err != nil {
+			return dtores.GetTemplateVersionsResponse{}, err
+		}
+		res.ToTemplateVersions(template.ID, versions)
+		return res, nil
+	}
+}
+
+// DeleteTemplate deletes a template, its versions are kept for the tasks that
+// were enqueued with it and are not sent yet.
+func (s *templateService) DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return notFound(s.templateStorage.Delete(ctx, request.UserID, request.TemplateID))
+	}
+}
+
+// Resolve returns the task with the version of its template it is sent with,
+// the latest one when the task does not name a version, and the partials of
+// its user pinned at their current versions. The task is rendered with its
+// variables, so a task that can not be rendered is not enqueued.
+func (s *templateService) Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	task, version, partials, err := s.resolve(ctx, task)
+	if err != nil {
+		return task, err
+	}
+	if _, err := render(version, partials, task.TemplateVariables); err != nil {
+		return task, fmt.Errorf("%w: %v", ErrRender, err)
+	}
+	return task, nil
+}
+
+// ResolveAll resolves the template of a task like Resolve, the template is
+// rendered with every set of variables. The version and partials are loaded
+// and parsed once, the first set that fails the render is reported by its row.
+func (s *templateService) ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error) {
+	task, version, partials, err := s.resolve(ctx, task)
+	if err != nil {
+		return task, err
+	}
+	c, err := compile(version, partials)
+	if err != nil {
+		return task, fmt.Errorf("%w: %v", ErrRender, err)
+	}
+	for i, v := range variables {
+		if err := ctx.Err(); err != nil {
+			return task, err
+		}
+		if _, err := c.render(v); err != nil {
+			return task, fmt.Errorf("%w: row %d: %v", ErrRender, i+1, err)
+		}
+	}
+	return task, nil
+}
+
+// resolve sets the version of the template and the pinned partials of a task
+// and returns them.
+func (s *templateService) resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, model.MailTemplateVersion, []model.MailTemplate, error) {
+	template, err := s.templateStorage.GetByID(ctx, task.UserID, task.TemplateID)
+	if err != nil {
+		return task, model.MailTemplateVersion{}, nil, notFound(err)
+	}
+	if template.Partial {
+		return task, model.MailTemplateVersion{}, nil, fmt.Errorf("%w: %s is a partial", ErrInvalidTemplate, template.Name)
+	}
+	if task.TemplateVersion == 0 {
+		task.TemplateVersion = template.Version
+	}
+	version, err := s.templateStorage.GetVersion(ctx, task.TemplateID, task.TemplateVersion)
+	if err != nil {
+		return task, version, nil, notFound(err)
+	}
+	partials, err := s.templateStorage.GetAllPartials(ctx, task.UserID)
+	if err != nil {
+		return task, version, nil, err
+	}
+	task.TemplatePartials = make([]model.MailTemplatePartial, 0, len(partials))
+	for _, partial := range partials {
+		task.TemplatePartials = append(task.TemplatePartials, model.MailTemplatePartial{
+			Name: partial.Name, TemplateID: partial.ID, Version: partial.Version,
+		})
+	}
+	return task, version, partials, nil
+}
+
+// Render returns the task with the subject and bodies rendered from the
+// version of its template and its pinned partials.
+func (s *templateService) Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	version, err := s.templateStorage.GetVersion(ctx, task.TemplateID, task.TemplateVersion)
+	if err != nil {
+		return task, notFound(err)
+	}
+	partials, err := s.partials(ctx, task)
+	if err != nil {
+		return task, err
+	}
+	out, err := render(version, partials, task.TemplateVariables)
+	if err != nil {
+		return task, fmt.Errorf("%w: %v", ErrRender, err)
+	}
+	task.Subject, task.Body = out.Subject, ""
+	task.TextBody, task.HTMLBody = out.TextBody, out.HTMLBody
+	return task, nil
+}
+
+// partials returns the partials of a task at their pinned versions, the tasks
+// enqueued before partials were pinned get the current partials of the user.
+func (s *templateService) partials(ctx context.Context, task model.MailTaskQueue) ([]model.MailTemplate, error) {
+	if task.TemplatePartials == nil {
+		return s.templateStorage.GetAllPartials(ctx, task.UserID)
+	}
+	versions, err := s.templateStorage.GetPartialVersions(ctx, task.TemplatePartials)
+	if err != nil {
+		return nil, err
+	}
+	partials := make([]model.MailTemplate, 0, len(versions))
+	for _, pinned := range task.TemplatePartials {
+		for _, v := range versions {
+			if v.TemplateID == pinned.TemplateID && v.Version == pinned.Version {
+				partials = append(partials, model.MailTemplate{
+					Name: pinned.Name, Partial: true, Version: v.Version,
+					HTMLBody: v.HTMLBody, TextBody: v.TextBody,
+				})
+			}
+		}
+	}
+	return partials, nil
+}
+
+// check returns ErrInvalidTemplate for a template that can not be parsed or
+// uses a layout that is not a partial of the user.
+func (s *templateService) check(ctx context.Context, template model.MailTemplate) error {
+	if !template.Partial && template.Subject == "" {
+		return fmt.Errorf("%w: subject is required", ErrInvalidTemplate)
+	}
+	if template.TextBody == "" && template.HTMLBody == "" {
+		return fmt.Errorf("%w: a body is required", ErrInvalidTemplate)
+	}
+	if err := parse(template); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if template.Layout == "" {
+		return nil
+	}
+	if template.Partial {
+		return fmt.Errorf("%w: a partial can not have a layout", ErrInvalidTemplate)
+	}
+	layout, err := s.templateStorage.GetByName(ctx, template.UserID, template.Layout)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !layout.Partial) {
+		return fmt.Errorf("%w: layout %s is not a partial", ErrInvalidTemplate, template.Layout)
+	}
+	return err
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTemplateNotFound
+	}
+	return err
+}
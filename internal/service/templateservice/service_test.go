@@ -0,0 +1,355 @@
+package templateservice_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newMockTemplateStorer returns a storer with a layout and a signature partial
+// and a welcome template at version 2 for user 1.
+func newMockTemplateStorer() *mockTemplateStorer {
+	template := func(id uint, name string, partial bool, version int, layout, subject, html, text string) model.MailTemplate {
+		return model.MailTemplate{
+			Model:  gorm.Model{ID: id},
+			UserID: 1, Name: name, Partial: partial, Version: version,
+			Layout: layout, Subject: subject, HTMLBody: html, TextBody: text,
+		}
+	}
+	return &mockTemplateStorer{
+		templates: []model.MailTemplate{
+			template(1, "layout", true, 1, "", "", `<html><body>{{template "content" .}}</body></html>`, "{{template \"content\" .}}\n--\nAcme"),
+			template(2, "signature", true, 1, "", "", "<p>Thanks, {{.team}}</p>", "Thanks, {{.team}}"),
+			template(3, "welcome", false, 2, "layout", "Welcome {{.name}}", `<h1>Hello {{.name}}</h1>{{template "signature" .}}`, "Hello {{.name}}\n{{template \"signature\" .}}"),
+		},
+		versions: []model.MailTemplateVersion{
+			{TemplateID: 1, Version: 1, HTMLBody: `<html><body>{{template "content" .}}</body></html>`, TextBody: "{{template \"content\" .}}\n--\nAcme"},
+			{TemplateID: 2, Version: 1, HTMLBody: "<p>Thanks, {{.team}}</p>", TextBody: "Thanks, {{.team}}"},
+			{TemplateID: 3, Version: 1, Subject: "Welcome", TextBody: "Hello {{.name}}"},
+			{TemplateID: 3, Version: 2, Layout: "layout", Subject: "Welcome {{.name}}", HTMLBody: `<h1>Hello {{.name}}</h1>{{template "signature" .}}`, TextBody: "Hello {{.name}}\n{{template \"signature\" .}}"},
+		},
+	}
+}
+
+func Test_templateService_CreateTemplate(t *testing.T) {
+	mockTemplateStorer := newMockTemplateStorer()
+	mockService := templateservice.New(templateservice.WithTemplateStorage(mockTemplateStorer))
+	{
+		tc := "Case 1: Context is done and returns context error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := mockService.CreateTemplate(ctx, dtoreq.CreateTemplateRequest{})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: expected %v but got %v", tc, context.Canceled, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Name that can not be included returns invalid template error"
+		_, err := mockService.CreateTemplate(context.Background(), dtoreq.CreateTemplateRequest{
+			UserID: 1, Name: "order confirmation", Subject: "Order", TextBody: "Thanks",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrInvalidTemplate) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrInvalidTemplate, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Body that can not be parsed returns invalid template error"
+		_, err := mockService.CreateTemplate(context.Background(), dtoreq.CreateTemplateRequest{
+			UserID: 1, Name: "order", Subject: "Order", HTMLBody: "<p>{{.total</p>",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrInvalidTemplate) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrInvalidTemplate, err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Layout that is not a partial returns invalid template error"
+		_, err := mockService.CreateTemplate(context.Background(), dtoreq.CreateTemplateRequest{
+			UserID: 1, Name: "order", Layout: "welcome", Subject: "Order", TextBody: "Thanks",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrInvalidTemplate) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrInvalidTemplate, err)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Name the user already has returns template exists error"
+		_, err := mockService.CreateTemplate(context.Background(), dtoreq.CreateTemplateRequest{
+			UserID: 1, Name: "welcome", Subject: "Welcome", TextBody: "Hello",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateExists) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateExists, err)
+			}
+		})
+	}
+	{
+		tc := "Case 6: Template with a layout of the user is stored at version 1"
+		res, err := mockService.CreateTemplate(context.Background(), dtoreq.CreateTemplateRequest{
+			UserID: 1, Name: "order", Layout: "layout", Subject: "Order {{.id}}", TextBody: "Total {{.total}}",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if res.Name != "order" || res.Version != 1 || len(mockTemplateStorer.inserted) != 1 {
+				t.Errorf("%s: expected order at version 1 inserted but got %+v, %d inserted", tc, res, len(mockTemplateStorer.inserted))
+			}
+		})
+	}
+}
+
+func Test_templateService_UpdateTemplate(t *testing.T) {
+	mockTemplateStorer := newMockTemplateStorer()
+	mockService := templateservice.New(templateservice.WithTemplateStorage(mockTemplateStorer))
+	{
+		tc := "Case 1: Template of another user returns not found error"
+		_, err := mockService.UpdateTemplate(context.Background(), dtoreq.UpdateTemplateRequest{
+			UserID: 2, TemplateID: 3, Subject: "Welcome", TextBody: "Hello",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Template without a subject returns invalid template error"
+		_, err := mockService.UpdateTemplate(context.Background(), dtoreq.UpdateTemplateRequest{
+			UserID: 1, TemplateID: 3, TextBody: "Hello",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrInvalidTemplate) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrInvalidTemplate, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Update keeps the name of the template and stores a new version"
+		res, err := mockService.UpdateTemplate(context.Background(), dtoreq.UpdateTemplateRequest{
+			UserID: 1, TemplateID: 3, Subject: "Welcome aboard", TextBody: "Hello {{.name}}",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if len(mockTemplateStorer.updated) != 1 || mockTemplateStorer.updated[0].Name != "welcome" || res.Subject != "Welcome aboard" {
+				t.Errorf("%s: expected welcome updated but got %+v", tc, mockTemplateStorer.updated)
+			}
+		})
+	}
+}
+
+func Test_templateService_GetTemplate(t *testing.T) {
+	mockService := templateservice.New(templateservice.WithTemplateStorage(newMockTemplateStorer()))
+	{
+		tc := "Case 1: Template is returned at the given version"
+		res, err := mockService.GetTemplate(context.Background(), dtoreq.GetTemplateRequest{UserID: 1, TemplateID: 3, Version: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if res.Name != "welcome" || res.Version != 1 || res.Subject != "Welcome" {
+				t.Errorf("%s: expected welcome at version 1 but got %+v", tc, res)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Version that does not exist returns not found error"
+		_, err := mockService.GetTemplate(context.Background(), dtoreq.GetTemplateRequest{UserID: 1, TemplateID: 3, Version: 7})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateNotFound, err)
+			}
+		})
+	}
+}
+
+func Test_templateService_Resolve(t *testing.T) {
+	mockService := templateservice.New(templateservice.WithTemplateStorage(newMockTemplateStorer()))
+	variables := map[string]interface{}{"name": "Ada", "team": "Ops"}
+	{
+		tc := "Case 1: Template of another user returns not found error"
+		_, err := mockService.Resolve(context.Background(), model.MailTaskQueue{UserID: 2, TemplateID: 3, TemplateVariables: variables})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Partial can not be sent and returns invalid template error"
+		_, err := mockService.Resolve(context.Background(), model.MailTaskQueue{UserID: 1, TemplateID: 2, TemplateVariables: variables})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrInvalidTemplate) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrInvalidTemplate, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Missing variable returns render error"
+		_, err := mockService.Resolve(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVariables: map[string]interface{}{"name": "Ada"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrRender) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrRender, err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Task without a version is sent with the latest version and the partials are pinned"
+		task, err := mockService.Resolve(context.Background(), model.MailTaskQueue{UserID: 1, TemplateID: 3, TemplateVariables: variables})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || task.TemplateVersion != 2 {
+				t.Errorf("%s: expected version 2 but got %d, %v", tc, task.TemplateVersion, err)
+			}
+			want := []model.MailTemplatePartial{{Name: "layout", TemplateID: 1, Version: 1}, {Name: "signature", TemplateID: 2, Version: 1}}
+			if !reflect.DeepEqual(task.TemplatePartials, want) {
+				t.Errorf("%s: expected partials %v but got %v", tc, want, task.TemplatePartials)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Task keeps the version it names"
+		task, err := mockService.Resolve(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVersion: 1, TemplateVariables: map[string]interface{}{"name": "Ada"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || task.TemplateVersion != 1 {
+				t.Errorf("%s: expected version 1 but got %d, %v", tc, task.TemplateVersion, err)
+			}
+		})
+	}
+}
+
+func Test_templateService_ResolveAll(t *testing.T) {
+	mockService := templateservice.New(templateservice.WithTemplateStorage(newMockTemplateStorer()))
+	{
+		tc := "Case 1: Every row rendered and the latest version returned"
+		task, err := mockService.ResolveAll(context.Background(), model.MailTaskQueue{UserID: 1, TemplateID: 3}, []map[string]interface{}{
+			{"name": "Ada", "team": "Ops"},
+			{"name": "Grace", "team": "Dev"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || task.TemplateVersion != 2 || len(task.TemplatePartials) != 2 {
+				t.Errorf("%s: expected version 2 with 2 partials but got %+v, %v", tc, task, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Row missing a variable returns render error naming the row"
+		_, err := mockService.ResolveAll(context.Background(), model.MailTaskQueue{UserID: 1, TemplateID: 3}, []map[string]interface{}{
+			{"name": "Ada", "team": "Ops"},
+			{"name": "Grace"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrRender) || !strings.Contains(err.Error(), "row 2") {
+				t.Errorf("%s: expected %v of row 2 but got %v", tc, templateservice.ErrRender, err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Template of another user returns not found error"
+		_, err := mockService.ResolveAll(context.Background(), model.MailTaskQueue{UserID: 2, TemplateID: 3}, nil)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateNotFound, err)
+			}
+		})
+	}
+}
+
+func Test_templateService_Render(t *testing.T) {
+	mockService := templateservice.New(templateservice.WithTemplateStorage(newMockTemplateStorer()))
+	{
+		tc := "Case 1: Bodies are rendered into the layout with the partials and HTML is escaped"
+		task, err := mockService.Render(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVersion: 2, Body: "stale",
+			TemplateVariables: map[string]interface{}{"name": "<Ada>", "team": "Ops"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if task.Subject != "Welcome <Ada>" {
+				t.Errorf("%s: expected subject %q but got %q", tc, "Welcome <Ada>", task.Subject)
+			}
+			if want := "Hello <Ada>\nThanks, Ops\n--\nAcme"; task.TextBody != want {
+				t.Errorf("%s: expected text body %q but got %q", tc, want, task.TextBody)
+			}
+			if want := "<html><body><h1>Hello &lt;Ada&gt;</h1><p>Thanks, Ops</p></body></html>"; task.HTMLBody != want {
+				t.Errorf("%s: expected html body %q but got %q", tc, want, task.HTMLBody)
+			}
+			if task.Body != "" {
+				t.Errorf("%s: expected empty body but got %q", tc, task.Body)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Variable with a line break is kept out of the subject line"
+		task, err := mockService.Render(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVersion: 2,
+			TemplateVariables: map[string]interface{}{"name": "Ada\r\nBcc: x@example.com", "team": "Ops"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: expected nil but got %v", tc, err)
+			}
+			if want := "Welcome Ada Bcc: x@example.com"; task.Subject != want {
+				t.Errorf("%s: expected subject %q but got %q", tc, want, task.Subject)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Version that does not exist returns not found error"
+		_, err := mockService.Render(context.Background(), model.MailTaskQueue{UserID: 1, TemplateID: 3, TemplateVersion: 3})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrTemplateNotFound) {
+				t.Errorf("%s: expected %v but got %v", tc, templateservice.ErrTemplateNotFound, err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Task is rendered with the versions of its pinned partials after they were updated"
+		mockTemplateStorer := newMockTemplateStorer()
+		mockTemplateStorer.templates[1].Version, mockTemplateStorer.templates[1].TextBody = 2, "Cheers, {{.team}}"
+		mockTemplateStorer.versions = append(mockTemplateStorer.versions, model.MailTemplateVersion{TemplateID: 2, Version: 2, TextBody: "Cheers, {{.team}}"})
+		mockService := templateservice.New(templateservice.WithTemplateStorage(mockTemplateStorer))
+		task, err := mockService.Render(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVersion: 2,
+			TemplatePartials:  []model.MailTemplatePartial{{Name: "layout", TemplateID: 1, Version: 1}, {Name: "signature", TemplateID: 2, Version: 1}},
+			TemplateVariables: map[string]interface{}{"name": "Ada", "team": "Ops"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if want := "Hello Ada\nThanks, Ops\n--\nAcme"; err != nil || task.TextBody != want {
+				t.Errorf("%s: expected text body %q but got %q, %v", tc, want, task.TextBody, err)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Output over the size limit returns render error"
+		_, err := mockService.Render(context.Background(), model.MailTaskQueue{
+			UserID: 1, TemplateID: 3, TemplateVersion: 2,
+			TemplateVariables: map[string]interface{}{"name": strings.Repeat("a", constant.TemplateMaxOutputSize), "team": "Ops"},
+		})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, templateservice.ErrRender) || !strings.Contains(err.Error(), "larger than") {
+				t.Errorf("%s: expected %v for output over the limit but got %v", tc, templateservice.ErrRender, err)
+			}
+		})
+	}
+}
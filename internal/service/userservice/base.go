@@ -8,12 +8,14 @@ import (
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/userstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 )
 
 type UserService interface {
 	Register(ctx context.Context, req dtoreq.RegisterRequest) error
 	Login(ctx context.Context, req dtoreq.LoginRequest) (dtores.LoginResponse, error)
 	GetUser(ctx context.Context, req dtoreq.GetUserRequest) (dtores.GetUserResponse, error)
+	UpdateSmtpSettings(ctx context.Context, req dtoreq.UpdateSmtpSettingsRequest) error
 }
 
 type userService struct {
@@ -21,6 +23,8 @@ type userService struct {
 	userStorage userstorage.UserStorer
 	taskStorage taskstorage.TaskStorer
 	mailService mailservice.MailService
+	breaker     breaker.Breaker
+	providers   mailservice.ProviderPolicy
 }
 
 type Option func(*userService)
@@ -49,6 +53,21 @@ func WithMailService(mailService mailservice.MailService) Option {
 	}
 }
 
+// WithBreaker shows the circuit of the SMTP endpoint of a user in GetUser.
+func WithBreaker(b breaker.Breaker) Option {
+	return func(u *userService) {
+		u.breaker = b
+	}
+}
+
+// WithProviderPolicy sets the providers users can register with, by default
+// only the smtp provider and the http provider to public addresses.
+func WithProviderPolicy(policy mailservice.ProviderPolicy) Option {
+	return func(u *userService) {
+		u.providers = policy
+	}
+}
+
 func New(opts ...Option) UserService {
 	service := &userService{}
 	for _, opt := range opts {
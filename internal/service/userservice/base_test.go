@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
 	"time"
@@ -33,7 +34,7 @@ func (m *mockTaskStorer) GetAll(ctx context.Context, userID uint) ([]model.MailT
 	return m.taskModelArr, m.errGetAll
 }
 
-func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context) ([]model.MailTaskQueue, error) {
+func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
 	return m.taskModelArr, m.errGetAllByUnprocessedTasks
 }
 
@@ -49,6 +50,22 @@ func (m *mockTaskStorer) Delete(ctx context.Context, id uint) error {
 	return m.errDelete
 }
 
+func (m *mockTaskStorer) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return tasks, nil
+}
+
+func (m *mockTaskStorer) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskStorer) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
 type mockUserStorer struct {
 	errInsert     error
 	errGetByID    error
@@ -56,6 +73,7 @@ type mockUserStorer struct {
 	errUpdate     error
 	errDelete     error
 	userModel     model.User
+	updatedUser   model.User
 }
 
 func (m *mockUserStorer) Insert(ctx context.Context, user model.User, tx ...*gorm.DB) error {
@@ -74,6 +92,15 @@ func (m *mockUserStorer) Update(ctx context.Context, user model.User, tx ...*gor
 	return m.errUpdate
 }
 
+func (m *mockUserStorer) SetSmtpPause(ctx context.Context, id uint, authError string, until time.Time) error {
+	return nil
+}
+
+func (m *mockUserStorer) UpdateSmtpSettings(ctx context.Context, user model.User) error {
+	m.updatedUser = user
+	return m.errUpdate
+}
+
 func (m *mockUserStorer) Delete(ctx context.Context, id uint) error {
 	return m.errDelete
 }
@@ -111,7 +138,15 @@ func (m *mockMailService) NewMessage() *gomail.Message {
 	return nil
 }
 
-func (m *mockMailService) SendMail(d mailservice.Dialer, me *gomail.Message) error {
+func (m *mockMailService) NewMail() mailservice.Mail {
+	return mailservice.Mail{}
+}
+
+func (m *mockMailService) NewTransport() mailservice.Transport {
+	return nil
+}
+
+func (m *mockMailService) SendMail(t mailservice.Transport, mail mailservice.Mail) error {
 	return m.errSendMail
 }
 
@@ -137,3 +172,30 @@ type mockJwtUtils struct {
 func (m *mockJwtUtils) GenerateJwtToken(userID uint, expiration time.Duration) (string, error) {
 	return m.generateJwtTokenRes, m.errGenerateJwtToken
 }
+
+type mockBreaker struct {
+	errStatus error
+	status    breaker.Status
+	endpoint  string
+}
+
+func (m *mockBreaker) Allow(ctx context.Context, endpoint string) (breaker.Result, error) {
+	return breaker.Result{Allowed: true}, nil
+}
+
+func (m *mockBreaker) Success(ctx context.Context, endpoint string) (breaker.Result, error) {
+	return breaker.Result{}, nil
+}
+
+func (m *mockBreaker) Failure(ctx context.Context, endpoint string, reason error) (breaker.Result, error) {
+	return breaker.Result{}, nil
+}
+
+func (m *mockBreaker) Release(ctx context.Context, endpoint string) error {
+	return nil
+}
+
+func (m *mockBreaker) Status(ctx context.Context, endpoint string) (breaker.Status, error) {
+	m.endpoint = endpoint
+	return m.status, m.errStatus
+}
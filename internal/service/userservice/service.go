@@ -5,6 +5,7 @@ import (
 	"fmt"
 	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
 	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"time"
 )
@@ -22,6 +23,9 @@ func (s *userService) Register(ctx context.Context, req dtoreq.RegisterRequest)
 		if _, err := s.userStorage.GetByEmail(ctx, req.Email); err == nil {
 			return fmt.Errorf("email already exists")
 		}
+		if err := s.providers.Check(req.ConvertToUser()); err != nil {
+			return err
+		}
 		hashPwd, err := s.Packages.PassUtils.HashPassword(req.Password)
 		if err != nil {
 			return fmt.Errorf("error hashing password: %w", err)
@@ -75,6 +79,28 @@ func (s *userService) GetUser(ctx context.Context, req dtoreq.GetUserRequest) (d
 			return res, fmt.Errorf("error getting user: %w", err)
 		}
 		res.FromUser(user)
+		if s.breaker != nil {
+			status, err := s.breaker.Status(ctx, mailservice.Endpoint(user))
+			if err != nil {
+				return res, fmt.Errorf("error getting smtp circuit: %w", err)
+			}
+			res.FromCircuit(status)
+		}
 		return res, nil
 	}
 }
+
+// UpdateSmtpSettings sets the SMTP settings of a user. A user paused because
+// the server rejected its settings is resumed, its tasks are sent with the new
+// settings when they are due.
+func (s *userService) UpdateSmtpSettings(ctx context.Context, req dtoreq.UpdateSmtpSettingsRequest) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		if err := s.userStorage.UpdateSmtpSettings(ctx, req.ConvertToUser()); err != nil {
+			return fmt.Errorf("error updating smtp settings: %w", err)
+		}
+		return nil
+	}
+}
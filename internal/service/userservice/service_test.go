@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/userservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"testing"
+	"time"
 )
 
 func Test_userService_Register(t *testing.T) {
@@ -79,7 +83,19 @@ func Test_userService_Register(t *testing.T) {
 		mockUserStorer.errGetByEmail = nil
 	}
 	{
-		tc := "Case 5: Success And Should Return Nil"
+		tc := "Case 5: Provider Not Allowed And Should Return Error"
+		mockUserStorer.errGetByEmail = errors.New("not found")
+
+		err := userService.Register(context.Background(), dtoreq.RegisterRequest{Provider: mailservice.ProviderFile, ProviderDir: "/etc"})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, mailservice.ErrProviderNotAllowed) {
+				t.Errorf("Expected error to be %v but got %v", mailservice.ErrProviderNotAllowed, err)
+			}
+		})
+		mockUserStorer.errGetByEmail = nil
+	}
+	{
+		tc := "Case 6: Success And Should Return Nil"
 		mockUserStorer.errGetByEmail = errors.New("not found")
 		mockUserStorer.errInsert = nil
 
@@ -201,11 +217,94 @@ func Test_userService_GetUser(t *testing.T) {
 	}
 	{
 		tc := "Case 3: Success And Should Return Nil"
+		res, err := userService.GetUser(context.Background(), dtoreq.GetUserRequest{})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected error to be nil but got %v", err)
+			}
+			if res.SmtpCircuit != nil {
+				t.Errorf("Expected no circuit without breaker but got %v", res.SmtpCircuit)
+			}
+		})
+	}
+	mockBreaker := &mockBreaker{}
+	userService = userservice.New(
+		userservice.WithUserStorage(mockUserStorer),
+		userservice.WithBreaker(mockBreaker),
+	)
+	mockUserStorer.userModel = model.User{SmtpHost: "smtp.test.com", SmtpPort: 587}
+	{
+		tc := "Case 4: Error Getting Circuit And Should Return Error"
+		mockBreaker.errStatus = errors.New("redis error")
+
 		_, err := userService.GetUser(context.Background(), dtoreq.GetUserRequest{})
+		want := "error getting smtp circuit: redis error"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || err.Error() != want {
+				t.Errorf("Expected error to be %s but got %v", want, err)
+			}
+		})
+		mockBreaker.errStatus = nil
+	}
+	{
+		tc := "Case 5: Open Circuit Of SMTP Endpoint Returned With User"
+		openUntil := time.Now().Add(time.Minute)
+		mockBreaker.status = breaker.Status{State: breaker.StateOpen, Failures: 5, OpenUntil: openUntil, LastError: "connection refused"}
+
+		res, err := userService.GetUser(context.Background(), dtoreq.GetUserRequest{})
 		t.Run(tc, func(t *testing.T) {
 			if err != nil {
 				t.Errorf("Expected error to be nil but got %v", err)
 			}
+			if mockBreaker.endpoint != "smtp.test.com:587" {
+				t.Errorf("Expected circuit of smtp.test.com:587 but got %s", mockBreaker.endpoint)
+			}
+			circuit := res.SmtpCircuit
+			if circuit == nil || circuit.State != breaker.StateOpen || circuit.Failures != 5 ||
+				circuit.OpenUntil == nil || !circuit.OpenUntil.Equal(openUntil) || circuit.LastError != "connection refused" {
+				t.Errorf("Expected open circuit but got %v", circuit)
+			}
+		})
+	}
+	mockUserStorer.userModel = model.User{}
+}
+
+func Test_userService_UpdateSmtpSettings(t *testing.T) {
+	mockUserStorer := &mockUserStorer{}
+	userService := userservice.New(
+		userservice.WithUserStorage(mockUserStorer),
+	)
+	req := dtoreq.UpdateSmtpSettingsRequest{
+		UserID:       1,
+		SmtpHost:     "smtp.test.com",
+		SmtpPort:     587,
+		SmtpUsername: "test",
+		SmtpPassword: "secret",
+	}
+	{
+		tc := "Case 1: Error Updating Smtp Settings And Should Return Error"
+		mockUserStorer.errUpdate = errors.New("db error")
+
+		err := userService.UpdateSmtpSettings(context.Background(), req)
+		want := "error updating smtp settings: db error"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || err.Error() != want {
+				t.Errorf("Expected error to be %s but got %v", want, err)
+			}
+		})
+		mockUserStorer.errUpdate = nil
+	}
+	{
+		tc := "Case 2: Success And Settings Of User Updated"
+		err := userService.UpdateSmtpSettings(context.Background(), req)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected error to be nil but got %v", err)
+			}
+			user := mockUserStorer.updatedUser
+			if user.ID != 1 || user.SmtpHost != "smtp.test.com" || user.SmtpPort != 587 {
+				t.Errorf("Expected settings of user 1 to be updated but got %+v", user)
+			}
 		})
 	}
 }
@@ -3,9 +3,16 @@ package workerservice
 import (
 	"context"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attachmentstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attemptstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/userstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"os"
 )
 
 type IWorker interface {
@@ -14,12 +21,19 @@ type IWorker interface {
 }
 
 type worker struct {
-	id          uint32
-	mailService mailservice.MailService
-	taskStorage taskstorage.TaskStorer
-	taskqueue   taskqueue.TaskQueue
-	taskChannel chan model.MailTaskQueue
-	done        chan struct{}
+	id             uint32
+	podName        string
+	mailService    mailservice.MailService
+	taskStorage    taskstorage.TaskStorer
+	userStorage    userstorage.UserStorer
+	attemptStorage attemptstorage.AttemptStorer
+	attachments    attachmentstorage.AttachmentStorer
+	templates      templateservice.TemplateService
+	taskqueue      taskqueue.TaskQueue
+	limiter        ratelimit.Limiter
+	breaker        breaker.Breaker
+	taskChannel    chan model.MailTaskQueue
+	done           chan struct{}
 }
 
 type Option func(*worker)
@@ -30,18 +44,71 @@ func WithID(id int) Option {
 	}
 }
 
+// WithPodName sets the name of the pod of the worker, the host name by default.
+func WithPodName(name string) Option {
+	return func(w *worker) {
+		w.podName = name
+	}
+}
+
 func WithTaskStorage(rds taskstorage.TaskStorer) Option {
 	return func(w *worker) {
 		w.taskStorage = rds
 	}
 }
 
+func WithUserStorage(storage userstorage.UserStorer) Option {
+	return func(w *worker) {
+		w.userStorage = storage
+	}
+}
+
+// WithAttemptStorage records the send attempts of the tasks, no attempts are
+// recorded without it.
+func WithAttemptStorage(storage attemptstorage.AttemptStorer) Option {
+	return func(w *worker) {
+		w.attemptStorage = storage
+	}
+}
+
+// WithAttachmentStorage loads the attachments of the tasks, the mails are sent
+// without attachments without it.
+func WithAttachmentStorage(storage attachmentstorage.AttachmentStorer) Option {
+	return func(w *worker) {
+		w.attachments = storage
+	}
+}
+
+// WithTemplateService renders the mails of the tasks sent with a template, the
+// tasks are sent with the subject and body they were enqueued with without it.
+func WithTemplateService(service templateservice.TemplateService) Option {
+	return func(w *worker) {
+		w.templates = service
+	}
+}
+
 func WithTaskQueue(rds taskqueue.TaskQueue) Option {
 	return func(w *worker) {
 		w.taskqueue = rds
 	}
 }
 
+// WithLimiter limits the mails sent to the SMTP host and by the user of a task,
+// the mails are not limited without a limiter.
+func WithLimiter(limiter ratelimit.Limiter) Option {
+	return func(w *worker) {
+		w.limiter = limiter
+	}
+}
+
+// WithBreaker parks the tasks of SMTP endpoints that can not be reached, the
+// tasks are sent as usual without a breaker.
+func WithBreaker(b breaker.Breaker) Option {
+	return func(w *worker) {
+		w.breaker = b
+	}
+}
+
 func WithChannel(ch chan model.MailTaskQueue) Option {
 	return func(w *worker) {
 		w.taskChannel = ch
@@ -65,5 +132,8 @@ func New(opts ...Option) IWorker {
 	for _, opt := range opts {
 		opt(w)
 	}
+	if w.podName == "" {
+		w.podName, _ = os.Hostname()
+	}
 	return w
 }
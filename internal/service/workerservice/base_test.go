@@ -2,10 +2,16 @@ package workerservice_test
 
 import (
 	"context"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
 	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
+	"time"
 )
 
 type mockTaskStorer struct {
@@ -18,6 +24,8 @@ type mockTaskStorer struct {
 	errDelete                   error
 	taskModelArr                []model.MailTaskQueue
 	taskModel                   model.MailTaskQueue
+	updatedTask                 model.MailTaskQueue
+	updatedStatuses             []int
 }
 
 func (m *mockTaskStorer) Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error) {
@@ -32,7 +40,7 @@ func (m *mockTaskStorer) GetAll(ctx context.Context, userID uint) ([]model.MailT
 	return m.taskModelArr, m.errGetAll
 }
 
-func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context) ([]model.MailTaskQueue, error) {
+func (m *mockTaskStorer) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
 	return m.taskModelArr, m.errGetAllByUnprocessedTasks
 }
 
@@ -41,6 +49,8 @@ func (m *mockTaskStorer) GetAllByStatusWithUserID(ctx context.Context, state int
 }
 
 func (m *mockTaskStorer) Update(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) error {
+	m.updatedTask = task
+	m.updatedStatuses = append(m.updatedStatuses, task.Status)
 	return m.errUpdate
 }
 
@@ -48,12 +58,146 @@ func (m *mockTaskStorer) Delete(ctx context.Context, id uint) error {
 	return m.errDelete
 }
 
+func (m *mockTaskStorer) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	return tasks, nil
+}
+
+func (m *mockTaskStorer) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	return nil, nil
+}
+
+func (m *mockTaskStorer) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+func (m *mockTaskStorer) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	return 0, nil
+}
+
+type mockAttemptStorer struct {
+	errInsert error
+	attempts  []model.MailTaskAttempt
+}
+
+func (m *mockAttemptStorer) Insert(ctx context.Context, attempt model.MailTaskAttempt) (model.MailTaskAttempt, error) {
+	m.attempts = append(m.attempts, attempt)
+	return attempt, m.errInsert
+}
+
+func (m *mockAttemptStorer) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailTaskAttempt, error) {
+	return m.attempts, nil
+}
+
+type mockAttachmentStorer struct {
+	errGetAllByTaskID error
+	attachments       []model.MailAttachment
+}
+
+func (m *mockAttachmentStorer) Insert(ctx context.Context, attachment model.MailAttachment, tx ...*gorm.DB) (model.MailAttachment, error) {
+	return attachment, nil
+}
+
+func (m *mockAttachmentStorer) GetAllByIDs(ctx context.Context, userID uint, ids []uint, tx ...*gorm.DB) ([]model.MailAttachment, error) {
+	return m.attachments, nil
+}
+
+func (m *mockAttachmentStorer) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailAttachment, error) {
+	return m.attachments, m.errGetAllByTaskID
+}
+
+func (m *mockAttachmentStorer) AttachToTask(ctx context.Context, taskID uint, ids []uint, tx ...*gorm.DB) (int, error) {
+	return len(ids), nil
+}
+
+func (m *mockAttachmentStorer) TotalSizeByUserID(ctx context.Context, userID uint, tx ...*gorm.DB) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockAttachmentStorer) LockQuota(ctx context.Context, userID uint, tx *gorm.DB) error {
+	return nil
+}
+
+func (m *mockAttachmentStorer) GetAllExpired(ctx context.Context, unattachedBefore time.Time, limit int) ([]model.MailAttachment, error) {
+	return m.attachments, nil
+}
+
+func (m *mockAttachmentStorer) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *mockAttachmentStorer) CreateTx() *gorm.DB {
+	return nil
+}
+
+func (m *mockAttachmentStorer) CommitTx(tx *gorm.DB) error {
+	return nil
+}
+
+func (m *mockAttachmentStorer) RollbackTx(tx *gorm.DB) {}
+
+type mockUserStorer struct {
+	errGetByID  error
+	userModel   model.User
+	updatedUser model.User
+}
+
+func (m *mockUserStorer) Insert(ctx context.Context, user model.User, tx ...*gorm.DB) error {
+	return nil
+}
+
+func (m *mockUserStorer) GetByID(ctx context.Context, id uint) (model.User, error) {
+	return m.userModel, m.errGetByID
+}
+
+func (m *mockUserStorer) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	return m.userModel, nil
+}
+
+func (m *mockUserStorer) Update(ctx context.Context, user model.User, tx ...*gorm.DB) error {
+	m.updatedUser = user
+	return nil
+}
+
+func (m *mockUserStorer) SetSmtpPause(ctx context.Context, id uint, authError string, until time.Time) error {
+	m.updatedUser.ID = id
+	m.updatedUser.SmtpAuthError = authError
+	m.updatedUser.SmtpPausedUntil = until
+	return nil
+}
+
+func (m *mockUserStorer) UpdateSmtpSettings(ctx context.Context, user model.User) error {
+	return nil
+}
+
+func (m *mockUserStorer) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+
+func (m *mockUserStorer) CreateTx() *gorm.DB {
+	return nil
+}
+
+func (m *mockUserStorer) CommitTx(tx *gorm.DB) {
+
+}
+
+func (m *mockUserStorer) RollbackTx(tx *gorm.DB) {
+
+}
+
+func (m *mockUserStorer) SetTx(tx ...*gorm.DB) *gorm.DB {
+	return nil
+}
+
 type mockMailService struct {
 	errAddTask  error
 	errSendMail error
+	addedTask   model.MailTaskQueue
+	mail        mailservice.Mail
 }
 
 func (m *mockMailService) AddTask(task model.MailTaskQueue) error {
+	m.addedTask = task
 	return m.errAddTask
 }
 
@@ -65,20 +209,48 @@ func (m *mockMailService) NewMessage() *gomail.Message {
 	return nil
 }
 
-func (m *mockMailService) SendMail(d mailservice.Dialer, me *gomail.Message) error {
+func (m *mockMailService) NewMail() mailservice.Mail {
+	return m.mail
+}
+
+func (m *mockMailService) NewTransport() mailservice.Transport {
+	return nil
+}
+
+func (m *mockMailService) SendMail(t mailservice.Transport, mail mailservice.Mail) error {
 	return m.errSendMail
 }
 
 type mockTaskQueue struct {
-	errPublishTask   error
-	errSubscribeTask error
-	errStartConsume  <-chan error
+	errPublishTask       error
+	errSubscribeTask     error
+	errStartConsume      <-chan error
+	errAck               error
+	errNack              error
+	errRetry             error
+	errReapExpiredLeases error
+	errScheduleTask      error
+	errPromoteDueTasks   error
+	promoteDueTasksRes   int
+	errDeadLetter        error
+	errDeadLetters       error
+	deadLettersRes       []taskqueue.DeadLetter
+	errRemoveDeadLetters error
+	removeDeadLettersRes []taskqueue.DeadLetter
+	acked                int
+	nacked               int
+	retriedTask          model.MailTaskQueue
+	retryAt              time.Time
 }
 
 func (m *mockTaskQueue) PublishTask(ctx context.Context, task interface{}) error {
 	return m.errPublishTask
 }
 
+func (m *mockTaskQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
+	return m.errPublishTask
+}
+
 func (m *mockTaskQueue) SubscribeTask(ctx context.Context, consumerID int) error {
 	return m.errSubscribeTask
 }
@@ -86,3 +258,138 @@ func (m *mockTaskQueue) SubscribeTask(ctx context.Context, consumerID int) error
 func (m *mockTaskQueue) StartConsume(ctx context.Context) <-chan error {
 	return m.errStartConsume
 }
+
+func (m *mockTaskQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	m.acked++
+	return m.errAck
+}
+
+func (m *mockTaskQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	m.nacked++
+	return m.errNack
+}
+
+func (m *mockTaskQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	m.retriedTask = task
+	m.retryAt = at
+	return m.errRetry
+}
+
+func (m *mockTaskQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	return 0, m.errReapExpiredLeases
+}
+
+func (m *mockTaskQueue) ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	return m.errScheduleTask
+}
+
+func (m *mockTaskQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	return m.promoteDueTasksRes, m.errPromoteDueTasks
+}
+
+func (m *mockTaskQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	return m.errDeadLetter
+}
+
+func (m *mockTaskQueue) DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]taskqueue.DeadLetter, error) {
+	return m.deadLettersRes, m.errDeadLetters
+}
+
+func (m *mockTaskQueue) RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]taskqueue.DeadLetter, error) {
+	return m.removeDeadLettersRes, m.errRemoveDeadLetters
+}
+
+func (m *mockTaskQueue) Close(ctx context.Context) error {
+	return nil
+}
+
+type mockLimiter struct {
+	errAcquire error
+	permit     ratelimit.Permit
+	domains    []string
+}
+
+func (m *mockLimiter) Acquire(ctx context.Context, host string, domains []string, userID uint) (*ratelimit.Permit, error) {
+	m.domains = domains
+	return &m.permit, m.errAcquire
+}
+
+func (m *mockLimiter) AcquireConn(ctx context.Context, host string) (*ratelimit.Permit, error) {
+	return &m.permit, m.errAcquire
+}
+
+type mockBreaker struct {
+	allowRes   breaker.Result
+	failureRes breaker.Result
+	successes  int
+	failures   int
+	releases   int
+}
+
+func (m *mockBreaker) Allow(ctx context.Context, endpoint string) (breaker.Result, error) {
+	return m.allowRes, nil
+}
+
+func (m *mockBreaker) Success(ctx context.Context, endpoint string) (breaker.Result, error) {
+	m.successes++
+	return breaker.Result{Allowed: true, State: breaker.StateClosed}, nil
+}
+
+func (m *mockBreaker) Failure(ctx context.Context, endpoint string, reason error) (breaker.Result, error) {
+	m.failures++
+	return m.failureRes, nil
+}
+
+func (m *mockBreaker) Release(ctx context.Context, endpoint string) error {
+	m.releases++
+	return nil
+}
+
+func (m *mockBreaker) Status(ctx context.Context, endpoint string) (breaker.Status, error) {
+	return breaker.Status{}, nil
+}
+
+type mockTemplateService struct {
+	errRender error
+}
+
+func (m *mockTemplateService) CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error) {
+	return dtores.GetTemplatesResponse{}, nil
+}
+
+func (m *mockTemplateService) GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error) {
+	return dtores.GetTemplateVersionsResponse{}, nil
+}
+
+func (m *mockTemplateService) DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error {
+	return nil
+}
+
+func (m *mockTemplateService) Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTemplateService) ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTemplateService) Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	if m.errRender != nil {
+		return task, m.errRender
+	}
+	task.Subject = "Welcome " + task.TemplateVariables["name"].(string)
+	task.TextBody = "Hello " + task.TemplateVariables["name"].(string)
+	return task, nil
+}
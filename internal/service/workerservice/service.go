@@ -2,16 +2,34 @@ package workerservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/retry"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+	"gorm.io/gorm"
+	"math/rand"
+	"strings"
+	"time"
 )
 
 func (c *worker) TriggerWorker() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	for {
+		// Done is checked first, so a stopping worker leaves the buffered
+		// tasks to be returned to the queue.
+		select {
+		case <-c.done:
+			return fmt.Errorf("worker %d done", c.id)
+		default:
+		}
 		select {
 		case <-c.done:
 			return fmt.Errorf("worker %d done", c.id)
@@ -31,39 +49,384 @@ func (c *worker) HandleTask(ctx context.Context, task model.MailTaskQueue) error
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
+		task, err := c.rehydrate(ctx, task)
+		if errors.Is(err, errTaskFinished) {
+			log.Infof("worker %d skipped finished task %d", c.id, task.ID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if until := task.User.SmtpPausedUntil; until.After(time.Now()) {
+			log.Infof("worker %d postponing task %d of paused user %d", c.id, task.ID, task.UserID)
+			return c.postpone(ctx, task, until)
+		}
+		if task, err = c.render(ctx, task); err != nil {
+			return err
+		}
 		if err := c.mailService.AddTask(task); err != nil {
+			c.deadLetter(ctx, task, err)
 			return fmt.Errorf("worker %d error adding task: %v", c.id, err)
 		}
+		wait, probe := c.checkCircuit(ctx, task)
+		if wait > 0 {
+			return c.delay(ctx, task, time.Now().Add(wait))
+		}
+		if wait := c.throttle(ctx, task); wait > 0 {
+			c.releaseProbe(ctx, task, probe)
+			return c.delay(ctx, task, time.Now().Add(wait))
+		}
+		task = c.startProcessing(ctx, task)
 		log.Infof("worker %d sending mail to %s", c.id, task.RecipientEmail)
-		err := c.mailService.SendMail(c.mailService.NewDialer(), c.mailService.NewMessage())
-		if err != nil {
-			return c.handleError(ctx, task, err)
+		mail := c.mailService.NewMail()
+		err = c.mailService.SendMail(c.mailService.NewTransport(), mail)
+		var connErr *smtppool.ConnLimitError
+		if errors.As(err, &connErr) {
+			log.Infof("worker %d deferring task %d over the %s rate limit", c.id, task.ID, ratelimit.LimitConns)
+			c.releaseProbe(ctx, task, probe)
+			return c.delay(ctx, task, time.Now().Add(jitter(connErr.RetryAfter)))
+		}
+		// The recipients that got the mail are kept, a retry is only sent
+		// to the ones that failed.
+		task.Recipients, err = mailservice.Outcome(task, err)
+		sendErr := mailservice.Classify(err)
+		// A mail without recipients left is not sent, so it has no attempt.
+		if mail.HasRecipients() {
+			c.recordAttempt(ctx, task, sendErr)
+		}
+		c.recordCircuit(ctx, task, sendErr)
+		if sendErr != nil {
+			return c.handleError(ctx, task, sendErr)
 		}
 		task.Status = constant.StatusSuccess
 		if err := c.taskStorage.Update(ctx, task); err != nil {
 			log.Errorf("worker %d error updating task: %v", c.id, err)
 		}
+		if task.User.SmtpAuthError != "" {
+			c.resumeUser(ctx, task.User)
+		}
+		c.ack(ctx, task)
 		log.Infof("worker %d sent mail to %s", c.id, task.RecipientEmail)
 	}
 	return nil
 }
 
-func (c *worker) handleError(ctx context.Context, task model.MailTaskQueue, err error) error {
+// errTaskFinished is returned by rehydrate for envelopes of tasks that were
+// already sent, rejected or cancelled, such as a task published again after a crash of
+// the outbox relay.
+var errTaskFinished = errors.New("task already finished")
+
+// rehydrate loads the task referenced by a queue envelope, the SMTP settings of
+// its user and its attachments from storage, so mails are always sent with the
+// current settings.
+// The try count, trace and lease of the envelope are kept. Envelopes of deleted tasks
+// are acked, other storage errors return the envelope to the queue.
+func (c *worker) rehydrate(ctx context.Context, envelope model.MailTaskQueue) (model.MailTaskQueue, error) {
+	task, err := c.taskStorage.GetByID(ctx, envelope.ID)
+	if err == nil {
+		task.User, err = c.userStorage.GetByID(ctx, task.UserID)
+	}
+	if err == nil && c.attachments != nil {
+		task.Attachments, err = c.attachments.GetAllByTaskID(ctx, task.ID)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.ack(ctx, envelope)
+		} else if err := c.taskqueue.Nack(ctx, envelope); err != nil {
+			log.Errorf("worker %d error nacking task: %v", c.id, err)
+		}
+		return model.MailTaskQueue{}, fmt.Errorf("worker %d error loading task %d: %v", c.id, envelope.ID, err)
+	}
+	if task.Status == constant.StatusSuccess || task.Status == constant.StatusRejected || task.Status == constant.StatusCancelled {
+		c.ack(ctx, envelope)
+		return task, errTaskFinished
+	}
+	task.TryCount = envelope.TryCount
+	task.TraceID = envelope.TraceID
+	task.LeaseID = envelope.LeaseID
+	log.Infof("worker %d loaded task %d trace %s", c.id, task.ID, task.TraceID)
+	return task, nil
+}
+
+// render renders the mail of a task sent with a template. Tasks whose template
+// version is gone or can not be rendered with their variables are
+// dead-lettered, other errors return the task to the queue.
+func (c *worker) render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	if task.TemplateID == 0 || c.templates == nil {
+		return task, nil
+	}
+	rendered, err := c.templates.Render(ctx, task)
+	if err == nil {
+		return rendered, nil
+	}
+	if errors.Is(err, templateservice.ErrTemplateNotFound) || errors.Is(err, templateservice.ErrRender) {
+		c.deadLetter(ctx, task, err)
+	} else if err := c.taskqueue.Nack(ctx, task); err != nil {
+		log.Errorf("worker %d error nacking task: %v", c.id, err)
+	}
+	return task, fmt.Errorf("worker %d error rendering task %d: %v", c.id, task.ID, err)
+}
+
+// startProcessing sets a task to processing by the worker right before its
+// mail is sent, so a task that is being sent can be told apart from a queued one.
+func (c *worker) startProcessing(ctx context.Context, task model.MailTaskQueue) model.MailTaskQueue {
+	task.Status = constant.StatusProcessing
+	task.ProcessingBy = fmt.Sprintf("%s/%d", c.podName, c.id)
+	task.ProcessingStartedAt = time.Now()
+	if err := c.taskStorage.Update(ctx, task); err != nil {
+		log.Errorf("worker %d error updating task: %v", c.id, err)
+	}
+	return task
+}
+
+// recordAttempt stores the attempt of sending the mail of a task with the
+// transport of its user. The reply of a failed send is recorded, the transports
+// do not report the replies to sent mails. Errors are only logged, the history
+// is not worth failing the task for.
+func (c *worker) recordAttempt(ctx context.Context, task model.MailTaskQueue, sendErr *mailservice.SendError) {
+	if c.attemptStorage == nil {
+		return
+	}
+	finished := time.Now()
+	attempt := model.MailTaskAttempt{
+		TaskID:     task.ID,
+		WorkerID:   task.ProcessingBy,
+		StartedAt:  task.ProcessingStartedAt,
+		FinishedAt: finished,
+		DurationMs: finished.Sub(task.ProcessingStartedAt).Milliseconds(),
+		Transport:  mailservice.ProviderOf(task.User),
+	}
+	if sendErr != nil {
+		attempt.ResponseCode = sendErr.Code
+		attempt.ResponseMessage = sendErr.Error()
+		attempt.ErrorClass = sendErr.Class
+	}
+	if _, err := c.attemptStorage.Insert(ctx, attempt); err != nil {
+		log.Errorf("worker %d error recording attempt: %v", c.id, err)
+	}
+}
+
+// handleError acts on the class of a send error. Permanent failures reject the
+// task and auth failures pause its user, both without burning a try. Other
+// failures retry the task after the backoff of its retry policy, tasks that
+// exhausted their attempts are dead-lettered.
+func (c *worker) handleError(ctx context.Context, task model.MailTaskQueue, err *mailservice.SendError) error {
 	log.Errorf("worker %d error sending mail to %s: %v", c.id, task.RecipientEmail, err)
+	task.LastError = err.Error()
+	task.FailureReason = err.Class
+	switch err.Class {
+	case mailservice.FailurePermanent:
+		return c.reject(ctx, task)
+	case mailservice.FailureAuth:
+		return c.pauseUser(ctx, task, err)
+	}
+	policy := retry.Resolve(task.User.RetryPolicy, task.RetryPolicy)
 	task.TryCount++
-	if task.TryCount >= constant.MaxTryCount {
-		task.Status = constant.StatusCancelled
-		if err := c.taskStorage.Update(ctx, task); err != nil {
-			log.Errorf("worker %d error updating task: %v", c.id, err)
-		}
+	if policy.Exhausted(task.TryCount) {
+		c.deadLetter(ctx, task, err)
 		return fmt.Errorf("task %d cancelled after %d tries", task.ID, task.TryCount)
 	}
+	return c.postpone(ctx, task, time.Now().Add(policy.Delay(task.TryCount)))
+}
+
+// checkCircuit returns how long to park a task whose SMTP endpoint has an open
+// circuit, parked tasks are delayed without burning a try. It also reports
+// whether the task was let through as the probe of a half open circuit. Errors
+// of the breaker are logged and let the mail through.
+func (c *worker) checkCircuit(ctx context.Context, task model.MailTaskQueue) (time.Duration, bool) {
+	if c.breaker == nil {
+		return 0, false
+	}
+	endpoint := mailservice.Endpoint(task.User)
+	res, err := c.breaker.Allow(ctx, endpoint)
+	if err != nil {
+		log.Errorf("worker %d error checking circuit: %v", c.id, err)
+		return 0, false
+	}
+	c.logCircuit(endpoint, res)
+	if res.Allowed {
+		return 0, res.State == breaker.StateHalfOpen
+	}
+	log.Infof("worker %d parking task %d of %s circuit %s", c.id, task.ID, res.State, endpoint)
+	return jitter(res.RetryAfter), false
+}
+
+// releaseProbe gives up the probe of a half open circuit when the probe task is
+// deferred without sending, so the next task of the endpoint probes it instead
+// of waiting for the probe timeout.
+func (c *worker) releaseProbe(ctx context.Context, task model.MailTaskQueue, probe bool) {
+	if !probe {
+		return
+	}
+	if err := c.breaker.Release(ctx, mailservice.Endpoint(task.User)); err != nil {
+		log.Errorf("worker %d error releasing probe: %v", c.id, err)
+	}
+}
+
+// recordCircuit records the result of a send in the circuit of its SMTP
+// endpoint. Only connection failures count, any reply of the server means the
+// endpoint can be reached and closes the circuit.
+func (c *worker) recordCircuit(ctx context.Context, task model.MailTaskQueue, sendErr *mailservice.SendError) {
+	if c.breaker == nil {
+		return
+	}
+	endpoint := mailservice.Endpoint(task.User)
+	var (
+		res breaker.Result
+		err error
+	)
+	if sendErr != nil && sendErr.Class == mailservice.FailureConnection {
+		res, err = c.breaker.Failure(ctx, endpoint, sendErr)
+	} else {
+		res, err = c.breaker.Success(ctx, endpoint)
+	}
+	if err != nil {
+		log.Errorf("worker %d error recording circuit: %v", c.id, err)
+		return
+	}
+	c.logCircuit(endpoint, res)
+}
+
+func (c *worker) logCircuit(endpoint string, res breaker.Result) {
+	if res.Changed {
+		log.Infof("worker %d circuit %s is %s", c.id, endpoint, res.State)
+	}
+}
+
+// throttle takes a permit of the rate limiter for the SMTP host, the user and
+// the domains of the recipients a task is still to be sent to. It returns how long to defer a task over the
+// limits. Errors of the limiter are logged and let the mail through. The
+// connections to the host are limited by the SMTP pool.
+func (c *worker) throttle(ctx context.Context, task model.MailTaskQueue) time.Duration {
+	if c.limiter == nil {
+		return 0
+	}
+	permit, err := c.limiter.Acquire(ctx, mailservice.Host(task.User), domainsOf(task), task.UserID)
+	if err != nil {
+		log.Errorf("worker %d error acquiring rate limit: %v", c.id, err)
+		return 0
+	}
+	if !permit.Allowed {
+		log.Infof("worker %d deferring task %d over the %s rate limit", c.id, task.ID, permit.Limit)
+		return jitter(permit.RetryAfter)
+	}
+	return 0
+}
+
+// jitter adds up to the wait again to a wait, so deferred tasks do not come
+// back at once. A wait that is not positive, such as one skewed by the clock of
+// redis, is returned as it is.
+func jitter(wait time.Duration) time.Duration {
+	if wait <= 0 {
+		return wait
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)+1))
+}
+
+// domainsOf returns the domains of the pending recipients of a task.
+func domainsOf(task model.MailTaskQueue) []string {
+	var domains []string
+	for _, r := range mailservice.Recipients(task) {
+		if mailservice.Pending(r) {
+			domains = append(domains, domainOf(r.Email))
+		}
+	}
+	return domains
+}
+
+// domainOf returns the domain of a recipient address.
+func domainOf(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return strings.ToLower(strings.TrimRight(email[i+1:], ">"))
+	}
+	return ""
+}
+
+// postpone sets a task to failed and moves it to the scheduled set, so it is
+// queued again at the given time.
+func (c *worker) postpone(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	task.Status = constant.StatusFailed
+	task.NextAttemptAt = at
+	if err := c.taskStorage.Update(ctx, task); err != nil {
+		log.Errorf("worker %d error updating task: %v", c.id, err)
+	}
+	if err := c.taskqueue.Retry(ctx, task, task.NextAttemptAt); err != nil {
+		log.Errorf("worker %d error retrying task: %v", c.id, err)
+		return nil
+	}
+	log.Infof("worker %d retrying task %d at %s", c.id, task.ID, task.NextAttemptAt.Format(time.RFC3339))
+	return nil
+}
+
+// delay queues a throttled task again at the given time. It was not sent, so
+// its tries are kept. Like a retry it waits as failed, a queued row could be
+// claimed by another worker before the queue holds the task.
+func (c *worker) delay(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
 	task.Status = constant.StatusFailed
+	task.NextAttemptAt = at
 	if err := c.taskStorage.Update(ctx, task); err != nil {
 		log.Errorf("worker %d error updating task: %v", c.id, err)
 	}
-	if err := c.taskqueue.PublishTask(ctx, task); err != nil {
-		log.Errorf("worker %d error publishing task: %v", c.id, err)
+	if err := c.taskqueue.Retry(ctx, task, task.NextAttemptAt); err != nil {
+		log.Errorf("worker %d error delaying task: %v", c.id, err)
+		return nil
 	}
+	log.Infof("worker %d delaying task %d until %s", c.id, task.ID, task.NextAttemptAt.Format(time.RFC3339))
 	return nil
 }
+
+// reject ends a task the SMTP server refused for good, such as an unknown
+// recipient. Sending it again would fail the same way.
+func (c *worker) reject(ctx context.Context, task model.MailTaskQueue) error {
+	task.Status = constant.StatusRejected
+	if err := c.taskStorage.Update(ctx, task); err != nil {
+		log.Errorf("worker %d error updating task: %v", c.id, err)
+	}
+	c.ack(ctx, task)
+	return fmt.Errorf("task %d rejected: %s", task.ID, task.LastError)
+}
+
+// pauseUser flags the SMTP settings of a user that the server rejected and
+// postpones the task. The tasks of the user are postponed for SmtpAuthPause,
+// the pause is lifted by the first mail the user sends after it.
+func (c *worker) pauseUser(ctx context.Context, task model.MailTaskQueue, err *mailservice.SendError) error {
+	user := task.User
+	user.SmtpAuthError = err.Error()
+	user.SmtpPausedUntil = time.Now().Add(constant.SmtpAuthPause)
+	if err := c.userStorage.SetSmtpPause(ctx, user.ID, user.SmtpAuthError, user.SmtpPausedUntil); err != nil {
+		log.Errorf("worker %d error pausing user: %v", c.id, err)
+	}
+	log.Warnf("worker %d paused user %d until %s", c.id, user.ID, user.SmtpPausedUntil.Format(time.RFC3339))
+	return c.postpone(ctx, task, user.SmtpPausedUntil)
+}
+
+// resumeUser clears the SMTP auth flag of a user whose mail was sent again.
+func (c *worker) resumeUser(ctx context.Context, user model.User) {
+	if err := c.userStorage.SetSmtpPause(ctx, user.ID, "", time.Time{}); err != nil {
+		log.Errorf("worker %d error resuming user: %v", c.id, err)
+		return
+	}
+	log.Infof("worker %d resumed user %d", c.id, user.ID)
+}
+
+// deadLetter cancels a task that can not be delivered and moves it to the
+// dead-letter queue, where it can be inspected and replayed.
+func (c *worker) deadLetter(ctx context.Context, task model.MailTaskQueue, reason error) {
+	task.Status = constant.StatusCancelled
+	task.DeadLettered = true
+	task.LastError = reason.Error()
+	if err := c.taskStorage.Update(ctx, task); err != nil {
+		log.Errorf("worker %d error updating task: %v", c.id, err)
+	}
+	if err := c.taskqueue.DeadLetter(ctx, task, reason); err != nil {
+		log.Errorf("worker %d error dead-lettering task: %v", c.id, err)
+	}
+}
+
+// ack releases the lease of a finished task. A failed ack is only logged, the
+// task is delivered again once its lease expires.
+func (c *worker) ack(ctx context.Context, task model.MailTaskQueue) {
+	if err := c.taskqueue.Ack(ctx, task); err != nil {
+		log.Errorf("worker %d error acking task: %v", c.id, err)
+	}
+}
@@ -4,16 +4,28 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/workerservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+	"gorm.io/gorm"
+	"net"
+	"net/textproto"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_worker_TriggerWorker(t *testing.T) {
 	mockTaskStorer := &mockTaskStorer{}
+	mockUserStorer := &mockUserStorer{}
 	mockMailService := &mockMailService{}
 	mockTaskQueue := &mockTaskQueue{}
 	{
@@ -21,6 +33,7 @@ func Test_worker_TriggerWorker(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 			workerservice.WithDoneChannel(done),
@@ -43,6 +56,7 @@ func Test_worker_TriggerWorker(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 			workerservice.WithChannel(taskChannel),
@@ -66,6 +80,7 @@ func Test_worker_TriggerWorker(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 			workerservice.WithChannel(taskChannel),
@@ -73,14 +88,14 @@ func Test_worker_TriggerWorker(t *testing.T) {
 		)
 		tc := "Case 3: Task MaxTryCount reached and print logs"
 
+		mockTaskStorer.taskModel = model.MailTaskQueue{RecipientEmail: "test@test.com"}
 		mockMailService.errSendMail = errors.New("send mail error")
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
 
 		go func() {
 			taskChannel <- model.MailTaskQueue{
-				TryCount:       4,
-				RecipientEmail: "test@test.com",
+				TryCount: 4,
 			}
 			close(taskChannel)
 			close(done)
@@ -101,17 +116,20 @@ func Test_worker_TriggerWorker(t *testing.T) {
 			}
 		})
 		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
 	}
 }
 
 func Test_worker_HandleTask(t *testing.T) {
 	mockTaskStorer := &mockTaskStorer{}
-	mockMailService := &mockMailService{}
+	mockUserStorer := &mockUserStorer{}
+	mockMailService := &mockMailService{mail: mailservice.Mail{To: []string{"test@test.com"}}}
 	mockTaskQueue := &mockTaskQueue{}
 	{
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
@@ -130,6 +148,7 @@ func Test_worker_HandleTask(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
@@ -148,6 +167,7 @@ func Test_worker_HandleTask(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
@@ -168,6 +188,7 @@ func Test_worker_HandleTask(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
@@ -190,6 +211,7 @@ func Test_worker_HandleTask(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
@@ -217,11 +239,12 @@ func Test_worker_HandleTask(t *testing.T) {
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
-		tc := "Case 6: Task TryCount is less than MaxTryCount but TaskQueue.PublishTask returns error and print logs"
-		mockTaskQueue.errPublishTask = errors.New("publish task error")
+		tc := "Case 6: Task TryCount is less than MaxTryCount but TaskQueue.Retry returns error and print logs"
+		mockTaskQueue.errRetry = errors.New("retry task error")
 		mockMailService.errSendMail = errors.New("send mail error")
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
@@ -229,7 +252,7 @@ func Test_worker_HandleTask(t *testing.T) {
 		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{
 			TryCount: 1,
 		})
-		want := "worker 1 error publishing task: publish task error"
+		want := "worker 1 error retrying task: retry task error"
 		logContents := buf.String()
 
 		t.Run(tc, func(t *testing.T) {
@@ -237,23 +260,152 @@ func Test_worker_HandleTask(t *testing.T) {
 				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, logContents)
 			}
 		})
-		mockTaskQueue.errPublishTask = nil
+		mockTaskQueue.errRetry = nil
 		mockMailService.errSendMail = nil
 	}
 	{
 		mockWorkerService := workerservice.New(
 			workerservice.WithID(1),
 			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
 			workerservice.WithTaskQueue(mockTaskQueue),
 			workerservice.WithMailService(mockMailService),
 		)
-		tc := "Case 5: All operations are successful"
+		tc := "Case 7: Mail is sent but TaskQueue.Ack returns error and print logs"
+		mockTaskQueue.errAck = errors.New("ack task error")
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
 
 		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{
 			RecipientEmail: "test@test.com",
 		})
+		want := "worker 1 error acking task: ack task error"
+		logContents := buf.String()
+
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, want) {
+				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, logContents)
+			}
+		})
+		mockTaskQueue.errAck = nil
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 8: MaxTryCount reached but TaskQueue.DeadLetter returns error and print logs"
+		mockTaskQueue.errDeadLetter = errors.New("dead letter error")
+		mockMailService.errSendMail = errors.New("send mail error")
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{
+			TryCount: constant.MaxTryCount,
+		})
+		want := "worker 1 error dead-lettering task: dead letter error"
+		logContents := buf.String()
+
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, want) {
+				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, logContents)
+			}
+		})
+		mockTaskQueue.errDeadLetter = nil
+		mockMailService.errSendMail = nil
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 9: Task of envelope is deleted, envelope acked and returns error"
+		mockTaskStorer.errGetByID = gorm.ErrRecordNotFound
+		acked := mockTaskQueue.acked
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		want := "worker 1 error loading task 0: record not found"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %v but got %v", tc, want, err)
+			}
+			if mockTaskQueue.acked != acked+1 {
+				t.Errorf("%s: expected envelope to be acked", tc)
+			}
+		})
+		mockTaskStorer.errGetByID = nil
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 10: UserStorage.GetByID returns error, envelope nacked and returns error"
+		mockUserStorer.errGetByID = errors.New("get by id error")
+		nacked := mockTaskQueue.nacked
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		want := "worker 1 error loading task 0: get by id error"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %v but got %v", tc, want, err)
+			}
+			if mockTaskQueue.nacked != nacked+1 {
+				t.Errorf("%s: expected envelope to be nacked", tc)
+			}
+		})
+		mockUserStorer.errGetByID = nil
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 11: Task already sent, envelope acked and mail not sent again"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, Status: constant.StatusSuccess}
+		acked := mockTaskQueue.acked
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if mockTaskQueue.acked != acked+1 {
+				t.Errorf("%s: expected envelope to be acked", tc)
+			}
+			if !strings.Contains(logContents, "worker 1 skipped finished task 1") || strings.Contains(logContents, "sending mail") {
+				t.Errorf("Expected finished task to be skipped, got log contents:\n%s", logContents)
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 12: All operations are successful"
+		mockTaskStorer.taskModel = model.MailTaskQueue{RecipientEmail: "test@test.com"}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
 		logContents := buf.String()
 		expectedLogs := []string{
 			"worker 1 sending mail to test@test.com",
@@ -268,4 +420,720 @@ func Test_worker_HandleTask(t *testing.T) {
 			}
 		})
 	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 13: Failed task retried after exponential backoff with jitter"
+		mockMailService.errSendMail = errors.New("send mail error")
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, RecipientEmail: "test@test.com"}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		delay := mockTaskQueue.retryAt.Sub(started)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if task := mockTaskQueue.retriedTask; task.TryCount != 2 || task.Status != constant.StatusFailed || !task.NextAttemptAt.Equal(mockTaskQueue.retryAt) {
+				t.Errorf("%s: expected failed task with 2 tries to be retried but got %v", tc, task)
+			}
+			if delay < constant.RetryBaseDelay || delay > 2*constant.RetryBaseDelay+time.Second {
+				t.Errorf("%s: expected second retry within %s and %s but got %s", tc, constant.RetryBaseDelay, 2*constant.RetryBaseDelay, delay)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 14: Max attempts of user override the default and task is dead-lettered"
+		mockMailService.errSendMail = errors.New("send mail error")
+		mockUserStorer.userModel = model.User{RetryPolicy: model.RetryPolicy{MaxAttempts: 1}}
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), "cancelled after 1 tries") {
+				t.Errorf("%s: expected task to be cancelled after 1 try but got %v", tc, err)
+			}
+		})
+		mockMailService.errSendMail = nil
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 15: Retry policy of task overrides the one of its user"
+		mockMailService.errSendMail = errors.New("send mail error")
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, RetryPolicy: model.RetryPolicy{MaxAttempts: 5, RetryBaseDelay: 600}}
+		mockUserStorer.userModel = model.User{RetryPolicy: model.RetryPolicy{MaxAttempts: 1, RetryMaxDelay: 60}}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 3})
+		delay := mockTaskQueue.retryAt.Sub(started)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if mockTaskQueue.retriedTask.TryCount != 4 {
+				t.Errorf("%s: expected task with 4 tries to be retried but got %v", tc, mockTaskQueue.retriedTask)
+			}
+			if delay < 30*time.Second || delay > 61*time.Second {
+				t.Errorf("%s: expected retry within the max delay of the user but got %s", tc, delay)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+		mockUserStorer.userModel = model.User{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 16: Permanent SMTP failure rejects task without burning a try"
+		mockMailService.errSendMail = &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, RecipientEmail: "test@test.com"}
+		mockTaskQueue.acked = 0
+		mockTaskQueue.retriedTask = model.MailTaskQueue{}
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), "task 1 rejected") {
+				t.Errorf("%s: expected task to be rejected but got %v", tc, err)
+			}
+			task := mockTaskStorer.updatedTask
+			if task.Status != constant.StatusRejected || task.TryCount != 1 || task.FailureReason != mailservice.FailurePermanent {
+				t.Errorf("%s: expected rejected task with 1 try but got %v", tc, task)
+			}
+			if mockTaskQueue.acked != 1 || mockTaskQueue.retriedTask.ID != 0 {
+				t.Errorf("%s: expected task to be acked and not retried", tc)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 17: SMTP auth failure pauses user and postpones task without burning a try"
+		mockMailService.errSendMail = &textproto.Error{Code: 535, Msg: "5.7.8 Authentication credentials invalid"}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, UserID: 1}
+		mockUserStorer.userModel = model.User{Model: gorm.Model{ID: 1}}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			user := mockUserStorer.updatedUser
+			if user.SmtpAuthError == "" || user.SmtpPausedUntil.Before(started.Add(constant.SmtpAuthPause)) {
+				t.Errorf("%s: expected user to be flagged and paused but got %v", tc, user)
+			}
+			task := mockTaskQueue.retriedTask
+			if task.TryCount != 1 || task.FailureReason != mailservice.FailureAuth || !mockTaskQueue.retryAt.Equal(user.SmtpPausedUntil) {
+				t.Errorf("%s: expected task with 1 try to be retried after the pause but got %v", tc, task)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+		mockUserStorer.userModel = model.User{}
+		mockUserStorer.updatedUser = model.User{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 18: Task of paused user postponed without sending mail"
+		pausedUntil := time.Now().Add(time.Minute)
+		mockMailService.errSendMail = &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		mockUserStorer.userModel = model.User{SmtpAuthError: "535 invalid", SmtpPausedUntil: pausedUntil}
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if !mockTaskQueue.retryAt.Equal(pausedUntil) || mockTaskQueue.retriedTask.TryCount != 0 {
+				t.Errorf("%s: expected task to be retried at the end of the pause but got %s", tc, mockTaskQueue.retryAt)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+		mockUserStorer.userModel = model.User{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 19: Mail sent after the pause clears the SMTP auth flag of the user"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		mockUserStorer.userModel = model.User{Model: gorm.Model{ID: 1}, SmtpAuthError: "535 invalid", SmtpPausedUntil: time.Now().Add(-time.Minute)}
+		mockUserStorer.updatedUser = model.User{}
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if user := mockUserStorer.updatedUser; user.ID != 1 || user.SmtpAuthError != "" || !user.SmtpPausedUntil.IsZero() {
+				t.Errorf("%s: expected flag of user to be cleared but got %v", tc, user)
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+		mockUserStorer.userModel = model.User{}
+	}
+	{
+		mockLimiter := &mockLimiter{permit: ratelimit.Permit{RetryAfter: time.Second, Limit: ratelimit.LimitDomain}}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithLimiter(mockLimiter),
+		)
+		tc := "Case 20: Task over the rate limit of a domain of its pending recipients delayed without burning a try"
+		mockMailService.errSendMail = &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, RecipientEmail: "Test@Gmail.com", Recipients: []model.Recipient{
+			{Email: "Test@Gmail.com", Kind: model.RecipientTo},
+			{Email: "cc@example.com", Kind: model.RecipientCC},
+			{Email: "bcc@sent.com", Kind: model.RecipientBCC, Status: constant.StatusSuccess},
+		}}
+		mockUserStorer.userModel = model.User{SmtpHost: "smtp.test.com"}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		delay := mockTaskQueue.retryAt.Sub(started)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if task := mockTaskQueue.retriedTask; task.TryCount != 1 || task.Status != constant.StatusFailed {
+				t.Errorf("%s: expected waiting task with 1 try to be delayed without sending but got %v", tc, task)
+			}
+			if domains := mockLimiter.domains; len(domains) != 2 || domains[0] != "gmail.com" || domains[1] != "example.com" {
+				t.Errorf("%s: expected permit for domains gmail.com and example.com but got %v", tc, domains)
+			}
+			if delay < time.Second || delay > 2*time.Second+100*time.Millisecond {
+				t.Errorf("%s: expected task to be deferred within 1s and 2s but got %s", tc, delay)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+		mockUserStorer.userModel = model.User{}
+	}
+	{
+		mockLimiter := &mockLimiter{errAcquire: errors.New("acquire error")}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithLimiter(mockLimiter),
+		)
+		tc := "Case 21: Rate limiter error lets the mail through"
+		mockTaskStorer.taskModel = model.MailTaskQueue{RecipientEmail: "test@test.com"}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			for _, expectedLog := range []string{"worker 1 error acquiring rate limit: acquire error", "worker 1 sent mail to test@test.com"} {
+				if !strings.Contains(logContents, expectedLog) {
+					t.Errorf("Expected log \"%s\" not found in log contents:\n%s", expectedLog, logContents)
+				}
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockBreaker := &mockBreaker{allowRes: breaker.Result{State: breaker.StateOpen, RetryAfter: time.Second}}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithBreaker(mockBreaker),
+		)
+		tc := "Case 22: Task of SMTP endpoint with open circuit parked without burning a try"
+		mockMailService.errSendMail = &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 2})
+		delay := mockTaskQueue.retryAt.Sub(started)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if task := mockTaskQueue.retriedTask; task.TryCount != 2 || task.Status != constant.StatusFailed {
+				t.Errorf("%s: expected waiting task with 2 tries to be parked without sending but got %v", tc, task)
+			}
+			if delay < time.Second || delay > 2*time.Second+100*time.Millisecond {
+				t.Errorf("%s: expected task to be parked within 1s and 2s but got %s", tc, delay)
+			}
+			if mockBreaker.successes != 0 || mockBreaker.failures != 0 {
+				t.Errorf("%s: expected no result to be recorded but got %d successes and %d failures", tc, mockBreaker.successes, mockBreaker.failures)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockBreaker := &mockBreaker{
+			allowRes:   breaker.Result{Allowed: true, State: breaker.StateHalfOpen, Changed: true},
+			failureRes: breaker.Result{State: breaker.StateOpen, Changed: true},
+		}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithBreaker(mockBreaker),
+		)
+		tc := "Case 23: Connection failure of probe recorded and circuit changes logged"
+		mockMailService.errSendMail = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		mockUserStorer.userModel = model.User{SmtpHost: "smtp.test.com", SmtpPort: 587}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if mockBreaker.failures != 1 || mockBreaker.successes != 0 {
+				t.Errorf("%s: expected 1 failure but got %d successes and %d failures", tc, mockBreaker.successes, mockBreaker.failures)
+			}
+			for _, expectedLog := range []string{"worker 1 circuit smtp.test.com:587 is half_open", "worker 1 circuit smtp.test.com:587 is open"} {
+				if !strings.Contains(logContents, expectedLog) {
+					t.Errorf("Expected log \"%s\" not found in log contents:\n%s", expectedLog, logContents)
+				}
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockUserStorer.userModel = model.User{}
+	}
+	{
+		mockBreaker := &mockBreaker{allowRes: breaker.Result{Allowed: true, State: breaker.StateClosed}}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithBreaker(mockBreaker),
+		)
+		tc := "Case 24: Reply of SMTP server recorded as success of the circuit"
+		mockMailService.errSendMail = &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		t.Run(tc, func(t *testing.T) {
+			if mockBreaker.successes != 1 || mockBreaker.failures != 0 {
+				t.Errorf("%s: expected 1 success but got %d successes and %d failures", tc, mockBreaker.successes, mockBreaker.failures)
+			}
+		})
+		mockMailService.errSendMail = nil
+	}
+	{
+		mockAttemptStorer := &mockAttemptStorer{}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithPodName("pod-1"),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttemptStorage(mockAttemptStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 25: Task set to processing by worker before sending and sent attempt recorded"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, RecipientEmail: "test@test.com"}
+		mockTaskStorer.updatedStatuses = nil
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		t.Run(tc, func(t *testing.T) {
+			if len(mockTaskStorer.updatedStatuses) != 2 || mockTaskStorer.updatedStatuses[0] != constant.StatusProcessing {
+				t.Errorf("%s: expected task to be processing before sent but got statuses %v", tc, mockTaskStorer.updatedStatuses)
+			}
+			if got := mockTaskStorer.updatedTask; got.Status != constant.StatusSuccess || got.ProcessingBy != "pod-1/1" || got.ProcessingStartedAt.IsZero() {
+				t.Errorf("%s: expected sent task processed by pod-1/1 but got %v", tc, got)
+			}
+			if len(mockAttemptStorer.attempts) != 1 {
+				t.Fatalf("%s: expected 1 attempt but got %d", tc, len(mockAttemptStorer.attempts))
+			}
+			attempt := mockAttemptStorer.attempts[0]
+			if attempt.TaskID != 1 || attempt.WorkerID != "pod-1/1" || attempt.Transport != mailservice.ProviderSMTP ||
+				attempt.ResponseCode != 0 || attempt.ErrorClass != "" || attempt.FinishedAt.Before(attempt.StartedAt) {
+				t.Errorf("%s: expected sent attempt of task 1 by pod-1/1 but got %v", tc, attempt)
+			}
+		})
+	}
+	{
+		mockAttemptStorer := &mockAttemptStorer{errInsert: errors.New("insert error")}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithPodName("pod-1"),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttemptStorage(mockAttemptStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 26: Failed attempt recorded with SMTP reply and error class, storage error logged"
+		mockMailService.errSendMail = &textproto.Error{Code: 421, Msg: "4.7.0 Try again later"}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		t.Run(tc, func(t *testing.T) {
+			if len(mockAttemptStorer.attempts) != 1 {
+				t.Fatalf("%s: expected 1 attempt but got %d", tc, len(mockAttemptStorer.attempts))
+			}
+			attempt := mockAttemptStorer.attempts[0]
+			if attempt.ResponseCode != 421 || !strings.Contains(attempt.ResponseMessage, "4.7.0 Try again later") ||
+				attempt.ErrorClass != mailservice.FailureTransient {
+				t.Errorf("%s: expected transient attempt with 421 reply but got %v", tc, attempt)
+			}
+			if want := "worker 1 error recording attempt: insert error"; !strings.Contains(buf.String(), want) {
+				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, buf.String())
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockAttachmentStorer := &mockAttachmentStorer{
+			attachments: []model.MailAttachment{{Filename: "invoice.pdf", ContentType: "application/pdf", BlobKey: "key"}},
+		}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttachmentStorage(mockAttachmentStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 27: Attachments of task loaded before the mail is added"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 7}}
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 7}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if attachments := mockMailService.addedTask.Attachments; len(attachments) != 1 || attachments[0].BlobKey != "key" {
+				t.Errorf("%s: expected attachment of task but got %v", tc, attachments)
+			}
+		})
+	}
+	{
+		mockAttachmentStorer := &mockAttachmentStorer{errGetAllByTaskID: errors.New("get attachments error")}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttachmentStorage(mockAttachmentStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 28: Attachments can not be loaded and task returned to the queue"
+		mockMailService.addedTask = model.MailTaskQueue{}
+		nacked := mockTaskQueue.nacked
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 7}})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), "get attachments error") {
+				t.Errorf("%s: expected attachments error but got %v", tc, err)
+			}
+			if mockTaskQueue.nacked != nacked+1 || mockMailService.addedTask.ID != 0 {
+				t.Errorf("%s: expected task to be nacked without being sent", tc)
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 29: Mail accepted for some recipients retried for the ones that failed"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 9}, RecipientEmail: "a@ex.com", Recipients: []model.Recipient{
+			{Email: "a@ex.com", Kind: model.RecipientTo},
+			{Email: "b@ex.com", Kind: model.RecipientCC},
+			{Email: "c@ex.com", Kind: model.RecipientBCC},
+		}}
+		mockMailService.errSendMail = mailservice.Classify(&smtppool.RecipientsError{Sent: true, Rejected: map[string]error{
+			"b@ex.com": &textproto.Error{Code: 452, Msg: "4.2.2 Mailbox full"},
+			"c@ex.com": &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"},
+		}})
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 9}})
+		t.Run(tc, func(t *testing.T) {
+			got := mockTaskStorer.updatedTask
+			if got.Status != constant.StatusFailed || got.TryCount != 1 || got.FailureReason != mailservice.FailureTransient {
+				t.Errorf("%s: expected transient failure to be retried but got %v", tc, got)
+			}
+			want := []model.Recipient{
+				{Email: "a@ex.com", Kind: model.RecipientTo, Status: constant.StatusSuccess},
+				{Email: "b@ex.com", Kind: model.RecipientCC, Status: constant.StatusFailed, Code: 452, Error: `452 "4.2.2 Mailbox full"`},
+				{Email: "c@ex.com", Kind: model.RecipientBCC, Status: constant.StatusRejected, Code: 550, Error: `550 "5.1.1 User unknown"`},
+			}
+			if !reflect.DeepEqual(got.Recipients, want) {
+				t.Errorf("%s: expected recipients %v but got %v", tc, want, got.Recipients)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	mockTemplateService := &mockTemplateService{}
+	templated := model.MailTaskQueue{
+		Model: gorm.Model{ID: 10}, UserID: 1, RecipientEmail: "a@ex.com",
+		TemplateID: 3, TemplateVersion: 2, TemplateVariables: map[string]interface{}{"name": "Ada"},
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTemplateService(mockTemplateService),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 30: Mail of task sent with a template rendered before it is added"
+		mockTaskStorer.taskModel = templated
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 10}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if got := mockMailService.addedTask; got.Subject != "Welcome Ada" || got.TextBody != "Hello Ada" {
+				t.Errorf("%s: expected rendered mail but got %q, %q", tc, got.Subject, got.TextBody)
+			}
+		})
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTemplateService(mockTemplateService),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 31: Template that can not be rendered dead-letters the task without sending it"
+		mockMailService.addedTask = model.MailTaskQueue{}
+		mockTemplateService.errRender = fmt.Errorf("%w: map has no entry for key \"name\"", templateservice.ErrRender)
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 10}})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), "no entry for key") {
+				t.Errorf("%s: expected render error but got %v", tc, err)
+			}
+			if got := mockTaskStorer.updatedTask; !got.DeadLettered || mockMailService.addedTask.ID != 0 {
+				t.Errorf("%s: expected task to be dead-lettered without being sent but got %v", tc, got)
+			}
+		})
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTemplateService(mockTemplateService),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 32: Template storage error returns the task to the queue"
+		mockTaskStorer.updatedTask = model.MailTaskQueue{}
+		mockTemplateService.errRender = errors.New("get version error")
+		nacked := mockTaskQueue.nacked
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 10}})
+		t.Run(tc, func(t *testing.T) {
+			if mockTaskQueue.nacked != nacked+1 || mockTaskStorer.updatedTask.DeadLettered {
+				t.Errorf("%s: expected task to be nacked but got %v", tc, mockTaskStorer.updatedTask)
+			}
+		})
+		mockTemplateService.errRender = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockAttemptStorer := &mockAttemptStorer{}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttemptStorage(mockAttemptStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 33: Task without connection slot of its SMTP host delayed without burning a try"
+		mockMailService.errSendMail = &smtppool.ConnLimitError{Host: "smtp.test.com", RetryAfter: time.Second}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		delay := mockTaskQueue.retryAt.Sub(started)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if task := mockTaskQueue.retriedTask; task.TryCount != 1 || task.Status != constant.StatusFailed {
+				t.Errorf("%s: expected waiting task with 1 try to be delayed but got %v", tc, task)
+			}
+			if delay < time.Second || delay > 2*time.Second+100*time.Millisecond {
+				t.Errorf("%s: expected task to be deferred within 1s and 2s but got %s", tc, delay)
+			}
+			if len(mockAttemptStorer.attempts) != 0 {
+				t.Errorf("%s: expected no attempt but got %v", tc, mockAttemptStorer.attempts)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockAttemptStorer := &mockAttemptStorer{}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithAttemptStorage(mockAttemptStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 34: Mail without recipients left not recorded as an attempt"
+		mail := mockMailService.mail
+		mockMailService.mail = mailservice.Mail{}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{})
+		t.Run(tc, func(t *testing.T) {
+			if len(mockAttemptStorer.attempts) != 0 {
+				t.Errorf("%s: expected no attempt but got %v", tc, mockAttemptStorer.attempts)
+			}
+			if got := mockTaskStorer.updatedTask; got.Status != constant.StatusSuccess {
+				t.Errorf("%s: expected task to succeed but got %v", tc, got)
+			}
+		})
+		mockMailService.mail = mail
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockBreaker := &mockBreaker{allowRes: breaker.Result{Allowed: true, State: breaker.StateHalfOpen}}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithBreaker(mockBreaker),
+			workerservice.WithLimiter(&mockLimiter{permit: ratelimit.Permit{RetryAfter: time.Second, Limit: ratelimit.LimitHost}}),
+		)
+		tc := "Case 35: Probe over the rate limit delayed and its probe released"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		mockTaskQueue.retriedTask = model.MailTaskQueue{}
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if mockTaskQueue.retriedTask.ID != 1 {
+				t.Errorf("%s: expected task to be delayed but got %v", tc, mockTaskQueue.retriedTask)
+			}
+			if mockBreaker.releases != 1 || mockBreaker.successes != 0 || mockBreaker.failures != 0 {
+				t.Errorf("%s: expected the probe released without a result but got %d releases, %d successes and %d failures",
+					tc, mockBreaker.releases, mockBreaker.successes, mockBreaker.failures)
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockBreaker := &mockBreaker{allowRes: breaker.Result{Allowed: true, State: breaker.StateHalfOpen}}
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+			workerservice.WithBreaker(mockBreaker),
+		)
+		tc := "Case 36: Probe without connection slot delayed and its probe released"
+		mockMailService.errSendMail = &smtppool.ConnLimitError{Host: "smtp.test.com", RetryAfter: time.Second}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		_ = mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if mockBreaker.releases != 1 || mockBreaker.successes != 0 || mockBreaker.failures != 0 {
+				t.Errorf("%s: expected the probe released without a result but got %d releases, %d successes and %d failures",
+					tc, mockBreaker.releases, mockBreaker.successes, mockBreaker.failures)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 37: Rejected task delivered again, envelope acked and mail not sent again"
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}, Status: constant.StatusRejected}
+		acked := mockTaskQueue.acked
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if mockTaskQueue.acked != acked+1 {
+				t.Errorf("%s: expected envelope to be acked", tc)
+			}
+			if !strings.Contains(logContents, "worker 1 skipped finished task 1") || strings.Contains(logContents, "sending mail") {
+				t.Errorf("Expected rejected task to be skipped, got log contents:\n%s", logContents)
+			}
+		})
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
+	{
+		mockWorkerService := workerservice.New(
+			workerservice.WithID(1),
+			workerservice.WithTaskStorage(mockTaskStorer),
+			workerservice.WithUserStorage(mockUserStorer),
+			workerservice.WithTaskQueue(mockTaskQueue),
+			workerservice.WithMailService(mockMailService),
+		)
+		tc := "Case 38: Negative wait of the connection limit delays the task without jitter"
+		mockMailService.errSendMail = &smtppool.ConnLimitError{Host: "smtp.test.com", RetryAfter: -time.Second}
+		mockTaskStorer.taskModel = model.MailTaskQueue{Model: gorm.Model{ID: 1}}
+		started := time.Now()
+		err := mockWorkerService.HandleTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: expected nil but got %v", tc, err)
+			}
+			if !mockTaskQueue.retryAt.Before(started) {
+				t.Errorf("%s: expected task to be due at once but got %s", tc, mockTaskQueue.retryAt)
+			}
+		})
+		mockMailService.errSendMail = nil
+		mockTaskStorer.taskModel = model.MailTaskQueue{}
+	}
 }
@@ -0,0 +1,47 @@
+package attachmentstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AttachmentStorer is an interface for storing the attachments of mail tasks.
+type AttachmentStorer interface {
+	Insert(ctx context.Context, attachment model.MailAttachment, tx ...*gorm.DB) (model.MailAttachment, error)
+	GetAllByIDs(ctx context.Context, userID uint, ids []uint, tx ...*gorm.DB) ([]model.MailAttachment, error)
+	GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailAttachment, error)
+	AttachToTask(ctx context.Context, taskID uint, ids []uint, tx ...*gorm.DB) (int, error)
+	TotalSizeByUserID(ctx context.Context, userID uint, tx ...*gorm.DB) (int64, error)
+	LockQuota(ctx context.Context, userID uint, tx *gorm.DB) error
+	GetAllExpired(ctx context.Context, unattachedBefore time.Time, limit int) ([]model.MailAttachment, error)
+	Delete(ctx context.Context, id uint) error
+	CreateTx() *gorm.DB
+	CommitTx(tx *gorm.DB) error
+	RollbackTx(tx *gorm.DB)
+}
+
+// attachmentStorage is a storage for the attachments of mail tasks.
+type attachmentStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for attachment storage options.
+type Option func(*attachmentStorage)
+
+// WithAttachmentDB sets the database for attachment storage.
+func WithAttachmentDB(db *gorm.DB) Option {
+	return func(s *attachmentStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new attachment storage instance.
+func New(opts ...Option) AttachmentStorer {
+	storage := &attachmentStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
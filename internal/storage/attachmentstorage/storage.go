@@ -0,0 +1,95 @@
+package attachmentstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"time"
+)
+
+func (s *attachmentStorage) conn(ctx context.Context, tx ...*gorm.DB) *gorm.DB {
+	if len(tx) > 0 {
+		return tx[0].WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+func (s *attachmentStorage) Insert(ctx context.Context, attachment model.MailAttachment, tx ...*gorm.DB) (model.MailAttachment, error) {
+	if err := s.conn(ctx, tx...).Create(&attachment).Error; err != nil {
+		return attachment, err
+	}
+	return attachment, nil
+}
+
+// GetAllByIDs returns the attachments of the user with the given IDs, the
+// attachments of other users are left out.
+func (s *attachmentStorage) GetAllByIDs(ctx context.Context, userID uint, ids []uint, tx ...*gorm.DB) ([]model.MailAttachment, error) {
+	var attachments []model.MailAttachment
+	if err := s.conn(ctx, tx...).Where("user_id = ? AND id IN ?", userID, ids).Order("id").Find(&attachments).Error; err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+// GetAllByTaskID returns the attachments of a task in the order they were added.
+func (s *attachmentStorage) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailAttachment, error) {
+	var attachments []model.MailAttachment
+	if err := s.db.WithContext(ctx).Where("task_id = ?", taskID).Order("id").Find(&attachments).Error; err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+// AttachToTask adds the attachments that are not attached yet to a task and
+// reports how many were added.
+func (s *attachmentStorage) AttachToTask(ctx context.Context, taskID uint, ids []uint, tx ...*gorm.DB) (int, error) {
+	res := s.conn(ctx, tx...).Model(&model.MailAttachment{}).Where("id IN ? AND task_id = 0", ids).Update("task_id", taskID)
+	return int(res.RowsAffected), res.Error
+}
+
+// TotalSizeByUserID returns the size of the stored attachments of a user.
+func (s *attachmentStorage) TotalSizeByUserID(ctx context.Context, userID uint, tx ...*gorm.DB) (int64, error) {
+	var total int64
+	err := s.conn(ctx, tx...).Model(&model.MailAttachment{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").Scan(&total).Error
+	return total, err
+}
+
+// LockQuota locks the attachment quota of a user until the transaction ends,
+// the quota checks of the user wait for each other.
+func (s *attachmentStorage) LockQuota(ctx context.Context, userID uint, tx *gorm.DB) error {
+	return tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(?, ?)", constant.AttachmentQuotaLock, userID).Error
+}
+
+// GetAllExpired returns the attachments that are no longer needed: the ones of
+// finished tasks and the uploads that were not enqueued with a task before the
+// given time. Tasks in the dead-letter queue are not finished, they can be replayed.
+func (s *attachmentStorage) GetAllExpired(ctx context.Context, unattachedBefore time.Time, limit int) ([]model.MailAttachment, error) {
+	var attachments []model.MailAttachment
+	finished := s.db.Model(&model.MailTaskQueue{}).Select("id").
+		Where("status IN ? AND dead_lettered = ?", []int{constant.StatusSuccess, constant.StatusCancelled, constant.StatusRejected}, false)
+	if err := s.db.WithContext(ctx).
+		Where("(task_id = 0 AND created_at < ?) OR task_id IN (?)", unattachedBefore, finished).
+		Order("id").Limit(limit).Find(&attachments).Error; err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+// Delete removes an attachment for good, its blob must be deleted first.
+func (s *attachmentStorage) Delete(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Unscoped().Delete(&model.MailAttachment{}, id).Error
+}
+
+func (s *attachmentStorage) CreateTx() *gorm.DB {
+	return s.db.Begin()
+}
+
+func (s *attachmentStorage) CommitTx(tx *gorm.DB) error {
+	return tx.Commit().Error
+}
+
+func (s *attachmentStorage) RollbackTx(tx *gorm.DB) {
+	tx.Rollback()
+}
@@ -0,0 +1,172 @@
+package attachmentstorage_test
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attachmentstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newStorage() (attachmentstorage.AttachmentStorer, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return attachmentstorage.New(attachmentstorage.WithAttachmentDB(db)), mock
+}
+
+func Test_attachmentStorage_Insert(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Attachment Inserted"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_attachments"`).
+			WillReturnRows(sqlmock.NewRows([]string{"task_id", "id"}).AddRow(0, 1))
+		mock.ExpectCommit()
+		attachment, err := storage.Insert(context.Background(), model.MailAttachment{
+			UserID:      1,
+			Filename:    "invoice.pdf",
+			ContentType: "application/pdf",
+			Size:        10,
+			BlobKey:     "key",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || attachment.ID != 1 {
+				t.Errorf("Expected attachment 1, got %v %v", attachment.ID, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_attachments"`).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		_, err := storage.Insert(context.Background(), model.MailAttachment{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func Test_attachmentStorage_GetAllByIDs(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Attachments Of User Returned"
+	mock.ExpectQuery(`SELECT \* FROM "mail_attachments" WHERE \(user_id = \$1 AND id IN \(\$2,\$3\)\) AND "mail_attachments"."deleted_at" IS NULL ORDER BY id`).
+		WithArgs(1, 2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(2, 1))
+	attachments, err := storage.GetAllByIDs(context.Background(), 1, []uint{2, 3})
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(attachments) != 1 || attachments[0].ID != 2 {
+			t.Errorf("Expected attachment 2, got %v %v", attachments, err)
+		}
+	})
+}
+
+func Test_attachmentStorage_GetAllByTaskID(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Attachments Of Task Returned In Order"
+	mock.ExpectQuery(`SELECT \* FROM "mail_attachments" WHERE task_id = \$1 AND "mail_attachments"."deleted_at" IS NULL ORDER BY id`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id"}).AddRow(1, 1).AddRow(2, 1))
+	attachments, err := storage.GetAllByTaskID(context.Background(), 1)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(attachments) != 2 {
+			t.Errorf("Expected 2 attachments, got %v %v", attachments, err)
+		}
+	})
+}
+
+func Test_attachmentStorage_AttachToTask(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Unattached Attachments Added To Task"
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "mail_attachments" SET "task_id"=\$1,"updated_at"=\$2 WHERE \(id IN \(\$3,\$4\) AND task_id = 0\) AND "mail_attachments"."deleted_at" IS NULL`).
+		WithArgs(5, sqlmock.AnyArg(), 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	n, err := storage.AttachToTask(context.Background(), 5, []uint{1, 2})
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || n != 1 {
+			t.Errorf("Expected 1 attachment added, got %d %v", n, err)
+		}
+	})
+}
+
+func Test_attachmentStorage_TotalSizeByUserID(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Size Of Attachments Of User Returned"
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(size\), 0\) FROM "mail_attachments" WHERE user_id = \$1 AND "mail_attachments"."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(2048))
+	total, err := storage.TotalSizeByUserID(context.Background(), 1)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || total != 2048 {
+			t.Errorf("Expected 2048, got %d %v", total, err)
+		}
+	})
+}
+
+func Test_attachmentStorage_LockQuota(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Quota Of User Locked In Transaction"
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1, \$2\)`).
+		WithArgs(1, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	tx := storage.CreateTx()
+	err := storage.LockQuota(context.Background(), 7, tx)
+	if err == nil {
+		err = storage.CommitTx(tx)
+	}
+	t.Run(tc, func(t *testing.T) {
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expected queries not met: %v", err)
+		}
+	})
+}
+
+func Test_attachmentStorage_GetAllExpired(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Attachments Of Finished Tasks And Old Uploads Returned"
+	before := time.Now()
+	mock.ExpectQuery(`SELECT \* FROM "mail_attachments" WHERE \(\(task_id = 0 AND created_at < \$1\) OR task_id IN \(SELECT "id" FROM "mail_task_queues" WHERE \(status IN \(\$2,\$3,\$4\) AND dead_lettered = \$5\) AND "mail_task_queues"."deleted_at" IS NULL\)\) AND "mail_attachments"."deleted_at" IS NULL ORDER BY id LIMIT \$6`).
+		WithArgs(before, 2, 4, 6, false, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "blob_key"}).AddRow(1, "key"))
+	attachments, err := storage.GetAllExpired(context.Background(), before, 100)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(attachments) != 1 || attachments[0].BlobKey != "key" {
+			t.Errorf("Expected attachment with key, got %v %v", attachments, err)
+		}
+	})
+}
+
+func Test_attachmentStorage_Delete(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Attachment Deleted For Good"
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "mail_attachments" WHERE "mail_attachments"."id" = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err := storage.Delete(context.Background(), 1)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	})
+}
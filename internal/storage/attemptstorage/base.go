@@ -0,0 +1,37 @@
+package attemptstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+)
+
+// AttemptStorer is an interface for storing the send attempts of mail tasks.
+type AttemptStorer interface {
+	Insert(ctx context.Context, attempt model.MailTaskAttempt) (model.MailTaskAttempt, error)
+	GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailTaskAttempt, error)
+}
+
+// attemptStorage is a storage for the send attempts of mail tasks.
+type attemptStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for attempt storage options.
+type Option func(*attemptStorage)
+
+// WithAttemptDB sets the database for attempt storage.
+func WithAttemptDB(db *gorm.DB) Option {
+	return func(s *attemptStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new attempt storage instance.
+func New(opts ...Option) AttemptStorer {
+	storage := &attemptStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
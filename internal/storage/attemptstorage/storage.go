@@ -0,0 +1,22 @@
+package attemptstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+)
+
+func (s *attemptStorage) Insert(ctx context.Context, attempt model.MailTaskAttempt) (model.MailTaskAttempt, error) {
+	if err := s.db.WithContext(ctx).Create(&attempt).Error; err != nil {
+		return attempt, err
+	}
+	return attempt, nil
+}
+
+// GetAllByTaskID returns the attempts of a task, the oldest first.
+func (s *attemptStorage) GetAllByTaskID(ctx context.Context, taskID uint) ([]model.MailTaskAttempt, error) {
+	var attempts []model.MailTaskAttempt
+	if err := s.db.WithContext(ctx).Where("task_id = ?", taskID).Order("started_at, id").Find(&attempts).Error; err != nil {
+		return attempts, err
+	}
+	return attempts, nil
+}
@@ -0,0 +1,92 @@
+package attemptstorage_test
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/attemptstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newStorage() (attemptstorage.AttemptStorer, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return attemptstorage.New(attemptstorage.WithAttemptDB(db)), mock
+}
+
+func Test_attemptStorage_Insert(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Attempt Inserted"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_task_attempts"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		attempt, err := storage.Insert(context.Background(), model.MailTaskAttempt{
+			TaskID:       1,
+			WorkerID:     "pod-1/1",
+			StartedAt:    time.Now(),
+			FinishedAt:   time.Now(),
+			ResponseCode: 250,
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if attempt.ID != 1 {
+				t.Errorf("Expected attempt 1, got %d", attempt.ID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_task_attempts"`).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		_, err := storage.Insert(context.Background(), model.MailTaskAttempt{TaskID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func Test_attemptStorage_GetAllByTaskID(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Attempts Of Task Returned Oldest First"
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_attempts" WHERE task_id = \$1 AND "mail_task_attempts"."deleted_at" IS NULL ORDER BY started_at, id`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "error_class"}).AddRow(1, 1, "transient").AddRow(2, 1, ""))
+		attempts, err := storage.GetAllByTaskID(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(attempts) != 2 || attempts[0].ErrorClass != "transient" {
+				t.Errorf("Expected 2 attempts with the failed one first, got %v", attempts)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Database Error And Return Error"
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_attempts"`).WillReturnError(gorm.ErrInvalidData)
+		_, err := storage.GetAllByTaskID(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package campaignstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// CampaignStorer is an interface for storing the mail campaigns of users and
+// their recipient lists.
+type CampaignStorer interface {
+	Insert(ctx context.Context, campaign model.MailCampaign, recipients []model.MailCampaignRecipient) (model.MailCampaign, error)
+	GetByID(ctx context.Context, userID, id uint) (model.MailCampaign, error)
+	GetAllByUserID(ctx context.Context, userID uint) ([]model.MailCampaign, error)
+	GetAllDue(ctx context.Context, now time.Time) ([]model.MailCampaign, error)
+	Lock(ctx context.Context, id uint, now time.Time, tx *gorm.DB) (model.MailCampaign, error)
+	GetRecipients(ctx context.Context, campaignID, afterID uint, limit int, tx ...*gorm.DB) ([]model.MailCampaignRecipient, error)
+	Update(ctx context.Context, campaign model.MailCampaign, tx ...*gorm.DB) error
+	SetStatus(ctx context.Context, userID, id uint, from []int, to int, tx ...*gorm.DB) (int, error)
+	CreateTx() *gorm.DB
+	CommitTx(tx *gorm.DB) error
+	RollbackTx(tx *gorm.DB)
+}
+
+// campaignStorage is a storage for the mail campaigns.
+type campaignStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for campaign storage options.
+type Option func(*campaignStorage)
+
+// WithCampaignDB sets the database for campaign storage.
+func WithCampaignDB(db *gorm.DB) Option {
+	return func(s *campaignStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new campaign storage instance.
+func New(opts ...Option) CampaignStorer {
+	storage := &campaignStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
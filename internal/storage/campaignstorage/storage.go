@@ -0,0 +1,110 @@
+package campaignstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// dueStatuses are the statuses of the campaigns that are fanned out once their
+// schedule is due.
+var dueStatuses = []int{constant.CampaignStatusScheduled, constant.CampaignStatusRunning}
+
+func (s *campaignStorage) conn(ctx context.Context, tx ...*gorm.DB) *gorm.DB {
+	if len(tx) > 0 {
+		return tx[0].WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+// Insert stores a campaign with its recipient list, the recipients are
+// inserted with multi-row inserts of CampaignBatchSize rows.
+func (s *campaignStorage) Insert(ctx context.Context, campaign model.MailCampaign, recipients []model.MailCampaignRecipient) (model.MailCampaign, error) {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&campaign).Error; err != nil {
+			return err
+		}
+		for i := range recipients {
+			recipients[i].CampaignID = campaign.ID
+		}
+		return tx.CreateInBatches(&recipients, constant.CampaignBatchSize).Error
+	})
+	return campaign, err
+}
+
+func (s *campaignStorage) GetByID(ctx context.Context, userID, id uint) (model.MailCampaign, error) {
+	var campaign model.MailCampaign
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&campaign).Error; err != nil {
+		return campaign, err
+	}
+	return campaign, nil
+}
+
+// GetAllByUserID returns the campaigns of a user, the latest first.
+func (s *campaignStorage) GetAllByUserID(ctx context.Context, userID uint) ([]model.MailCampaign, error) {
+	var campaigns []model.MailCampaign
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC").Find(&campaigns).Error; err != nil {
+		return campaigns, err
+	}
+	return campaigns, nil
+}
+
+// GetAllDue returns the scheduled and running campaigns whose schedule is due.
+func (s *campaignStorage) GetAllDue(ctx context.Context, now time.Time) ([]model.MailCampaign, error) {
+	var campaigns []model.MailCampaign
+	if err := s.db.WithContext(ctx).Where("status IN ? AND scheduled_at <= ?", dueStatuses, now).
+		Order("id").Find(&campaigns).Error; err != nil {
+		return campaigns, err
+	}
+	return campaigns, nil
+}
+
+// Lock locks a due campaign until the transaction ends. Campaigns locked by
+// another pod are skipped and gorm.ErrRecordNotFound is returned, as it is for
+// campaigns that were paused or cancelled since they were listed.
+func (s *campaignStorage) Lock(ctx context.Context, id uint, now time.Time, tx *gorm.DB) (model.MailCampaign, error) {
+	var campaign model.MailCampaign
+	if err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("id = ? AND status IN ? AND scheduled_at <= ?", id, dueStatuses, now).First(&campaign).Error; err != nil {
+		return campaign, err
+	}
+	return campaign, nil
+}
+
+// GetRecipients returns the next recipients of a campaign after the given
+// recipient, in ID order.
+func (s *campaignStorage) GetRecipients(ctx context.Context, campaignID, afterID uint, limit int, tx ...*gorm.DB) ([]model.MailCampaignRecipient, error) {
+	var recipients []model.MailCampaignRecipient
+	if err := s.conn(ctx, tx...).Where("campaign_id = ? AND id > ?", campaignID, afterID).
+		Order("id").Limit(limit).Find(&recipients).Error; err != nil {
+		return recipients, err
+	}
+	return recipients, nil
+}
+
+func (s *campaignStorage) Update(ctx context.Context, campaign model.MailCampaign, tx ...*gorm.DB) error {
+	return s.conn(ctx, tx...).Save(&campaign).Error
+}
+
+// SetStatus moves a campaign of a user to a status if it is in one of the
+// given statuses and reports whether it was moved.
+func (s *campaignStorage) SetStatus(ctx context.Context, userID, id uint, from []int, to int, tx ...*gorm.DB) (int, error) {
+	res := s.conn(ctx, tx...).Model(&model.MailCampaign{}).
+		Where("id = ? AND user_id = ? AND status IN ?", id, userID, from).Update("status", to)
+	return int(res.RowsAffected), res.Error
+}
+
+func (s *campaignStorage) CreateTx() *gorm.DB {
+	return s.db.Begin()
+}
+
+func (s *campaignStorage) CommitTx(tx *gorm.DB) error {
+	return tx.Commit().Error
+}
+
+func (s *campaignStorage) RollbackTx(tx *gorm.DB) {
+	tx.Rollback()
+}
@@ -0,0 +1,157 @@
+package campaignstorage_test
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/campaignstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newStorage() (campaignstorage.CampaignStorer, sqlmock.Sqlmock, *gorm.DB) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return campaignstorage.New(campaignstorage.WithCampaignDB(db)), mock, db
+}
+
+func Test_campaignStorage_Insert(t *testing.T) {
+	storage, mock, _ := newStorage()
+	recipients := []model.MailCampaignRecipient{
+		{Email: "a@example.com", Variables: map[string]interface{}{"name": "A"}},
+		{Email: "b@example.com", Variables: map[string]interface{}{"name": "B"}},
+	}
+	{
+		tc := "Case 1: Campaign Inserted With Its Recipients In One Statement"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_campaigns"`).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "id"}).AddRow(0, 1))
+		mock.ExpectQuery(`INSERT INTO "mail_campaign_recipients" \("campaign_id","email","variables"\) VALUES \(\$1,\$2,\$3\),\(\$4,\$5,\$6\) RETURNING "id"`).
+			WithArgs(1, "a@example.com", `{"name":"A"}`, 1, "b@example.com", `{"name":"B"}`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+		campaign, err := storage.Insert(context.Background(), model.MailCampaign{UserID: 1, Name: "news", Total: 2}, recipients)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || campaign.ID != 1 {
+				t.Errorf("Expected campaign 1, got %v %v", campaign, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Recipients Not Inserted And Campaign Rolled Back"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_campaigns"`).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "id"}).AddRow(0, 2))
+		mock.ExpectQuery(`INSERT INTO "mail_campaign_recipients"`).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		_, err := storage.Insert(context.Background(), model.MailCampaign{UserID: 1, Name: "news"}, recipients)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, gorm.ErrInvalidData) {
+				t.Errorf("Expected invalid data error, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_campaignStorage_GetAllDue(t *testing.T) {
+	storage, mock, _ := newStorage()
+	{
+		tc := "Case 1: Scheduled And Running Campaigns Due Returned"
+		now := time.Now()
+		mock.ExpectQuery(`SELECT \* FROM "mail_campaigns" WHERE \(status IN \(\$1,\$2\) AND scheduled_at <= \$3\) AND "mail_campaigns"."deleted_at" IS NULL ORDER BY id`).
+			WithArgs(constant.CampaignStatusScheduled, constant.CampaignStatusRunning, now).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, constant.CampaignStatusScheduled).AddRow(2, constant.CampaignStatusRunning))
+		campaigns, err := storage.GetAllDue(context.Background(), now)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || len(campaigns) != 2 {
+				t.Errorf("Expected 2 campaigns, got %v %v", campaigns, err)
+			}
+		})
+	}
+}
+
+func Test_campaignStorage_Lock(t *testing.T) {
+	storage, mock, db := newStorage()
+	{
+		tc := "Case 1: Due Campaign Locked And Campaigns Locked Elsewhere Skipped"
+		now := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "mail_campaigns" WHERE \(id = \$1 AND status IN \(\$2,\$3\) AND scheduled_at <= \$4\) AND "mail_campaigns"."deleted_at" IS NULL ORDER BY "mail_campaigns"."id" LIMIT \$5 FOR UPDATE SKIP LOCKED`).
+			WithArgs(1, constant.CampaignStatusScheduled, constant.CampaignStatusRunning, now, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "dispatched"}).AddRow(1, 500))
+		tx := db.Begin()
+		campaign, err := storage.Lock(context.Background(), 1, now, tx)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || campaign.Dispatched != 500 {
+				t.Errorf("Expected campaign with 500 dispatched recipients, got %v %v", campaign, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_campaignStorage_GetRecipients(t *testing.T) {
+	storage, mock, _ := newStorage()
+	{
+		tc := "Case 1: Next Recipients After The Last Dispatched One Returned"
+		mock.ExpectQuery(`SELECT \* FROM "mail_campaign_recipients" WHERE campaign_id = \$1 AND id > \$2 ORDER BY id LIMIT \$3`).
+			WithArgs(1, 500, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "campaign_id", "email", "variables"}).
+				AddRow(501, 1, "a@example.com", `{"name":"A"}`).
+				AddRow(502, 1, "b@example.com", `{"name":"B"}`))
+		recipients, err := storage.GetRecipients(context.Background(), 1, 500, 2)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || len(recipients) != 2 {
+				t.Fatalf("Expected 2 recipients, got %v %v", recipients, err)
+			}
+			if recipients[1].ID != 502 || recipients[1].Variables["name"] != "B" {
+				t.Errorf("Expected recipient 502 with its variables, got %v", recipients[1])
+			}
+		})
+	}
+}
+
+func Test_campaignStorage_SetStatus(t *testing.T) {
+	storage, mock, _ := newStorage()
+	{
+		tc := "Case 1: Running Campaign Paused"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_campaigns" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND user_id = \$4 AND status IN \(\$5,\$6\)\)`).
+			WithArgs(constant.CampaignStatusPaused, sqlmock.AnyArg(), 1, 1, constant.CampaignStatusScheduled, constant.CampaignStatusRunning).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		n, err := storage.SetStatus(context.Background(), 1, 1,
+			[]int{constant.CampaignStatusScheduled, constant.CampaignStatusRunning}, constant.CampaignStatusPaused)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 1 {
+				t.Errorf("Expected 1 campaign paused, got %d %v", n, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Campaign In Another Status Not Moved"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_campaigns" SET "status"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		n, err := storage.SetStatus(context.Background(), 1, 1,
+			[]int{constant.CampaignStatusPaused}, constant.CampaignStatusRunning)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 0 {
+				t.Errorf("Expected no campaign moved, got %d %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package fencestorage
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+)
+
+// ErrStaleToken is returned by Advance when a cron job already wrote with a
+// higher token, the lock of the caller expired and was taken by another replica.
+var ErrStaleToken = errors.New("fencing token is older than the last one written with")
+
+// FenceStorer is an interface for storing the fencing tokens of the cron jobs.
+type FenceStorer interface {
+	Advance(ctx context.Context, name string, token int64, tx ...*gorm.DB) error
+	CreateTx() *gorm.DB
+	CommitTx(tx *gorm.DB) error
+	RollbackTx(tx *gorm.DB)
+}
+
+// fenceStorage is a storage for the fencing tokens of the cron jobs.
+type fenceStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for fence storage options.
+type Option func(*fenceStorage)
+
+// WithFenceDB sets the database for fence storage.
+func WithFenceDB(db *gorm.DB) Option {
+	return func(s *fenceStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new fence storage instance.
+func New(opts ...Option) FenceStorer {
+	storage := &fenceStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
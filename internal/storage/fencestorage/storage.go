@@ -0,0 +1,48 @@
+package fencestorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (s *fenceStorage) conn(ctx context.Context, tx ...*gorm.DB) *gorm.DB {
+	if len(tx) > 0 {
+		return tx[0].WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+// Advance stores the token of a tick of a cron job unless the job already
+// wrote with a higher one, which returns ErrStaleToken. The row of the job is
+// locked until the transaction ends, so the writes of the tick made in it
+// commit before a tick with a higher token can advance the fence.
+func (s *fenceStorage) Advance(ctx context.Context, name string, token int64, tx ...*gorm.DB) error {
+	res := s.conn(ctx, tx...).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"token": token}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "cron_fences.token <= ?", Vars: []interface{}{token}},
+		}},
+	}).Create(&model.CronFence{Name: name, Token: token})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStaleToken
+	}
+	return nil
+}
+
+func (s *fenceStorage) CreateTx() *gorm.DB {
+	return s.db.Begin()
+}
+
+func (s *fenceStorage) CommitTx(tx *gorm.DB) error {
+	return tx.Commit().Error
+}
+
+func (s *fenceStorage) RollbackTx(tx *gorm.DB) {
+	tx.Rollback()
+}
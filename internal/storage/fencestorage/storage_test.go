@@ -0,0 +1,68 @@
+package fencestorage_test
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/fencestorage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func newStorage() (fencestorage.FenceStorer, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return fencestorage.New(fencestorage.WithFenceDB(db)), mock
+}
+
+const advanceQuery = `INSERT INTO "cron_fences" \("name","token"\) VALUES \(\$1,\$2\) ON CONFLICT \("name"\) DO UPDATE SET "token"=\$3 WHERE cron_fences.token <= \$4`
+
+func Test_fenceStorage_Advance(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Token Stored In Given Transaction"
+		mock.ExpectBegin()
+		mock.ExpectExec(advanceQuery).WithArgs("job", 7, 7, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		tx := storage.CreateTx()
+		err := storage.Advance(context.Background(), "job", 7, tx)
+		errCommit := storage.CommitTx(tx)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || errCommit != nil {
+				t.Errorf("Expected nil, got %v, %v", err, errCommit)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Token Lower Than The Stored One And Return Stale Token Error"
+		mock.ExpectBegin()
+		mock.ExpectExec(advanceQuery).WithArgs("job", 6, 6, 6).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		err := storage.Advance(context.Background(), "job", 6)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, fencestorage.ErrStaleToken) {
+				t.Errorf("Expected stale token error, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectExec(advanceQuery).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		err := storage.Advance(context.Background(), "job", 8)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || errors.Is(err, fencestorage.ErrStaleToken) {
+				t.Errorf("Expected database error, got %v", err)
+			}
+		})
+	}
+}
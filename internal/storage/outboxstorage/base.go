@@ -0,0 +1,45 @@
+package outboxstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OutboxStorer is an interface for storing the tasks that wait to be published.
+type OutboxStorer interface {
+	Insert(ctx context.Context, entry model.TaskOutbox, tx ...*gorm.DB) (model.TaskOutbox, error)
+	InsertBatch(ctx context.Context, entries []model.TaskOutbox, tx ...*gorm.DB) error
+	GetUndelivered(ctx context.Context, limit int, tx ...*gorm.DB) ([]model.TaskOutbox, error)
+	MarkDelivered(ctx context.Context, id uint, tx ...*gorm.DB) error
+	MarkFailed(ctx context.Context, id uint, reason string, tx ...*gorm.DB) error
+	DeleteDelivered(ctx context.Context, before time.Time) (int, error)
+	CreateTx() *gorm.DB
+	CommitTx(tx *gorm.DB) error
+	RollbackTx(tx *gorm.DB)
+}
+
+// outboxStorage is a storage for the task outbox.
+type outboxStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for outbox storage options.
+type Option func(*outboxStorage)
+
+// WithOutboxDB sets the database for outbox storage.
+func WithOutboxDB(db *gorm.DB) Option {
+	return func(s *outboxStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new outbox storage instance.
+func New(opts ...Option) OutboxStorer {
+	storage := &outboxStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
@@ -0,0 +1,82 @@
+package outboxstorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// unfinishedStatuses are the statuses of the tasks that are still in the queue.
+var unfinishedStatuses = []int{constant.StatusQueued, constant.StatusProcessing, constant.StatusFailed, constant.StatusScheduled}
+
+func (s *outboxStorage) conn(ctx context.Context, tx ...*gorm.DB) *gorm.DB {
+	if len(tx) > 0 {
+		return tx[0].WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+func (s *outboxStorage) Insert(ctx context.Context, entry model.TaskOutbox, tx ...*gorm.DB) (model.TaskOutbox, error) {
+	if err := s.conn(ctx, tx...).Create(&entry).Error; err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// InsertBatch inserts the entries of a batch of tasks in chunks of
+// constant.CampaignBatchSize rows.
+func (s *outboxStorage) InsertBatch(ctx context.Context, entries []model.TaskOutbox, tx ...*gorm.DB) error {
+	return s.conn(ctx, tx...).CreateInBatches(&entries, constant.CampaignBatchSize).Error
+}
+
+// GetUndelivered returns the oldest entries that were not delivered yet. The
+// entries are locked until the transaction ends and entries locked by another
+// relay are skipped, so every entry is relayed by one pod at a time.
+func (s *outboxStorage) GetUndelivered(ctx context.Context, limit int, tx ...*gorm.DB) ([]model.TaskOutbox, error) {
+	var entries []model.TaskOutbox
+	if err := s.conn(ctx, tx...).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("delivered_at IS NULL").Order("id").Limit(limit).Find(&entries).Error; err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+func (s *outboxStorage) MarkDelivered(ctx context.Context, id uint, tx ...*gorm.DB) error {
+	return s.conn(ctx, tx...).Model(&model.TaskOutbox{}).Where("id = ?", id).
+		Update("delivered_at", time.Now()).Error
+}
+
+// MarkFailed records a failed delivery, the entry is relayed again on the next run.
+func (s *outboxStorage) MarkFailed(ctx context.Context, id uint, reason string, tx ...*gorm.DB) error {
+	return s.conn(ctx, tx...).Model(&model.TaskOutbox{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": reason,
+	}).Error
+}
+
+// DeleteDelivered removes the entries delivered before the given time whose
+// task is finished and reports how many entries were removed. The entries of
+// unfinished tasks are kept, they tell the reconciler when the task was last
+// handed to the queue.
+func (s *outboxStorage) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	unfinished := s.db.Model(&model.MailTaskQueue{}).Select("1").
+		Where("mail_task_queues.id = task_outboxes.task_id AND mail_task_queues.status IN ?", unfinishedStatuses)
+	res := s.db.WithContext(ctx).Unscoped().Where("delivered_at < ? AND NOT EXISTS (?)", before, unfinished).
+		Delete(&model.TaskOutbox{})
+	return int(res.RowsAffected), res.Error
+}
+
+func (s *outboxStorage) CreateTx() *gorm.DB {
+	return s.db.Begin()
+}
+
+func (s *outboxStorage) CommitTx(tx *gorm.DB) error {
+	return tx.Commit().Error
+}
+
+func (s *outboxStorage) RollbackTx(tx *gorm.DB) {
+	tx.Rollback()
+}
@@ -0,0 +1,156 @@
+package outboxstorage_test
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/outboxstorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newStorage() (outboxstorage.OutboxStorer, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return outboxstorage.New(outboxstorage.WithOutboxDB(db)), mock
+}
+
+func Test_outboxStorage_Insert(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Entry Inserted In Given Transaction"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "task_outboxes"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		tx := storage.CreateTx()
+		entry, err := storage.Insert(context.Background(), model.TaskOutbox{TaskID: 1}, tx)
+		errCommit := storage.CommitTx(tx)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || errCommit != nil {
+				t.Errorf("Expected nil, got %v, %v", err, errCommit)
+			}
+			if entry.ID != 1 {
+				t.Errorf("Expected entry 1, got %d", entry.ID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "task_outboxes"`).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		_, err := storage.Insert(context.Background(), model.TaskOutbox{TaskID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func Test_outboxStorage_InsertBatch(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Entries Of A Batch Inserted In Given Transaction"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "task_outboxes" .* VALUES \(.*\),\(.*\) RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+		tx := storage.CreateTx()
+		err := storage.InsertBatch(context.Background(), []model.TaskOutbox{{TaskID: 5}, {TaskID: 6}}, tx)
+		errCommit := storage.CommitTx(tx)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || errCommit != nil {
+				t.Errorf("Expected nil, got %v, %v", err, errCommit)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_outboxStorage_GetUndelivered(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Oldest Undelivered Entries Locked And Locked Entries Skipped"
+		mock.ExpectQuery(`SELECT \* FROM "task_outboxes" WHERE delivered_at IS NULL AND "task_outboxes"."deleted_at" IS NULL ORDER BY id LIMIT \$1 FOR UPDATE SKIP LOCKED`).
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "task_id"}).AddRow(1, 5).AddRow(2, 6))
+		entries, err := storage.GetUndelivered(context.Background(), 10)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 2 || entries[0].TaskID != 5 {
+				t.Errorf("Expected entries of tasks 5 and 6, got %v", entries)
+			}
+		})
+	}
+}
+
+func Test_outboxStorage_MarkDelivered(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Delivery Time Of Entry Set"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "task_outboxes" SET "delivered_at"=\$1,"updated_at"=\$2 WHERE id = \$3`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := storage.MarkDelivered(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_outboxStorage_MarkFailed(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Attempts Of Entry Incremented And Error Recorded"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "task_outboxes" SET "attempts"=attempts \+ 1,"last_error"=\$1,"updated_at"=\$2 WHERE id = \$3`).
+			WithArgs("error", sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := storage.MarkFailed(context.Background(), 1, "error")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_outboxStorage_DeleteDelivered(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Old Delivered Entries Of Finished Tasks Deleted"
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "task_outboxes" WHERE delivered_at < \$1 AND NOT EXISTS \(SELECT 1 FROM "mail_task_queues" WHERE \(mail_task_queues.id = task_outboxes.task_id AND mail_task_queues.status IN \(\$2,\$3,\$4,\$5\)\) AND "mail_task_queues"."deleted_at" IS NULL\)`).
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectCommit()
+		n, err := storage.DeleteDelivered(context.Background(), time.Now())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if n != 4 {
+				t.Errorf("Expected 4 deleted entries, got %d", n)
+			}
+		})
+	}
+}
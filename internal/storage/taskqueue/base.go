@@ -4,23 +4,98 @@ import (
 	"context"
 	"github.com/redis/go-redis/v9"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"os"
+	"sync"
+	"time"
 )
 
+// TaskQueue is an interface for publishing and consuming mail tasks.
+//
+// Tasks are published to the lane of their priority, consumers drain the high,
+// normal and bulk lanes with weighted preference. The list backend keeps a
+// sub-queue per user in every lane and serves the users round-robin, each user
+// is limited to a number of tasks in flight across all pods.
+//
+// Consumed tasks are leased: they stay in a processing list until the worker
+// calls Ack or Nack with the task it received, which carries the ID of its
+// lease in LeaseID. Leases are kept alive by a heartbeat of the consuming pod,
+// when the heartbeat expires ReapExpiredLeases returns the tasks to the queue.
+//
+// PublishTasks publishes a batch of tasks in one round trip, the redis backends
+// pipeline the tasks and the postgres backend queues their rows at once.
+//
+// Tasks that should be sent later are added with ScheduleTask and wait in a
+// sorted set until PromoteDueTasks moves them to the queue. Failed tasks are
+// moved to the same set with Retry, so they are tried again after a backoff.
+//
+// Tasks that exhausted their tries and payloads that can not be decoded are
+// moved to the dead-letter queue of their user, where they can be inspected,
+// removed and replayed.
+//
+// Consumers stop when the context of StartConsume is done, the leases of the
+// pod are kept alive until Close returns the tasks it still leases to the queue.
+//
+// The list and stream backends keep the queue in redis, the postgres backend
+// keeps it in the mail_task_queues table.
 type TaskQueue interface {
 	PublishTask(ctx context.Context, task interface{}) error
+	PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error
 	SubscribeTask(ctx context.Context, consumerID int) error
 	StartConsume(ctx context.Context) <-chan error
+	Ack(ctx context.Context, task model.MailTaskQueue) error
+	Nack(ctx context.Context, task model.MailTaskQueue) error
+	Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error
+	ReapExpiredLeases(ctx context.Context) (int, error)
+	ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error
+	PromoteDueTasks(ctx context.Context) (int, error)
+	DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error
+	DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]DeadLetter, error)
+	RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]DeadLetter, error)
+	Close(ctx context.Context) error
+}
+
+// lease is a consumed message that waits for an ack. List backend leases
+// point to a processing list, stream backend leases to a pending entry. Every
+// delivery gets its own lease, so a task delivered twice is acked twice.
+type lease struct {
+	processingKey string
+	payload       string
+	user          string
+	stream        string
+	messageID     string
 }
 
 type taskQueue struct {
-	consumerCount int
-	queueName     string
-	rdb           *redis.Client
-	taskChannel   chan model.MailTaskQueue
+	backend         string
+	consumerCount   int
+	queueName       string
+	groupName       string
+	consumerName    string
+	leaseTimeout    time.Duration
+	reapInterval    time.Duration
+	pollInterval    time.Duration
+	userConcurrency int
+	rdb             *redis.Client
+	db              *gorm.DB
+	taskChannel     chan model.MailTaskQueue
+	mu              sync.Mutex
+	leases          map[string]lease
+	leaseSeq        uint64
+	stopLeases      context.CancelFunc
 }
 
 type Option func(*taskQueue)
 
+// WithBackend sets the data structure of the queue, constant.QueueBackendList,
+// constant.QueueBackendStream or constant.QueueBackendPostgres.
+func WithBackend(backend string) Option {
+	return func(r *taskQueue) {
+		r.backend = backend
+	}
+}
+
 func WithConsumerCount(count int) Option {
 	return func(r *taskQueue) {
 		r.consumerCount = count
@@ -33,12 +108,62 @@ func WithQueueName(name string) Option {
 	}
 }
 
+// WithGroupName sets the consumer group of the stream backend.
+func WithGroupName(name string) Option {
+	return func(r *taskQueue) {
+		r.groupName = name
+	}
+}
+
+// WithConsumerName sets the name that identifies this pod's consumers. It defaults to the hostname.
+func WithConsumerName(name string) Option {
+	return func(r *taskQueue) {
+		r.consumerName = name
+	}
+}
+
+// WithLeaseTimeout sets how long leases survive without a heartbeat.
+func WithLeaseTimeout(timeout time.Duration) Option {
+	return func(r *taskQueue) {
+		r.leaseTimeout = timeout
+	}
+}
+
+// WithReapInterval sets how often expired leases are returned to the queue.
+func WithReapInterval(interval time.Duration) Option {
+	return func(r *taskQueue) {
+		r.reapInterval = interval
+	}
+}
+
+// WithUserConcurrency sets how many tasks of a user can be in flight at once,
+// 0 means unlimited. Per-user overrides are read from the <queue>:user_caps hash.
+func WithUserConcurrency(n int) Option {
+	return func(r *taskQueue) {
+		r.userConcurrency = n
+	}
+}
+
+// WithPollInterval sets how long idle consumers wait before polling the queue again.
+func WithPollInterval(interval time.Duration) Option {
+	return func(r *taskQueue) {
+		r.pollInterval = interval
+	}
+}
+
 func WithRedisClient(rdb *redis.Client) Option {
 	return func(r *taskQueue) {
 		r.rdb = rdb
 	}
 }
 
+// WithDB sets the database of the postgres backend.
+func WithDB(db *gorm.DB) Option {
+	return func(r *taskQueue) {
+		r.db = db
+	}
+}
+
 func WithTaskChannel(ch chan model.MailTaskQueue) Option {
 	return func(r *taskQueue) {
 		r.taskChannel = ch
@@ -46,9 +171,25 @@ func WithTaskChannel(ch chan model.MailTaskQueue) Option {
 }
 
 func New(opts ...Option) TaskQueue {
-	queue := &taskQueue{}
+	queue := &taskQueue{
+		backend:      constant.QueueBackendList,
+		groupName:    constant.RedisMailQueueGroup,
+		leaseTimeout: constant.QueueLeaseTimeout,
+		reapInterval: constant.QueueReapInterval,
+		pollInterval: constant.QueuePollInterval,
+		leases:       make(map[string]lease),
+	}
 	for _, opt := range opts {
 		opt(queue)
 	}
+	if queue.consumerName == "" {
+		queue.consumerName, _ = os.Hostname()
+	}
+	switch queue.backend {
+	case constant.QueueBackendStream:
+		return &streamQueue{taskQueue: queue}
+	case constant.QueueBackendPostgres:
+		return &postgresQueue{taskQueue: queue}
+	}
 	return queue
 }
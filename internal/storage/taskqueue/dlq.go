@@ -0,0 +1,192 @@
+package taskqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"strconv"
+	"time"
+)
+
+// DeadLetter is a task that can not be delivered anymore, either because it
+// exhausted its tries or because its payload can not be decoded.
+type DeadLetter struct {
+	TaskID   uint      `json:"task_id"`
+	UserID   uint      `json:"user_id"`
+	Payload  string    `json:"payload"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	DeadAt   time.Time `json:"dead_at"`
+}
+
+// deadLetterScript removes a consumed task from its processing list, releases
+// its user and adds the entry to the dead-letter queue.
+// KEYS[1] = processing list, KEYS[2] = in-flight hash, KEYS[3] = dead-letter queue;
+// ARGV[1] = payload, ARGV[2] = user, ARGV[3] = entry.
+var deadLetterScript = redis.NewScript(enqueueScript + `
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+release(KEYS[2], ARGV[2])
+redis.call('LPUSH', KEYS[3], ARGV[3])
+return 1
+`)
+
+// dlqKey returns the dead-letter queue of a user.
+func (r *taskQueue) dlqKey(userID uint) string {
+	return fmt.Sprintf("%s:dlq:%d", r.queueName, userID)
+}
+
+// newDeadLetter builds the dead-letter entry of a task, its payload is the
+// envelope of the task.
+func newDeadLetter(task model.MailTaskQueue, reason error) (DeadLetter, error) {
+	taskJson, err := encode(task)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	return DeadLetter{
+		TaskID:   task.ID,
+		UserID:   task.UserID,
+		Payload:  string(taskJson),
+		Error:    reason.Error(),
+		Attempts: task.TryCount,
+		DeadAt:   time.Now(),
+	}, nil
+}
+
+// poison builds the dead-letter entry of a payload that can not be decoded. The
+// user is known when the payload was dispatched from the sub-queue of a user,
+// otherwise the task and its user are read from the envelope if the payload is
+// still JSON, such as an envelope of a newer version. Only payloads without a
+// readable user are kept in the queue of user 0.
+func poison(payload, user string, reason error) DeadLetter {
+	var envelope Envelope
+	_ = json.Unmarshal([]byte(payload), &envelope)
+	if userID, err := strconv.ParseUint(user, 10, 64); err == nil && userID > 0 {
+		envelope.UserID = uint(userID)
+	}
+	return DeadLetter{
+		TaskID:  envelope.TaskID,
+		UserID:  envelope.UserID,
+		Payload: payload,
+		Error:   reason.Error(),
+		DeadAt:  time.Now(),
+	}
+}
+
+// DeadLetter moves a consumed task that exhausted its tries from its processing
+// list to the dead-letter queue of its user.
+func (r *taskQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	entry, err := newDeadLetter(task, reason)
+	if err != nil {
+		return err
+	}
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.pushDeadLetter(ctx, entry)
+	}
+	return r.deadLetterPayload(ctx, l.processingKey, l.payload, l.user, entry)
+}
+
+// deadLetterPayload atomically moves a payload of a processing list to the dead-letter queue.
+func (r *taskQueue) deadLetterPayload(ctx context.Context, processingKey, payload, user string, entry DeadLetter) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	keys := []string{processingKey, r.inflightKey(), r.dlqKey(entry.UserID)}
+	return deadLetterScript.Run(ctx, r.rdb, keys, payload, user, entryJson).Err()
+}
+
+// pushDeadLetter adds an entry to the dead-letter queue of its user.
+func (r *taskQueue) pushDeadLetter(ctx context.Context, entry DeadLetter) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return r.rdb.LPush(ctx, r.dlqKey(entry.UserID), entryJson).Err()
+}
+
+// DeadLetter acknowledges the pending entry of a consumed task that exhausted
+// its tries and adds it to the dead-letter queue of its user.
+func (r *streamQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	entry, err := newDeadLetter(task, reason)
+	if err != nil {
+		return err
+	}
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.pushDeadLetter(ctx, entry)
+	}
+	return r.deadLetterMessage(ctx, l.stream, l.messageID, entry)
+}
+
+// deadLetterMessage atomically acknowledges a pending entry and adds it to the dead-letter queue.
+func (r *streamQueue) deadLetterMessage(ctx context.Context, stream, messageID string, entry DeadLetter) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, r.groupName, messageID)
+		pipe.LPush(ctx, r.dlqKey(entry.UserID), entryJson)
+		return nil
+	})
+	return err
+}
+
+// DeadLetters returns a page of the dead-letter queue of a user, newest first.
+// A limit of 0 returns every entry from the offset on.
+func (r *taskQueue) DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]DeadLetter, error) {
+	stop := int64(offset + limit - 1)
+	if limit <= 0 {
+		stop = -1
+	}
+	raw, err := r.rdb.LRange(ctx, r.dlqKey(userID), int64(offset), stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DeadLetter, 0, len(raw))
+	for _, entryJson := range raw {
+		var entry DeadLetter
+		if err := json.Unmarshal([]byte(entryJson), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// RemoveDeadLetters removes the entries of the given tasks from the dead-letter
+// queue of a user and returns them, no task IDs remove every entry. An entry is
+// only returned by the call that removed it, so concurrent replays do not
+// deliver a task twice.
+func (r *taskQueue) RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]DeadLetter, error) {
+	key := r.dlqKey(userID)
+	raw, err := r.rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[uint]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		wanted[id] = true
+	}
+	var removed []DeadLetter
+	for _, entryJson := range raw {
+		var entry DeadLetter
+		if err := json.Unmarshal([]byte(entryJson), &entry); err != nil {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[entry.TaskID] {
+			continue
+		}
+		n, err := r.rdb.LRem(ctx, key, 1, entryJson).Result()
+		if err != nil {
+			return removed, err
+		}
+		if n > 0 {
+			removed = append(removed, entry)
+		}
+	}
+	return removed, nil
+}
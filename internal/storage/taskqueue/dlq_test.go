@@ -0,0 +1,190 @@
+package taskqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"sync"
+	"testing"
+)
+
+func deadLetterJson(taskID uint) string {
+	entry, _ := json.Marshal(taskqueue.DeadLetter{TaskID: taskID, UserID: 1, Error: "error", Attempts: 3})
+	return string(entry)
+}
+
+func Test_taskQueue_DeadLetter(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+	{
+		tc := "Case 1: Task Without Lease Added To Dead-Letter Queue Of User"
+		mockClient.CustomMatch(ignoreArgs(-1)).ExpectLPush("testQueue:dlq:1", "entry").SetVal(1)
+		err := taskQueue.DeadLetter(context.Background(), model.MailTaskQueue{UserID: 1, TryCount: 3}, errors.New("error"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Consumed Task Moved From Processing List To Dead-Letter Queue"
+		ctx := context.Background()
+		consumedJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectDispatch(mockClient).SetVal([]interface{}{string(consumedJson), "1"})
+		mockClient.CustomMatch(ignoreArgs(1, -1)).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:dlq:1"},
+			string(consumedJson), "1", "entry").SetVal(int64(1))
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		task := <-taskCh
+		err := taskQueue.DeadLetter(ctx, task, errors.New("error"))
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_DeadLetter(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	{
+		tc := "Case 1: Consumed Task Acked And Added To Dead-Letter Queue"
+		ctx := context.Background()
+		taskJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": string(taskJson)}}},
+		}})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		mockClient.CustomMatch(ignoreArgs(-1)).ExpectLPush("testQueue:dlq:1", "entry").SetVal(1)
+		mockClient.ExpectTxPipelineExec()
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		task := <-taskCh
+		err := taskQueue.DeadLetter(ctx, task, errors.New("error"))
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_DeadLetters(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := taskqueue.New(
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithRedisClient(rdb),
+	)
+	{
+		tc := "Case 1: Redis LRANGE Error And Return Error"
+		mockClient.ExpectLRange("testQueue:dlq:1", 0, 9).SetErr(errors.New("error"))
+		_, err := taskQueue.DeadLetters(context.Background(), 1, 0, 10)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Page Of Entries Returned And Invalid Entries Skipped"
+		mockClient.ExpectLRange("testQueue:dlq:1", 10, 19).SetVal([]string{deadLetterJson(1), "invalid json", deadLetterJson(2)})
+		entries, err := taskQueue.DeadLetters(context.Background(), 1, 10, 10)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 2 || entries[0].TaskID != 1 || entries[1].TaskID != 2 {
+				t.Errorf("Expected entries of tasks 1 and 2, got %v", entries)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_RemoveDeadLetters(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := taskqueue.New(
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithRedisClient(rdb),
+	)
+	{
+		tc := "Case 1: Redis LRANGE Error And Return Error"
+		mockClient.ExpectLRange("testQueue:dlq:1", 0, -1).SetErr(errors.New("error"))
+		_, err := taskQueue.RemoveDeadLetters(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Only Entries Of Given Tasks Removed"
+		mockClient.ExpectLRange("testQueue:dlq:1", 0, -1).SetVal([]string{deadLetterJson(1), deadLetterJson(2)})
+		mockClient.ExpectLRem("testQueue:dlq:1", 1, deadLetterJson(2)).SetVal(1)
+		entries, err := taskQueue.RemoveDeadLetters(context.Background(), 1, 2)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 1 || entries[0].TaskID != 2 {
+				t.Errorf("Expected entry of task 2, got %v", entries)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Entries Removed By Another Call Are Not Returned"
+		mockClient.ExpectLRange("testQueue:dlq:1", 0, -1).SetVal([]string{deadLetterJson(1), deadLetterJson(2)})
+		mockClient.ExpectLRem("testQueue:dlq:1", 1, deadLetterJson(1)).SetVal(0)
+		mockClient.ExpectLRem("testQueue:dlq:1", 1, deadLetterJson(2)).SetVal(1)
+		entries, err := taskQueue.RemoveDeadLetters(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 1 || entries[0].TaskID != 2 {
+				t.Errorf("Expected entry of task 2, got %v", entries)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
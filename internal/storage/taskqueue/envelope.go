@@ -0,0 +1,75 @@
+package taskqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+)
+
+// Envelope is the message the queue carries for a mail task. It only references
+// the task, workers load the task and the SMTP settings of its user from
+// storage when they process it, so no user data or credentials reach redis.
+type Envelope struct {
+	Version  int    `json:"v"`
+	TaskID   uint   `json:"task_id"`
+	UserID   uint   `json:"user_id"`
+	Attempt  int    `json:"attempt"`
+	Priority int    `json:"priority,omitempty"`
+	Trace    string `json:"trace,omitempty"`
+}
+
+// NewEnvelope returns the envelope of a task.
+func NewEnvelope(task model.MailTaskQueue) Envelope {
+	return Envelope{
+		Version:  constant.QueueEnvelopeVersion,
+		TaskID:   task.ID,
+		UserID:   task.UserID,
+		Attempt:  task.TryCount,
+		Priority: task.Priority,
+		Trace:    task.TraceID,
+	}
+}
+
+// Task returns the task reference of the envelope. Only the fields carried by
+// the envelope are set, the rest of the task is loaded from storage.
+func (e Envelope) Task() model.MailTaskQueue {
+	task := model.MailTaskQueue{
+		UserID:   e.UserID,
+		TryCount: e.Attempt,
+		Priority: e.Priority,
+		TraceID:  e.Trace,
+	}
+	task.ID = e.TaskID
+	return task
+}
+
+// encode returns the payload of a published value. Tasks are wrapped in an
+// envelope, other values are published as they are.
+func encode(task interface{}) ([]byte, error) {
+	if t, ok := task.(model.MailTaskQueue); ok {
+		return json.Marshal(NewEnvelope(t))
+	}
+	return json.Marshal(task)
+}
+
+// decode returns the task reference of a payload. Payloads without a version
+// are whole tasks published before envelopes existed, they are reduced to the
+// fields of an envelope so both are processed the same way.
+func decode(payload string) (model.MailTaskQueue, error) {
+	var envelope Envelope
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		return model.MailTaskQueue{}, err
+	}
+	if envelope.Version > constant.QueueEnvelopeVersion {
+		return model.MailTaskQueue{}, fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	if envelope.Version > 0 {
+		return envelope.Task(), nil
+	}
+	var task model.MailTaskQueue
+	if err := json.Unmarshal([]byte(payload), &task); err != nil {
+		return model.MailTaskQueue{}, err
+	}
+	return NewEnvelope(task).Task(), nil
+}
@@ -0,0 +1,118 @@
+package taskqueue
+
+import "github.com/redis/go-redis/v9"
+
+// enqueueScript is prepended to the list backend scripts that add task payloads
+// to the queue. Every lane keeps a sub-queue per user (<lane>:user:<id>) and a
+// ring of the users whose sub-queue is not empty (<lane>:users), payloads
+// without a user go to the lane list itself. The lane names mirror laneKey.
+//
+// Sub-queue keys depend on the payload, so they can not be declared as KEYS and
+// the scripts expect a single redis instance.
+const enqueueScript = payloadScript + `
+local function enqueue(queue, payload, push)
+	local task = decode(payload)
+	if not task then
+		return redis.call(push, queue, payload)
+	end
+	local lane = queue
+	local priority = priorityOf(task)
+	if priority == 1 then
+		lane = queue .. ':high'
+	elseif priority == 2 then
+		lane = queue .. ':bulk'
+	end
+	local user = userIDOf(task)
+	if user == 0 then
+		return redis.call(push, lane, payload)
+	end
+	user = string.format('%d', user)
+	if redis.call(push, lane .. ':user:' .. user, payload) == 1 then
+		redis.call('RPUSH', lane .. ':users', user)
+	end
+end
+
+local function release(inflight, user)
+	if user ~= '' and redis.call('HINCRBY', inflight, user, -1) <= 0 then
+		redis.call('HDEL', inflight, user)
+	end
+end
+
+local function userOf(payload)
+	local task = decode(payload)
+	if task and userIDOf(task) > 0 then
+		return string.format('%d', userIDOf(task))
+	end
+	return ''
+end
+`
+
+// publishScript adds a task payload to the sub-queue of its user.
+// ARGV[1] = queue name, ARGV[2] = payload.
+var publishScript = redis.NewScript(enqueueScript + `
+enqueue(ARGV[1], ARGV[2], 'LPUSH')
+return 1
+`)
+
+// dispatchScript moves the next task to a processing list. The lanes are tried
+// in the given order, within a lane the users are served round-robin and users
+// that reached their concurrency cap are skipped. The lane list itself is tried
+// after the users, for payloads without a user. It returns the payload and its
+// user, which is counted in the in-flight hash until the task is released.
+// KEYS[1] = processing list, KEYS[2] = in-flight hash, KEYS[3] = user caps hash, KEYS[4..] = lanes;
+// ARGV[1] = default per-user cap, 0 means unlimited.
+var dispatchScript = redis.NewScript(`
+for i = 4, #KEYS do
+	local ring = KEYS[i] .. ':users'
+	for _ = 1, redis.call('LLEN', ring) do
+		local user = redis.call('LMOVE', ring, ring, 'LEFT', 'RIGHT')
+		local queue = KEYS[i] .. ':user:' .. user
+		if redis.call('LLEN', queue) == 0 then
+			redis.call('LREM', ring, 1, user)
+		else
+			local cap = tonumber(redis.call('HGET', KEYS[3], user) or ARGV[1])
+			local inflight = tonumber(redis.call('HGET', KEYS[2], user) or '0')
+			if cap <= 0 or inflight < cap then
+				local payload = redis.call('LMOVE', queue, KEYS[1], 'RIGHT', 'LEFT')
+				redis.call('HINCRBY', KEYS[2], user, 1)
+				if redis.call('LLEN', queue) == 0 then
+					redis.call('LREM', ring, 1, user)
+				end
+				return {payload, user}
+			end
+		end
+	end
+	local payload = redis.call('LMOVE', KEYS[i], KEYS[1], 'RIGHT', 'LEFT')
+	if payload then
+		return {payload, ''}
+	end
+end
+return false
+`)
+
+// ackScript removes a consumed task from its processing list and releases its user.
+// KEYS[1] = processing list, KEYS[2] = in-flight hash; ARGV[1] = payload, ARGV[2] = user.
+var ackScript = redis.NewScript(enqueueScript + `
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+release(KEYS[2], ARGV[2])
+return 1
+`)
+
+// nackScript replaces a consumed task in its processing list with the given
+// task in the sub-queue of its user and releases the user of the consumed task.
+// KEYS[1] = processing list, KEYS[2] = in-flight hash;
+// ARGV[1] = queue name, ARGV[2] = consumed payload, ARGV[3] = user, ARGV[4] = new payload.
+var nackScript = redis.NewScript(enqueueScript + `
+redis.call('LREM', KEYS[1], 1, ARGV[2])
+release(KEYS[2], ARGV[3])
+enqueue(ARGV[1], ARGV[4], 'LPUSH')
+return 1
+`)
+
+func (r *taskQueue) inflightKey() string {
+	return r.queueName + ":inflight"
+}
+
+func (r *taskQueue) userCapsKey() string {
+	return r.queueName + ":user_caps"
+}
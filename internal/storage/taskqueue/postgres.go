@@ -0,0 +1,341 @@
+package taskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// postgresQueue is a TaskQueue backed by the mail_task_queues table, for
+// deployments without redis. Publishing a task is a status update, consumers
+// claim queued rows with SELECT ... FOR UPDATE SKIP LOCKED and lease them to
+// their pod until they are acked. Rows whose lease expired are queued again by
+// the reaper, so the rows are the only state and nothing has to be reconciled.
+// Lanes are claimed with weighted preference, users are not scheduled fairly.
+type postgresQueue struct {
+	*taskQueue
+}
+
+// claimQuery leases the next queued row to a pod. The lane of the poll comes
+// first, then the second lane of the poll, then the last one. Rows that are
+// still leased are skipped, a worker that saves a row before it hands it back
+// to the queue keeps it.
+const claimQuery = `UPDATE mail_task_queues SET status = ?, leased_by = ?, lease_expires_at = ?, updated_at = ?
+WHERE id = (
+	SELECT id FROM mail_task_queues
+	WHERE status = ? AND leased_by = '' AND deleted_at IS NULL
+	ORDER BY CASE priority WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// leasedStatuses are the statuses of rows that are leased to a worker, failed
+// rows are leased until the worker nacks them.
+var leasedStatuses = []int{constant.StatusProcessing, constant.StatusFailed}
+
+func (r *postgresQueue) tasks(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Model(&model.MailTaskQueue{})
+}
+
+// release returns the columns that end the lease of a row.
+func release(columns map[string]interface{}) map[string]interface{} {
+	columns["leased_by"] = ""
+	columns["lease_expires_at"] = time.Time{}
+	return columns
+}
+
+// PublishTask queues the row of the task.
+func (r *postgresQueue) PublishTask(ctx context.Context, task interface{}) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		t, ok := task.(model.MailTaskQueue)
+		if !ok {
+			return fmt.Errorf("postgres queue can not publish %T", task)
+		}
+		err := r.tasks(ctx).Where("id = ?", t.ID).Updates(release(map[string]interface{}{
+			"status":    constant.StatusQueued,
+			"try_count": t.TryCount,
+		})).Error
+		if err != nil {
+			return err
+		}
+		log.Infof("publishing task to table: %d", t.ID)
+		return nil
+	}
+}
+
+// PublishTasks queues the rows of the tasks with one update.
+func (r *postgresQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	err := r.tasks(ctx).Where("id IN ?", ids).Updates(release(map[string]interface{}{
+		"status": constant.StatusQueued,
+	})).Error
+	if err != nil {
+		return err
+	}
+	log.Infof("publishing %d tasks to table", len(tasks))
+	return nil
+}
+
+// claim leases the next queued row of the n-th poll to this pod. When no row is
+// queued it returns gorm.ErrRecordNotFound.
+func (r *postgresQueue) claim(ctx context.Context, n int) (model.MailTaskQueue, error) {
+	var task model.MailTaskQueue
+	order := laneOrder(n)
+	now := time.Now()
+	res := r.db.WithContext(ctx).Raw(claimQuery, constant.StatusProcessing, r.consumerName, now.Add(r.leaseTimeout), now,
+		constant.StatusQueued, order[0], order[1]).Scan(&task)
+	if res.Error != nil {
+		return task, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return task, gorm.ErrRecordNotFound
+	}
+	return task, nil
+}
+
+func (r *postgresQueue) SubscribeTask(ctx context.Context, consumerID int) error {
+	log.Infof("consumer %d subscribed to table: mail_task_queues", consumerID)
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("consumer %d done: %v", consumerID, ctx.Err())
+		default:
+			task, err := r.claim(ctx, n)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					r.wait(ctx)
+					continue
+				}
+				return err
+			}
+			log.Infof("consumer %d received task id: %d", consumerID, task.ID)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("consumer %d done: %v", consumerID, ctx.Err())
+			case r.taskChannel <- NewEnvelope(task).Task():
+			}
+			log.Infof("consumer %d sent task to internal channel", consumerID)
+		}
+	}
+}
+
+// Ack ends the lease of a finished task, its status is set by the worker.
+func (r *postgresQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	return r.tasks(ctx).Where("id = ? AND leased_by = ?", task.ID, r.consumerName).
+		Updates(release(map[string]interface{}{})).Error
+}
+
+// Nack ends the lease of a task and queues it again with its new try count.
+// Rows whose lease was taken over by another pod are left alone.
+func (r *postgresQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	return r.tasks(ctx).Where("id = ? AND leased_by = ?", task.ID, r.consumerName).
+		Updates(release(map[string]interface{}{
+			"status":    constant.StatusQueued,
+			"try_count": task.TryCount,
+		})).Error
+}
+
+// Retry ends the lease of a failed task and keeps its row failed until the
+// given time, PromoteDueTasks queues it again once it is due. Rows whose lease
+// was taken over by another pod are left alone.
+func (r *postgresQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	return r.tasks(ctx).Where("id = ? AND leased_by = ?", task.ID, r.consumerName).
+		Updates(release(map[string]interface{}{
+			"status":          constant.StatusFailed,
+			"next_attempt_at": at,
+			"try_count":       task.TryCount,
+		})).Error
+}
+
+// ReapExpiredLeases queues the leased rows whose pod stopped renewing the lease
+// and reports how many rows were queued.
+func (r *postgresQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	res := r.tasks(ctx).Where("status IN ? AND leased_by <> '' AND lease_expires_at < ?", leasedStatuses, time.Now()).
+		Updates(release(map[string]interface{}{"status": constant.StatusQueued}))
+	return int(res.RowsAffected), res.Error
+}
+
+// ScheduleTask sets the row of the task to scheduled with the given send time.
+func (r *postgresQueue) ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return r.tasks(ctx).Where("id = ?", task.ID).Updates(release(map[string]interface{}{
+			"status":       constant.StatusScheduled,
+			"scheduled_at": at,
+			"try_count":    task.TryCount,
+		})).Error
+	}
+}
+
+// PromoteDueTasks queues the scheduled rows and the failed rows waiting for a
+// retry that are due.
+func (r *postgresQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	now := time.Now()
+	res := r.tasks(ctx).Where("status = ? AND scheduled_at <= ?", constant.StatusScheduled, now).
+		Or("status = ? AND leased_by = '' AND next_attempt_at <= ?", constant.StatusFailed, now).
+		Update("status", constant.StatusQueued)
+	return int(res.RowsAffected), res.Error
+}
+
+// DeadLetter cancels the row of a task and flags it as dead-lettered, the
+// dead-letter queue of the postgres backend is the set of flagged rows.
+func (r *postgresQueue) DeadLetter(ctx context.Context, task model.MailTaskQueue, reason error) error {
+	return r.tasks(ctx).Where("id = ?", task.ID).Updates(release(map[string]interface{}{
+		"status":        constant.StatusCancelled,
+		"dead_lettered": true,
+		"last_error":    reason.Error(),
+		"try_count":     task.TryCount,
+	})).Error
+}
+
+// deadLetterOf returns the dead-letter entry of a flagged row.
+func deadLetterOf(task model.MailTaskQueue) DeadLetter {
+	payload, _ := encode(task)
+	return DeadLetter{
+		TaskID:   task.ID,
+		UserID:   task.UserID,
+		Payload:  string(payload),
+		Error:    task.LastError,
+		Attempts: task.TryCount,
+		DeadAt:   task.UpdatedAt,
+	}
+}
+
+// DeadLetters returns a page of the dead-lettered rows of a user, newest first.
+// A limit of 0 returns every row from the offset on.
+func (r *postgresQueue) DeadLetters(ctx context.Context, userID uint, offset, limit int) ([]DeadLetter, error) {
+	var tasks []model.MailTaskQueue
+	if limit <= 0 {
+		limit = -1
+	}
+	err := r.db.WithContext(ctx).Where("user_id = ? AND dead_lettered = ?", userID, true).
+		Order("updated_at DESC").Offset(offset).Limit(limit).Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DeadLetter, 0, len(tasks))
+	for _, task := range tasks {
+		entries = append(entries, deadLetterOf(task))
+	}
+	return entries, nil
+}
+
+// RemoveDeadLetters clears the dead-letter flag of the given rows of a user and
+// returns their entries, no task IDs clear every row. The rows are locked, so
+// concurrent calls do not return the same entry.
+func (r *postgresQueue) RemoveDeadLetters(ctx context.Context, userID uint, taskIDs ...uint) ([]DeadLetter, error) {
+	var removed []DeadLetter
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tasks []model.MailTaskQueue
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND dead_lettered = ?", userID, true)
+		if len(taskIDs) > 0 {
+			query = query.Where("id IN ?", taskIDs)
+		}
+		if err := query.Find(&tasks).Error; err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return nil
+		}
+		ids := make([]uint, 0, len(tasks))
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+			removed = append(removed, deadLetterOf(task))
+		}
+		return tx.Model(&model.MailTaskQueue{}).Where("id IN ?", ids).Update("dead_lettered", false).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// renewLeases extends the leases of the rows leased to this pod.
+func (r *postgresQueue) renewLeases(ctx context.Context) error {
+	return r.tasks(ctx).Where("status IN ? AND leased_by = ?", leasedStatuses, r.consumerName).
+		Update("lease_expires_at", time.Now().Add(r.leaseTimeout)).Error
+}
+
+// heartbeat keeps the leases of this pod alive until the context is done.
+func (r *postgresQueue) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(r.leaseTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.renewLeases(ctx); err != nil {
+				log.Errorf("error renewing task leases: %v", err)
+			}
+		}
+	}
+}
+
+// reap periodically queues the rows of expired leases until the context is done.
+func (r *postgresQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(r.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := r.ReapExpiredLeases(ctx)
+			if err != nil {
+				log.Errorf("error reaping expired leases: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Infof("%d tasks queued again from expired leases", n)
+			}
+		}
+	}
+}
+
+func (r *postgresQueue) StartConsume(ctx context.Context) <-chan error {
+	errCh := make(chan error, r.consumerCount)
+	wg := sync.WaitGroup{}
+	leaseCtx := r.leaseContext(ctx)
+	go r.heartbeat(leaseCtx)
+	go r.reap(leaseCtx)
+	for i := 0; i < r.consumerCount; i++ {
+		wg.Add(1)
+		go func(consumerID int) {
+			defer wg.Done()
+			if err := r.SubscribeTask(ctx, consumerID+1); err != nil {
+				log.Errorf("error consuming task: %v", err)
+				errCh <- err
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+	return errCh
+}
+
+// Close stops renewing the leases of the pod and queues the rows it still
+// leases again.
+func (r *postgresQueue) Close(ctx context.Context) error {
+	r.takeLeases()
+	return r.tasks(ctx).Where("status = ? AND leased_by = ?", constant.StatusProcessing, r.consumerName).
+		Updates(release(map[string]interface{}{"status": constant.StatusQueued})).Error
+}
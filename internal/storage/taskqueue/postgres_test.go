@@ -0,0 +1,347 @@
+package taskqueue_test
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newPostgresQueue(taskCh chan model.MailTaskQueue) (taskqueue.TaskQueue, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return taskqueue.New(
+		taskqueue.WithBackend(constant.QueueBackendPostgres),
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithPollInterval(time.Millisecond),
+		taskqueue.WithTaskChannel(taskCh),
+		taskqueue.WithDB(db),
+	), mock
+}
+
+func Test_postgresQueue_PublishTask(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Invalid Task Type And Return Error"
+		err := taskQueue.PublishTask(context.Background(), "task")
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Row Of Task Queued And Lease Cleared"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"leased_by"=.*"status"=.*"try_count"=.* WHERE id = .*`).
+			WithArgs(sqlmock.AnyArg(), "", constant.StatusQueued, 2, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := taskQueue.PublishTask(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 2})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_PublishTasks(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Rows Of Tasks Queued With One Update"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"leased_by"=.*"status"=.* WHERE id IN \(.*,.*\)`).
+			WithArgs(sqlmock.AnyArg(), "", constant.StatusQueued, sqlmock.AnyArg(), 1, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		err := taskQueue.PublishTasks(context.Background(), []model.MailTaskQueue{{Model: gorm.Model{ID: 1}}, {Model: gorm.Model{ID: 2}}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_SubscribeTask(t *testing.T) {
+	{
+		tc := "Case 1: Claim Error And Return Error"
+		taskQueue, mock := newPostgresQueue(nil)
+		mock.ExpectQuery("UPDATE mail_task_queues SET status = .* FOR UPDATE SKIP LOCKED").
+			WillReturnError(errors.New("error"))
+		err := taskQueue.SubscribeTask(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Empty Table Polled Until A Row Is Claimed And Envelope Sent To Channel"
+		taskCh := make(chan model.MailTaskQueue, 1)
+		taskQueue, mock := newPostgresQueue(taskCh)
+		mock.ExpectQuery("UPDATE mail_task_queues SET status = .* WHERE status = \\$5 AND leased_by = '' .* FOR UPDATE SKIP LOCKED").
+			WithArgs(constant.StatusProcessing, "test", sqlmock.AnyArg(), sqlmock.AnyArg(),
+				constant.StatusQueued, constant.PriorityHigh, constant.PriorityNormal).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery("UPDATE mail_task_queues SET status = .* FOR UPDATE SKIP LOCKED").
+			WithArgs(constant.StatusProcessing, "test", sqlmock.AnyArg(), sqlmock.AnyArg(),
+				constant.StatusQueued, constant.PriorityHigh, constant.PriorityNormal).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "try_count", "subject"}).AddRow(1, 2, 1, "subject"))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- taskQueue.SubscribeTask(ctx, 1)
+		}()
+		task := <-taskCh
+		cancel()
+		<-done
+		t.Run(tc, func(t *testing.T) {
+			if task.ID != 1 || task.UserID != 2 || task.TryCount != 1 {
+				t.Errorf("Expected envelope of task 1, got %v", task)
+			}
+			if task.Subject != "" {
+				t.Errorf("Expected stub task, got subject %s", task.Subject)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_Ack(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Lease Of This Pod Cleared"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"leased_by"=.* WHERE \(id = .* AND leased_by = .*\)`).
+			WithArgs(sqlmock.AnyArg(), "", sqlmock.AnyArg(), 1, "test").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := taskQueue.Ack(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_Nack(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET`).WillReturnError(errors.New("error"))
+		mock.ExpectRollback()
+		err := taskQueue.Nack(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Row Queued Again With New Try Count"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"status"=.*"try_count"=.* WHERE \(id = .* AND leased_by = .*\)`).
+			WithArgs(sqlmock.AnyArg(), "", constant.StatusQueued, 1, sqlmock.AnyArg(), 1, "test").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := taskQueue.Nack(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_Retry(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	at := time.Now().Add(time.Minute)
+	{
+		tc := "Case 1: Database Error And Return Error"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET`).WillReturnError(errors.New("error"))
+		mock.ExpectRollback()
+		err := taskQueue.Retry(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1}, at)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Row Failed Until Next Attempt With New Try Count"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"next_attempt_at"=.*"status"=.*"try_count"=.* WHERE \(id = .* AND leased_by = .*\)`).
+			WithArgs(sqlmock.AnyArg(), "", at, constant.StatusFailed, 1, sqlmock.AnyArg(), 1, "test").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := taskQueue.Retry(context.Background(), model.MailTaskQueue{Model: gorm.Model{ID: 1}, TryCount: 1}, at)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_ReapExpiredLeases(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Rows Of Expired Leases Queued Again"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .* WHERE \(status IN .* AND leased_by <> '' AND lease_expires_at < .*\)`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		n, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if n != 2 {
+				t.Errorf("Expected 2 reaped tasks, got %d", n)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_PromoteDueTasks(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Due Scheduled And Retried Rows Queued"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET "status"=.* WHERE \(\(status = .* AND scheduled_at <= .*\) OR \(status = .* AND leased_by = '' AND next_attempt_at <= .*\)\)`).
+			WithArgs(constant.StatusQueued, sqlmock.AnyArg(), constant.StatusScheduled, sqlmock.AnyArg(), constant.StatusFailed, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+		n, err := taskQueue.PromoteDueTasks(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if n != 3 {
+				t.Errorf("Expected 3 promoted tasks, got %d", n)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_DeadLetters(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Database Error And Return Error"
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_queues"`).WillReturnError(errors.New("error"))
+		_, err := taskQueue.DeadLetters(context.Background(), 1, 0, 10)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Page Of Dead-Lettered Rows Returned As Entries"
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_queues" WHERE \(user_id = .* AND dead_lettered = .*\) .* ORDER BY updated_at DESC LIMIT .* OFFSET .*`).
+			WithArgs(1, true, 10, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "try_count", "last_error"}).AddRow(4, 1, 3, "error"))
+		entries, err := taskQueue.DeadLetters(context.Background(), 1, 10, 10)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 1 || entries[0].TaskID != 4 || entries[0].Attempts != 3 || entries[0].Error != "error" {
+				t.Errorf("Expected entry of task 4, got %v", entries)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_RemoveDeadLetters(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: No Dead-Lettered Rows And Nothing Removed"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_queues" WHERE \(user_id = .* AND dead_lettered = .*\) AND id IN .* FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+		entries, err := taskQueue.RemoveDeadLetters(context.Background(), 1, 2)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("Expected no entries, got %v", entries)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Locked Rows Unflagged And Returned"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "mail_task_queues" WHERE \(user_id = .* AND dead_lettered = .*\) .* FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(1, 1).AddRow(2, 1))
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET "dead_lettered"=.* WHERE id IN`).
+			WithArgs(false, sqlmock.AnyArg(), 1, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		entries, err := taskQueue.RemoveDeadLetters(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if len(entries) != 2 || entries[0].TaskID != 1 || entries[1].TaskID != 2 {
+				t.Errorf("Expected entries of tasks 1 and 2, got %v", entries)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_postgresQueue_Close(t *testing.T) {
+	taskQueue, mock := newPostgresQueue(nil)
+	{
+		tc := "Case 1: Rows Leased By Pod Queued Again"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mail_task_queues" SET .*"status"=.* WHERE \(status = .* AND leased_by = .*\)`).
+			WithArgs(sqlmock.AnyArg(), "", constant.StatusQueued, sqlmock.AnyArg(), constant.StatusProcessing, "test").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		err := taskQueue.Close(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package taskqueue
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+)
+
+// lanePriorities lists the priorities from the most to the least urgent.
+var lanePriorities = []int{constant.PriorityHigh, constant.PriorityNormal, constant.PriorityBulk}
+
+// payloadScript is prepended to the scripts that read the priority or the user
+// of a payload. It reads envelopes as well as whole tasks published before
+// envelopes existed, decode returns nil for payloads that are not objects.
+const payloadScript = `
+local function decode(payload)
+	local ok, task = pcall(cjson.decode, payload)
+	if ok and type(task) == 'table' then
+		return task
+	end
+	return nil
+end
+
+local function priorityOf(task)
+	return tonumber(task['priority'] or task['Priority']) or 0
+end
+
+local function userIDOf(task)
+	return tonumber(task['user_id'] or task['UserID']) or 0
+end
+`
+
+// laneScript is prepended to the stream backend scripts that move task payloads
+// between keys. lane returns the key of the payload's priority, the lane keys
+// are passed as consecutive KEYS starting at first and ordered by priority value.
+const laneScript = payloadScript + `
+local function lane(payload, first)
+	local task = decode(payload)
+	if task then
+		local priority = priorityOf(task)
+		if priority == 1 or priority == 2 then
+			return KEYS[first + priority]
+		end
+	end
+	return KEYS[first]
+end
+`
+
+// laneKey returns the list of the given priority. Normal priority tasks use the
+// queue name itself, so tasks published before priorities existed are consumed.
+func (r *taskQueue) laneKey(priority int) string {
+	switch priority {
+	case constant.PriorityHigh:
+		return r.queueName + ":high"
+	case constant.PriorityBulk:
+		return r.queueName + ":bulk"
+	default:
+		return r.queueName
+	}
+}
+
+// priorityOf returns the priority of a published task, values that are not
+// tasks are published to the normal lane.
+func priorityOf(task interface{}) int {
+	if t, ok := task.(model.MailTaskQueue); ok {
+		return t.Priority
+	}
+	return constant.PriorityNormal
+}
+
+// laneOrder returns the priorities in the order a consumer tries them on its
+// n-th poll. The first lane rotates by the priority weights, the rest follow in
+// priority order, so bulk mail keeps flowing without starving transactional mail.
+func laneOrder(n int) []int {
+	slot := n % (constant.PriorityWeightHigh + constant.PriorityWeightNormal + constant.PriorityWeightBulk)
+	first := constant.PriorityHigh
+	switch {
+	case slot >= constant.PriorityWeightHigh+constant.PriorityWeightNormal:
+		first = constant.PriorityBulk
+	case slot >= constant.PriorityWeightHigh:
+		first = constant.PriorityNormal
+	}
+	order := []int{first}
+	for _, priority := range lanePriorities {
+		if priority != first {
+			order = append(order, priority)
+		}
+	}
+	return order
+}
@@ -2,65 +2,317 @@ package taskqueue
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/redis/go-redis/v9"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// reapScript moves every message of a processing list back to the sub-queue of
+// its user and releases the user, if the heartbeat of its consumer has expired.
+// KEYS[1] = consumer registry, KEYS[2] = processing list, KEYS[3] = heartbeat, KEYS[4] = in-flight hash;
+// ARGV[1] = queue name.
+var reapScript = redis.NewScript(enqueueScript + `
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 0
+end
+local n = 0
+local payload = redis.call('LPOP', KEYS[2])
+while payload do
+	release(KEYS[4], userOf(payload))
+	enqueue(ARGV[1], payload, 'RPUSH')
+	n = n + 1
+	payload = redis.call('LPOP', KEYS[2])
+end
+redis.call('HDEL', KEYS[1], KEYS[2])
+return n
+`)
+
+func (r *taskQueue) processingKey(consumerID int) string {
+	return fmt.Sprintf("%s:processing:%s:%d", r.queueName, r.consumerName, consumerID)
+}
+
+func (r *taskQueue) heartbeatKey() string {
+	return fmt.Sprintf("%s:heartbeat:%s", r.queueName, r.consumerName)
+}
+
+func (r *taskQueue) registryKey() string {
+	return r.queueName + ":consumers"
+}
+
 func (r *taskQueue) PublishTask(ctx context.Context, task interface{}) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
-		taskJson, err := json.Marshal(task)
+		taskJson, err := encode(task)
 		if err != nil {
 			return err
 		}
-		if err := r.rdb.LPush(ctx, r.queueName, taskJson).Err(); err != nil {
+		if err := publishScript.Run(ctx, r.rdb, nil, r.queueName, taskJson).Err(); err != nil {
 			return err
 		}
-		log.Infof("publishing task to channel: %s", r.queueName)
+		log.Infof("publishing task to channel: %s", r.laneKey(priorityOf(task)))
 		return nil
 	}
 }
 
+// PublishTasks pipelines the publish script for every task. When the script
+// is not cached by redis yet it is loaded and the pipeline is sent again.
+func (r *taskQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
+	payloads := make([][]byte, len(tasks))
+	for i, task := range tasks {
+		taskJson, err := encode(task)
+		if err != nil {
+			return err
+		}
+		payloads[i] = taskJson
+	}
+	publish := func(pipe redis.Pipeliner) error {
+		for _, payload := range payloads {
+			publishScript.EvalSha(ctx, pipe, nil, r.queueName, payload)
+		}
+		return nil
+	}
+	_, err := r.rdb.Pipelined(ctx, publish)
+	if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err := publishScript.Load(ctx, r.rdb).Err(); err != nil {
+			return err
+		}
+		_, err = r.rdb.Pipelined(ctx, publish)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("publishing %d tasks to queue: %s", len(tasks), r.queueName)
+	return nil
+}
+
+// dequeue moves the next task of the n-th poll to the processing list and
+// returns its payload and user. The lanes are tried in weighted order and the
+// users of a lane round-robin, when no task is available it returns redis.Nil.
+func (r *taskQueue) dequeue(ctx context.Context, processingKey string, n int) (string, string, error) {
+	keys := []string{processingKey, r.inflightKey(), r.userCapsKey()}
+	for _, priority := range laneOrder(n) {
+		keys = append(keys, r.laneKey(priority))
+	}
+	res, err := dispatchScript.Run(ctx, r.rdb, keys, r.userConcurrency).StringSlice()
+	if err != nil {
+		return "", "", err
+	}
+	if len(res) != 2 {
+		return "", "", fmt.Errorf("unexpected dispatch result: %v", res)
+	}
+	return res[0], res[1], nil
+}
+
+// wait blocks for the poll interval or until the context is done.
+func (r *taskQueue) wait(ctx context.Context) {
+	timer := time.NewTimer(r.pollInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
 func (r *taskQueue) SubscribeTask(ctx context.Context, consumerID int) error {
-	var (
-		task model.MailTaskQueue
-	)
+	processingKey := r.processingKey(consumerID)
 	log.Infof("consumer %d subscribed to channel: %s", consumerID, r.queueName)
-	for {
+	for n := 0; ; n++ {
 		select {
 		case <-ctx.Done():
 			return fmt.Errorf("consumer %d done: %v", consumerID, ctx.Err())
 		default:
-			timeout := 1 * time.Second
-			result, err := r.rdb.BRPop(ctx, timeout, r.queueName).Result()
+			payload, user, err := r.dequeue(ctx, processingKey, n)
 			if err != nil {
 				if errors.Is(err, redis.Nil) {
+					r.wait(ctx)
 					continue
 				}
 				return err
 			}
-			if err := json.Unmarshal([]byte(result[1]), &task); err != nil {
+			task, err := decode(payload)
+			if err != nil {
 				log.Errorf("consumer %d error unmarshalling task: %v", consumerID, err)
+				if err := r.deadLetterPayload(ctx, processingKey, payload, user, poison(payload, user, err)); err != nil {
+					log.Errorf("consumer %d error dead-lettering invalid task: %v", consumerID, err)
+				}
 				continue
 			}
+			task.LeaseID = r.addLease(lease{processingKey: processingKey, payload: payload, user: user})
 			log.Infof("consumer %d received task id: %d", consumerID, task.ID)
-			r.taskChannel <- task
+			// A task that can not be handed to a worker before the consumer
+			// stops stays leased, Close returns it to the queue.
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("consumer %d done: %v", consumerID, ctx.Err())
+			case r.taskChannel <- task:
+			}
 			log.Infof("consumer %d sent task to internal channel", consumerID)
 		}
 	}
 }
 
+// addLease stores the lease of a consumed message and returns its ID, which
+// the task carries to Ack, Nack, Retry or DeadLetter the message.
+func (r *taskQueue) addLease(l lease) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaseSeq++
+	id := strconv.FormatUint(r.leaseSeq, 10)
+	r.leases[id] = l
+	return id
+}
+
+// takeLease removes and returns the lease of the given task.
+func (r *taskQueue) takeLease(task model.MailTaskQueue) (lease, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.leases[task.LeaseID]
+	if ok {
+		delete(r.leases, task.LeaseID)
+	}
+	return l, ok
+}
+
+// Ack removes a consumed task from its processing list and releases the
+// concurrency slot of its user. It is called when the task is finished, either
+// successfully or with a permanent failure.
+func (r *taskQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return nil
+	}
+	keys := []string{l.processingKey, r.inflightKey()}
+	if err := ackScript.Run(ctx, r.rdb, keys, l.payload, l.user).Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Nack atomically replaces a consumed task in its processing list with the
+// given task in the sub-queue of its user, so it is delivered again.
+func (r *taskQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.PublishTask(ctx, task)
+	}
+	taskJson, err := encode(task)
+	if err != nil {
+		return err
+	}
+	keys := []string{l.processingKey, r.inflightKey()}
+	return nackScript.Run(ctx, r.rdb, keys, r.queueName, l.payload, l.user, taskJson).Err()
+}
+
+// ReapExpiredLeases returns the tasks of consumers whose heartbeat has expired
+// to the queue and reports how many tasks were returned.
+func (r *taskQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	consumers, err := r.rdb.HGetAll(ctx, r.registryKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for processingKey, heartbeatKey := range consumers {
+		keys := []string{r.registryKey(), processingKey, heartbeatKey, r.inflightKey()}
+		n, err := reapScript.Run(ctx, r.rdb, keys, r.queueName).Int()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// register refreshes the heartbeat of this pod and registers the processing
+// lists of its consumers, so the reaper can find them once the heartbeat expires.
+func (r *taskQueue) register(ctx context.Context) error {
+	fields := make([]interface{}, 0, r.consumerCount*2)
+	for i := 0; i < r.consumerCount; i++ {
+		fields = append(fields, r.processingKey(i+1), r.heartbeatKey())
+	}
+	_, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.heartbeatKey(), time.Now().Unix(), r.leaseTimeout)
+		pipe.HSet(ctx, r.registryKey(), fields...)
+		return nil
+	})
+	return err
+}
+
+// heartbeat keeps the leases of this pod's consumers alive until the context is done.
+func (r *taskQueue) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(r.leaseTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.register(ctx); err != nil {
+				log.Errorf("error refreshing consumer heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// reap periodically returns expired leases to the queue until the context is done.
+func (r *taskQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(r.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := r.ReapExpiredLeases(ctx)
+			if err != nil {
+				log.Errorf("error reaping expired leases: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Infof("%d tasks returned to channel %s from expired leases", n, r.queueName)
+			}
+		}
+	}
+}
+
+// leaseContext returns the context of the heartbeat and the reaper, which
+// outlive the consumers until Close is called.
+func (r *taskQueue) leaseContext(ctx context.Context) context.Context {
+	leaseCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	r.mu.Lock()
+	r.stopLeases = cancel
+	r.mu.Unlock()
+	return leaseCtx
+}
+
+// takeLeases stops the heartbeat and the reaper and removes and returns all
+// the leases of the pod.
+func (r *taskQueue) takeLeases() map[string]lease {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopLeases != nil {
+		r.stopLeases()
+	}
+	leases := r.leases
+	r.leases = make(map[string]lease)
+	return leases
+}
+
 func (r *taskQueue) StartConsume(ctx context.Context) <-chan error {
 	errCh := make(chan error, r.consumerCount)
 	wg := sync.WaitGroup{}
+	if err := r.register(ctx); err != nil {
+		log.Errorf("error registering consumers: %v", err)
+	}
+	leaseCtx := r.leaseContext(ctx)
+	go r.heartbeat(leaseCtx)
+	go r.reap(leaseCtx)
 	for i := 0; i < r.consumerCount; i++ {
 		wg.Add(1)
 		go func(consumerID int) {
@@ -77,3 +329,21 @@ func (r *taskQueue) StartConsume(ctx context.Context) <-chan error {
 	}()
 	return errCh
 }
+
+// Close stops the heartbeat of the pod and returns the tasks it still leases,
+// buffered for the workers or not finished by them, to the sub-queue of their
+// user. The heartbeat is deleted, so the reapers of other pods
+// clean up the processing lists right away.
+func (r *taskQueue) Close(ctx context.Context) error {
+	var errs []error
+	for _, l := range r.takeLeases() {
+		keys := []string{l.processingKey, r.inflightKey()}
+		if err := nackScript.Run(ctx, r.rdb, keys, r.queueName, l.payload, l.user, l.payload).Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := r.rdb.Del(ctx, r.heartbeatKey()).Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -5,21 +5,61 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-redis/redismock/v9"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"gorm.io/gorm"
 	"strings"
 	"sync"
 	"testing"
-	"time"
 )
 
+// ignoreArgs matches commands by their arguments, ignoring the arguments at the
+// given indexes. Negative indexes count from the end.
+func ignoreArgs(indexes ...int) func(expected, actual []interface{}) error {
+	return func(expected, actual []interface{}) error {
+		if len(expected) != len(actual) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+		ignored := make(map[int]bool, len(indexes))
+		for _, i := range indexes {
+			if i < 0 {
+				i += len(expected)
+			}
+			ignored[i] = true
+		}
+		for i := range expected {
+			if !ignored[i] && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+				return fmt.Errorf("expected %v, got %v", expected, actual)
+			}
+		}
+		return nil
+	}
+}
+
+// scriptArgs matches script calls by their keys and arguments, ignoring the sha of the script.
+var scriptArgs = ignoreArgs(1)
+
+// expectDispatch expects consumer 1 to dispatch a task on its first poll.
+func expectDispatch(mockClient redismock.ClientMock) *redismock.ExpectedCmd {
+	keys := []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:user_caps", "testQueue:high", "testQueue", "testQueue:bulk"}
+	return mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", keys, 0)
+}
+
+// expectPublish expects the task payload to be published.
+func expectPublish(mockClient redismock.ClientMock, taskJson []byte) *redismock.ExpectedCmd {
+	return mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", nil, "testQueue", taskJson)
+}
+
 func Test_taskQueue_PublishTask(t *testing.T) {
 	rdb, mockClient := redismock.NewClientMock()
 	taskQueue := taskqueue.New(
 		taskqueue.WithConsumerCount(1),
 		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
 		taskqueue.WithRedisClient(rdb),
 		taskqueue.WithTaskChannel(make(chan model.MailTaskQueue)),
 	)
@@ -45,9 +85,9 @@ func Test_taskQueue_PublishTask(t *testing.T) {
 		})
 	}
 	{
-		tc := "Case 3: Redis LPUSH Error And Return Error"
+		tc := "Case 3: Redis Publish Script Error And Return Error"
 		ctx := context.Background()
-		mockClient.ExpectLPush("testQueue", "test").SetErr(errors.New("error"))
+		expectPublish(mockClient, []byte(`"test"`)).SetErr(errors.New("error"))
 		err := taskQueue.PublishTask(ctx, "test")
 		t.Run(tc, func(t *testing.T) {
 			if err == nil {
@@ -63,8 +103,8 @@ func Test_taskQueue_PublishTask(t *testing.T) {
 		log.SetOutput(&buf)
 
 		expectedTask := model.MailTaskQueue{UserID: 1}
-		expectedJson, _ := json.Marshal(expectedTask)
-		mockClient.ExpectLPush("testQueue", expectedJson).SetVal(1)
+		expectedJson, _ := json.Marshal(taskqueue.NewEnvelope(expectedTask))
+		expectPublish(mockClient, expectedJson).SetVal(int64(1))
 
 		err := taskQueue.PublishTask(ctx, expectedTask)
 		want := "publishing task to channel: testQueue"
@@ -79,6 +119,97 @@ func Test_taskQueue_PublishTask(t *testing.T) {
 		})
 		mockClient.ClearExpect()
 	}
+	{
+		tc := "Case 5: High Priority Task Published To High Lane"
+		ctx := context.Background()
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		expectedTask := model.MailTaskQueue{UserID: 1, Priority: constant.PriorityHigh}
+		expectedJson, _ := json.Marshal(taskqueue.NewEnvelope(expectedTask))
+		expectPublish(mockClient, expectedJson).SetVal(int64(1))
+
+		err := taskQueue.PublishTask(ctx, expectedTask)
+		want := "publishing task to channel: testQueue:high"
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !strings.Contains(logContents, want) {
+				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, logContents)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+// redisError is an error replied by redis.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func (e redisError) RedisError() {}
+
+func Test_taskQueue_PublishTasks(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := taskqueue.New(
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+	)
+	tasks := []model.MailTaskQueue{{UserID: 1}, {UserID: 2, Priority: constant.PriorityBulk}}
+	{
+		tc := "Case 1: Publish Scripts Of All Tasks Pipelined And Return Nil"
+		for _, task := range tasks {
+			taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+			expectPublish(mockClient, taskJson).SetVal(int64(1))
+		}
+		err := taskQueue.PublishTasks(context.Background(), tasks)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Publish Script Not Loaded, Loaded And Pipeline Sent Again"
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(tasks[0]))
+		expectPublish(mockClient, taskJson).SetErr(redisError("NOSCRIPT No matching script"))
+		mockClient.Regexp().ExpectScriptLoad(".*").SetVal("sha")
+		for _, task := range tasks {
+			taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+			expectPublish(mockClient, taskJson).SetVal(int64(1))
+		}
+		err := taskQueue.PublishTasks(context.Background(), tasks)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Redis Publish Script Error And Return Error"
+		for _, task := range tasks {
+			taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+			expectPublish(mockClient, taskJson).SetErr(errors.New("error"))
+		}
+		err := taskQueue.PublishTasks(context.Background(), tasks)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
 }
 
 func Test_taskQueue_SubscribeTask(t *testing.T) {
@@ -87,6 +218,7 @@ func Test_taskQueue_SubscribeTask(t *testing.T) {
 	taskQueue := taskqueue.New(
 		taskqueue.WithConsumerCount(1),
 		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
 		taskqueue.WithRedisClient(rdb),
 		taskqueue.WithTaskChannel(taskCh),
 	)
@@ -103,9 +235,9 @@ func Test_taskQueue_SubscribeTask(t *testing.T) {
 		})
 	}
 	{
-		tc := "Case 2: Redis BRPOP Error And Return Error"
+		tc := "Case 2: Redis Dispatch Script Error And Return Error"
 		ctx := context.Background()
-		mockClient.ExpectBRPop(time.Second, "testQueue").SetErr(errors.New("error"))
+		expectDispatch(mockClient).SetErr(errors.New("error"))
 		err := taskQueue.SubscribeTask(ctx, 1)
 		t.Run(tc, func(t *testing.T) {
 			if err == nil {
@@ -115,9 +247,11 @@ func Test_taskQueue_SubscribeTask(t *testing.T) {
 		mockClient.ClearExpect()
 	}
 	{
-		tc := "Case 3: Redis BRPOP Return Redis.Nil Error And Continue But JSON Unmarshal Error And Print Log"
+		tc := "Case 3: Redis Dispatch Script Returns Invalid Payload, JSON Unmarshal Error, Dead-Letter Payload And Print Log"
 		ctx := context.Background()
-		mockClient.ExpectBRPop(time.Second, "testQueue").SetVal([]string{"test", "invalid json"})
+		expectDispatch(mockClient).SetVal([]interface{}{"invalid json", ""})
+		mockClient.CustomMatch(ignoreArgs(1, -1)).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:dlq:0"},
+			"invalid json", "", "entry").SetVal(int64(1))
 		var buf bytes.Buffer
 		log.SetOutput(&buf)
 
@@ -135,12 +269,13 @@ func Test_taskQueue_SubscribeTask(t *testing.T) {
 		mockClient.ClearExpect()
 	}
 	{
-		tc := "Case 4: Valid Task Model Send Task To Channel"
+		tc := "Case 4: Valid Envelope Send Task Reference To Channel"
 		ctx := context.Background()
 
-		expectedTask := model.MailTaskQueue{UserID: 1}
-		expectedJson, _ := json.Marshal(expectedTask)
-		mockClient.ExpectBRPop(time.Second, "testQueue").SetVal([]string{"testQueue", string(expectedJson)})
+		expectedTask := model.MailTaskQueue{UserID: 1, TryCount: 2, TraceID: "trace"}
+		expectedTask.ID = 1
+		expectedJson, _ := json.Marshal(taskqueue.NewEnvelope(expectedTask))
+		expectDispatch(mockClient).SetVal([]interface{}{string(expectedJson), "1"})
 
 		wg := sync.WaitGroup{}
 		wg.Add(1)
@@ -151,12 +286,60 @@ func Test_taskQueue_SubscribeTask(t *testing.T) {
 			}
 		}()
 
+		t.Run(tc, func(t *testing.T) {
+			if task := <-taskCh; task.ID != expectedTask.ID || task.UserID != expectedTask.UserID ||
+				task.TryCount != expectedTask.TryCount || task.TraceID != expectedTask.TraceID {
+				t.Errorf("Expected task: %v, got %v", expectedTask, task)
+			}
+		})
+		wg.Wait()
+	}
+	{
+		tc := "Case 5: No Task Dispatched, Wait Poll Interval And Send Next Task Published Before Envelopes To Channel"
+		ctx := context.Background()
+
+		expectedTask := model.MailTaskQueue{UserID: 2}
+		expectedJson, _ := json.Marshal(expectedTask)
+		expectDispatch(mockClient).RedisNil()
+		expectDispatch(mockClient).SetVal([]interface{}{string(expectedJson), "2"})
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+
 		t.Run(tc, func(t *testing.T) {
 			if task := <-taskCh; task.UserID != expectedTask.UserID {
 				t.Errorf("Expected task: %v, got %v", expectedTask, task)
 			}
 		})
 		wg.Wait()
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 6: Envelope Of Newer Version Dead-Lettered And Print Log"
+		ctx := context.Background()
+		payload := `{"v":99,"task_id":1,"user_id":1}`
+		expectDispatch(mockClient).SetVal([]interface{}{payload, "1"})
+		mockClient.CustomMatch(ignoreArgs(1, -1)).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:dlq:1"},
+			payload, "1", "entry").SetVal(int64(1))
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		_ = taskQueue.SubscribeTask(ctx, 1)
+		want := "consumer 1 error unmarshalling task: unsupported envelope version 99"
+		logContents := buf.String()
+		t.Run(tc, func(t *testing.T) {
+			if !strings.Contains(logContents, want) {
+				t.Errorf("Expected log \"%s\" not found in log contents:\n%s", want, logContents)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
 	}
 }
 
@@ -166,6 +349,7 @@ func Test_taskQueue_StartConsume(t *testing.T) {
 	taskQueue := taskqueue.New(
 		taskqueue.WithConsumerCount(1),
 		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
 		taskqueue.WithRedisClient(rdb),
 		taskqueue.WithTaskChannel(taskCh),
 	)
@@ -182,9 +366,9 @@ func Test_taskQueue_StartConsume(t *testing.T) {
 		})
 	}
 	{
-		tc := "Case 2: Redis BRPOP Error And Return Error"
+		tc := "Case 2: Redis Dispatch Script Error And Return Error"
 		ctx := context.Background()
-		mockClient.ExpectBRPop(time.Second, "testQueue").SetErr(errors.New("error"))
+		expectDispatch(mockClient).SetErr(errors.New("error"))
 		errCh := taskQueue.StartConsume(ctx)
 		t.Run(tc, func(t *testing.T) {
 			if err := <-errCh; err == nil || !strings.Contains(err.Error(), "error") {
@@ -198,7 +382,7 @@ func Test_taskQueue_StartConsume(t *testing.T) {
 
 		expectedTask := model.MailTaskQueue{UserID: 1}
 		expectedJson, _ := json.Marshal(expectedTask)
-		mockClient.ExpectBRPop(time.Second, "testQueue").SetVal([]string{"testQueue", string(expectedJson)})
+		expectDispatch(mockClient).SetVal([]interface{}{string(expectedJson), "1"})
 		wg := sync.WaitGroup{}
 		t.Run(tc, func(t *testing.T) {
 			wg.Add(1)
@@ -209,10 +393,252 @@ func Test_taskQueue_StartConsume(t *testing.T) {
 					log.Errorf("error: %v", err)
 				}
 			}()
-			if task := <-taskCh; task.UserID != expectedTask.UserID {
+			if task := <-taskCh; task.ID != expectedTask.ID || task.UserID != expectedTask.UserID ||
+				task.TryCount != expectedTask.TryCount || task.TraceID != expectedTask.TraceID {
 				t.Errorf("Expected task: %v, got %v", expectedTask, task)
 			}
 		})
 		wg.Wait()
 	}
 }
+
+func Test_taskQueue_Ack(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+	{
+		tc := "Case 1: Task Without Lease And Return Nil"
+		err := taskQueue.Ack(context.Background(), model.MailTaskQueue{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Consumed Task Removed From Processing List And User Released"
+		ctx := context.Background()
+		expectedTask := model.MailTaskQueue{UserID: 1}
+		expectedJson, _ := json.Marshal(expectedTask)
+		expectDispatch(mockClient).SetVal([]interface{}{string(expectedJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight"}, string(expectedJson), "1").SetVal(int64(1))
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		task := <-taskCh
+		err := taskQueue.Ack(ctx, task)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Task Delivered Twice And Every Delivery Removed From Processing List"
+		ctx := context.Background()
+		firstJson, _ := json.Marshal(taskqueue.Envelope{Version: 1, TaskID: 1, UserID: 1, Trace: "first"})
+		secondJson, _ := json.Marshal(taskqueue.Envelope{Version: 1, TaskID: 1, UserID: 1, Trace: "second"})
+		expectDispatch(mockClient).SetVal([]interface{}{string(firstJson), "1"})
+		expectDispatch(mockClient).SetVal([]interface{}{string(secondJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight"}, string(firstJson), "1").SetVal(int64(1))
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight"}, string(secondJson), "1").SetVal(int64(1))
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		first := <-taskCh
+		second := <-taskCh
+		firstErr := taskQueue.Ack(ctx, first)
+		secondErr := taskQueue.Ack(ctx, second)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if firstErr != nil || secondErr != nil {
+				t.Errorf("Expected nil, got %v and %v", firstErr, secondErr)
+			}
+			if first.LeaseID == second.LeaseID {
+				t.Errorf("Expected a lease per delivery, got %s twice", first.LeaseID)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_Nack(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+	{
+		tc := "Case 1: Task Without Lease Is Published Again"
+		task := model.MailTaskQueue{UserID: 1, TryCount: 1}
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+		expectPublish(mockClient, taskJson).SetVal(int64(1))
+		err := taskQueue.Nack(context.Background(), task)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Consumed Task Replaced In User Sub-Queue"
+		ctx := context.Background()
+		consumedJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		retriedTask := model.MailTaskQueue{UserID: 1, TryCount: 1}
+		retriedJson, _ := json.Marshal(taskqueue.NewEnvelope(retriedTask))
+		expectDispatch(mockClient).SetVal([]interface{}{string(consumedJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight"},
+			"testQueue", string(consumedJson), "1", retriedJson).SetVal(int64(1))
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		retriedTask.LeaseID = (<-taskCh).LeaseID
+		err := taskQueue.Nack(ctx, retriedTask)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_ReapExpiredLeases(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(make(chan model.MailTaskQueue)),
+	)
+	{
+		tc := "Case 1: Redis HGETALL Error And Return Error"
+		mockClient.ExpectHGetAll("testQueue:consumers").SetErr(errors.New("error"))
+		_, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Expired Processing List Returned To Queue"
+		keys := []string{"testQueue:consumers", "testQueue:processing:old:1", "testQueue:heartbeat:old", "testQueue:inflight"}
+		mockClient.ExpectHGetAll("testQueue:consumers").SetVal(map[string]string{
+			"testQueue:processing:old:1": "testQueue:heartbeat:old",
+		})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", keys, "testQueue").SetVal(int64(2))
+		n, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if n != 2 {
+				t.Errorf("Expected 2 reaped tasks, got %d", n)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_Close(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+	processingKeys := []string{"testQueue:processing:test:1", "testQueue:inflight"}
+	{
+		tc := "Case 1: Task Not Handed To Worker Before Consumer Stops Returned To Queue"
+		ctx, cancel := context.WithCancel(context.Background())
+		consumedJson, _ := json.Marshal(model.MailTaskQueue{Model: gorm.Model{ID: 1}, UserID: 1})
+		// The consumer is stopped while it waits for a worker to take the task.
+		keys := []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:user_caps", "testQueue:high", "testQueue", "testQueue:bulk"}
+		mockClient.CustomMatch(func(expected, actual []interface{}) error {
+			cancel()
+			return scriptArgs(expected, actual)
+		}).ExpectEvalSha("sha", keys, 0).SetVal([]interface{}{string(consumedJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", processingKeys,
+			"testQueue", string(consumedJson), "1", string(consumedJson)).SetVal(int64(1))
+		mockClient.ExpectDel("testQueue:heartbeat:test").SetVal(1)
+		errSubscribe := taskQueue.SubscribeTask(ctx, 1)
+		err := taskQueue.Close(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if errSubscribe == nil || !strings.Contains(errSubscribe.Error(), "consumer 1 done") {
+				t.Errorf("Expected consumer to be done, got %v", errSubscribe)
+			}
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Redis Error Returned After Every Lease Is Released"
+		ctx := context.Background()
+		consumedJson, _ := json.Marshal(model.MailTaskQueue{Model: gorm.Model{ID: 2}, UserID: 1})
+		expectDispatch(mockClient).SetVal([]interface{}{string(consumedJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", processingKeys,
+			"testQueue", string(consumedJson), "1", string(consumedJson)).SetErr(errors.New("error"))
+		mockClient.ExpectDel("testQueue:heartbeat:test").SetVal(1)
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		<-taskCh
+		wg.Wait()
+		err := taskQueue.Close(ctx)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
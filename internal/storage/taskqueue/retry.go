@@ -0,0 +1,53 @@
+package taskqueue
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"time"
+)
+
+// retryScript removes a consumed task from its processing list, releases its
+// user and adds the given task to the scheduled set.
+// KEYS[1] = processing list, KEYS[2] = in-flight hash, KEYS[3] = scheduled set;
+// ARGV[1] = consumed payload, ARGV[2] = user, ARGV[3] = send time in ms, ARGV[4] = new payload.
+var retryScript = redis.NewScript(enqueueScript + `
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+release(KEYS[2], ARGV[2])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[4])
+return 1
+`)
+
+// Retry atomically moves a consumed task that failed from its processing list
+// to the scheduled set, so it is queued again at the given time.
+func (r *taskQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.ScheduleTask(ctx, task, at)
+	}
+	taskJson, err := encode(task)
+	if err != nil {
+		return err
+	}
+	keys := []string{l.processingKey, r.inflightKey(), r.scheduledKey()}
+	return retryScript.Run(ctx, r.rdb, keys, l.payload, l.user, at.UnixMilli(), taskJson).Err()
+}
+
+// Retry atomically acknowledges the pending entry of a consumed task that
+// failed and adds it to the scheduled set, so it is queued again at the given time.
+func (r *streamQueue) Retry(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.ScheduleTask(ctx, task, at)
+	}
+	taskJson, err := encode(task)
+	if err != nil {
+		return err
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, l.stream, r.groupName, l.messageID)
+		pipe.ZAdd(ctx, r.scheduledKey(), redis.Z{Score: float64(at.UnixMilli()), Member: string(taskJson)})
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,112 @@
+package taskqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_taskQueue_Retry(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := taskqueue.New(
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+	at := time.Now().Add(time.Minute)
+	retriedTask := model.MailTaskQueue{UserID: 1, TryCount: 1}
+	retriedJson, _ := json.Marshal(taskqueue.NewEnvelope(retriedTask))
+	{
+		tc := "Case 1: Task Without Lease Added To Scheduled Set"
+		mockClient.ExpectZAdd("testQueue:scheduled", redis.Z{Score: float64(at.UnixMilli()), Member: string(retriedJson)}).
+			SetVal(1)
+		err := taskQueue.Retry(context.Background(), retriedTask, at)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Consumed Task Moved From Processing List To Scheduled Set"
+		ctx := context.Background()
+		consumedJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectDispatch(mockClient).SetVal([]interface{}{string(consumedJson), "1"})
+		mockClient.CustomMatch(scriptArgs).ExpectEvalSha("sha", []string{"testQueue:processing:test:1", "testQueue:inflight", "testQueue:scheduled"},
+			string(consumedJson), "1", at.UnixMilli(), retriedJson).SetVal(int64(1))
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		retriedTask.LeaseID = (<-taskCh).LeaseID
+		err := taskQueue.Retry(ctx, retriedTask, at)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_Retry(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	at := time.Now().Add(time.Minute)
+	{
+		tc := "Case 1: Consumed Task Acked And Added To Scheduled Set"
+		ctx := context.Background()
+		retriedTask := model.MailTaskQueue{UserID: 1, TryCount: 1}
+		retriedJson, _ := json.Marshal(taskqueue.NewEnvelope(retriedTask))
+		taskJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": string(taskJson)}}},
+		}})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		mockClient.ExpectZAdd("testQueue:scheduled", redis.Z{Score: float64(at.UnixMilli()), Member: string(retriedJson)}).SetVal(1)
+		mockClient.ExpectTxPipelineExec()
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		retriedTask.LeaseID = (<-taskCh).LeaseID
+		err := taskQueue.Retry(ctx, retriedTask, at)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
@@ -0,0 +1,83 @@
+package taskqueue
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"strconv"
+	"time"
+)
+
+// promoteListScript moves due tasks from the scheduled set to the sub-queues of their users.
+// KEYS[1] = scheduled set; ARGV[1] = now in ms, ARGV[2] = batch size, ARGV[3] = queue name.
+var promoteListScript = redis.NewScript(enqueueScript + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, payload in ipairs(due) do
+	redis.call('ZREM', KEYS[1], payload)
+	enqueue(ARGV[3], payload, 'LPUSH')
+end
+return #due
+`)
+
+// promoteStreamScript moves due tasks from the scheduled set to the lane streams of their priority.
+// KEYS[1] = scheduled set, KEYS[2..4] = lane streams; ARGV[1] = now in ms, ARGV[2] = batch size.
+var promoteStreamScript = redis.NewScript(laneScript + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, payload in ipairs(due) do
+	redis.call('ZREM', KEYS[1], payload)
+	redis.call('XADD', lane(payload, 2), '*', 'task', payload)
+end
+return #due
+`)
+
+func (r *taskQueue) scheduledKey() string {
+	return r.queueName + ":scheduled"
+}
+
+// ScheduleTask adds the task to the scheduled set, scored by its send time in
+// milliseconds. The task is moved to the queue by PromoteDueTasks once it is due.
+func (r *taskQueue) ScheduleTask(ctx context.Context, task model.MailTaskQueue, at time.Time) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		taskJson, err := encode(task)
+		if err != nil {
+			return err
+		}
+		return r.rdb.ZAdd(ctx, r.scheduledKey(), redis.Z{
+			Score:  float64(at.UnixMilli()),
+			Member: string(taskJson),
+		}).Err()
+	}
+}
+
+// promote runs the given promote script until there are no due tasks left and
+// reports how many tasks were moved to the queue.
+func (r *taskQueue) promote(ctx context.Context, script *redis.Script, laneKeys []string, args ...interface{}) (int, error) {
+	total := 0
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	keys := append([]string{r.scheduledKey()}, laneKeys...)
+	args = append([]interface{}{now, constant.QueueReapBatchSize}, args...)
+	for {
+		n, err := script.Run(ctx, r.rdb, keys, args...).Int()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < constant.QueueReapBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// PromoteDueTasks moves the scheduled tasks that are due to the sub-queues of their users.
+func (r *taskQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	return r.promote(ctx, promoteListScript, nil, r.queueName)
+}
+
+// PromoteDueTasks moves the scheduled tasks that are due to the lane streams.
+func (r *streamQueue) PromoteDueTasks(ctx context.Context) (int, error) {
+	return r.promote(ctx, promoteStreamScript, r.streamKeys())
+}
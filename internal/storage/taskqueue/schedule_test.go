@@ -0,0 +1,106 @@
+package taskqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"testing"
+	"time"
+)
+
+func Test_taskQueue_ScheduleTask(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := taskqueue.New(
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithRedisClient(rdb),
+	)
+	at := time.Now().Add(time.Hour)
+	task := model.MailTaskQueue{UserID: 1, ScheduledAt: at}
+	taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+	{
+		tc := "Case 1: Context Cancelled And Return Error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := taskQueue.ScheduleTask(ctx, task, at)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected error to be context.Canceled, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Redis ZADD Error And Return Error"
+		mockClient.ExpectZAdd("testQueue:scheduled", redis.Z{Score: float64(at.UnixMilli()), Member: string(taskJson)}).
+			SetErr(errors.New("error"))
+		err := taskQueue.ScheduleTask(context.Background(), task, at)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Task Added To Scheduled Set Scored By Send Time"
+		mockClient.ExpectZAdd("testQueue:scheduled", redis.Z{Score: float64(at.UnixMilli()), Member: string(taskJson)}).
+			SetVal(1)
+		err := taskQueue.ScheduleTask(context.Background(), task, at)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_taskQueue_PromoteDueTasks(t *testing.T) {
+	anyArgs := func(expected, actual []interface{}) error {
+		return nil
+	}
+	for _, backend := range []string{constant.QueueBackendList, constant.QueueBackendStream} {
+		rdb, mockClient := redismock.NewClientMock()
+		taskQueue := taskqueue.New(
+			taskqueue.WithBackend(backend),
+			taskqueue.WithQueueName("testQueue"),
+			taskqueue.WithRedisClient(rdb),
+		)
+		keys := []string{"testQueue:scheduled"}
+		args := []interface{}{"now", constant.QueueReapBatchSize, "testQueue"}
+		if backend == constant.QueueBackendStream {
+			keys = []string{"testQueue:scheduled", "testQueue:stream", "testQueue:high:stream", "testQueue:bulk:stream"}
+			args = []interface{}{"now", constant.QueueReapBatchSize}
+		}
+		{
+			tc := "Case 1: " + backend + " Backend Script Error And Return Error"
+			mockClient.CustomMatch(anyArgs).ExpectEvalSha("sha", keys, args...).SetErr(errors.New("error"))
+			_, err := taskQueue.PromoteDueTasks(context.Background())
+			t.Run(tc, func(t *testing.T) {
+				if err == nil {
+					t.Errorf("Expected error, got nil")
+				}
+			})
+			mockClient.ClearExpect()
+		}
+		{
+			tc := "Case 2: " + backend + " Backend Due Tasks Promoted In Batches"
+			mockClient.CustomMatch(anyArgs).ExpectEvalSha("sha", keys, args...).SetVal(int64(constant.QueueReapBatchSize))
+			mockClient.CustomMatch(anyArgs).ExpectEvalSha("sha", keys, args...).SetVal(int64(2))
+			n, err := taskQueue.PromoteDueTasks(context.Background())
+			t.Run(tc, func(t *testing.T) {
+				if err != nil {
+					t.Errorf("Expected nil, got %v", err)
+				}
+				if n != constant.QueueReapBatchSize+2 {
+					t.Errorf("Expected %d promoted tasks, got %d", constant.QueueReapBatchSize+2, n)
+				}
+			})
+			mockClient.ClearExpect()
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package taskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamQueue is a TaskQueue backed by a redis stream per priority lane and a consumer group.
+// Consumed messages stay in the pending entries list of the group until they
+// are acked, messages that are idle longer than the lease timeout are claimed
+// by the reaper of another consumer.
+type streamQueue struct {
+	*taskQueue
+}
+
+func (r *streamQueue) streamKey(priority int) string {
+	return r.laneKey(priority) + ":stream"
+}
+
+// streamKeys returns the streams of all priorities ordered by priority value, as laneScript expects them.
+func (r *streamQueue) streamKeys() []string {
+	return []string{
+		r.streamKey(constant.PriorityNormal),
+		r.streamKey(constant.PriorityHigh),
+		r.streamKey(constant.PriorityBulk),
+	}
+}
+
+func (r *streamQueue) consumerKey(consumerID int) string {
+	return fmt.Sprintf("%s-%d", r.consumerName, consumerID)
+}
+
+func (r *streamQueue) addArgs(priority int, taskJson []byte) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: r.streamKey(priority),
+		Values: []interface{}{"task", string(taskJson)},
+	}
+}
+
+func (r *streamQueue) PublishTask(ctx context.Context, task interface{}) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		taskJson, err := encode(task)
+		if err != nil {
+			return err
+		}
+		args := r.addArgs(priorityOf(task), taskJson)
+		if err := r.rdb.XAdd(ctx, args).Err(); err != nil {
+			return err
+		}
+		log.Infof("publishing task to stream: %s", args.Stream)
+		return nil
+	}
+}
+
+// PublishTasks pipelines an XADD to the lane stream of every task.
+func (r *streamQueue) PublishTasks(ctx context.Context, tasks []model.MailTaskQueue) error {
+	_, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, task := range tasks {
+			taskJson, err := encode(task)
+			if err != nil {
+				return err
+			}
+			pipe.XAdd(ctx, r.addArgs(task.Priority, taskJson))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("publishing %d tasks to streams: %s", len(tasks), r.queueName)
+	return nil
+}
+
+// deliver decodes a stream message, leases it and sends it to the internal channel.
+// Messages that can not be decoded are moved to the dead-letter queue.
+func (r *streamQueue) deliver(ctx context.Context, consumerID int, stream string, msg redis.XMessage) error {
+	payload, _ := msg.Values["task"].(string)
+	task, err := decode(payload)
+	if err != nil {
+		log.Errorf("consumer %d error unmarshalling task: %v", consumerID, err)
+		return r.deadLetterMessage(ctx, stream, msg.ID, poison(payload, "", err))
+	}
+	task.LeaseID = r.addLease(lease{stream: stream, messageID: msg.ID, payload: payload})
+	log.Infof("consumer %d received task id: %d", consumerID, task.ID)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.taskChannel <- task:
+	}
+	log.Infof("consumer %d sent task to internal channel", consumerID)
+	return nil
+}
+
+// read returns the next message of the n-th poll. The lanes are read in
+// weighted order without blocking, when all of them are empty it blocks on all
+// lanes until a message arrives or it times out.
+func (r *streamQueue) read(ctx context.Context, consumerID int, n int) ([]redis.XStream, error) {
+	for _, priority := range laneOrder(n) {
+		streams, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.groupName,
+			Consumer: r.consumerKey(consumerID),
+			Streams:  []string{r.streamKey(priority), ">"},
+			Count:    1,
+			Block:    -1,
+		}).Result()
+		if err == nil && len(streams) > 0 {
+			return streams, nil
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+	}
+	streams := make([]string, 0, len(lanePriorities)*2)
+	for _, priority := range lanePriorities {
+		streams = append(streams, r.streamKey(priority))
+	}
+	for range lanePriorities {
+		streams = append(streams, ">")
+	}
+	return r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    r.groupName,
+		Consumer: r.consumerKey(consumerID),
+		Streams:  streams,
+		Count:    1,
+		Block:    1 * time.Second,
+	}).Result()
+}
+
+func (r *streamQueue) SubscribeTask(ctx context.Context, consumerID int) error {
+	log.Infof("consumer %d subscribed to stream: %s", consumerID, r.streamKey(constant.PriorityNormal))
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("consumer %d done: %v", consumerID, ctx.Err())
+		default:
+			streams, err := r.read(ctx, consumerID, n)
+			if err != nil {
+				if errors.Is(err, redis.Nil) {
+					continue
+				}
+				return err
+			}
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					if err := r.deliver(ctx, consumerID, stream.Stream, msg); err != nil {
+						log.Errorf("consumer %d error delivering message %s: %v", consumerID, msg.ID, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Ack acknowledges the pending entry of a consumed task. The message itself
+// stays in the stream as history until it is trimmed.
+func (r *streamQueue) Ack(ctx context.Context, task model.MailTaskQueue) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return nil
+	}
+	return r.rdb.XAck(ctx, l.stream, r.groupName, l.messageID).Err()
+}
+
+// Nack atomically acknowledges the pending entry of a consumed task and adds
+// the given task to the stream of its priority, so it is delivered again.
+func (r *streamQueue) Nack(ctx context.Context, task model.MailTaskQueue) error {
+	l, ok := r.takeLease(task)
+	if !ok {
+		return r.PublishTask(ctx, task)
+	}
+	taskJson, err := encode(task)
+	if err != nil {
+		return err
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, l.stream, r.groupName, l.messageID)
+		pipe.XAdd(ctx, r.addArgs(task.Priority, taskJson))
+		return nil
+	})
+	return err
+}
+
+// ReapExpiredLeases claims the pending entries that have been idle longer than
+// the lease timeout, which are left by crashed consumers, and delivers them to
+// the internal channel of this pod. Lanes are claimed in priority order, then
+// the acknowledged history of every lane is trimmed.
+func (r *streamQueue) ReapExpiredLeases(ctx context.Context) (int, error) {
+	total := 0
+	for _, priority := range lanePriorities {
+		n, err := r.claim(ctx, r.streamKey(priority))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	for _, priority := range lanePriorities {
+		if err := r.trim(ctx, r.streamKey(priority)); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// trim deletes the entries of a stream that the group has delivered and
+// acknowledged. Everything from the oldest pending entry of the group, or after
+// its last delivered entry, is kept, so mail that is not consumed is never lost.
+func (r *streamQueue) trim(ctx context.Context, stream string) error {
+	groups, err := r.rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return err
+	}
+	minID := ""
+	for _, group := range groups {
+		if group.Name == r.groupName {
+			minID = group.LastDeliveredID
+		}
+	}
+	if minID == "" || minID == "0-0" {
+		return nil
+	}
+	pending, err := r.rdb.XPending(ctx, stream, r.groupName).Result()
+	if err != nil {
+		return err
+	}
+	if pending.Count > 0 && streamIDLess(pending.Lower, minID) {
+		minID = pending.Lower
+	}
+	return r.rdb.XTrimMinIDApprox(ctx, stream, minID, 0).Err()
+}
+
+// streamIDLess reports whether the stream entry ID a is lower than b.
+func streamIDLess(a, b string) bool {
+	aMs, aSeq := splitStreamID(a)
+	bMs, bSeq := splitStreamID(b)
+	if aMs != bMs {
+		return aMs < bMs
+	}
+	return aSeq < bSeq
+}
+
+// splitStreamID splits a stream entry ID into its time and sequence parts.
+func splitStreamID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	msPart, _ := strconv.ParseUint(ms, 10, 64)
+	seqPart, _ := strconv.ParseUint(seq, 10, 64)
+	return msPart, seqPart
+}
+
+// claim claims and delivers the expired pending entries of a stream.
+func (r *streamQueue) claim(ctx context.Context, stream string) (int, error) {
+	total := 0
+	start := "0-0"
+	for {
+		msgs, next, err := r.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    r.groupName,
+			Consumer: r.consumerKey(1),
+			MinIdle:  r.leaseTimeout,
+			Start:    start,
+			Count:    constant.QueueReapBatchSize,
+		}).Result()
+		if err != nil {
+			return total, err
+		}
+		for _, msg := range msgs {
+			if err := r.deliver(ctx, 1, stream, msg); err != nil {
+				return total, err
+			}
+			total++
+		}
+		if next == "0-0" || len(msgs) == 0 {
+			return total, nil
+		}
+		start = next
+	}
+}
+
+// renewLeases resets the idle time of the pending entries consumed by this
+// pod, so they are not claimed by other consumers while they are processed.
+func (r *streamQueue) renewLeases(ctx context.Context) error {
+	r.mu.Lock()
+	ids := make(map[string][]string)
+	for _, l := range r.leases {
+		ids[l.stream] = append(ids[l.stream], l.messageID)
+	}
+	r.mu.Unlock()
+	for stream, messages := range ids {
+		err := r.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    r.groupName,
+			Consumer: r.consumerKey(1),
+			Messages: messages,
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heartbeat keeps the leases of this pod alive until the context is done.
+func (r *streamQueue) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(r.leaseTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.renewLeases(ctx); err != nil {
+				log.Errorf("error renewing stream leases: %v", err)
+			}
+		}
+	}
+}
+
+// reap periodically claims expired leases and trims the acknowledged history
+// of the lanes until the context is done.
+func (r *streamQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(r.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := r.ReapExpiredLeases(ctx)
+			if err != nil {
+				log.Errorf("error reaping expired leases: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Infof("%d tasks claimed from expired leases of stream %s", n, r.queueName)
+			}
+		}
+	}
+}
+
+// createGroup creates the consumer group and the streams of all lanes if they do not exist.
+func (r *streamQueue) createGroup(ctx context.Context) error {
+	for _, priority := range lanePriorities {
+		err := r.rdb.XGroupCreateMkStream(ctx, r.streamKey(priority), r.groupName, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *streamQueue) StartConsume(ctx context.Context) <-chan error {
+	errCh := make(chan error, r.consumerCount)
+	wg := sync.WaitGroup{}
+	if err := r.createGroup(ctx); err != nil {
+		log.Errorf("error creating consumer group: %v", err)
+	}
+	go r.heartbeat(r.leaseContext(ctx))
+	// The reaper delivers claimed tasks to the workers, so it stops with the consumers.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.reap(ctx)
+	}()
+	for i := 0; i < r.consumerCount; i++ {
+		wg.Add(1)
+		go func(consumerID int) {
+			defer wg.Done()
+			if err := r.SubscribeTask(ctx, consumerID+1); err != nil {
+				log.Errorf("error consuming task: %v", err)
+				errCh <- err
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+	return errCh
+}
+
+// Close stops renewing the pending entries of the pod and returns the tasks it
+// still leases to their stream, acknowledging their pending entries.
+func (r *streamQueue) Close(ctx context.Context) error {
+	var errs []error
+	for _, l := range r.takeLeases() {
+		_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAck(ctx, l.stream, r.groupName, l.messageID)
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: l.stream,
+				Values: []interface{}{"task", l.payload},
+			})
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,434 @@
+package taskqueue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskqueue"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStreamQueue(rdb *redis.Client, taskCh chan model.MailTaskQueue) taskqueue.TaskQueue {
+	return taskqueue.New(
+		taskqueue.WithBackend(constant.QueueBackendStream),
+		taskqueue.WithConsumerCount(1),
+		taskqueue.WithQueueName("testQueue"),
+		taskqueue.WithGroupName("testGroup"),
+		taskqueue.WithConsumerName("test"),
+		taskqueue.WithRedisClient(rdb),
+		taskqueue.WithTaskChannel(taskCh),
+	)
+}
+
+func xAddArgs(taskJson []byte) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: "testQueue:stream",
+		Values: []interface{}{"task", string(taskJson)},
+	}
+}
+
+func xReadGroupArgs() *redis.XReadGroupArgs {
+	return &redis.XReadGroupArgs{
+		Group:    "testGroup",
+		Consumer: "test-1",
+		Streams:  []string{"testQueue:high:stream", "testQueue:stream", "testQueue:bulk:stream", ">", ">", ">"},
+		Count:    1,
+		Block:    time.Second,
+	}
+}
+
+// expectEmptyStreams expects the first poll of consumer 1 to find all lane streams empty.
+func expectEmptyStreams(mockClient redismock.ClientMock) {
+	for _, stream := range []string{"testQueue:high:stream", "testQueue:stream", "testQueue:bulk:stream"} {
+		mockClient.ExpectXReadGroup(&redis.XReadGroupArgs{
+			Group:    "testGroup",
+			Consumer: "test-1",
+			Streams:  []string{stream, ">"},
+			Count:    1,
+			Block:    -1,
+		}).RedisNil()
+	}
+}
+
+func Test_streamQueue_PublishTask(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := newStreamQueue(rdb, make(chan model.MailTaskQueue))
+	{
+		tc := "Case 1: Context Cancelled And Return Error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := taskQueue.PublishTask(ctx, model.MailTaskQueue{})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected error to be context.Canceled, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Redis XADD Error And Return Error"
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(model.MailTaskQueue{UserID: 1}))
+		mockClient.ExpectXAdd(xAddArgs(taskJson)).SetErr(errors.New("error"))
+		err := taskQueue.PublishTask(context.Background(), model.MailTaskQueue{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Valid Task Model Added To Stream And Return Nil"
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(model.MailTaskQueue{UserID: 1}))
+		mockClient.ExpectXAdd(xAddArgs(taskJson)).SetVal("1-0")
+		err := taskQueue.PublishTask(context.Background(), model.MailTaskQueue{UserID: 1})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: High Priority Task Added To High Lane Stream"
+		task := model.MailTaskQueue{UserID: 1, Priority: constant.PriorityHigh}
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(task))
+		args := xAddArgs(taskJson)
+		args.Stream = "testQueue:high:stream"
+		mockClient.ExpectXAdd(args).SetVal("1-0")
+		err := taskQueue.PublishTask(context.Background(), task)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_PublishTasks(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskQueue := newStreamQueue(rdb, make(chan model.MailTaskQueue))
+	tasks := []model.MailTaskQueue{{UserID: 1}, {UserID: 2, Priority: constant.PriorityBulk}}
+	{
+		tc := "Case 1: Tasks Added To Their Lane Streams In One Pipeline"
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(tasks[0]))
+		mockClient.ExpectXAdd(xAddArgs(taskJson)).SetVal("1-0")
+		taskJson, _ = json.Marshal(taskqueue.NewEnvelope(tasks[1]))
+		args := xAddArgs(taskJson)
+		args.Stream = "testQueue:bulk:stream"
+		mockClient.ExpectXAdd(args).SetVal("1-1")
+		err := taskQueue.PublishTasks(context.Background(), tasks)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Redis XADD Error And Return Error"
+		taskJson, _ := json.Marshal(taskqueue.NewEnvelope(tasks[0]))
+		mockClient.ExpectXAdd(xAddArgs(taskJson)).SetErr(errors.New("error"))
+		err := taskQueue.PublishTasks(context.Background(), tasks[:1])
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_SubscribeTask(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	{
+		tc := "Case 1: Context Cancelled And Return Error"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := taskQueue.SubscribeTask(ctx, 1)
+		want := "consumer 1 done: context canceled"
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected error %q, got %v", want, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Redis XREADGROUP Error And Return Error"
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetErr(errors.New("error"))
+		err := taskQueue.SubscribeTask(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Invalid Payload Is Acked And Dead-Lettered"
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": "invalid json"}}},
+		}})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		mockClient.CustomMatch(ignoreArgs(-1)).ExpectLPush("testQueue:dlq:0", "entry").SetVal(1)
+		mockClient.ExpectTxPipelineExec()
+		_ = taskQueue.SubscribeTask(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Valid Task Model Send Task To Channel"
+		taskJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": string(taskJson)}}},
+		}})
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(context.Background(), 1)
+		}()
+		t.Run(tc, func(t *testing.T) {
+			if task := <-taskCh; task.UserID != 1 {
+				t.Errorf("Expected task with user id 1, got %v", task)
+			}
+		})
+		wg.Wait()
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 5: Envelope Of Newer Version Is Dead-Lettered To Its User"
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": `{"v":99,"task_id":7,"user_id":3}`}}},
+		}})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		mockClient.CustomMatch(ignoreArgs(-1)).ExpectLPush("testQueue:dlq:3", "entry").SetVal(1)
+		mockClient.ExpectTxPipelineExec()
+		_ = taskQueue.SubscribeTask(context.Background(), 1)
+		t.Run(tc, func(t *testing.T) {
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_AckAndNack(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	consume := func(id string, task model.MailTaskQueue) {
+		taskJson, _ := json.Marshal(task)
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: id, Values: map[string]interface{}{"task": string(taskJson)}}},
+		}})
+	}
+	{
+		tc := "Case 1: Consumed Task Acked"
+		ctx := context.Background()
+		consume("1-0", model.MailTaskQueue{UserID: 1})
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		err := taskQueue.Ack(ctx, <-taskCh)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Consumed Task Acked And Added To Stream Again"
+		ctx := context.Background()
+		retriedTask := model.MailTaskQueue{UserID: 1, TryCount: 1}
+		retriedJson, _ := json.Marshal(taskqueue.NewEnvelope(retriedTask))
+		consume("2-0", model.MailTaskQueue{UserID: 1})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "2-0").SetVal(1)
+		mockClient.ExpectXAdd(xAddArgs(retriedJson)).SetVal("3-0")
+		mockClient.ExpectTxPipelineExec()
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		retriedTask.LeaseID = (<-taskCh).LeaseID
+		err := taskQueue.Nack(ctx, retriedTask)
+		wg.Wait()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_ReapExpiredLeases(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue, 1)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	args := func(stream string) *redis.XAutoClaimArgs {
+		return &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    "testGroup",
+			Consumer: "test-1",
+			MinIdle:  constant.QueueLeaseTimeout,
+			Start:    "0-0",
+			Count:    constant.QueueReapBatchSize,
+		}
+	}
+	{
+		tc := "Case 1: Redis XAUTOCLAIM Error And Return Error"
+		mockClient.ExpectXAutoClaim(args("testQueue:high:stream")).SetErr(errors.New("error"))
+		_, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Claimed Tasks Of All Lanes Sent To Channel"
+		taskJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		mockClient.ExpectXAutoClaim(args("testQueue:high:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:stream")).SetVal([]redis.XMessage{
+			{ID: "1-0", Values: map[string]interface{}{"task": string(taskJson)}},
+		}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:bulk:stream")).SetVal([]redis.XMessage{}, "0-0")
+		for _, stream := range []string{"testQueue:high:stream", "testQueue:stream", "testQueue:bulk:stream"} {
+			mockClient.ExpectXInfoGroups(stream).SetVal([]redis.XInfoGroup{{Name: "testGroup", LastDeliveredID: "0-0"}})
+		}
+		n, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if n != 1 {
+				t.Errorf("Expected 1 claimed task, got %d", n)
+			}
+			if task := <-taskCh; task.UserID != 1 {
+				t.Errorf("Expected task with user id 1, got %v", task)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Lanes Trimmed Below Oldest Pending Entry Or After Last Delivered Entry"
+		mockClient.ExpectXAutoClaim(args("testQueue:high:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:bulk:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXInfoGroups("testQueue:high:stream").SetVal([]redis.XInfoGroup{{Name: "testGroup", LastDeliveredID: "12-0"}})
+		mockClient.ExpectXPending("testQueue:high:stream", "testGroup").SetVal(&redis.XPending{Count: 2, Lower: "9-1", Higher: "12-0"})
+		mockClient.ExpectXTrimMinIDApprox("testQueue:high:stream", "9-1", 0).SetVal(3)
+		mockClient.ExpectXInfoGroups("testQueue:stream").SetVal([]redis.XInfoGroup{{Name: "testGroup", LastDeliveredID: "20-0"}})
+		mockClient.ExpectXPending("testQueue:stream", "testGroup").SetVal(&redis.XPending{})
+		mockClient.ExpectXTrimMinIDApprox("testQueue:stream", "20-0", 0).SetVal(5)
+		mockClient.ExpectXInfoGroups("testQueue:bulk:stream").SetVal([]redis.XInfoGroup{{Name: "testGroup", LastDeliveredID: "0-0"}})
+		_, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Redis XPENDING Error And Return Error"
+		mockClient.ExpectXAutoClaim(args("testQueue:high:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXAutoClaim(args("testQueue:bulk:stream")).SetVal([]redis.XMessage{}, "0-0")
+		mockClient.ExpectXInfoGroups("testQueue:high:stream").SetVal([]redis.XInfoGroup{{Name: "testGroup", LastDeliveredID: "12-0"}})
+		mockClient.ExpectXPending("testQueue:high:stream", "testGroup").SetErr(errors.New("error"))
+		_, err := taskQueue.ReapExpiredLeases(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_streamQueue_Close(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	taskCh := make(chan model.MailTaskQueue)
+	taskQueue := newStreamQueue(rdb, taskCh)
+	{
+		tc := "Case 1: Task Not Finished By Worker Acked And Added To Stream Again"
+		ctx := context.Background()
+		taskJson, _ := json.Marshal(model.MailTaskQueue{UserID: 1})
+		expectEmptyStreams(mockClient)
+		mockClient.ExpectXReadGroup(xReadGroupArgs()).SetVal([]redis.XStream{{
+			Stream:   "testQueue:stream",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"task": string(taskJson)}}},
+		}})
+		mockClient.ExpectTxPipeline()
+		mockClient.ExpectXAck("testQueue:stream", "testGroup", "1-0").SetVal(1)
+		mockClient.ExpectXAdd(xAddArgs(taskJson)).SetVal("2-0")
+		mockClient.ExpectTxPipelineExec()
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = taskQueue.SubscribeTask(ctx, 1)
+		}()
+		<-taskCh
+		wg.Wait()
+		err := taskQueue.Close(ctx)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"gorm.io/gorm"
+	"time"
 )
 
 // TaskStorer is an interface for storing mail tasks
@@ -11,10 +12,14 @@ type TaskStorer interface {
 	Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error)
 	GetByID(ctx context.Context, id uint) (model.MailTaskQueue, error)
 	GetAll(ctx context.Context, userID uint) ([]model.MailTaskQueue, error)
-	GetAllByUnprocessedTasks(ctx context.Context) ([]model.MailTaskQueue, error)
+	GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error)
 	GetAllByStatusWithUserID(ctx context.Context, state int, userID uint) ([]model.MailTaskQueue, error)
 	Update(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) error
 	Delete(ctx context.Context, id uint) error
+	InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error)
+	CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error)
+	CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error)
+	QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error)
 }
 
 // taskStorage is a storage for mail tasks
@@ -5,6 +5,7 @@ import (
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
 	"gorm.io/gorm"
+	"time"
 )
 
 func (s *taskStorage) Insert(ctx context.Context, task model.MailTaskQueue, tx ...*gorm.DB) (model.MailTaskQueue, error) {
@@ -34,9 +35,24 @@ func (s *taskStorage) GetAll(ctx context.Context, userID uint) ([]model.MailTask
 	return tasks, nil
 }
 
-func (s *taskStorage) GetAllByUnprocessedTasks(ctx context.Context) ([]model.MailTaskQueue, error) {
+func (s *taskStorage) GetAllByUnprocessedTasks(ctx context.Context, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
 	var tasks []model.MailTaskQueue
-	if err := s.db.Where("status = ? AND updated_at < NOW() - INTERVAL '5 minutes'", constant.StatusQueued).Find(&tasks).Error; err != nil {
+	db := s.db
+	if len(tx) > 0 {
+		db = tx[0]
+	}
+	// Tasks with a pending outbox entry are published by the outbox relay, and
+	// tasks whose entry was delivered lately may still be leased by a worker.
+	// A task whose entry was delivered before is published again, the queue
+	// may have lost it.
+	outboxed := s.db.Model(&model.TaskOutbox{}).Select("1").
+		Where("task_outboxes.task_id = mail_task_queues.id").
+		Where("task_outboxes.delivered_at IS NULL OR task_outboxes.delivered_at > NOW() - INTERVAL '5 minutes'")
+	if err := db.Where(s.db.Where("status = ? AND updated_at < NOW() - INTERVAL '5 minutes'", constant.StatusQueued).
+		Or("status = ? AND scheduled_at < NOW() - INTERVAL '5 minutes'", constant.StatusScheduled).
+		Or("status = ? AND next_attempt_at < NOW() - INTERVAL '5 minutes'", constant.StatusFailed)).
+		Where("NOT EXISTS (?)", outboxed).
+		Find(&tasks).Error; err != nil {
 		return tasks, err
 	}
 	return tasks, nil
@@ -67,3 +83,71 @@ func (s *taskStorage) Delete(ctx context.Context, id uint) error {
 	}
 	return nil
 }
+
+// InsertBatch inserts the tasks with multi-row inserts of CampaignBatchSize
+// tasks and returns them with their IDs.
+func (s *taskStorage) InsertBatch(ctx context.Context, tasks []model.MailTaskQueue, tx ...*gorm.DB) ([]model.MailTaskQueue, error) {
+	db := s.db
+	if len(tx) > 0 {
+		db = tx[0]
+	}
+	if err := db.CreateInBatches(&tasks, constant.CampaignBatchSize).Error; err != nil {
+		return tasks, err
+	}
+	return tasks, nil
+}
+
+// CountByCampaignID returns the number of tasks of a campaign by their status.
+func (s *taskStorage) CountByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (map[int]int, error) {
+	db := s.db
+	if len(tx) > 0 {
+		db = tx[0]
+	}
+	var rows []struct {
+		Status int
+		Count  int
+	}
+	if err := db.Model(&model.MailTaskQueue{}).Select("status, COUNT(*) AS count").
+		Where("campaign_id = ?", campaignID).Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// CancelByCampaignID cancels the tasks of a campaign that are queued or wait
+// for a retry and reports how many were cancelled. The tasks being sent are
+// not cancelled.
+func (s *taskStorage) CancelByCampaignID(ctx context.Context, campaignID uint, tx ...*gorm.DB) (int, error) {
+	db := s.db
+	if len(tx) > 0 {
+		db = tx[0]
+	}
+	result := db.Model(&model.MailTaskQueue{}).
+		Where("campaign_id = ? AND status IN ?", campaignID, []int{constant.StatusQueued, constant.StatusScheduled, constant.StatusFailed}).
+		Update("status", constant.StatusCancelled)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return int(result.RowsAffected), nil
+}
+
+// QueueScheduled sets the scheduled tasks due before the given time to queued
+// and reports how many were set. It is called once they are promoted to the
+// queue, so they are not published again as scheduled tasks.
+func (s *taskStorage) QueueScheduled(ctx context.Context, before time.Time, tx ...*gorm.DB) (int, error) {
+	db := s.db
+	if len(tx) > 0 {
+		db = tx[0]
+	}
+	result := db.Model(&model.MailTaskQueue{}).
+		Where("status = ? AND scheduled_at <= ?", constant.StatusScheduled, before).
+		Update("status", constant.StatusQueued)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return int(result.RowsAffected), nil
+}
@@ -5,9 +5,11 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/taskstorage"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 func Test_taskStorage_Insert(t *testing.T) {
@@ -141,8 +143,8 @@ func Test_taskStorage_GetAllByUnprocessedTasks(t *testing.T) {
 	db, _ := gorm.Open(dialector, &gorm.Config{})
 	{
 		tc := "Case 1: Valid Case And Success"
-		mock.ExpectQuery("SELECT * FROM \"mail_task_queues\" WHERE (status = $1 AND updated_at < NOW() - INTERVAL '5 minutes') AND \"mail_task_queues\".\"deleted_at\" IS NULL").
-			WithArgs(0).
+		mock.ExpectQuery("SELECT * FROM \"mail_task_queues\" WHERE ((status = $1 AND updated_at < NOW() - INTERVAL '5 minutes') OR (status = $2 AND scheduled_at < NOW() - INTERVAL '5 minutes') OR (status = $3 AND next_attempt_at < NOW() - INTERVAL '5 minutes')) AND NOT EXISTS (SELECT 1 FROM \"task_outboxes\" WHERE task_outboxes.task_id = mail_task_queues.id AND (task_outboxes.delivered_at IS NULL OR task_outboxes.delivered_at > NOW() - INTERVAL '5 minutes') AND \"task_outboxes\".\"deleted_at\" IS NULL) AND \"mail_task_queues\".\"deleted_at\" IS NULL").
+			WithArgs(0, 5, 3).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		storage := taskstorage.New(taskstorage.WithTaskDB(db))
 		_, err := storage.GetAllByUnprocessedTasks(context.Background())
@@ -154,8 +156,8 @@ func Test_taskStorage_GetAllByUnprocessedTasks(t *testing.T) {
 	}
 	{
 		tc := "Case 2: Wrong Status Value And Error"
-		mock.ExpectQuery("SELECT * FROM \"mail_task_queues\" WHERE (status = $1 AND updated_at < NOW() - INTERVAL '5 minutes') AND \"mail_task_queues\".\"deleted_at\" IS NULL").
-			WithArgs(1).
+		mock.ExpectQuery("SELECT * FROM \"mail_task_queues\" WHERE ((status = $1 AND updated_at < NOW() - INTERVAL '5 minutes') OR (status = $2 AND scheduled_at < NOW() - INTERVAL '5 minutes') OR (status = $3 AND next_attempt_at < NOW() - INTERVAL '5 minutes')) AND NOT EXISTS (SELECT 1 FROM \"task_outboxes\" WHERE task_outboxes.task_id = mail_task_queues.id AND (task_outboxes.delivered_at IS NULL OR task_outboxes.delivered_at > NOW() - INTERVAL '5 minutes') AND \"task_outboxes\".\"deleted_at\" IS NULL) AND \"mail_task_queues\".\"deleted_at\" IS NULL").
+			WithArgs(1, 5, 3).
 			WillReturnError(gorm.ErrInvalidData)
 		storage := taskstorage.New(taskstorage.WithTaskDB(db))
 		_, err := storage.GetAllByUnprocessedTasks(context.Background())
@@ -212,8 +214,8 @@ func Test_taskStorage_Update(t *testing.T) {
 	{
 		tc := "Case 1: Valid Case And Success"
 		mock.ExpectBegin()
-		mock.ExpectQuery("INSERT INTO \"mail_task_queues\" (\"created_at\",\"updated_at\",\"deleted_at\",\"user_id\",\"status\",\"try_count\",\"recipient_email\",\"subject\",\"body\",\"scheduled_at\") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING \"id\"").
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		mock.ExpectQuery("INSERT INTO \"mail_task_queues\" (\"created_at\",\"updated_at\",\"deleted_at\",\"user_id\",\"status\",\"try_count\",\"recipient_email\",\"recipients\",\"from_name\",\"reply_to\",\"subject\",\"body\",\"text_body\",\"html_body\",\"scheduled_at\",\"priority\",\"dead_lettered\",\"last_error\",\"failure_reason\",\"leased_by\",\"lease_expires_at\",\"next_attempt_at\",\"processing_by\",\"processing_started_at\",\"template_id\",\"template_version\",\"template_variables\",\"template_partials\",\"campaign_id\",\"max_attempts\",\"retry_base_delay\",\"retry_max_delay\") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32) RETURNING \"id\"").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		mock.ExpectCommit()
 		mock.ExpectClose()
@@ -231,8 +233,8 @@ func Test_taskStorage_Update(t *testing.T) {
 	{
 		tc := "Case 2: Invalid Case And Error"
 		mock.ExpectBegin()
-		mock.ExpectQuery("INSERT INTO \"mail_task_queues\" (\"created_at\",\"updated_at\",\"deleted_at\",\"user_id\",\"status\",\"try_count\",\"recipient_email\",\"subject\",\"body\",\"scheduled_at\") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING \"id\"").
-			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		mock.ExpectQuery("INSERT INTO \"mail_task_queues\" (\"created_at\",\"updated_at\",\"deleted_at\",\"user_id\",\"status\",\"try_count\",\"recipient_email\",\"recipients\",\"from_name\",\"reply_to\",\"subject\",\"body\",\"text_body\",\"html_body\",\"scheduled_at\",\"priority\",\"dead_lettered\",\"last_error\",\"failure_reason\",\"leased_by\",\"lease_expires_at\",\"next_attempt_at\",\"processing_by\",\"processing_started_at\",\"max_attempts\",\"retry_base_delay\",\"retry_max_delay\") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27) RETURNING \"id\"").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 			WillReturnError(gorm.ErrInvalidData)
 		mock.ExpectRollback()
 		mock.ExpectClose()
@@ -289,3 +291,117 @@ func Test_taskStorage_Delete(t *testing.T) {
 		})
 	}
 }
+
+func Test_taskStorage_InsertBatch(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Tasks inserted with one statement and returned with their IDs"
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO \"mail_task_queues\" .* VALUES (.+),(.+) RETURNING \"id\"").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(8))
+		mock.ExpectCommit()
+		storage := taskstorage.New(taskstorage.WithTaskDB(db))
+		tasks, err := storage.InsertBatch(context.Background(), []model.MailTaskQueue{{CampaignID: 1}, {CampaignID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: Expected err to be nil but got %v", tc, err)
+			}
+			if len(tasks) != 2 || tasks[0].ID != 7 || tasks[1].ID != 8 {
+				t.Errorf("%s: Expected tasks 7 and 8 but got %v", tc, tasks)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Invalid Case And Error"
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO \"mail_task_queues\"").
+			WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		storage := taskstorage.New(taskstorage.WithTaskDB(db))
+		_, err := storage.InsertBatch(context.Background(), []model.MailTaskQueue{{CampaignID: 1}})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("%s: Expected err to be not nil but got nil", tc)
+			}
+		})
+	}
+}
+
+func Test_taskStorage_CountByCampaignID(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Tasks of the campaign counted by status"
+		mock.ExpectQuery("SELECT status, COUNT(*) AS count FROM \"mail_task_queues\" WHERE campaign_id = $1 AND \"mail_task_queues\".\"deleted_at\" IS NULL GROUP BY \"status\"").
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow(constant.StatusQueued, 10).AddRow(constant.StatusSuccess, 490))
+		storage := taskstorage.New(taskstorage.WithTaskDB(db))
+		counts, err := storage.CountByCampaignID(context.Background(), 3)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("%s: Expected err to be nil but got %v", tc, err)
+			}
+			if counts[constant.StatusQueued] != 10 || counts[constant.StatusSuccess] != 490 {
+				t.Errorf("%s: Expected 10 queued and 490 sent but got %v", tc, counts)
+			}
+		})
+	}
+}
+
+func Test_taskStorage_CancelByCampaignID(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Queued tasks of the campaign and tasks waiting for a retry cancelled"
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE \"mail_task_queues\" SET \"status\"=$1,\"updated_at\"=$2 WHERE (campaign_id = $3 AND status IN ($4,$5,$6)) AND \"mail_task_queues\".\"deleted_at\" IS NULL").
+			WithArgs(constant.StatusCancelled, sqlmock.AnyArg(), 3, constant.StatusQueued, constant.StatusScheduled, constant.StatusFailed).
+			WillReturnResult(sqlmock.NewResult(0, 12))
+		mock.ExpectCommit()
+		storage := taskstorage.New(taskstorage.WithTaskDB(db))
+		n, err := storage.CancelByCampaignID(context.Background(), 3)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 12 {
+				t.Errorf("%s: Expected 12 cancelled tasks but got %d, %v", tc, n, err)
+			}
+		})
+	}
+}
+
+func Test_taskStorage_QueueScheduled(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Scheduled tasks due before the promotion set to queued"
+		before := time.Now()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE \"mail_task_queues\" SET \"status\"=$1,\"updated_at\"=$2 WHERE (status = $3 AND scheduled_at <= $4) AND \"mail_task_queues\".\"deleted_at\" IS NULL").
+			WithArgs(constant.StatusQueued, sqlmock.AnyArg(), constant.StatusScheduled, before).
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectCommit()
+		storage := taskstorage.New(taskstorage.WithTaskDB(db))
+		n, err := storage.QueueScheduled(context.Background(), before)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 4 {
+				t.Errorf("%s: Expected 4 queued tasks but got %d, %v", tc, n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package templatestorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+)
+
+// TemplateStorer is an interface for storing the mail templates of users and
+// their versions.
+type TemplateStorer interface {
+	Insert(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error)
+	Update(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error)
+	GetByID(ctx context.Context, userID, id uint) (model.MailTemplate, error)
+	GetByName(ctx context.Context, userID uint, name string) (model.MailTemplate, error)
+	GetAllByUserID(ctx context.Context, userID uint) ([]model.MailTemplate, error)
+	GetAllPartials(ctx context.Context, userID uint) ([]model.MailTemplate, error)
+	GetVersion(ctx context.Context, templateID uint, version int) (model.MailTemplateVersion, error)
+	GetAllVersions(ctx context.Context, templateID uint) ([]model.MailTemplateVersion, error)
+	GetPartialVersions(ctx context.Context, partials []model.MailTemplatePartial) ([]model.MailTemplateVersion, error)
+	Delete(ctx context.Context, userID, id uint) error
+}
+
+// templateStorage is a storage for the mail templates.
+type templateStorage struct {
+	db *gorm.DB
+}
+
+// Option is a type for template storage options.
+type Option func(*templateStorage)
+
+// WithTemplateDB sets the database for template storage.
+func WithTemplateDB(db *gorm.DB) Option {
+	return func(s *templateStorage) {
+		s.db = db
+	}
+}
+
+// New creates a new template storage instance.
+func New(opts ...Option) TemplateStorer {
+	storage := &templateStorage{}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
+}
@@ -0,0 +1,137 @@
+package templatestorage
+
+import (
+	"context"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Insert stores a template with its first version.
+func (s *templateStorage) Insert(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error) {
+	template.Version = 1
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&template).Error; err != nil {
+			return err
+		}
+		return tx.Create(versionOf(template)).Error
+	})
+	return template, err
+}
+
+// Update stores the content of a template as its next version. The template
+// is locked while the version is added, so concurrent updates get their own
+// versions. The name and partial flag of a template are not changed.
+func (s *templateStorage) Update(ctx context.Context, template model.MailTemplate) (model.MailTemplate, error) {
+	var current model.MailTemplate
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", template.ID, template.UserID).First(&current).Error; err != nil {
+			return err
+		}
+		current.Version++
+		current.Layout = template.Layout
+		current.Subject = template.Subject
+		current.HTMLBody = template.HTMLBody
+		current.TextBody = template.TextBody
+		if err := tx.Save(&current).Error; err != nil {
+			return err
+		}
+		return tx.Create(versionOf(current)).Error
+	})
+	return current, err
+}
+
+// versionOf returns the current version of a template.
+func versionOf(template model.MailTemplate) *model.MailTemplateVersion {
+	return &model.MailTemplateVersion{
+		TemplateID: template.ID,
+		Version:    template.Version,
+		Layout:     template.Layout,
+		Subject:    template.Subject,
+		HTMLBody:   template.HTMLBody,
+		TextBody:   template.TextBody,
+	}
+}
+
+func (s *templateStorage) GetByID(ctx context.Context, userID, id uint) (model.MailTemplate, error) {
+	var template model.MailTemplate
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&template).Error; err != nil {
+		return template, err
+	}
+	return template, nil
+}
+
+func (s *templateStorage) GetByName(ctx context.Context, userID uint, name string) (model.MailTemplate, error) {
+	var template model.MailTemplate
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND name = ?", userID, name).First(&template).Error; err != nil {
+		return template, err
+	}
+	return template, nil
+}
+
+func (s *templateStorage) GetAllByUserID(ctx context.Context, userID uint) ([]model.MailTemplate, error) {
+	var templates []model.MailTemplate
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("name").Find(&templates).Error; err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// GetAllPartials returns the partials and layouts of a user at their latest version.
+func (s *templateStorage) GetAllPartials(ctx context.Context, userID uint) ([]model.MailTemplate, error) {
+	var templates []model.MailTemplate
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND partial = ?", userID, true).Order("name").Find(&templates).Error; err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// GetVersion returns a version of a template, the versions of deleted
+// templates are kept for the tasks enqueued with them.
+func (s *templateStorage) GetVersion(ctx context.Context, templateID uint, version int) (model.MailTemplateVersion, error) {
+	var v model.MailTemplateVersion
+	if err := s.db.WithContext(ctx).Where("template_id = ? AND version = ?", templateID, version).First(&v).Error; err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// GetAllVersions returns the versions of a template, the latest first.
+func (s *templateStorage) GetAllVersions(ctx context.Context, templateID uint) ([]model.MailTemplateVersion, error) {
+	var versions []model.MailTemplateVersion
+	if err := s.db.WithContext(ctx).Where("template_id = ?", templateID).Order("version DESC").Find(&versions).Error; err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// GetPartialVersions returns the versions of the pinned partials, the versions
+// of deleted partials included.
+func (s *templateStorage) GetPartialVersions(ctx context.Context, partials []model.MailTemplatePartial) ([]model.MailTemplateVersion, error) {
+	var versions []model.MailTemplateVersion
+	if len(partials) == 0 {
+		return versions, nil
+	}
+	pairs := make([][]interface{}, len(partials))
+	for i, partial := range partials {
+		pairs[i] = []interface{}{partial.TemplateID, partial.Version}
+	}
+	if err := s.db.WithContext(ctx).Where("(template_id, version) IN ?", pairs).Find(&versions).Error; err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// Delete removes a template for good so its name can be used again, its
+// versions are kept.
+func (s *templateStorage) Delete(ctx context.Context, userID, id uint) error {
+	res := s.db.WithContext(ctx).Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&model.MailTemplate{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package templatestorage_test
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/storage/templatestorage"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func newStorage() (templatestorage.TemplateStorer, sqlmock.Sqlmock) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	return templatestorage.New(templatestorage.WithTemplateDB(db)), mock
+}
+
+func Test_templateStorage_Insert(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Template Inserted With First Version"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_templates"`).
+			WillReturnRows(sqlmock.NewRows([]string{"partial", "id"}).AddRow(false, 1))
+		mock.ExpectQuery(`INSERT INTO "mail_template_versions"`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, "", "Hello {{.name}}", "", "Hi").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+		template, err := storage.Insert(context.Background(), model.MailTemplate{
+			UserID:   1,
+			Name:     "welcome",
+			Subject:  "Hello {{.name}}",
+			TextBody: "Hi",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || template.ID != 1 || template.Version != 1 {
+				t.Errorf("Expected template 1 at version 1, got %v %v", template, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Version Not Inserted And Template Rolled Back"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "mail_templates"`).
+			WillReturnRows(sqlmock.NewRows([]string{"partial", "id"}).AddRow(false, 2))
+		mock.ExpectQuery(`INSERT INTO "mail_template_versions"`).WillReturnError(gorm.ErrInvalidData)
+		mock.ExpectRollback()
+		_, err := storage.Insert(context.Background(), model.MailTemplate{UserID: 1, Name: "welcome"})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, gorm.ErrInvalidData) {
+				t.Errorf("Expected invalid data error, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_templateStorage_Update(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Locked Template Updated To Next Version"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "mail_templates" WHERE \(id = \$1 AND user_id = \$2\) AND "mail_templates"."deleted_at" IS NULL ORDER BY "mail_templates"."id" LIMIT \$3 FOR UPDATE`).
+			WithArgs(1, 1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "version", "subject"}).AddRow(1, 1, "welcome", 2, "Old"))
+		mock.ExpectExec(`UPDATE "mail_templates" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "mail_template_versions"`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 3, "", "New", "", "Hi").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectCommit()
+		template, err := storage.Update(context.Background(), model.MailTemplate{
+			Model:    gorm.Model{ID: 1},
+			UserID:   1,
+			Subject:  "New",
+			TextBody: "Hi",
+		})
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || template.Version != 3 || template.Name != "welcome" || template.Subject != "New" {
+				t.Errorf("Expected welcome at version 3, got %v %v", template, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Template Of Other User Not Found"
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "mail_templates"`).WithArgs(1, 2, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		_, err := storage.Update(context.Background(), model.MailTemplate{Model: gorm.Model{ID: 1}, UserID: 2})
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("Expected record not found, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_templateStorage_GetAllPartials(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Partials Of User Returned By Name"
+	mock.ExpectQuery(`SELECT \* FROM "mail_templates" WHERE \(user_id = \$1 AND partial = \$2\) AND "mail_templates"."deleted_at" IS NULL ORDER BY name`).
+		WithArgs(1, true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "partial"}).AddRow(2, "footer", true))
+	partials, err := storage.GetAllPartials(context.Background(), 1)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(partials) != 1 || partials[0].Name != "footer" {
+			t.Errorf("Expected footer partial, got %v %v", partials, err)
+		}
+	})
+}
+
+func Test_templateStorage_GetVersion(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Version Of Template Returned"
+	mock.ExpectQuery(`SELECT \* FROM "mail_template_versions" WHERE \(template_id = \$1 AND version = \$2\) AND "mail_template_versions"."deleted_at" IS NULL`).
+		WithArgs(1, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "template_id", "version", "subject"}).AddRow(5, 1, 2, "Hello"))
+	version, err := storage.GetVersion(context.Background(), 1, 2)
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || version.Version != 2 || version.Subject != "Hello" {
+			t.Errorf("Expected version 2, got %v %v", version, err)
+		}
+	})
+}
+
+func Test_templateStorage_GetPartialVersions(t *testing.T) {
+	storage, mock := newStorage()
+	tc := "Case 1: Pinned Versions Of Partials Returned"
+	mock.ExpectQuery(`SELECT \* FROM "mail_template_versions" WHERE \(template_id, version\) IN \(\(\$1,\$2\),\(\$3,\$4\)\) AND "mail_template_versions"."deleted_at" IS NULL`).
+		WithArgs(1, 2, 4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "template_id", "version", "text_body"}).AddRow(5, 1, 2, "Thanks").AddRow(9, 4, 1, "--"))
+	versions, err := storage.GetPartialVersions(context.Background(), []model.MailTemplatePartial{
+		{Name: "signature", TemplateID: 1, Version: 2},
+		{Name: "layout", TemplateID: 4, Version: 1},
+	})
+	t.Run(tc, func(t *testing.T) {
+		if err != nil || len(versions) != 2 || versions[0].TextBody != "Thanks" {
+			t.Errorf("Expected 2 versions, got %v %v", versions, err)
+		}
+	})
+}
+
+func Test_templateStorage_Delete(t *testing.T) {
+	storage, mock := newStorage()
+	{
+		tc := "Case 1: Template Deleted For Good"
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "mail_templates" WHERE id = \$1 AND user_id = \$2`).
+			WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		err := storage.Delete(context.Background(), 1, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Missing Template Returns Record Not Found"
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "mail_templates"`).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		err := storage.Delete(context.Background(), 1, 2)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("Expected record not found, got %v", err)
+			}
+		})
+	}
+}
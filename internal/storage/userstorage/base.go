@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"gorm.io/gorm"
+	"time"
 )
 
 // UserStorer is an interface for storing users.
@@ -12,6 +13,8 @@ type UserStorer interface {
 	GetByID(ctx context.Context, id uint) (model.User, error)
 	GetByEmail(ctx context.Context, email string) (model.User, error)
 	Update(ctx context.Context, user model.User, tx ...*gorm.DB) error
+	SetSmtpPause(ctx context.Context, id uint, authError string, until time.Time) error
+	UpdateSmtpSettings(ctx context.Context, user model.User) error
 	Delete(ctx context.Context, id uint) error
 	CreateTx() *gorm.DB
 	CommitTx(tx *gorm.DB)
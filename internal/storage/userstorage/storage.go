@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"gorm.io/gorm"
+	"time"
 )
 
 func (s *userStorage) Insert(ctx context.Context, user model.User, tx ...*gorm.DB) error {
@@ -45,6 +46,29 @@ func (s *userStorage) Update(ctx context.Context, user model.User, tx ...*gorm.D
 	return nil
 }
 
+// SetSmtpPause sets the SMTP auth error of a user and the time its tasks are
+// paused until. Only these columns are written, so settings the user changed
+// meanwhile are kept.
+func (s *userStorage) SetSmtpPause(ctx context.Context, id uint, authError string, until time.Time) error {
+	return s.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"smtp_auth_error":   authError,
+		"smtp_paused_until": until,
+	}).Error
+}
+
+// UpdateSmtpSettings sets the SMTP settings of a user and clears its SMTP auth
+// error and pause, so its tasks are sent with the new settings right away.
+func (s *userStorage) UpdateSmtpSettings(ctx context.Context, user model.User) error {
+	return s.db.Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"smtp_host":         user.SmtpHost,
+		"smtp_port":         user.SmtpPort,
+		"smtp_username":     user.SmtpUsername,
+		"smtp_password":     user.SmtpPassword,
+		"smtp_auth_error":   "",
+		"smtp_paused_until": time.Time{},
+	}).Error
+}
+
 func (s *userStorage) Delete(ctx context.Context, id uint) error {
 	if err := s.db.Where("id = ?", id).Delete(&model.User{}).Error; err != nil {
 		return err
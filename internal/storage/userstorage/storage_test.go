@@ -8,6 +8,7 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 func Test_userStorage_Insert(t *testing.T) {
@@ -289,3 +290,72 @@ func Test_userStorage_SetTx(t *testing.T) {
 		}
 	})
 }
+
+func Test_userStorage_SetSmtpPause(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Only the pause columns are updated"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "users" SET "smtp_auth_error"=\$1,"smtp_paused_until"=\$2,"updated_at"=\$3 WHERE id = \$4`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		storage := userstorage.New(userstorage.WithUserDB(db))
+		err := storage.SetSmtpPause(context.Background(), 1, "535 authentication failed", time.Now().Add(time.Hour))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: Expected err to be nil but got %v", tc, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("%s: Expected all expectations to be met but got %v", tc, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Error updating the pause columns"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "users" SET "smtp_auth_error"=`).
+			WillReturnError(gorm.ErrInvalidDB)
+		mock.ExpectRollback()
+		storage := userstorage.New(userstorage.WithUserDB(db))
+		err := storage.SetSmtpPause(context.Background(), 1, "", time.Time{})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("%s: Expected err to be not nil but got nil", tc)
+			}
+		})
+	}
+}
+
+func Test_userStorage_UpdateSmtpSettings(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	dialector := postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	})
+	db, _ := gorm.Open(dialector, &gorm.Config{})
+	{
+		tc := "Case 1: Settings are updated and the pause is cleared"
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "users" SET "smtp_auth_error"=\$1,"smtp_host"=\$2,"smtp_password"=\$3,"smtp_paused_until"=\$4,"smtp_port"=\$5,"smtp_username"=\$6,"updated_at"=\$7 WHERE id = \$8`).
+			WithArgs("", "smtp.test.com", "secret", time.Time{}, 587, "test", sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		storage := userstorage.New(userstorage.WithUserDB(db))
+		user := model.User{SmtpHost: "smtp.test.com", SmtpPort: 587, SmtpUsername: "test", SmtpPassword: "secret"}
+		user.ID = 1
+		err := storage.UpdateSmtpSettings(context.Background(), user)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("%s: Expected err to be nil but got %v", tc, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("%s: Expected all expectations to be met but got %v", tc, err)
+			}
+		})
+	}
+}
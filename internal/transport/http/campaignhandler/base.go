@@ -0,0 +1,50 @@
+package campaignhandler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+)
+
+// CampaignHandler is the interface for campaign handler.
+type CampaignHandler interface {
+	AddRoutes(router fiber.Router)
+	CreateCampaign(c *fiber.Ctx) error
+	GetCampaigns(c *fiber.Ctx) error
+	GetCampaign(c *fiber.Ctx) error
+	PauseCampaign(c *fiber.Ctx) error
+	ResumeCampaign(c *fiber.Ctx) error
+	CancelCampaign(c *fiber.Ctx) error
+}
+
+// campaignHandler is the handler for http requests.
+type campaignHandler struct {
+	*basehttphandler.BaseHttpHandler
+	campaignService campaignservice.CampaignService
+}
+
+// Option is the option type for campaign handler.
+type Option func(*campaignHandler)
+
+// WithBaseHttpHandler sets the base http handler option.
+func WithBaseHttpHandler(handler *basehttphandler.BaseHttpHandler) Option {
+	return func(h *campaignHandler) {
+		h.BaseHttpHandler = handler
+	}
+}
+
+// WithCampaignService sets the campaign service option.
+func WithCampaignService(service campaignservice.CampaignService) Option {
+	return func(h *campaignHandler) {
+		h.campaignService = service
+	}
+}
+
+// New creates a new http handler with the given options.
+func New(opts ...Option) CampaignHandler {
+	h := &campaignHandler{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
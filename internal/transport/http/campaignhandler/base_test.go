@@ -0,0 +1,93 @@
+package campaignhandler_test
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/response"
+	"io"
+	"log/slog"
+)
+
+type mockCampaignService struct {
+	errCreateCampaign error
+	errGetCampaign    error
+	errGetCampaigns   error
+	errPauseCampaign  error
+	errResumeCampaign error
+	errCancelCampaign error
+	createCampaign    dtoreq.CreateCampaignRequest
+	recipientList     string
+	actionRequest     dtoreq.CampaignActionRequest
+}
+
+func (m *mockCampaignService) CreateCampaign(ctx context.Context, request dtoreq.CreateCampaignRequest) (dtores.CampaignResponse, error) {
+	m.createCampaign = request
+	if request.Content != nil {
+		content, _ := io.ReadAll(request.Content)
+		m.recipientList = string(content)
+	}
+	return dtores.CampaignResponse{}, m.errCreateCampaign
+}
+
+func (m *mockCampaignService) GetCampaign(ctx context.Context, request dtoreq.GetCampaignRequest) (dtores.CampaignResponse, error) {
+	return dtores.CampaignResponse{}, m.errGetCampaign
+}
+
+func (m *mockCampaignService) GetCampaigns(ctx context.Context, request dtoreq.GetCampaignsRequest) (dtores.GetCampaignsResponse, error) {
+	return dtores.GetCampaignsResponse{}, m.errGetCampaigns
+}
+
+func (m *mockCampaignService) PauseCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	m.actionRequest = request
+	return dtores.CampaignResponse{}, m.errPauseCampaign
+}
+
+func (m *mockCampaignService) ResumeCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	m.actionRequest = request
+	return dtores.CampaignResponse{}, m.errResumeCampaign
+}
+
+func (m *mockCampaignService) CancelCampaign(ctx context.Context, request dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error) {
+	m.actionRequest = request
+	return dtores.CampaignResponse{}, m.errCancelCampaign
+}
+
+func (m *mockCampaignService) DispatchCampaigns() {
+
+}
+
+type mockValidator struct {
+	errBindAndValidate error
+}
+
+func (m *mockValidator) BindAndValidate(c *fiber.Ctx, data interface{}) error {
+	return m.errBindAndValidate
+}
+
+type mockResponse struct {
+	errBasicError response.ErrorResponse
+	errData       response.DataResponse
+}
+
+func (m *mockResponse) BasicError(d interface{}, status int) response.ErrorResponse {
+	return m.errBasicError
+}
+
+func (m *mockResponse) Data(status int, data interface{}) response.DataResponse {
+	return m.errData
+}
+
+type mockMiddleware struct {
+	errAuthMiddleware        fiber.Handler
+	errHttpLoggingMiddleware fiber.Handler
+}
+
+func (m *mockMiddleware) AuthMiddleware() fiber.Handler {
+	return m.errAuthMiddleware
+}
+
+func (m *mockMiddleware) HttpLoggingMiddleware(logger *slog.Logger, app *fiber.App) fiber.Handler {
+	return m.errHttpLoggingMiddleware
+}
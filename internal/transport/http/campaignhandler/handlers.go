@@ -0,0 +1,135 @@
+package campaignhandler
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/releaseinfo"
+)
+
+func (h *campaignHandler) AddRoutes(r fiber.Router) {
+	r.Use(h.Middleware.AuthMiddleware())
+	r.Post(releaseinfo.CampaignsApiPath, h.CreateCampaign)
+	r.Get(releaseinfo.CampaignsApiPath, h.GetCampaigns)
+	r.Get(releaseinfo.CampaignApiPath, h.GetCampaign)
+	r.Post(releaseinfo.PauseCampaignApiPath, h.PauseCampaign)
+	r.Post(releaseinfo.ResumeCampaignApiPath, h.ResumeCampaign)
+	r.Post(releaseinfo.CancelCampaignApiPath, h.CancelCampaign)
+}
+
+// CreateCampaign creates a campaign from the fields of a multipart form, the
+// recipient list is its file.
+func (h *campaignHandler) CreateCampaign(c *fiber.Ctx) error {
+	var (
+		req dtoreq.CreateCampaignRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("file is required", fiber.StatusBadRequest))
+	}
+	content, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	defer content.Close()
+	req.Filename = file.Filename
+	req.ContentType = file.Header.Get(fiber.HeaderContentType)
+	req.Content = content
+	res, err := h.campaignService.CreateCampaign(c.Context(), req)
+	if err != nil {
+		return c.Status(campaignStatus(err)).JSON(h.Response.BasicError(err, campaignStatus(err)))
+	}
+	return c.Status(fiber.StatusCreated).JSON(h.Response.Data(fiber.StatusCreated, res))
+}
+
+func (h *campaignHandler) GetCampaigns(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetCampaignsRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.campaignService.GetCampaigns(c.Context(), req)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// GetCampaign returns a campaign with the progress of its tasks.
+func (h *campaignHandler) GetCampaign(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetCampaignRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid campaign id", fiber.StatusBadRequest))
+	}
+	req.CampaignID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.campaignService.GetCampaign(c.Context(), req)
+	if err != nil {
+		return c.Status(campaignStatus(err)).JSON(h.Response.BasicError(err, campaignStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+func (h *campaignHandler) PauseCampaign(c *fiber.Ctx) error {
+	return h.action(c, h.campaignService.PauseCampaign)
+}
+
+func (h *campaignHandler) ResumeCampaign(c *fiber.Ctx) error {
+	return h.action(c, h.campaignService.ResumeCampaign)
+}
+
+func (h *campaignHandler) CancelCampaign(c *fiber.Ctx) error {
+	return h.action(c, h.campaignService.CancelCampaign)
+}
+
+// action runs an action of the service on the campaign of the id parameter.
+func (h *campaignHandler) action(c *fiber.Ctx, do func(context.Context, dtoreq.CampaignActionRequest) (dtores.CampaignResponse, error)) error {
+	var (
+		req dtoreq.CampaignActionRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid campaign id", fiber.StatusBadRequest))
+	}
+	req.CampaignID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := do(c.Context(), req)
+	if err != nil {
+		return c.Status(campaignStatus(err)).JSON(h.Response.BasicError(err, campaignStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// campaignStatus maps the errors of the campaign endpoints to a status code. The
+// template of a new campaign is part of its request, so template errors are 400.
+func campaignStatus(err error) int {
+	switch {
+	case errors.Is(err, campaignservice.ErrCampaignNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, campaignservice.ErrCampaignState):
+		return fiber.StatusConflict
+	case errors.Is(err, campaignservice.ErrInvalidRecipients), errors.Is(err, templateservice.ErrTemplateNotFound),
+		errors.Is(err, templateservice.ErrInvalidTemplate), errors.Is(err, templateservice.ErrRender):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
@@ -0,0 +1,260 @@
+package campaignhandler_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/campaignservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/campaignhandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newCampaignApp returns an app that serves the campaign routes to user 1.
+func newCampaignApp(mockCampaignService *mockCampaignService, mockValidator *mockValidator) *fiber.App {
+	mockMiddleware := &mockMiddleware{
+		errAuthMiddleware: func(c *fiber.Ctx) error {
+			c.Locals("userID", uint(1))
+			return c.Next()
+		},
+	}
+	pkgs := pkg.New(
+		pkg.WithValidator(mockValidator),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	campaignHandler := campaignhandler.New(
+		campaignhandler.WithCampaignService(mockCampaignService),
+		campaignhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	app := fiber.New()
+	campaignHandler.AddRoutes(app)
+	return app
+}
+
+func Test_campaignHandler_AddRoutes(t *testing.T) {
+	app := newCampaignApp(&mockCampaignService{}, &mockValidator{})
+	{
+		tc := "Case 1: Campaign routes are served behind the auth middleware"
+		t.Run(tc, func(t *testing.T) {
+			for _, route := range []struct{ method, path string }{
+				{"POST", "/api/v1/campaigns"},
+				{"GET", "/api/v1/campaigns"},
+				{"GET", "/api/v1/campaigns/1"},
+				{"POST", "/api/v1/campaigns/1/pause"},
+				{"POST", "/api/v1/campaigns/1/resume"},
+				{"POST", "/api/v1/campaigns/1/cancel"},
+			} {
+				resp, err := app.Test(httptest.NewRequest(route.method, route.path, nil))
+				if err != nil {
+					t.Fatalf("expected nil, got %v", err)
+				}
+				if resp.StatusCode == fiber.StatusNotFound || resp.StatusCode == fiber.StatusMethodNotAllowed {
+					t.Errorf("%s: expected %s %s to be routed, got %d", tc, route.method, route.path, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func Test_campaignHandler_CreateCampaign(t *testing.T) {
+	mockCampaignService := &mockCampaignService{}
+	mockValidator := &mockValidator{}
+	app := newCampaignApp(mockCampaignService, mockValidator)
+	upload := func(field string) *http.Request {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile(field, "list.csv")
+		part.Write([]byte("email,name\nada@example.com,Ada\n"))
+		w.WriteField("name", "newsletter")
+		w.WriteField("template_id", "3")
+		w.Close()
+		req := httptest.NewRequest("POST", "/api/v1/campaigns", &body)
+		req.Header.Set(fiber.HeaderContentType, w.FormDataContentType())
+		return req
+	}
+	{
+		tc := "Case 1: Validation error in request and returns 400"
+		mockValidator.errBindAndValidate = errors.New("validation error")
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockValidator.errBindAndValidate = nil
+	}
+	{
+		tc := "Case 2: Form without file returns 400"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("other"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Recipient list with an invalid row returns 400"
+		mockCampaignService.errCreateCampaign = fmt.Errorf("%w: line 2: invalid email", campaignservice.ErrInvalidRecipients)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockCampaignService.errCreateCampaign = nil
+	}
+	{
+		tc := "Case 4: Template of another user returns 400"
+		mockCampaignService.errCreateCampaign = templateservice.ErrTemplateNotFound
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockCampaignService.errCreateCampaign = nil
+	}
+	{
+		tc := "Case 5: Success, campaign created with the uploaded list and returns 201"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusCreated {
+				t.Fatalf("expected %d, got %d", fiber.StatusCreated, resp.StatusCode)
+			}
+			created := mockCampaignService.createCampaign
+			if created.UserID != 1 || created.Filename != "list.csv" || mockCampaignService.recipientList != "email,name\nada@example.com,Ada\n" {
+				t.Errorf("%s: expected the list of user 1 but got %v %q", tc, created, mockCampaignService.recipientList)
+			}
+		})
+	}
+}
+
+func Test_campaignHandler_GetCampaign(t *testing.T) {
+	mockCampaignService := &mockCampaignService{}
+	app := newCampaignApp(mockCampaignService, &mockValidator{})
+	{
+		tc := "Case 1: Invalid campaign id returns 400"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/campaigns/abc", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Campaign of another user returns 404"
+		mockCampaignService.errGetCampaign = campaignservice.ErrCampaignNotFound
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/campaigns/1", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockCampaignService.errGetCampaign = nil
+	}
+	{
+		tc := "Case 3: Success, campaign returned with 200"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/campaigns/1", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_campaignHandler_Actions(t *testing.T) {
+	mockCampaignService := &mockCampaignService{}
+	app := newCampaignApp(mockCampaignService, &mockValidator{})
+	{
+		tc := "Case 1: Completed campaign can not be paused and returns 409"
+		mockCampaignService.errPauseCampaign = campaignservice.ErrCampaignState
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("POST", "/api/v1/campaigns/1/pause", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusConflict {
+				t.Fatalf("expected %d, got %d", fiber.StatusConflict, resp.StatusCode)
+			}
+		})
+		mockCampaignService.errPauseCampaign = nil
+	}
+	{
+		tc := "Case 2: Invalid campaign id returns 400"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("POST", "/api/v1/campaigns/0/resume", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Success, campaign of the id cancelled and returns 200"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("POST", "/api/v1/campaigns/7/cancel", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+			if req := mockCampaignService.actionRequest; req.UserID != 1 || req.CampaignID != 7 {
+				t.Errorf("%s: expected campaign 7 of user 1 but got %v", tc, req)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Campaign service returns error and returns 500"
+		mockCampaignService.errResumeCampaign = errors.New("campaign service error")
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("POST", "/api/v1/campaigns/1/resume", nil))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockCampaignService.errResumeCampaign = nil
+	}
+}
@@ -2,6 +2,7 @@ package taskhandler
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/userservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
@@ -11,8 +12,14 @@ import (
 type TaskHandler interface {
 	AddRoutes(router fiber.Router)
 	EnqueueTask(c *fiber.Ctx) error
+	UploadAttachment(c *fiber.Ctx) error
 	GetAllQueuedTasks(c *fiber.Ctx) error
 	GetAllFailedQueuedTasks(c *fiber.Ctx) error
+	GetTaskAttempts(c *fiber.Ctx) error
+	GetDeadLetters(c *fiber.Ctx) error
+	GetDeadLetter(c *fiber.Ctx) error
+	ReplayDeadLetters(c *fiber.Ctx) error
+	PurgeDeadLetters(c *fiber.Ctx) error
 }
 
 // taskHandler is the handler for http requests.
@@ -20,6 +27,7 @@ type taskHandler struct {
 	*basehttphandler.BaseHttpHandler
 	userService userservice.UserService
 	taskService taskservice.TaskService
+	attachments attachmentservice.AttachmentService
 }
 
 // Option is the option type for task handler.
@@ -46,6 +54,13 @@ func WithTaskService(service taskservice.TaskService) Option {
 	}
 }
 
+// WithAttachmentService sets the attachment service option.
+func WithAttachmentService(service attachmentservice.AttachmentService) Option {
+	return func(h *taskHandler) {
+		h.attachments = service
+	}
+}
+
 // New creates a new http handler with the given options.
 func New(opts ...Option) TaskHandler {
 	h := &taskHandler{}
@@ -5,7 +5,10 @@ import (
 	"github.com/gofiber/fiber/v2"
 	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
 	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/response"
+	"gorm.io/gorm"
+	"io"
 	"log/slog"
 	"time"
 )
@@ -16,6 +19,7 @@ type mockUserService struct {
 	errGetUser  error
 	resLogin    dtores.LoginResponse
 	resGetUser  dtores.GetUserResponse
+	errUpdate   error
 }
 
 func (m *mockUserService) Register(ctx context.Context, req dtoreq.RegisterRequest) error {
@@ -30,6 +34,10 @@ func (m *mockUserService) GetUser(ctx context.Context, req dtoreq.GetUserRequest
 	return m.resGetUser, m.errGetUser
 }
 
+func (m *mockUserService) UpdateSmtpSettings(ctx context.Context, req dtoreq.UpdateSmtpSettingsRequest) error {
+	return m.errUpdate
+}
+
 type mockTaskService struct {
 	errEnqueueMailTask         error
 	errGetAllQueuedTasks       error
@@ -37,6 +45,16 @@ type mockTaskService struct {
 	resEnqueueMailTask         dtores.TaskEnqueueResponse
 	resGetAllQueuedTasks       dtores.GetAllQueuedTasksResponse
 	resGetAllFailedQueuedTasks dtores.GetAllFailedTasksResponse
+	errGetDeadLetters          error
+	errGetDeadLetter           error
+	errReplayDeadLetters       error
+	errPurgeDeadLetters        error
+	resGetDeadLetters          dtores.GetDeadLettersResponse
+	resGetDeadLetter           dtores.GetDeadLetterResponse
+	resReplayDeadLetters       dtores.ReplayDeadLettersResponse
+	resPurgeDeadLetters        dtores.PurgeDeadLettersResponse
+	errGetTaskAttempts         error
+	resGetTaskAttempts         dtores.GetTaskAttemptsResponse
 }
 
 func (m *mockTaskService) EnqueueMailTask(ctx context.Context, request dtoreq.TaskEnqueueRequest) (dtores.TaskEnqueueResponse, error) {
@@ -51,10 +69,56 @@ func (m *mockTaskService) GetAllFailedQueuedTasks(ctx context.Context, request d
 	return m.resGetAllFailedQueuedTasks, m.errGetAllFailedQueuedTasks
 }
 
-func (m *mockTaskService) FindUnprocessedTasksAndEnqueue() {
+func (m *mockTaskService) GetTaskAttempts(ctx context.Context, request dtoreq.GetTaskAttemptsRequest) (dtores.GetTaskAttemptsResponse, error) {
+	return m.resGetTaskAttempts, m.errGetTaskAttempts
+}
+
+func (m *mockTaskService) GetDeadLetters(ctx context.Context, request dtoreq.GetDeadLettersRequest) (dtores.GetDeadLettersResponse, error) {
+	return m.resGetDeadLetters, m.errGetDeadLetters
+}
+
+func (m *mockTaskService) GetDeadLetter(ctx context.Context, request dtoreq.GetDeadLetterRequest) (dtores.GetDeadLetterResponse, error) {
+	return m.resGetDeadLetter, m.errGetDeadLetter
+}
+
+func (m *mockTaskService) ReplayDeadLetters(ctx context.Context, request dtoreq.ReplayDeadLettersRequest) (dtores.ReplayDeadLettersResponse, error) {
+	return m.resReplayDeadLetters, m.errReplayDeadLetters
+}
+
+func (m *mockTaskService) PurgeDeadLetters(ctx context.Context, request dtoreq.PurgeDeadLettersRequest) (dtores.PurgeDeadLettersResponse, error) {
+	return m.resPurgeDeadLetters, m.errPurgeDeadLetters
+}
+
+func (m *mockTaskService) FindUnprocessedTasksAndEnqueue(ctx context.Context, token int64) {
 	return
 }
 
+func (m *mockTaskService) PromoteScheduledTasks(ctx context.Context, token int64) {
+	return
+}
+
+type mockAttachmentService struct {
+	errUpload error
+	resUpload dtores.AttachmentResponse
+	uploaded  dtoreq.UploadAttachmentRequest
+	content   string
+}
+
+func (m *mockAttachmentService) Upload(ctx context.Context, request dtoreq.UploadAttachmentRequest) (dtores.AttachmentResponse, error) {
+	m.uploaded = request
+	content, _ := io.ReadAll(request.Content)
+	m.content = string(content)
+	return m.resUpload, m.errUpload
+}
+
+func (m *mockAttachmentService) Attach(ctx context.Context, task model.MailTaskQueue, inline []dtoreq.AttachmentRequest, ids []uint, tx *gorm.DB) ([]model.MailAttachment, error) {
+	return nil, nil
+}
+
+func (m *mockAttachmentService) Discard(ctx context.Context, attachments []model.MailAttachment) {}
+
+func (m *mockAttachmentService) CleanupAttachments() {}
+
 type mockJwtUtils struct {
 	errGenerateToken error
 	resGenerateToken string
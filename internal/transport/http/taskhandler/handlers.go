@@ -1,16 +1,28 @@
 package taskhandler
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/releaseinfo"
 )
 
 func (h *taskHandler) AddRoutes(r fiber.Router) {
 	r.Use(h.Middleware.AuthMiddleware())
 	r.Post(releaseinfo.EnqueueMailApiPath, h.EnqueueTask)
+	r.Post(releaseinfo.UploadAttachmentApiPath, h.UploadAttachment)
 	r.Get(releaseinfo.GetAllQueuedMailTasksApiPath, h.GetAllQueuedTasks)
 	r.Get(releaseinfo.GetAllFailedQueuedMailApiPath, h.GetAllFailedQueuedTasks)
+	r.Get(releaseinfo.DeadLettersApiPath, h.GetDeadLetters)
+	r.Get(releaseinfo.DeadLetterApiPath, h.GetDeadLetter)
+	r.Post(releaseinfo.ReplayDeadLettersApiPath, h.ReplayDeadLetters)
+	r.Post(releaseinfo.ReplayDeadLetterApiPath, h.ReplayDeadLetters)
+	r.Delete(releaseinfo.DeadLettersApiPath, h.PurgeDeadLetters)
+	r.Delete(releaseinfo.DeadLetterApiPath, h.PurgeDeadLetters)
+	r.Get(releaseinfo.GetTaskAttemptsApiPath, h.GetTaskAttempts)
 }
 
 func (h *taskHandler) EnqueueTask(c *fiber.Ctx) error {
@@ -23,7 +35,35 @@ func (h *taskHandler) EnqueueTask(c *fiber.Ctx) error {
 	}
 	res, err := h.taskService.EnqueueMailTask(c.Context(), req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
+		return c.Status(enqueueStatus(err)).JSON(h.Response.BasicError(err, enqueueStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// UploadAttachment stores the file of a multipart form, the returned ID is sent
+// in the attachment_ids of a task. The content type is taken from the
+// content_type field or the part of the file.
+func (h *taskHandler) UploadAttachment(c *fiber.Ctx) error {
+	var (
+		req dtoreq.UploadAttachmentRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("file is required", fiber.StatusBadRequest))
+	}
+	content, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	defer content.Close()
+	req.Filename = file.Filename
+	req.ContentType = c.FormValue("content_type", file.Header.Get(fiber.HeaderContentType))
+	req.Size = file.Size
+	req.Content = content
+	res, err := h.attachments.Upload(c.Context(), req)
+	if err != nil {
+		return c.Status(attachmentStatus(err)).JSON(h.Response.BasicError(err, attachmentStatus(err)))
 	}
 	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
 }
@@ -57,3 +97,143 @@ func (h *taskHandler) GetAllFailedQueuedTasks(c *fiber.Ctx) error {
 	}
 	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
 }
+
+func (h *taskHandler) GetTaskAttempts(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetTaskAttemptsRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid task id", fiber.StatusBadRequest))
+	}
+	req.TaskID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.taskService.GetTaskAttempts(c.Context(), req)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, taskservice.ErrTaskNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(h.Response.BasicError(err, status))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+func (h *taskHandler) GetDeadLetters(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetDeadLettersRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.taskService.GetDeadLetters(c.Context(), req)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+func (h *taskHandler) GetDeadLetter(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetDeadLetterRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid task id", fiber.StatusBadRequest))
+	}
+	req.TaskID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.taskService.GetDeadLetter(c.Context(), req)
+	if err != nil {
+		return c.Status(deadLetterStatus(err)).JSON(h.Response.BasicError(err, deadLetterStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// ReplayDeadLetters replays the dead-letter entry of the task in the path, or
+// the entries of the task IDs in the body when the path has no task.
+func (h *taskHandler) ReplayDeadLetters(c *fiber.Ctx) error {
+	var (
+		req dtoreq.ReplayDeadLettersRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	if c.Params("id") != "" {
+		id, err := c.ParamsInt("id")
+		if err != nil || id <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid task id", fiber.StatusBadRequest))
+		}
+		req.TaskIDs = []uint{uint(id)}
+	}
+	res, err := h.taskService.ReplayDeadLetters(c.Context(), req)
+	if err != nil {
+		return c.Status(deadLetterStatus(err)).JSON(h.Response.BasicError(err, deadLetterStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// PurgeDeadLetters purges the dead-letter entry of the task in the path, or
+// the entries of the task IDs in the body when the path has no task.
+func (h *taskHandler) PurgeDeadLetters(c *fiber.Ctx) error {
+	var (
+		req dtoreq.PurgeDeadLettersRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	if c.Params("id") != "" {
+		id, err := c.ParamsInt("id")
+		if err != nil || id <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid task id", fiber.StatusBadRequest))
+		}
+		req.TaskIDs = []uint{uint(id)}
+	}
+	res, err := h.taskService.PurgeDeadLetters(c.Context(), req)
+	if err != nil {
+		return c.Status(deadLetterStatus(err)).JSON(h.Response.BasicError(err, deadLetterStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// enqueueStatus maps the errors of enqueueing a task to a status code, a task
+// is not enqueued with a template that does not render with its variables.
+func enqueueStatus(err error) int {
+	if errors.Is(err, templateservice.ErrTemplateNotFound) || errors.Is(err, templateservice.ErrInvalidTemplate) ||
+		errors.Is(err, templateservice.ErrRender) {
+		return fiber.StatusBadRequest
+	}
+	return attachmentStatus(err)
+}
+
+// attachmentStatus maps the errors of the attachments of a task to a status code.
+func attachmentStatus(err error) int {
+	switch {
+	case errors.Is(err, attachmentservice.ErrAttachmentTooLarge), errors.Is(err, attachmentservice.ErrQuotaExceeded):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, attachmentservice.ErrTooManyAttachments), errors.Is(err, attachmentservice.ErrAttachmentNotFound),
+		errors.Is(err, attachmentservice.ErrInvalidAttachment):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+// deadLetterStatus maps the errors of the dead-letter endpoints to a status code.
+func deadLetterStatus(err error) int {
+	if errors.Is(err, taskservice.ErrDeadLetterNotFound) {
+		return fiber.StatusNotFound
+	}
+	if errors.Is(err, taskservice.ErrDeadLetterSelection) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
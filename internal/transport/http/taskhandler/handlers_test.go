@@ -1,12 +1,19 @@
 package taskhandler_test
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/attachmentservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/taskservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/taskhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 )
@@ -143,7 +150,30 @@ func Test_taskHandler_EnqueueTask(t *testing.T) {
 		mockMiddleware.errAuthMiddleware = nil
 	}
 	{
-		tc := "Case 4: Success"
+		tc := "Case 4: Attachment of task too large and returns 413"
+		mockTaskService.errEnqueueMailTask = fmt.Errorf("enqueue: %w", attachmentservice.ErrAttachmentTooLarge)
+		mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+			c.Locals("userID", uint(1))
+			return c.Next()
+		}
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/enqueue", taskHandler.EnqueueTask)
+		req := httptest.NewRequest("POST", "/api/v1/task/enqueue", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusRequestEntityTooLarge {
+				t.Fatalf("expected %d, got %d", fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+			}
+		})
+		mockTaskService.errEnqueueMailTask = nil
+		mockMiddleware.errAuthMiddleware = nil
+	}
+	{
+		tc := "Case 5: Success"
 		mockTaskService.resEnqueueMailTask = dtores.TaskEnqueueResponse{
 			TaskID: 1,
 		}
@@ -165,6 +195,114 @@ func Test_taskHandler_EnqueueTask(t *testing.T) {
 			}
 		})
 	}
+	{
+		tc := "Case 6: Template can not be rendered with the variables and returns 400"
+		mockTaskService.errEnqueueMailTask = fmt.Errorf("%w: map has no entry for key \"name\"", templateservice.ErrRender)
+		mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+			c.Locals("userID", uint(1))
+			return c.Next()
+		}
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/enqueue", taskHandler.EnqueueTask)
+		req := httptest.NewRequest("POST", "/api/v1/task/enqueue", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockTaskService.errEnqueueMailTask = nil
+		mockMiddleware.errAuthMiddleware = nil
+	}
+}
+
+func Test_taskHandler_UploadAttachment(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockAttachmentService := &mockAttachmentService{}
+	mockValidator := &mockValidator{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(mockValidator),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithAttachmentService(mockAttachmentService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Use(mockMiddleware.AuthMiddleware())
+	app.Post("/api/v1/task/attachments", taskHandler.UploadAttachment)
+	upload := func(field string) *http.Request {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile(field, "report.csv")
+		part.Write([]byte("id,total"))
+		w.WriteField("content_type", "text/csv")
+		w.Close()
+		req := httptest.NewRequest("POST", "/api/v1/task/attachments", &body)
+		req.Header.Set(fiber.HeaderContentType, w.FormDataContentType())
+		return req
+	}
+	{
+		tc := "Case 1: Form without file returns 400"
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("other"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Attachment over quota returns 413"
+		mockAttachmentService.errUpload = attachmentservice.ErrQuotaExceeded
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusRequestEntityTooLarge {
+				t.Fatalf("expected %d, got %d", fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+			}
+		})
+		mockAttachmentService.errUpload = nil
+	}
+	{
+		tc := "Case 3: Success, file uploaded with its name and content type"
+		mockAttachmentService.resUpload = dtores.AttachmentResponse{AttachmentID: 1}
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(upload("file"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+			uploaded := mockAttachmentService.uploaded
+			if uploaded.UserID != 1 || uploaded.Filename != "report.csv" || uploaded.ContentType != "text/csv" ||
+				uploaded.Size != 8 || mockAttachmentService.content != "id,total" {
+				t.Errorf("expected csv upload of user 1, got %+v %q", uploaded, mockAttachmentService.content)
+			}
+		})
+	}
 }
 
 func Test_taskHandler_GetAllQueuedTasks(t *testing.T) {
@@ -409,3 +547,523 @@ func Test_taskHandler_GetAllFailedQueuedTasks(t *testing.T) {
 		})
 	}
 }
+
+func Test_taskHandler_GetDeadLetters(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithUserService(mockUserService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Validation error in request and returns 400"
+		mockValidator.errBindAndValidate = errors.New("validation error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq", taskHandler.GetDeadLetters)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq?limit=0", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockValidator.errBindAndValidate = nil
+	}
+	{
+		tc := "Case 2: Task service returns error and returns 500"
+		mockTaskService.errGetDeadLetters = errors.New("task service error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq", taskHandler.GetDeadLetters)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTaskService.errGetDeadLetters = nil
+	}
+	{
+		tc := "Case 3: Success"
+		mockTaskService.resGetDeadLetters = dtores.GetDeadLettersResponse{
+			DeadLetters: []dtores.DeadLetterResponse{{TaskID: 1, Error: "error", Attempts: 3}},
+		}
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq", taskHandler.GetDeadLetters)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq?offset=0&limit=10", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_taskHandler_GetDeadLetter(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithUserService(mockUserService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Invalid task id in path and returns 400"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq/:id", taskHandler.GetDeadLetter)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq/abc", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Dead letter not found and returns 404"
+		mockTaskService.errGetDeadLetter = taskservice.ErrDeadLetterNotFound
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq/:id", taskHandler.GetDeadLetter)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq/1", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTaskService.errGetDeadLetter = nil
+	}
+	{
+		tc := "Case 3: Task service returns error and returns 500"
+		mockTaskService.errGetDeadLetter = errors.New("task service error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq/:id", taskHandler.GetDeadLetter)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq/1", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTaskService.errGetDeadLetter = nil
+	}
+	{
+		tc := "Case 4: Success"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/dlq/:id", taskHandler.GetDeadLetter)
+		req := httptest.NewRequest("GET", "/api/v1/task/dlq/1", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_taskHandler_GetTaskAttempts(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithUserService(mockUserService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Invalid task id in path and returns 400"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/:id/attempts", taskHandler.GetTaskAttempts)
+		req := httptest.NewRequest("GET", "/api/v1/task/abc/attempts", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Task not found or of another user and returns 404"
+		mockTaskService.errGetTaskAttempts = taskservice.ErrTaskNotFound
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/:id/attempts", taskHandler.GetTaskAttempts)
+		req := httptest.NewRequest("GET", "/api/v1/task/1/attempts", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTaskService.errGetTaskAttempts = nil
+	}
+	{
+		tc := "Case 3: Task service returns error and returns 500"
+		mockTaskService.errGetTaskAttempts = errors.New("task service error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/:id/attempts", taskHandler.GetTaskAttempts)
+		req := httptest.NewRequest("GET", "/api/v1/task/1/attempts", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTaskService.errGetTaskAttempts = nil
+	}
+	{
+		tc := "Case 4: Success"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Get("/api/v1/task/:id/attempts", taskHandler.GetTaskAttempts)
+		req := httptest.NewRequest("GET", "/api/v1/task/1/attempts", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_taskHandler_ReplayDeadLetters(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithUserService(mockUserService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Validation error in request and returns 400"
+		mockValidator.errBindAndValidate = errors.New("validation error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/dlq/replay", taskHandler.ReplayDeadLetters)
+		req := httptest.NewRequest("POST", "/api/v1/task/dlq/replay", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockValidator.errBindAndValidate = nil
+	}
+	{
+		tc := "Case 2: Invalid task id in path and returns 400"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/dlq/:id/replay", taskHandler.ReplayDeadLetters)
+		req := httptest.NewRequest("POST", "/api/v1/task/dlq/abc/replay", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Dead letter not found and returns 404"
+		mockTaskService.errReplayDeadLetters = taskservice.ErrDeadLetterNotFound
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/dlq/:id/replay", taskHandler.ReplayDeadLetters)
+		req := httptest.NewRequest("POST", "/api/v1/task/dlq/1/replay", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTaskService.errReplayDeadLetters = nil
+	}
+	{
+		tc := "Case 4: Task service returns error and returns 500"
+		mockTaskService.errReplayDeadLetters = errors.New("task service error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/dlq/replay", taskHandler.ReplayDeadLetters)
+		req := httptest.NewRequest("POST", "/api/v1/task/dlq/replay", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTaskService.errReplayDeadLetters = nil
+	}
+	{
+		tc := "Case 5: Success"
+		mockTaskService.resReplayDeadLetters = dtores.ReplayDeadLettersResponse{Replayed: []uint{1}}
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Post("/api/v1/task/dlq/replay", taskHandler.ReplayDeadLetters)
+		req := httptest.NewRequest("POST", "/api/v1/task/dlq/replay", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_taskHandler_PurgeDeadLetters(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	taskHandler := taskhandler.New(
+		taskhandler.WithTaskService(mockTaskService),
+		taskhandler.WithUserService(mockUserService),
+		taskhandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Invalid task id in path and returns 400"
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Delete("/api/v1/task/dlq/:id", taskHandler.PurgeDeadLetters)
+		req := httptest.NewRequest("DELETE", "/api/v1/task/dlq/abc", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Dead letter not found and returns 404"
+		mockTaskService.errPurgeDeadLetters = taskservice.ErrDeadLetterNotFound
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Delete("/api/v1/task/dlq/:id", taskHandler.PurgeDeadLetters)
+		req := httptest.NewRequest("DELETE", "/api/v1/task/dlq/1", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTaskService.errPurgeDeadLetters = nil
+	}
+	{
+		tc := "Case 3: No tasks selected and returns 400"
+		mockTaskService.errPurgeDeadLetters = taskservice.ErrDeadLetterSelection
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Delete("/api/v1/task/dlq", taskHandler.PurgeDeadLetters)
+		req := httptest.NewRequest("DELETE", "/api/v1/task/dlq", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockTaskService.errPurgeDeadLetters = nil
+	}
+	{
+		tc := "Case 4: Task service returns error and returns 500"
+		mockTaskService.errPurgeDeadLetters = errors.New("task service error")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Delete("/api/v1/task/dlq", taskHandler.PurgeDeadLetters)
+		req := httptest.NewRequest("DELETE", "/api/v1/task/dlq", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTaskService.errPurgeDeadLetters = nil
+	}
+	{
+		tc := "Case 5: Success"
+		mockTaskService.resPurgeDeadLetters = dtores.PurgeDeadLettersResponse{Purged: 1}
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Delete("/api/v1/task/dlq", taskHandler.PurgeDeadLetters)
+		req := httptest.NewRequest("DELETE", "/api/v1/task/dlq", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package templatehandler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+)
+
+// TemplateHandler is the interface for template handler.
+type TemplateHandler interface {
+	AddRoutes(router fiber.Router)
+	CreateTemplate(c *fiber.Ctx) error
+	GetTemplates(c *fiber.Ctx) error
+	GetTemplate(c *fiber.Ctx) error
+	UpdateTemplate(c *fiber.Ctx) error
+	DeleteTemplate(c *fiber.Ctx) error
+	GetTemplateVersions(c *fiber.Ctx) error
+}
+
+// templateHandler is the handler for http requests.
+type templateHandler struct {
+	*basehttphandler.BaseHttpHandler
+	templateService templateservice.TemplateService
+}
+
+// Option is the option type for template handler.
+type Option func(*templateHandler)
+
+// WithBaseHttpHandler sets the base http handler option.
+func WithBaseHttpHandler(handler *basehttphandler.BaseHttpHandler) Option {
+	return func(h *templateHandler) {
+		h.BaseHttpHandler = handler
+	}
+}
+
+// WithTemplateService sets the template service option.
+func WithTemplateService(service templateservice.TemplateService) Option {
+	return func(h *templateHandler) {
+		h.templateService = service
+	}
+}
+
+// New creates a new http handler with the given options.
+func New(opts ...Option) TemplateHandler {
+	h := &templateHandler{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
@@ -0,0 +1,94 @@
+package templatehandler_test
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	dtoreq "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/response"
+	"log/slog"
+)
+
+type mockTemplateService struct {
+	errCreateTemplate      error
+	errUpdateTemplate      error
+	errGetTemplate         error
+	errGetTemplates        error
+	errGetTemplateVersions error
+	errDeleteTemplate      error
+	getTemplate            dtoreq.GetTemplateRequest
+	updateTemplate         dtoreq.UpdateTemplateRequest
+}
+
+func (m *mockTemplateService) CreateTemplate(ctx context.Context, request dtoreq.CreateTemplateRequest) (dtores.TemplateResponse, error) {
+	return dtores.TemplateResponse{}, m.errCreateTemplate
+}
+
+func (m *mockTemplateService) UpdateTemplate(ctx context.Context, request dtoreq.UpdateTemplateRequest) (dtores.TemplateResponse, error) {
+	m.updateTemplate = request
+	return dtores.TemplateResponse{}, m.errUpdateTemplate
+}
+
+func (m *mockTemplateService) GetTemplate(ctx context.Context, request dtoreq.GetTemplateRequest) (dtores.TemplateResponse, error) {
+	m.getTemplate = request
+	return dtores.TemplateResponse{}, m.errGetTemplate
+}
+
+func (m *mockTemplateService) GetTemplates(ctx context.Context, request dtoreq.GetTemplatesRequest) (dtores.GetTemplatesResponse, error) {
+	return dtores.GetTemplatesResponse{}, m.errGetTemplates
+}
+
+func (m *mockTemplateService) GetTemplateVersions(ctx context.Context, request dtoreq.GetTemplateVersionsRequest) (dtores.GetTemplateVersionsResponse, error) {
+	return dtores.GetTemplateVersionsResponse{}, m.errGetTemplateVersions
+}
+
+func (m *mockTemplateService) DeleteTemplate(ctx context.Context, request dtoreq.DeleteTemplateRequest) error {
+	return m.errDeleteTemplate
+}
+
+func (m *mockTemplateService) Resolve(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTemplateService) ResolveAll(ctx context.Context, task model.MailTaskQueue, variables []map[string]interface{}) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+func (m *mockTemplateService) Render(ctx context.Context, task model.MailTaskQueue) (model.MailTaskQueue, error) {
+	return task, nil
+}
+
+type mockValidator struct {
+	errBindAndValidate error
+}
+
+func (m *mockValidator) BindAndValidate(c *fiber.Ctx, data interface{}) error {
+	return m.errBindAndValidate
+}
+
+type mockResponse struct {
+	errBasicError response.ErrorResponse
+	errData       response.DataResponse
+}
+
+func (m *mockResponse) BasicError(d interface{}, status int) response.ErrorResponse {
+	return m.errBasicError
+}
+
+func (m *mockResponse) Data(status int, data interface{}) response.DataResponse {
+	return m.errData
+}
+
+type mockMiddleware struct {
+	errAuthMiddleware        fiber.Handler
+	errHttpLoggingMiddleware fiber.Handler
+}
+
+func (m *mockMiddleware) AuthMiddleware() fiber.Handler {
+	return m.errAuthMiddleware
+}
+
+func (m *mockMiddleware) HttpLoggingMiddleware(logger *slog.Logger, app *fiber.App) fiber.Handler {
+	return m.errHttpLoggingMiddleware
+}
@@ -0,0 +1,144 @@
+package templatehandler
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/releaseinfo"
+)
+
+func (h *templateHandler) AddRoutes(r fiber.Router) {
+	r.Use(h.Middleware.AuthMiddleware())
+	r.Post(releaseinfo.TemplatesApiPath, h.CreateTemplate)
+	r.Get(releaseinfo.TemplatesApiPath, h.GetTemplates)
+	r.Get(releaseinfo.TemplateApiPath, h.GetTemplate)
+	r.Put(releaseinfo.TemplateApiPath, h.UpdateTemplate)
+	r.Delete(releaseinfo.TemplateApiPath, h.DeleteTemplate)
+	r.Get(releaseinfo.TemplateVersionsApiPath, h.GetTemplateVersions)
+}
+
+func (h *templateHandler) CreateTemplate(c *fiber.Ctx) error {
+	var (
+		req dtoreq.CreateTemplateRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.templateService.CreateTemplate(c.Context(), req)
+	if err != nil {
+		return c.Status(templateStatus(err)).JSON(h.Response.BasicError(err, templateStatus(err)))
+	}
+	return c.Status(fiber.StatusCreated).JSON(h.Response.Data(fiber.StatusCreated, res))
+}
+
+func (h *templateHandler) GetTemplates(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetTemplatesRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.templateService.GetTemplates(c.Context(), req)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// GetTemplate returns a template at its latest version, or at the version of
+// the version query parameter.
+func (h *templateHandler) GetTemplate(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetTemplateRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid template id", fiber.StatusBadRequest))
+	}
+	req.TemplateID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.templateService.GetTemplate(c.Context(), req)
+	if err != nil {
+		return c.Status(templateStatus(err)).JSON(h.Response.BasicError(err, templateStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// UpdateTemplate stores the body as the next version of a template.
+func (h *templateHandler) UpdateTemplate(c *fiber.Ctx) error {
+	var (
+		req dtoreq.UpdateTemplateRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid template id", fiber.StatusBadRequest))
+	}
+	req.TemplateID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.templateService.UpdateTemplate(c.Context(), req)
+	if err != nil {
+		return c.Status(templateStatus(err)).JSON(h.Response.BasicError(err, templateStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+func (h *templateHandler) DeleteTemplate(c *fiber.Ctx) error {
+	var (
+		req dtoreq.DeleteTemplateRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid template id", fiber.StatusBadRequest))
+	}
+	req.TemplateID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	if err := h.templateService.DeleteTemplate(c.Context(), req); err != nil {
+		return c.Status(templateStatus(err)).JSON(h.Response.BasicError(err, templateStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, "template deleted successfully"))
+}
+
+func (h *templateHandler) GetTemplateVersions(c *fiber.Ctx) error {
+	var (
+		req dtoreq.GetTemplateVersionsRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError("invalid template id", fiber.StatusBadRequest))
+	}
+	req.TemplateID = uint(id)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	res, err := h.templateService.GetTemplateVersions(c.Context(), req)
+	if err != nil {
+		return c.Status(templateStatus(err)).JSON(h.Response.BasicError(err, templateStatus(err)))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, res))
+}
+
+// templateStatus maps the errors of the template endpoints to a status code.
+func templateStatus(err error) int {
+	switch {
+	case errors.Is(err, templateservice.ErrTemplateNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, templateservice.ErrTemplateExists):
+		return fiber.StatusConflict
+	case errors.Is(err, templateservice.ErrInvalidTemplate), errors.Is(err, templateservice.ErrRender):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
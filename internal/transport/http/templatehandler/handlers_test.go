@@ -0,0 +1,340 @@
+package templatehandler_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/templateservice"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/templatehandler"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_templateHandler_AddRoutes(t *testing.T) {
+	mockTemplateService := &mockTemplateService{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(&mockValidator{}),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithTemplateService(mockTemplateService),
+		templatehandler.WithBaseHttpHandler(basehttphandler),
+	)
+	{
+		tc := "Case 1: Template routes are served behind the auth middleware"
+		app := fiber.New()
+		mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+			c.Locals("userID", uint(1))
+			return c.Next()
+		}
+		templateHandler.AddRoutes(app)
+		t.Run(tc, func(t *testing.T) {
+			for _, route := range []struct{ method, path string }{
+				{"POST", "/api/v1/templates"},
+				{"GET", "/api/v1/templates"},
+				{"GET", "/api/v1/templates/1"},
+				{"PUT", "/api/v1/templates/1"},
+				{"DELETE", "/api/v1/templates/1"},
+				{"GET", "/api/v1/templates/1/versions"},
+			} {
+				resp, err := app.Test(httptest.NewRequest(route.method, route.path, nil))
+				if err != nil {
+					t.Fatalf("expected nil, got %v", err)
+				}
+				if resp.StatusCode == fiber.StatusNotFound || resp.StatusCode == fiber.StatusMethodNotAllowed {
+					t.Errorf("%s: expected %s %s to be routed, got %d", tc, route.method, route.path, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func Test_templateHandler_CreateTemplate(t *testing.T) {
+	mockTemplateService := &mockTemplateService{}
+	mockValidator := &mockValidator{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(mockValidator),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithTemplateService(mockTemplateService),
+		templatehandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Use(mockMiddleware.AuthMiddleware())
+	app.Post("/api/v1/templates", templateHandler.CreateTemplate)
+	{
+		tc := "Case 1: Validation error in request and returns 400"
+		mockValidator.errBindAndValidate = errors.New("validation error")
+		req := httptest.NewRequest("POST", "/api/v1/templates", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockValidator.errBindAndValidate = nil
+	}
+	{
+		tc := "Case 2: Template that can not be parsed returns 400"
+		mockTemplateService.errCreateTemplate = fmt.Errorf("%w: unclosed action", templateservice.ErrInvalidTemplate)
+		req := httptest.NewRequest("POST", "/api/v1/templates", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errCreateTemplate = nil
+	}
+	{
+		tc := "Case 3: Name the user already has returns 409"
+		mockTemplateService.errCreateTemplate = templateservice.ErrTemplateExists
+		req := httptest.NewRequest("POST", "/api/v1/templates", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusConflict {
+				t.Fatalf("expected %d, got %d", fiber.StatusConflict, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errCreateTemplate = nil
+	}
+	{
+		tc := "Case 4: Template service returns error and returns 500"
+		mockTemplateService.errCreateTemplate = errors.New("template service error")
+		req := httptest.NewRequest("POST", "/api/v1/templates", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errCreateTemplate = nil
+	}
+	{
+		tc := "Case 5: Success"
+		req := httptest.NewRequest("POST", "/api/v1/templates", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusCreated {
+				t.Fatalf("expected %d, got %d", fiber.StatusCreated, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_templateHandler_GetTemplate(t *testing.T) {
+	mockTemplateService := &mockTemplateService{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(&mockValidator{}),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithTemplateService(mockTemplateService),
+		templatehandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Use(mockMiddleware.AuthMiddleware())
+	app.Get("/api/v1/templates/:id", templateHandler.GetTemplate)
+	{
+		tc := "Case 1: Invalid template id in path and returns 400"
+		req := httptest.NewRequest("GET", "/api/v1/templates/abc", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Template of another user returns 404"
+		mockTemplateService.errGetTemplate = templateservice.ErrTemplateNotFound
+		req := httptest.NewRequest("GET", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errGetTemplate = nil
+	}
+	{
+		tc := "Case 3: Success, template of the path requested"
+		req := httptest.NewRequest("GET", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+			if got := mockTemplateService.getTemplate; got.UserID != 1 || got.TemplateID != 3 {
+				t.Fatalf("expected template 3 of user 1, got %+v", got)
+			}
+		})
+	}
+}
+
+func Test_templateHandler_UpdateTemplate(t *testing.T) {
+	mockTemplateService := &mockTemplateService{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(&mockValidator{}),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithTemplateService(mockTemplateService),
+		templatehandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Use(mockMiddleware.AuthMiddleware())
+	app.Put("/api/v1/templates/:id", templateHandler.UpdateTemplate)
+	{
+		tc := "Case 1: Layout that is not a partial returns 400"
+		mockTemplateService.errUpdateTemplate = fmt.Errorf("%w: layout welcome is not a partial", templateservice.ErrInvalidTemplate)
+		req := httptest.NewRequest("PUT", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errUpdateTemplate = nil
+	}
+	{
+		tc := "Case 2: Success, template of the path updated"
+		req := httptest.NewRequest("PUT", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+			if got := mockTemplateService.updateTemplate; got.TemplateID != 3 {
+				t.Fatalf("expected template 3, got %d", got.TemplateID)
+			}
+		})
+	}
+}
+
+func Test_templateHandler_DeleteTemplate(t *testing.T) {
+	mockTemplateService := &mockTemplateService{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithValidator(&mockValidator{}),
+		pkg.WithResponse(&mockResponse{}),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	templateHandler := templatehandler.New(
+		templatehandler.WithTemplateService(mockTemplateService),
+		templatehandler.WithBaseHttpHandler(basehttphandler),
+	)
+	mockMiddleware.errAuthMiddleware = func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	app := fiber.New()
+	app.Use(mockMiddleware.AuthMiddleware())
+	app.Delete("/api/v1/templates/:id", templateHandler.DeleteTemplate)
+	{
+		tc := "Case 1: Template not found and returns 404"
+		mockTemplateService.errDeleteTemplate = templateservice.ErrTemplateNotFound
+		req := httptest.NewRequest("DELETE", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Fatalf("expected %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+			}
+		})
+		mockTemplateService.errDeleteTemplate = nil
+	}
+	{
+		tc := "Case 2: Success"
+		req := httptest.NewRequest("DELETE", "/api/v1/templates/3", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ type UserHandler interface {
 	Register(c *fiber.Ctx) error
 	Login(c *fiber.Ctx) error
 	GetUser(c *fiber.Ctx) error
+	UpdateSmtpSettings(c *fiber.Ctx) error
 }
 
 // userHandler is the handler for http requests.
@@ -1,8 +1,10 @@
 package userhandler
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/req"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/releaseinfo"
 )
 
@@ -11,6 +13,7 @@ func (h *userHandler) AddRoutes(r fiber.Router) {
 	r.Post(releaseinfo.LoginUserApiPath, h.Login)
 	r.Use(h.Middleware.AuthMiddleware())
 	r.Get(releaseinfo.GetUserApiPath, h.GetUser)
+	r.Put(releaseinfo.UpdateSmtpSettingsApiPath, h.UpdateSmtpSettings)
 }
 
 func (h *userHandler) Register(c *fiber.Ctx) error {
@@ -21,6 +24,9 @@ func (h *userHandler) Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
 	}
 	if err := h.userService.Register(c.Context(), req); err != nil {
+		if errors.Is(err, mailservice.ErrProviderNotAllowed) {
+			return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
 	}
 	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusCreated, "user registered successfully"))
@@ -54,3 +60,19 @@ func (h *userHandler) GetUser(c *fiber.Ctx) error {
 	}
 	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, user))
 }
+
+// UpdateSmtpSettings sets the SMTP settings of the logged in user and lifts the
+// pause of a user whose settings the server rejected.
+func (h *userHandler) UpdateSmtpSettings(c *fiber.Ctx) error {
+	var (
+		req dtoreq.UpdateSmtpSettingsRequest
+	)
+	req.UserID = c.Locals("userID").(uint)
+	if err := h.Validator.BindAndValidate(c, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(h.Response.BasicError(err, fiber.StatusBadRequest))
+	}
+	if err := h.userService.UpdateSmtpSettings(c.Context(), req); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(h.Response.BasicError(err, fiber.StatusInternalServerError))
+	}
+	return c.Status(fiber.StatusOK).JSON(h.Response.Data(fiber.StatusOK, "smtp settings updated successfully"))
+}
@@ -2,8 +2,10 @@ package userhandler_test
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	dtores "github.com/yigithankarabulut/distributed-mail-queue-service/internal/dto/res"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/service/mailservice"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/basehttphandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/internal/transport/http/userhandler"
 	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg"
@@ -111,7 +113,24 @@ func Test_userHandler_Register(t *testing.T) {
 		mockUserService.errRegister = nil
 	}
 	{
-		tc := "Case 3: Successful registration"
+		tc := "Case 3: Provider not allowed and returns 400"
+		mockUserService.errRegister = fmt.Errorf("%w: log is disabled", mailservice.ErrProviderNotAllowed)
+		app := fiber.New()
+		app.Post("/api/v1/register", userHandler.Register)
+		req := httptest.NewRequest("POST", "/api/v1/register", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockUserService.errRegister = nil
+	}
+	{
+		tc := "Case 4: Successful registration"
 		app := fiber.New()
 		app.Post("/api/v1/register", userHandler.Register)
 		req := httptest.NewRequest("POST", "/api/v1/register", nil)
@@ -327,3 +346,92 @@ func Test_userHandler_GetUser(t *testing.T) {
 		mockMiddleware.errAuthMiddleware = nil
 	}
 }
+
+func Test_userHandler_UpdateSmtpSettings(t *testing.T) {
+	mockTaskService := &mockTaskService{}
+	mockUserService := &mockUserService{}
+	mockJwtUtils := &mockJwtUtils{}
+	mockValidator := &mockValidator{}
+	mockPassUtils := &mockPassUtils{}
+	mockResponse := &mockResponse{}
+	mockMiddleware := &mockMiddleware{}
+	pkgs := pkg.New(
+		pkg.WithJwtUtils(mockJwtUtils),
+		pkg.WithValidator(mockValidator),
+		pkg.WithPassUtils(mockPassUtils),
+		pkg.WithResponse(mockResponse),
+		pkg.WithMiddleware(mockMiddleware),
+	)
+	basehttphandler := basehttphandler.New(
+		basehttphandler.WithPackages(pkgs),
+		basehttphandler.WithContextTimeout(10),
+		basehttphandler.WithLogger(nil),
+	)
+	userHandler := userhandler.New(
+		userhandler.WithBaseHttpHandler(basehttphandler),
+		userhandler.WithUserService(mockUserService),
+		userhandler.WithTaskService(mockTaskService),
+	)
+	loggedIn := func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(1))
+		return c.Next()
+	}
+	{
+		tc := "Case 1: Validation error in request and returns 400"
+		mockMiddleware.errAuthMiddleware = loggedIn
+		mockValidator.errBindAndValidate = errors.New("smtp_port must be at most 65535")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Put("/api/v1/user/smtp", userHandler.UpdateSmtpSettings)
+		req := httptest.NewRequest("PUT", "/api/v1/user/smtp", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("expected %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+			}
+		})
+		mockValidator.errBindAndValidate = nil
+		mockMiddleware.errAuthMiddleware = nil
+	}
+	{
+		tc := "Case 2: Error in user service and returns 500"
+		mockMiddleware.errAuthMiddleware = loggedIn
+		mockUserService.errUpdate = errors.New("error updating smtp settings")
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Put("/api/v1/user/smtp", userHandler.UpdateSmtpSettings)
+		req := httptest.NewRequest("PUT", "/api/v1/user/smtp", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
+			}
+		})
+		mockUserService.errUpdate = nil
+		mockMiddleware.errAuthMiddleware = nil
+	}
+	{
+		tc := "Case 3: Successful update of smtp settings"
+		mockMiddleware.errAuthMiddleware = loggedIn
+		app := fiber.New()
+		app.Use(mockMiddleware.AuthMiddleware())
+		app.Put("/api/v1/user/smtp", userHandler.UpdateSmtpSettings)
+		req := httptest.NewRequest("PUT", "/api/v1/user/smtp", nil)
+		t.Run(tc, func(t *testing.T) {
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("expected %d, got %d", fiber.StatusOK, resp.StatusCode)
+			}
+		})
+		mockMiddleware.errAuthMiddleware = nil
+	}
+}
@@ -0,0 +1,16 @@
+package model
+
+import "gorm.io/gorm"
+
+// MailAttachment is a struct that represent the mail attachments table in the
+// database. The content of an attachment is kept in the blob store under
+// BlobKey, TaskID is 0 until the attachment is enqueued with a task.
+type MailAttachment struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index"`
+	TaskID      uint   `gorm:"not null;default:0;index"`
+	Filename    string `gorm:"not null"`
+	ContentType string `gorm:"not null"`
+	Size        int64  `gorm:"not null"`
+	BlobKey     string `gorm:"not null;uniqueIndex"`
+}
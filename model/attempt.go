@@ -0,0 +1,25 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// MailTaskAttempt is a struct that represent the mail task attempts table in the
+// database. An attempt is recorded for every time a worker tried to send the
+// mail of a task with a transport, the provider of its user. ResponseCode is
+// the SMTP reply code or the HTTP status of a failed send, 0 when the mail was
+// sent or failed before the server replied, the transports do not report the
+// replies to sent mails.
+type MailTaskAttempt struct {
+	gorm.Model
+	TaskID          uint `gorm:"not null;index"`
+	WorkerID        string
+	StartedAt       time.Time
+	FinishedAt      time.Time
+	DurationMs      int64
+	Transport       string
+	ResponseCode    int
+	ResponseMessage string
+	ErrorClass      string
+}
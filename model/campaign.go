@@ -0,0 +1,38 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// MailCampaign is a struct that represent the mail campaigns table in the
+// database. A campaign sends a version of a template to every row of its
+// recipient list, the rows are fanned out into tasks in batches once the
+// campaign is due. The partials of the template are pinned at their versions
+// when the campaign is created. Dispatched is the number of rows that have a task and
+// LastRecipientID the last of them, the rows are fanned out in ID order.
+type MailCampaign struct {
+	gorm.Model
+	UserID           uint `gorm:"not null;index"`
+	Name             string
+	TemplateID       uint
+	TemplateVersion  int
+	TemplatePartials []MailTemplatePartial `gorm:"serializer:json"`
+	Status           int                   `gorm:"default:0;index"`
+	ScheduledAt      time.Time
+	FromName         string
+	ReplyTo          string
+	Total            int
+	Dispatched       int
+	LastRecipientID  uint
+	CompletedAt      time.Time
+}
+
+// MailCampaignRecipient is a row of the recipient list of a campaign, the
+// Variables of the row are the variables of the template of its mail.
+type MailCampaignRecipient struct {
+	ID         uint                   `gorm:"primarykey"`
+	CampaignID uint                   `gorm:"not null;index"`
+	Email      string                 `gorm:"not null"`
+	Variables  map[string]interface{} `gorm:"serializer:json"`
+}
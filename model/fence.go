@@ -0,0 +1,9 @@
+package model
+
+// CronFence is a struct that represent the cron fence table in the database. It
+// holds the highest fencing token a cron job wrote with, the writes of a tick
+// whose token is lower are refused.
+type CronFence struct {
+	Name  string `gorm:"primaryKey"`
+	Token int64  `gorm:"not null"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// TaskOutbox is a struct that represent the task outbox table in the database.
+// An entry is inserted in the transaction of its task and waits until the relay
+// hands the task off to the queue.
+type TaskOutbox struct {
+	gorm.Model
+	TaskID      uint `gorm:"not null;index"`
+	TraceID     string
+	Attempts    int `gorm:"default:0"`
+	LastError   string
+	DeliveredAt *time.Time `gorm:"index"`
+}
@@ -13,7 +13,70 @@ type MailTaskQueue struct {
 	Status         int    `gorm:"default:0"`
 	TryCount       int    `gorm:"default:0"`
 	RecipientEmail string `gorm:"not null"`
-	Subject        string
+	// Recipients are the To, CC and BCC recipients of the mail with their
+	// delivery status, RecipientEmail is the first To recipient. Tasks
+	// enqueued before Recipients only have RecipientEmail.
+	Recipients []Recipient `gorm:"serializer:json"`
+	FromName   string
+	ReplyTo    string
+	Subject    string
+	// Body is the plain text body of the tasks enqueued before TextBody and
+	// HTMLBody, the text body of the mail when TextBody is empty.
 	Body           string
+	TextBody       string
+	HTMLBody       string
 	ScheduledAt    time.Time
+	Priority       int  `gorm:"default:0"`
+	DeadLettered   bool `gorm:"default:false"`
+	LastError      string
+	FailureReason  string
+	TraceID        string `gorm:"-"`
+	LeaseID        string `gorm:"-"`
+	LeasedBy       string
+	LeaseExpiresAt time.Time
+	NextAttemptAt  time.Time
+	// ProcessingBy is the worker that sends the mail of the task, as
+	// <pod>/<worker id>, and ProcessingStartedAt the time it started.
+	ProcessingBy        string
+	ProcessingStartedAt time.Time
+	// TemplateID is the template the subject and bodies of the task are
+	// rendered from by the workers, at TemplateVersion with TemplateVariables
+	// and the partials of TemplatePartials. Tasks enqueued before partials
+	// were pinned have none and are rendered with the current partials.
+	TemplateID        uint
+	TemplateVersion   int
+	TemplateVariables map[string]interface{} `gorm:"serializer:json"`
+	TemplatePartials  []MailTemplatePartial  `gorm:"serializer:json"`
+	// CampaignID is the campaign the task was fanned out from.
+	CampaignID uint `gorm:"index"`
+	// Attachments are loaded by the workers before the mail is sent.
+	Attachments []MailAttachment `gorm:"-"`
+	RetryPolicy
+}
+
+// The kinds of the recipients of a mail.
+const (
+	RecipientTo  = "to"
+	RecipientCC  = "cc"
+	RecipientBCC = "bcc"
+)
+
+// Recipient is a recipient of a mail and its delivery status, one of the task
+// statuses. The recipients the server rejected for good are not sent to again,
+// the failed ones are sent again with the next try of the task. Code is the
+// reply code of the server to the recipient.
+type Recipient struct {
+	Email  string `json:"email"`
+	Kind   string `json:"kind"`
+	Status int    `json:"status"`
+	Code   int    `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RetryPolicy overrides the retry policy of the service for the tasks of a user
+// or for a single task, zero values inherit the policy. Delays are in seconds.
+type RetryPolicy struct {
+	MaxAttempts    int
+	RetryBaseDelay int
+	RetryMaxDelay  int
 }
@@ -0,0 +1,43 @@
+package model
+
+import "gorm.io/gorm"
+
+// MailTemplate is a struct that represent the mail templates table in the
+// database. The subject and text body of a template are text/template
+// templates, the HTML body an html/template template. Partials are included
+// by their name from the other templates of the user, a template with a
+// Layout is rendered into the "content" template of that partial. Version is
+// the latest version of the template, every version is kept in the mail
+// template versions table.
+type MailTemplate struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;uniqueIndex:idx_mail_templates_user_name"`
+	Name     string `gorm:"not null;uniqueIndex:idx_mail_templates_user_name"`
+	Partial  bool   `gorm:"not null;default:false"`
+	Version  int    `gorm:"not null;default:1"`
+	Layout   string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// MailTemplatePartial is a partial a task or campaign is rendered with, pinned
+// to the version the partial had when the task or campaign was created.
+type MailTemplatePartial struct {
+	Name       string `json:"name"`
+	TemplateID uint   `json:"template_id"`
+	Version    int    `json:"version"`
+}
+
+// MailTemplateVersion is a version of a mail template. Versions are never
+// changed, so a task is rendered from the version it was enqueued with even if
+// its template was updated or deleted since.
+type MailTemplateVersion struct {
+	gorm.Model
+	TemplateID uint `gorm:"not null;uniqueIndex:idx_mail_template_versions_version"`
+	Version    int  `gorm:"not null;uniqueIndex:idx_mail_template_versions_version"`
+	Layout     string
+	Subject    string
+	HTMLBody   string
+	TextBody   string
+}
@@ -1,6 +1,9 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 // User struct
 type User struct {
@@ -11,4 +14,23 @@ type User struct {
 	SmtpPort     int    `gorm:"not null"`
 	SmtpUsername string `gorm:"not null"`
 	SmtpPassword string `gorm:"not null"`
+	// SmtpAuthError flags SMTP settings the server rejected, the tasks of the
+	// user are not sent before SmtpPausedUntil.
+	SmtpAuthError   string
+	SmtpPausedUntil time.Time
+	// Provider is the transport the mails of the user are sent with, smtp by
+	// default. The Smtp fields are the settings of smtp, ProviderSettings the
+	// settings of the other providers.
+	Provider         string           `gorm:"not null;default:smtp"`
+	ProviderSettings ProviderSettings `gorm:"serializer:json"`
+	RetryPolicy
+}
+
+// ProviderSettings are the settings of the mail providers other than smtp. URL
+// and APIKey are the endpoint and the key of the http provider, Dir is the
+// directory the file provider writes the mails to.
+type ProviderSettings struct {
+	URL    string `json:"url,omitempty"`
+	APIKey string `json:"api_key,omitempty"`
+	Dir    string `json:"dir,omitempty"`
 }
@@ -0,0 +1,124 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Open when the store has no blob with the key.
+var ErrNotFound = errors.New("blobstore: blob not found")
+
+// ErrInvalidKey is returned for keys that are not a single path element.
+var ErrInvalidKey = errors.New("blobstore: invalid key")
+
+// Store is an interface for storing blobs, such as the attachments of mails,
+// by key. The blobs of a store must be reachable from every pod that reads them.
+type Store interface {
+	// Put stores the content of the reader under the key and returns its size.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns a reader of the blob, closing it releases the blob.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// fileStore keeps the blobs as files of a directory.
+type fileStore struct {
+	dir string
+}
+
+type Option func(*fileStore)
+
+// WithDir sets the directory of the blobs, "attachments" by default. Pods share
+// the blobs by mounting the same volume at the directory.
+func WithDir(dir string) Option {
+	return func(s *fileStore) {
+		s.dir = dir
+	}
+}
+
+// New creates a store on the local filesystem.
+func New(opts ...Option) Store {
+	s := &fileStore{dir: "attachments"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *fileStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes the blob to a temporary file that is renamed once it is complete,
+// so a blob is never read before it is written in full.
+func (s *fileStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(s.dir, "."+key+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, readerWithContext{ctx: ctx, r: r})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *fileStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readerWithContext stops reading once the context is done, so a cancelled
+// upload is not written in full.
+type readerWithContext struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerWithContext) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
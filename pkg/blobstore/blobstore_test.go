@@ -0,0 +1,69 @@
+package blobstore_test
+
+import (
+	"context"
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/blobstore"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_fileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store := blobstore.New(blobstore.WithDir(dir))
+	ctx := context.Background()
+	{
+		tc := "Case 1: Blob Put And Read Back"
+		n, err := store.Put(ctx, "key", strings.NewReader("content"))
+		var data []byte
+		if err == nil {
+			var rc io.ReadCloser
+			if rc, err = store.Open(ctx, "key"); err == nil {
+				data, err = io.ReadAll(rc)
+				rc.Close()
+			}
+		}
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || n != 7 || string(data) != "content" {
+				t.Errorf("Expected 7 bytes of content, got %d %q %v", n, data, err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Deleted Blob Not Found And Deleted Again Without Error"
+		err := store.Delete(ctx, "key")
+		_, openErr := store.Open(ctx, "key")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || !errors.Is(openErr, blobstore.ErrNotFound) {
+				t.Errorf("Expected deleted blob, got %v %v", err, openErr)
+			}
+			if err := store.Delete(ctx, "key"); err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Key With Path Rejected"
+		_, err := store.Put(ctx, "../key", strings.NewReader("content"))
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, blobstore.ErrInvalidKey) {
+				t.Errorf("Expected invalid key, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Cancelled Put Leaves No Blob"
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.Put(cancelled, "cancelled", strings.NewReader("content"))
+		files, _ := os.ReadDir(dir)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || len(files) != 0 {
+				t.Errorf("Expected error and no files, got %v %v", err, files)
+			}
+		})
+	}
+}
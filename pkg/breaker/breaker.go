@@ -0,0 +1,249 @@
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// The states of a circuit.
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// Breaker is an interface for circuit breakers around SMTP endpoints shared by
+// all pods. A circuit opens after consecutive failures of its endpoint, stays
+// open for the cooldown and then lets a single probe through, the result of
+// the probe closes the circuit or opens it again.
+type Breaker interface {
+	// Allow reports whether a mail can be sent to the endpoint.
+	Allow(ctx context.Context, endpoint string) (Result, error)
+	// Success records that the endpoint could be reached and closes its circuit.
+	Success(ctx context.Context, endpoint string) (Result, error)
+	// Failure records that the endpoint could not be reached.
+	Failure(ctx context.Context, endpoint string, reason error) (Result, error)
+	// Release gives up the probe of a half open circuit that was let through
+	// but did not send, so the next mail is let through as the probe.
+	Release(ctx context.Context, endpoint string) error
+	// Status returns the circuit of the endpoint.
+	Status(ctx context.Context, endpoint string) (Status, error)
+}
+
+// Result is the state of a circuit after a call. Changed is set when the call
+// moved the circuit to the state, a call that is not allowed tells how long to
+// wait before trying again.
+type Result struct {
+	Allowed    bool
+	State      string
+	Changed    bool
+	RetryAfter time.Duration
+}
+
+// Status is the circuit of an endpoint as shown to its users.
+type Status struct {
+	State     string
+	Failures  int
+	OpenUntil time.Time
+	LastError string
+}
+
+// allowScript lets mails through a closed circuit. An open circuit whose
+// cooldown passed becomes half open and lets the caller through as its probe,
+// other callers wait until the probe times out.
+// KEYS[1] = circuit; ARGV[1] = probe timeout in ms, ARGV[2] = ttl in ms.
+var allowScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local circuit = redis.call('HMGET', KEYS[1], 'state', 'open_until', 'probe_until')
+local state = circuit[1] or 'closed'
+if state == 'closed' then
+	return {1, state, 0, 0}
+end
+local wait = tonumber(circuit[3]) or 0
+if state == 'open' then
+	wait = tonumber(circuit[2])
+end
+if wait > now then
+	return {0, state, 0, wait - now}
+end
+redis.call('HSET', KEYS[1], 'state', 'half_open', 'probe_until', now + tonumber(ARGV[1]))
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {1, 'half_open', state == 'open' and 1 or 0, 0}
+`)
+
+// successScript closes a circuit.
+// KEYS[1] = circuit.
+var successScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+redis.call('DEL', KEYS[1])
+if state and state ~= 'closed' then
+	return {'closed', 1}
+end
+return {'closed', 0}
+`)
+
+// failureScript counts a failure of a circuit. It opens a closed circuit that
+// reached the threshold and a half open circuit whose probe failed.
+// KEYS[1] = circuit; ARGV[1] = threshold, ARGV[2] = cooldown in ms, ARGV[3] = ttl in ms,
+// ARGV[4] = reason.
+var failureScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local circuit = redis.call('HMGET', KEYS[1], 'state', 'failures')
+local state = circuit[1] or 'closed'
+local failures = (tonumber(circuit[2]) or 0) + 1
+redis.call('HSET', KEYS[1], 'state', state, 'failures', failures, 'last_error', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+if state == 'half_open' or (state == 'closed' and failures >= tonumber(ARGV[1])) then
+	redis.call('HSET', KEYS[1], 'state', 'open', 'open_until', now + tonumber(ARGV[2]))
+	return {'open', 1}
+end
+return {state, 0}
+`)
+
+// releaseScript ends the probe of a half open circuit without a result.
+// KEYS[1] = circuit.
+var releaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') == 'half_open' then
+	redis.call('HSET', KEYS[1], 'probe_until', 0)
+end
+return 0
+`)
+
+type redisBreaker struct {
+	rdb          *redis.Client
+	prefix       string
+	threshold    int
+	cooldown     time.Duration
+	probeTimeout time.Duration
+	ttl          time.Duration
+}
+
+type Option func(*redisBreaker)
+
+func WithRedisClient(rdb *redis.Client) Option {
+	return func(b *redisBreaker) {
+		b.rdb = rdb
+	}
+}
+
+// WithPrefix sets the prefix of the circuit keys, "breaker" by default.
+func WithPrefix(prefix string) Option {
+	return func(b *redisBreaker) {
+		b.prefix = prefix
+	}
+}
+
+// WithThreshold sets the consecutive failures that open a circuit, 5 by default.
+func WithThreshold(n int) Option {
+	return func(b *redisBreaker) {
+		b.threshold = n
+	}
+}
+
+// WithCooldown sets how long a circuit stays open before a probe is let
+// through, 1 minute by default.
+func WithCooldown(d time.Duration) Option {
+	return func(b *redisBreaker) {
+		b.cooldown = d
+	}
+}
+
+// WithProbeTimeout sets how long a half open circuit waits for the result of
+// its probe before it lets another one through, 1 minute by default.
+func WithProbeTimeout(d time.Duration) Option {
+	return func(b *redisBreaker) {
+		b.probeTimeout = d
+	}
+}
+
+// WithTTL sets how long a circuit without calls is kept, 24 hours by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(b *redisBreaker) {
+		b.ttl = ttl
+	}
+}
+
+func New(opts ...Option) Breaker {
+	b := &redisBreaker{
+		prefix:       "breaker",
+		threshold:    5,
+		cooldown:     time.Minute,
+		probeTimeout: time.Minute,
+		ttl:          24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *redisBreaker) key(endpoint string) string {
+	return fmt.Sprintf("%s:%s", b.prefix, endpoint)
+}
+
+func (b *redisBreaker) Allow(ctx context.Context, endpoint string) (Result, error) {
+	res, err := allowScript.Run(ctx, b.rdb, []string{b.key(endpoint)},
+		b.probeTimeout.Milliseconds(), b.ttl.Milliseconds()).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, _ := res[0].(int64)
+	state, _ := res[1].(string)
+	changed, _ := res[2].(int64)
+	wait, _ := res[3].(int64)
+	return Result{
+		Allowed:    allowed == 1,
+		State:      state,
+		Changed:    changed == 1,
+		RetryAfter: time.Duration(wait) * time.Millisecond,
+	}, nil
+}
+
+func (b *redisBreaker) Success(ctx context.Context, endpoint string) (Result, error) {
+	res, err := successScript.Run(ctx, b.rdb, []string{b.key(endpoint)}).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return resultOf(res), nil
+}
+
+func (b *redisBreaker) Failure(ctx context.Context, endpoint string, reason error) (Result, error) {
+	res, err := failureScript.Run(ctx, b.rdb, []string{b.key(endpoint)},
+		b.threshold, b.cooldown.Milliseconds(), b.ttl.Milliseconds(), reason.Error()).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return resultOf(res), nil
+}
+
+func (b *redisBreaker) Release(ctx context.Context, endpoint string) error {
+	return releaseScript.Run(ctx, b.rdb, []string{b.key(endpoint)}).Err()
+}
+
+func resultOf(res []interface{}) Result {
+	state, _ := res[0].(string)
+	changed, _ := res[1].(int64)
+	return Result{Allowed: state != StateOpen, State: state, Changed: changed == 1}
+}
+
+// Status reads the circuit of the endpoint, endpoints without one are closed.
+func (b *redisBreaker) Status(ctx context.Context, endpoint string) (Status, error) {
+	circuit, err := b.rdb.HGetAll(ctx, b.key(endpoint)).Result()
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{State: StateClosed, LastError: circuit["last_error"]}
+	if state, ok := circuit["state"]; ok {
+		status.State = state
+	}
+	status.Failures, _ = strconv.Atoi(circuit["failures"])
+	if ms, err := strconv.ParseInt(circuit["open_until"], 10, 64); err == nil && status.State == StateOpen {
+		status.OpenUntil = time.UnixMilli(ms)
+	}
+	return status, nil
+}
@@ -0,0 +1,194 @@
+package breaker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/breaker"
+	"testing"
+	"time"
+)
+
+// ignoreSha matches script calls without comparing the sha of the script.
+func ignoreSha(expected, actual []interface{}) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if i != 1 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	return nil
+}
+
+var circuitKeys = []string{"breaker:smtp.test.com:587"}
+
+func Test_redisBreaker_Allow(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	b := breaker.New(breaker.WithRedisClient(rdb), breaker.WithProbeTimeout(30*time.Second))
+	{
+		tc := "Case 1: Redis Error And Return Error"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys, int64(30000), int64(86400000)).
+			SetErr(errors.New("error"))
+		_, err := b.Allow(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Open Circuit Not Allowed With Wait"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys, int64(30000), int64(86400000)).
+			SetVal([]interface{}{int64(0), "open", int64(0), int64(1500)})
+		res, err := b.Allow(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if res.Allowed || res.State != breaker.StateOpen || res.RetryAfter != 1500*time.Millisecond {
+				t.Errorf("Expected open circuit not allowed for 1.5s, got %v", res)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Circuit After Cooldown Allowed As Probe"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys, int64(30000), int64(86400000)).
+			SetVal([]interface{}{int64(1), "half_open", int64(1), int64(0)})
+		res, err := b.Allow(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !res.Allowed || res.State != breaker.StateHalfOpen || !res.Changed {
+				t.Errorf("Expected circuit to become half open, got %v", res)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_redisBreaker_Failure(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	b := breaker.New(breaker.WithRedisClient(rdb), breaker.WithThreshold(3), breaker.WithCooldown(time.Minute))
+	{
+		tc := "Case 1: Circuit Opened By Failure"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys, 3, int64(60000), int64(86400000), "connection refused").
+			SetVal([]interface{}{"open", int64(1)})
+		res, err := b.Failure(context.Background(), "smtp.test.com:587", errors.New("connection refused"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if res.Allowed || res.State != breaker.StateOpen || !res.Changed {
+				t.Errorf("Expected circuit to be opened, got %v", res)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Failure Below Threshold Keeps Circuit Closed"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys, 3, int64(60000), int64(86400000), "connection refused").
+			SetVal([]interface{}{"closed", int64(0)})
+		res, err := b.Failure(context.Background(), "smtp.test.com:587", errors.New("connection refused"))
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !res.Allowed || res.State != breaker.StateClosed || res.Changed {
+				t.Errorf("Expected circuit to stay closed, got %v", res)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_redisBreaker_Success(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	b := breaker.New(breaker.WithRedisClient(rdb))
+	{
+		tc := "Case 1: Circuit Closed By Success"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys).
+			SetVal([]interface{}{"closed", int64(1)})
+		res, err := b.Success(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if res.State != breaker.StateClosed || !res.Changed {
+				t.Errorf("Expected circuit to be closed, got %v", res)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_redisBreaker_Release(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	b := breaker.New(breaker.WithRedisClient(rdb))
+	{
+		tc := "Case 1: Probe Of Half Open Circuit Released"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys).SetVal(int64(0))
+		err := b.Release(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Redis Error And Return Error"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", circuitKeys).SetErr(errors.New("error"))
+		err := b.Release(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_redisBreaker_Status(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	b := breaker.New(breaker.WithRedisClient(rdb))
+	{
+		tc := "Case 1: Endpoint Without Circuit Is Closed"
+		mockClient.ExpectHGetAll("breaker:smtp.test.com:587").SetVal(map[string]string{})
+		status, err := b.Status(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || status.State != breaker.StateClosed {
+				t.Errorf("Expected closed circuit, got %v %v", status, err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Open Circuit With Failures And Last Error"
+		mockClient.ExpectHGetAll("breaker:smtp.test.com:587").SetVal(map[string]string{
+			"state":      "open",
+			"failures":   "5",
+			"open_until": "1700000000000",
+			"last_error": "connection refused",
+		})
+		status, err := b.Status(context.Background(), "smtp.test.com:587")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			expected := breaker.Status{State: breaker.StateOpen, Failures: 5, OpenUntil: time.UnixMilli(1700000000000), LastError: "connection refused"}
+			if status != expected {
+				t.Errorf("Expected %v, got %v", expected, status)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
@@ -4,9 +4,67 @@ import "time"
 
 const (
 	RedisMailQueueChannel = "mail_queue"
+	RedisMailQueueGroup   = "mail_workers"
 	QueueConsumerCount    = 10
 	WorkerCount           = 10
 	MaxTryCount           = 3
+	QueueReapBatchSize    = 100
+	QueueUserConcurrency  = 0
+	DeadLetterPageSize    = 20
+	QueueEnvelopeVersion  = 1
+	OutboxBatchSize       = 100
+	SmtpPoolMaxConns      = 5
+	BreakerThreshold      = 5
+	AttachmentMaxPerTask  = 10
+	AttachmentCleanupSize = 100
+	CampaignBatchSize     = 500
+	CampaignMaxRecipients = 100000
+)
+
+// TemplateMaxOutputSize is the size limit in bytes of the subject and each body
+// rendered from a template, a larger output fails the render.
+const TemplateMaxOutputSize = 1 << 20
+
+// Names of the cron jobs whose writes are fenced by the token of their lock.
+const (
+	CronJobReconcile = "FindUnprocessedTasksAndEnqueue"
+	CronJobPromote   = "PromoteScheduledTasks"
+)
+
+// Namespaces of the postgres advisory locks, the second key of a lock is the ID
+// of the locked row.
+const (
+	AttachmentQuotaLock = 1
+)
+
+// Limits of the attachments of mails in bytes. The body limit of the server
+// leaves room for the attachments of a task encoded in base64, the read timeout
+// of the server is long enough to read it at about 6 Mbit/s.
+const (
+	AttachmentMaxSize     = 10 << 20
+	AttachmentMaxTaskSize = 25 << 20
+	AttachmentUserQuota   = 100 << 20
+	ServerBodyLimit       = 40 << 20
+)
+
+const (
+	QueueBackendList     = "list"
+	QueueBackendStream   = "stream"
+	QueueBackendPostgres = "postgres"
+)
+
+const (
+	PriorityNormal = iota
+	PriorityHigh
+	PriorityBulk
+)
+
+// Weights of the priority lanes, out of every 10 polls a consumer tries the
+// high lane first 6 times, the normal lane 3 times and the bulk lane once.
+const (
+	PriorityWeightHigh   = 6
+	PriorityWeightNormal = 3
+	PriorityWeightBulk   = 1
 )
 
 const (
@@ -16,6 +74,17 @@ const (
 	StatusFailed
 	StatusCancelled
 	StatusScheduled
+	StatusRejected
+)
+
+// Statuses of the campaigns, a campaign is running once its first batch of
+// recipients is fanned out into tasks.
+const (
+	CampaignStatusScheduled = iota
+	CampaignStatusRunning
+	CampaignStatusPaused
+	CampaignStatusCompleted
+	CampaignStatusCancelled
 )
 
 const (
@@ -27,8 +96,25 @@ const (
 const (
 	ContextCancelTimeout = 5 * time.Second
 	ShutdownTimeout      = 2 * time.Second
-	ServerReadTimeout    = 5 * time.Second
+	ShutdownDrainTimeout = 25 * time.Second
+	ServerReadTimeout    = time.Minute
 	ServerWriteTimeout   = 5 * time.Second
 	ServerIdleTimeout    = 5 * time.Second
 	TaskCancelTimeout    = 5 * time.Second
+	QueueLeaseTimeout    = 30 * time.Second
+	QueueReapInterval    = 15 * time.Second
+	QueuePollInterval    = 100 * time.Millisecond
+	OutboxRelayInterval  = 200 * time.Millisecond
+	OutboxRetention      = 24 * time.Hour
+	CronLockTTL          = 30 * time.Second
+	RetryBaseDelay       = 30 * time.Second
+	RetryMaxDelay        = time.Hour
+	SmtpAuthPause        = 15 * time.Minute
+	SmtpPoolIdleTimeout  = 30 * time.Second
+	SmtpPoolWaitTimeout  = 10 * time.Second
+	RateLimitPermitTTL   = time.Minute
+	BreakerCooldown      = time.Minute
+	BreakerProbeTimeout  = time.Minute
+	FaultDelay           = 5 * time.Second
+	AttachmentRetention  = 24 * time.Hour
 )
@@ -1,38 +1,157 @@
 package cron
 
-import "github.com/robfig/cron/v3"
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/robfig/cron/v3"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/lock"
+	"time"
+)
+
+// Mode selects on how many replicas a job runs.
+type Mode int
+
+const (
+	// RunOnOne runs every tick of the job on a single replica. The replica that
+	// takes the lock of the job runs it, the others skip the tick.
+	RunOnOne Mode = iota
+	// RunOnAll runs every tick of the job on every replica.
+	RunOnAll
+)
 
 type CronJob struct {
 	Name     string
 	Schedule string
 	Func     func()
+	// Fenced is run instead of Func with the fencing token of the lock of the
+	// tick, the job writes only when no tick with a higher token wrote before
+	// it. The token is 0 when the tick is not locked, and the context is
+	// cancelled when the lock is lost.
+	Fenced func(ctx context.Context, token int64)
+	// Mode defaults to RunOnOne, jobs run on every replica when the service
+	// has no locker.
+	Mode Mode
+	// LockAtLeast is how long the lock is kept after the tick started, so
+	// replicas whose clock is behind do not run the same tick once the job is
+	// done. It defaults to half the interval of the schedule.
+	LockAtLeast time.Duration
+	// LockAtMost is the ttl of the lock, it is renewed while the job runs and
+	// bounds how long a tick is skipped when the replica running it dies.
+	// It defaults to constant.CronLockTTL.
+	LockAtMost time.Duration
 }
 
 type CronService struct {
-	cron *cron.Cron
+	cron   *cron.Cron
+	locker lock.Locker
+}
+
+type Option func(*CronService)
+
+// WithLocker sets the locker that elects the replica running a job.
+func WithLocker(locker lock.Locker) Option {
+	return func(cs *CronService) {
+		cs.locker = locker
+	}
 }
 
 var cronService *CronService
 
-func NewCronService() *CronService {
+func NewCronService(opts ...Option) *CronService {
 	if cronService == nil {
 		cronService = &CronService{
 			cron: cron.New(),
 		}
+		for _, opt := range opts {
+			opt(cronService)
+		}
 	}
 	return cronService
 }
 
 func (cs *CronService) RegisterJob(job CronJob) error {
-	_, err := cs.cron.AddFunc(job.Schedule, job.Func)
-
+	schedule, err := cron.ParseStandard(job.Schedule)
 	if err != nil {
 		return err
 	}
-
+	if cs.locker != nil && job.Mode == RunOnOne {
+		if job.LockAtLeast == 0 {
+			next := schedule.Next(time.Now())
+			job.LockAtLeast = schedule.Next(next).Sub(next) / 2
+		}
+		if job.LockAtMost == 0 {
+			job.LockAtMost = constant.CronLockTTL
+		}
+		cs.cron.Schedule(schedule, cron.FuncJob(cs.locked(job)))
+		return nil
+	}
+	cs.cron.Schedule(schedule, cron.FuncJob(func() {
+		job.run(context.Background(), 0)
+	}))
 	return nil
 }
 
+// run runs a tick of the job with the token of its lock.
+func (job CronJob) run(ctx context.Context, token int64) {
+	if job.Fenced != nil {
+		job.Fenced(ctx, token)
+		return
+	}
+	job.Func()
+}
+
+// locked wraps the function of a job so that a tick runs only on the replica
+// that takes the lock of the job.
+func (cs *CronService) locked(job CronJob) func() {
+	return func() {
+		ctx := context.Background()
+		started := time.Now()
+		l, err := cs.locker.Acquire(ctx, "cron:"+job.Name, job.LockAtMost)
+		if errors.Is(err, lock.ErrNotAcquired) {
+			return
+		}
+		if err != nil {
+			log.Errorf("error locking cron job %s: %v", job.Name, err)
+			return
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go cs.renew(job, l, done, cancel)
+		job.run(jobCtx, l.Token())
+		close(done)
+		cancel()
+		if hold := job.LockAtLeast - time.Since(started); hold > 0 {
+			err = l.Refresh(ctx, hold)
+		} else {
+			err = l.Release(ctx)
+		}
+		if err != nil {
+			log.Errorf("error unlocking cron job %s: %v", job.Name, err)
+		}
+	}
+}
+
+// renew keeps the lock of a running job alive until done is closed, the job
+// is cancelled when the lock can not be renewed.
+func (cs *CronService) renew(job CronJob, l *lock.Lock, done chan struct{}, cancel context.CancelFunc) {
+	ticker := time.NewTicker(job.LockAtMost / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := l.Refresh(context.Background(), job.LockAtMost); err != nil {
+				log.Errorf("error renewing lock of cron job %s with fencing token %d: %v", job.Name, l.Token(), err)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
 func (cs *CronService) Start() {
 	cs.cron.Start()
 }
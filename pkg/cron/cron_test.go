@@ -0,0 +1,314 @@
+package cron_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/cron"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/lock"
+	"testing"
+	"time"
+)
+
+// ignoreArgs matches script calls without comparing the sha of the script and
+// the arguments at the given indexes, negative indexes count from the end.
+func ignoreArgs(indexes ...int) func(expected, actual []interface{}) error {
+	return func(expected, actual []interface{}) error {
+		if len(expected) != len(actual) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+		ignored := map[int]bool{1: true}
+		for _, i := range indexes {
+			if i < 0 {
+				i += len(expected)
+			}
+			ignored[i] = true
+		}
+		for i := range expected {
+			if !ignored[i] && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+				return fmt.Errorf("expected %v, got %v", expected, actual)
+			}
+		}
+		return nil
+	}
+}
+
+func newLocker(rdb *redis.Client) lock.Locker {
+	return lock.New(lock.WithRedisClient(rdb), lock.WithOwner("pod-1"))
+}
+
+func expectAcquire(mockClient redismock.ClientMock, ttl time.Duration) *redismock.ExpectedCmd {
+	return mockClient.CustomMatch(ignoreArgs()).
+		ExpectEvalSha("sha", []string{"lock:cron:job", "lock:cron:job:token"}, "pod-1", ttl.Milliseconds())
+}
+
+func Test_CronService_RegisterJob(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	{
+		tc := "Case 1: Invalid Schedule And Return Error"
+		cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "every second", Func: func() {}})
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Job Run On One Replica Skipped When Lock Is Held"
+		cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+		ran := false
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "@every 1h", Func: func() { ran = true }})
+		expectAcquire(mockClient, constant.CronLockTTL).SetErr(redis.Nil)
+		cs.RunEntry(0)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if ran {
+				t.Errorf("Expected job not to run")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Job Run On One Replica Locked With Default Ttl And Kept For Half The Interval"
+		cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+		ran := false
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "@every 1h", Func: func() { ran = true }})
+		expectAcquire(mockClient, constant.CronLockTTL).SetVal(int64(7))
+		mockClient.CustomMatch(func(expected, actual []interface{}) error {
+			if err := ignoreArgs(-1)(expected, actual); err != nil {
+				return err
+			}
+			if ttl := actual[len(actual)-1].(int64); ttl <= 0 || ttl > (30*time.Minute).Milliseconds() {
+				return fmt.Errorf("expected a ttl of at most 30 minutes, got %d", ttl)
+			}
+			return nil
+		}).ExpectEvalSha("sha", []string{"lock:cron:job"}, "pod-1:7", int64(0)).SetVal(int64(1))
+		cs.RunEntry(0)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !ran {
+				t.Errorf("Expected job to run")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Job Run On All Replicas Not Locked"
+		cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+		ran := false
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "@every 1h", Func: func() { ran = true }, Mode: cron.RunOnAll})
+		cs.RunEntry(0)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !ran {
+				t.Errorf("Expected job to run")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected no redis calls, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 5: Job Of Service Without Locker Not Locked"
+		cs := cron.NewTestCronService()
+		ran := false
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "@every 1h", Func: func() { ran = true }})
+		cs.RunEntry(0)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !ran {
+				t.Errorf("Expected job to run")
+			}
+		})
+	}
+}
+
+func Test_CronService_locked(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+	job := func(ran *bool, lockAtLeast time.Duration) cron.CronJob {
+		return cron.CronJob{
+			Name:        "job",
+			Func:        func() { *ran = true },
+			LockAtLeast: lockAtLeast,
+			LockAtMost:  time.Hour,
+		}
+	}
+	{
+		tc := "Case 1: Lock Held By Another Replica And Job Skipped"
+		ran := false
+		expectAcquire(mockClient, time.Hour).SetErr(redis.Nil)
+		cs.Locked(job(&ran, 0))()
+		t.Run(tc, func(t *testing.T) {
+			if ran {
+				t.Errorf("Expected job not to run")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Redis Error And Job Skipped"
+		ran := false
+		expectAcquire(mockClient, time.Hour).SetErr(errors.New("error"))
+		cs.Locked(job(&ran, 0))()
+		t.Run(tc, func(t *testing.T) {
+			if ran {
+				t.Errorf("Expected job not to run")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Job Run And Lock Released After LockAtLeast"
+		ran := false
+		expectAcquire(mockClient, time.Hour).SetVal(int64(7))
+		mockClient.CustomMatch(ignoreArgs()).ExpectEvalSha("sha", []string{"lock:cron:job"}, "pod-1:7").SetVal(int64(1))
+		cs.Locked(job(&ran, 0))()
+		t.Run(tc, func(t *testing.T) {
+			if !ran {
+				t.Errorf("Expected job to run")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Job Run And Lock Kept Until LockAtLeast"
+		ran := false
+		expectAcquire(mockClient, time.Hour).SetVal(int64(8))
+		mockClient.CustomMatch(ignoreArgs(-1)).ExpectEvalSha("sha", []string{"lock:cron:job"}, "pod-1:8", int64(0)).SetVal(int64(1))
+		cs.Locked(job(&ran, time.Minute))()
+		t.Run(tc, func(t *testing.T) {
+			if !ran {
+				t.Errorf("Expected job to run")
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_CronService_locked_Fenced(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+	{
+		tc := "Case 1: Fenced Job Run With The Fencing Token Of Its Lock"
+		var token int64
+		ran := false
+		expectAcquire(mockClient, time.Hour).SetVal(int64(9))
+		mockClient.CustomMatch(ignoreArgs()).ExpectEvalSha("sha", []string{"lock:cron:job"}, "pod-1:9").SetVal(int64(1))
+		cs.Locked(cron.CronJob{
+			Name:       "job",
+			Func:       func() { ran = true },
+			Fenced:     func(ctx context.Context, t int64) { token = t },
+			LockAtMost: time.Hour,
+		})()
+		t.Run(tc, func(t *testing.T) {
+			if token != 9 || ran {
+				t.Errorf("Expected fenced job to run with token 9 instead of Func, got token %d and Func run %v", token, ran)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Fenced Job Of Service Without Locker Run Without Token"
+		cs := cron.NewTestCronService()
+		token := int64(-1)
+		err := cs.RegisterJob(cron.CronJob{Name: "job", Schedule: "@every 1h", Fenced: func(ctx context.Context, t int64) { token = t }})
+		cs.RunEntry(0)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if token != 0 {
+				t.Errorf("Expected token 0, got %d", token)
+			}
+		})
+	}
+}
+
+func Test_CronService_renew(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	cs := cron.NewTestCronService(cron.WithLocker(newLocker(rdb)))
+	job := cron.CronJob{Name: "job", LockAtMost: 30 * time.Millisecond}
+	expectAcquire(mockClient, job.LockAtMost).SetVal(int64(7))
+	l, _ := newLocker(rdb).Acquire(context.Background(), "cron:job", job.LockAtMost)
+	mockClient.ClearExpect()
+	expectRefresh := func() *redismock.ExpectedCmd {
+		return mockClient.CustomMatch(ignoreArgs()).
+			ExpectEvalSha("sha", []string{"lock:cron:job"}, "pod-1:7", job.LockAtMost.Milliseconds())
+	}
+	// renew runs Renew and reports whether it returned within a second and
+	// whether it cancelled the job.
+	renew := func(done chan struct{}) (bool, bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		returned := make(chan struct{})
+		go func() {
+			cs.Renew(job, l, done, cancel)
+			close(returned)
+		}()
+		select {
+		case <-returned:
+			return true, ctx.Err() != nil
+		case <-time.After(time.Second):
+			return false, ctx.Err() != nil
+		}
+	}
+	{
+		tc := "Case 1: Lock Renewed Until It Is Lost And Job Cancelled"
+		expectRefresh().SetVal(int64(1))
+		expectRefresh().SetVal(int64(0))
+		returned, cancelled := renew(make(chan struct{}))
+		t.Run(tc, func(t *testing.T) {
+			if !returned || !cancelled {
+				t.Errorf("Expected renew to stop and cancel the job after the lock was lost, got %v, %v", returned, cancelled)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Renew Stopped When Job Is Done"
+		done := make(chan struct{})
+		close(done)
+		returned, cancelled := renew(done)
+		t.Run(tc, func(t *testing.T) {
+			if !returned || cancelled {
+				t.Errorf("Expected renew to stop without cancelling the job when it is done, got %v, %v", returned, cancelled)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected no refresh, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
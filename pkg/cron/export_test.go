@@ -0,0 +1,32 @@
+package cron
+
+import (
+	"context"
+	"github.com/robfig/cron/v3"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/lock"
+)
+
+// NewTestCronService returns a cron service that is not shared with other
+// tests, so every test has its own jobs and locker.
+func NewTestCronService(opts ...Option) *CronService {
+	cs := &CronService{
+		cron: cron.New(),
+	}
+	for _, opt := range opts {
+		opt(cs)
+	}
+	return cs
+}
+
+func (cs *CronService) Locked(job CronJob) func() {
+	return cs.locked(job)
+}
+
+func (cs *CronService) Renew(job CronJob, l *lock.Lock, done chan struct{}, cancel context.CancelFunc) {
+	cs.renew(job, l, done, cancel)
+}
+
+// RunEntry runs the i-th registered job once.
+func (cs *CronService) RunEntry(i int) {
+	cs.cron.Entries()[i].Job.Run()
+}
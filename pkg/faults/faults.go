@@ -0,0 +1,160 @@
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"os"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// ErrInjected wraps the errors of the faults, so injected failures can be told
+// apart from the ones of a real SMTP server in the logs and the tasks.
+var ErrInjected = errors.New("injected fault")
+
+// The faults a send can be failed with.
+const (
+	FaultConnection = "connection"
+	FaultTransient  = "4xx"
+	FaultPermanent  = "5xx"
+	FaultTimeout    = "timeout"
+	FaultSlow       = "slow"
+)
+
+// Injector is an interface for injecting faults into the sends of mails, so
+// chaos drills can be run against the retry logic without a failing SMTP
+// server.
+type Injector interface {
+	// Inject rolls for a fault of a mail of a user to a recipient. It returns
+	// the error to fail the send with, or nil to send the mail. Timeouts and
+	// slow sends wait for the delay first.
+	Inject(userID uint, recipient string) error
+}
+
+// Rates are the chances of a send to fail with each fault, between 0 and 1.
+// A send gets at most one fault, so the rates must not add up to more than 1.
+type Rates struct {
+	Connection float64
+	Transient  float64
+	Permanent  float64
+	Timeout    float64
+	Slow       float64
+}
+
+type injector struct {
+	rates      Rates
+	delay      time.Duration
+	users      map[uint]bool
+	recipients *regexp.Regexp
+	random     func() float64
+}
+
+type Option func(*injector)
+
+func WithRates(rates Rates) Option {
+	return func(i *injector) {
+		i.rates = rates
+	}
+}
+
+// WithDelay sets how long timeouts and slow sends wait, 5 seconds by default.
+func WithDelay(d time.Duration) Option {
+	return func(i *injector) {
+		i.delay = d
+	}
+}
+
+// WithUsers only injects faults into the mails of the given users.
+func WithUsers(ids ...uint) Option {
+	return func(i *injector) {
+		for _, id := range ids {
+			i.users[id] = true
+		}
+	}
+}
+
+// WithRecipients only injects faults for the recipients of a mail that match
+// the pattern.
+func WithRecipients(pattern *regexp.Regexp) Option {
+	return func(i *injector) {
+		i.recipients = pattern
+	}
+}
+
+// WithRandom sets the source of the rolls, numbers in [0, 1), rand.Float64 by default.
+func WithRandom(random func() float64) Option {
+	return func(i *injector) {
+		i.random = random
+	}
+}
+
+func New(opts ...Option) Injector {
+	i := &injector{
+		delay:  5 * time.Second,
+		users:  make(map[uint]bool),
+		random: rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Inject picks a fault by a single roll, every fault takes the share of the
+// roll of its rate.
+func (i *injector) Inject(userID uint, recipient string) error {
+	if !i.targets(userID, recipient) {
+		return nil
+	}
+	roll := i.random()
+	for _, f := range []struct {
+		fault string
+		rate  float64
+	}{
+		{FaultConnection, i.rates.Connection},
+		{FaultTransient, i.rates.Transient},
+		{FaultPermanent, i.rates.Permanent},
+		{FaultTimeout, i.rates.Timeout},
+		{FaultSlow, i.rates.Slow},
+	} {
+		if roll < f.rate {
+			return i.fail(f.fault)
+		}
+		roll -= f.rate
+	}
+	return nil
+}
+
+// targets reports whether the mail is one of the users and recipients faults
+// are injected into, every mail when neither is set.
+func (i *injector) targets(userID uint, recipient string) bool {
+	if len(i.users) > 0 && !i.users[userID] {
+		return false
+	}
+	return i.recipients == nil || i.recipients.MatchString(recipient)
+}
+
+// fail returns the error of a fault as the SMTP client would, so it is
+// classified like a real failure.
+func (i *injector) fail(fault string) error {
+	var err error
+	switch fault {
+	case FaultConnection:
+		err = &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	case FaultTransient:
+		err = &textproto.Error{Code: 451, Msg: "4.3.0 Temporary failure"}
+	case FaultPermanent:
+		err = &textproto.Error{Code: 550, Msg: "5.1.1 Mailbox unavailable"}
+	case FaultTimeout:
+		time.Sleep(i.delay)
+		err = &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	case FaultSlow:
+		time.Sleep(i.delay)
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInjected, err)
+}
@@ -0,0 +1,108 @@
+package faults_test
+
+import (
+	"errors"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/faults"
+	"net"
+	"net/textproto"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func roll(n float64) func() float64 {
+	return func() float64 { return n }
+}
+
+func Test_injector_Inject(t *testing.T) {
+	rates := faults.Rates{Connection: 0.1, Transient: 0.2, Permanent: 0.1, Timeout: 0.1, Slow: 0.1}
+	{
+		tc := "Case 1: No Rates And Mail Sent"
+		injector := faults.New(faults.WithRandom(roll(0)))
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Roll In Connection Rate And Connection Refused"
+		injector := faults.New(faults.WithRates(rates), faults.WithRandom(roll(0.05)))
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			var opErr *net.OpError
+			if !errors.Is(err, faults.ErrInjected) || !errors.As(err, &opErr) || !errors.Is(err, syscall.ECONNREFUSED) {
+				t.Errorf("Expected injected connection refused, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Roll Past Connection And 4xx Rates And 5xx Reply"
+		injector := faults.New(faults.WithRates(rates), faults.WithRandom(roll(0.35)))
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			var protoErr *textproto.Error
+			if !errors.Is(err, faults.ErrInjected) || !errors.As(err, &protoErr) || protoErr.Code != 550 {
+				t.Errorf("Expected injected 550 reply, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Roll In Timeout Rate And Timeout After Delay"
+		injector := faults.New(faults.WithRates(rates), faults.WithRandom(roll(0.45)), faults.WithDelay(10*time.Millisecond))
+		started := time.Now()
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Errorf("Expected injected timeout, got %v", err)
+			}
+			if time.Since(started) < 10*time.Millisecond {
+				t.Errorf("Expected timeout after the delay, got %v", time.Since(started))
+			}
+		})
+	}
+	{
+		tc := "Case 5: Roll In Slow Rate And Mail Sent After Delay"
+		injector := faults.New(faults.WithRates(rates), faults.WithRandom(roll(0.55)), faults.WithDelay(10*time.Millisecond))
+		started := time.Now()
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || time.Since(started) < 10*time.Millisecond {
+				t.Errorf("Expected nil after the delay, got %v after %v", err, time.Since(started))
+			}
+		})
+	}
+	{
+		tc := "Case 6: Roll Past Every Rate And Mail Sent"
+		injector := faults.New(faults.WithRates(rates), faults.WithRandom(roll(0.65)))
+		err := injector.Inject(1, "test@test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Faults Only Injected Into Mails Of Targeted Users And Recipients"
+		injector := faults.New(
+			faults.WithRates(faults.Rates{Transient: 1}),
+			faults.WithRandom(roll(0)),
+			faults.WithUsers(1, 2),
+			faults.WithRecipients(regexp.MustCompile(`@chaos\.test$`)),
+		)
+		t.Run(tc, func(t *testing.T) {
+			if err := injector.Inject(1, "a@chaos.test"); err == nil {
+				t.Errorf("Expected fault for targeted user and recipient, got nil")
+			}
+			if err := injector.Inject(3, "a@chaos.test"); err != nil {
+				t.Errorf("Expected no fault for other user, got %v", err)
+			}
+			if err := injector.Inject(2, "a@example.com"); err != nil {
+				t.Errorf("Expected no fault for other recipient, got %v", err)
+			}
+		})
+	}
+}
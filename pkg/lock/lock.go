@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"time"
+)
+
+// ErrNotAcquired is returned by Acquire when the lock is held by another owner.
+var ErrNotAcquired = errors.New("lock is held by another owner")
+
+// ErrLost is returned by Refresh and Release when the lock expired or was
+// acquired by another owner since.
+var ErrLost = errors.New("lock lost")
+
+// Locker is an interface for acquiring distributed locks.
+type Locker interface {
+	Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+}
+
+// Lock is a held distributed lock. Every acquisition of a key gets a fencing
+// token that is greater than the tokens of all earlier acquisitions, the lock
+// stores it with its owner so a holder whose lock expired can neither renew
+// nor release the lock of the next holder. The work done under the lock is
+// fenced by writing the token with it and refusing writes with a lower token
+// than the last one written.
+type Lock struct {
+	rdb   *redis.Client
+	key   string
+	value string
+	token int64
+}
+
+// acquireScript takes the lock when it is free and returns the next fencing token.
+// KEYS[1] = lock, KEYS[2] = token counter; ARGV[1] = owner, ARGV[2] = ttl in milliseconds.
+var acquireScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return false
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. token, 'PX', ARGV[2])
+return token
+`)
+
+// refreshScript sets the ttl of a lock that is still held by the caller.
+// KEYS[1] = lock; ARGV[1] = value, ARGV[2] = ttl in milliseconds.
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes a lock that is still held by the caller.
+// KEYS[1] = lock; ARGV[1] = value.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type redisLocker struct {
+	rdb    *redis.Client
+	prefix string
+	owner  string
+}
+
+type Option func(*redisLocker)
+
+func WithRedisClient(rdb *redis.Client) Option {
+	return func(l *redisLocker) {
+		l.rdb = rdb
+	}
+}
+
+// WithPrefix sets the prefix of the lock keys, "lock" by default.
+func WithPrefix(prefix string) Option {
+	return func(l *redisLocker) {
+		l.prefix = prefix
+	}
+}
+
+// WithOwner sets the name the locks are held under, the hostname by default.
+func WithOwner(owner string) Option {
+	return func(l *redisLocker) {
+		l.owner = owner
+	}
+}
+
+// New creates a redis backed Locker.
+func New(opts ...Option) Locker {
+	l := &redisLocker{prefix: "lock"}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.owner == "" {
+		l.owner, _ = os.Hostname()
+	}
+	return l
+}
+
+// Acquire takes the lock of a key for ttl, it returns ErrNotAcquired when the
+// lock is held by another owner.
+func (l *redisLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	lockKey := fmt.Sprintf("%s:%s", l.prefix, key)
+	token, err := acquireScript.Run(ctx, l.rdb, []string{lockKey, lockKey + ":token"}, l.owner, ttl.Milliseconds()).Int64()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotAcquired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{
+		rdb:   l.rdb,
+		key:   lockKey,
+		value: fmt.Sprintf("%s:%d", l.owner, token),
+		token: token,
+	}, nil
+}
+
+// Token returns the fencing token of the lock.
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Refresh sets the ttl of the lock, it returns ErrLost when the lock is not held anymore.
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	n, err := refreshScript.Run(ctx, l.rdb, []string{l.key}, l.value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release deletes the lock, it returns ErrLost when the lock is not held anymore.
+func (l *Lock) Release(ctx context.Context) error {
+	n, err := releaseScript.Run(ctx, l.rdb, []string{l.key}, l.value).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLost
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/lock"
+	"testing"
+	"time"
+)
+
+// ignoreSha matches script calls without comparing the sha of the script.
+func ignoreSha(expected, actual []interface{}) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if i != 1 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	return nil
+}
+
+func Test_redisLocker_Acquire(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	locker := lock.New(lock.WithRedisClient(rdb), lock.WithOwner("pod-1"))
+	{
+		tc := "Case 1: Lock Held By Another Owner And Return ErrNotAcquired"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job", "lock:job:token"}, "pod-1", int64(30000)).
+			SetErr(redis.Nil)
+		_, err := locker.Acquire(context.Background(), "job", 30*time.Second)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, lock.ErrNotAcquired) {
+				t.Errorf("Expected %v, got %v", lock.ErrNotAcquired, err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Redis Error And Return Error"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job", "lock:job:token"}, "pod-1", int64(30000)).
+			SetErr(errors.New("error"))
+		_, err := locker.Acquire(context.Background(), "job", 30*time.Second)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil || errors.Is(err, lock.ErrNotAcquired) {
+				t.Errorf("Expected redis error, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Lock Acquired With Token"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job", "lock:job:token"}, "pod-1", int64(30000)).
+			SetVal(int64(7))
+		l, err := locker.Acquire(context.Background(), "job", 30*time.Second)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if l == nil || l.Token() != 7 {
+				t.Errorf("Expected lock with token 7, got %v", l)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_Lock_Refresh(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	locker := lock.New(lock.WithRedisClient(rdb), lock.WithOwner("pod-1"))
+	mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job", "lock:job:token"}, "pod-1", int64(30000)).
+		SetVal(int64(7))
+	l, _ := locker.Acquire(context.Background(), "job", 30*time.Second)
+	{
+		tc := "Case 1: Lock Taken Over After Expiry And Return ErrLost"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job"}, "pod-1:7", int64(10000)).
+			SetVal(int64(0))
+		err := l.Refresh(context.Background(), 10*time.Second)
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, lock.ErrLost) {
+				t.Errorf("Expected %v, got %v", lock.ErrLost, err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Ttl Of Held Lock Set"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"lock:job"}, "pod-1:7", int64(10000)).
+			SetVal(int64(1))
+		err := l.Refresh(context.Background(), 10*time.Second)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_Lock_Release(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	locker := lock.New(lock.WithRedisClient(rdb), lock.WithOwner("pod-1"), lock.WithPrefix("test"))
+	mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"test:job", "test:job:token"}, "pod-1", int64(30000)).
+		SetVal(int64(3))
+	l, _ := locker.Acquire(context.Background(), "job", 30*time.Second)
+	{
+		tc := "Case 1: Lock Taken Over After Expiry And Return ErrLost"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"test:job"}, "pod-1:3").SetVal(int64(0))
+		err := l.Release(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, lock.ErrLost) {
+				t.Errorf("Expected %v, got %v", lock.ErrLost, err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Held Lock Deleted"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{"test:job"}, "pod-1:3").SetVal(int64(1))
+		err := l.Release(context.Background())
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
@@ -37,6 +37,14 @@ func AutoMigrate() error {
 	err := DB.AutoMigrate(
 		&model.User{},
 		&model.MailTaskQueue{},
+		&model.TaskOutbox{},
+		&model.MailTaskAttempt{},
+		&model.MailAttachment{},
+		&model.MailTemplate{},
+		&model.MailTemplateVersion{},
+		&model.MailCampaign{},
+		&model.MailCampaignRecipient{},
+		&model.CronFence{},
 	)
 	if err != nil {
 		return err
@@ -0,0 +1,366 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Limiter is an interface for limiting the mails sent to SMTP hosts and
+// recipient domains across all pods.
+type Limiter interface {
+	// Acquire takes a permit to send a mail of a user to the recipient
+	// domains through a host. A permit that is not allowed tells how long to
+	// wait before trying again.
+	Acquire(ctx context.Context, host string, domains []string, userID uint) (*Permit, error)
+	// AcquireConn takes a connection slot of a host for a connection that is
+	// opened to it. The slot is held until the permit is released and expires
+	// unless it is refreshed.
+	AcquireConn(ctx context.Context, host string) (*Permit, error)
+}
+
+// The limits a permit is not allowed by.
+const (
+	LimitHost   = "host"
+	LimitConns  = "conns"
+	LimitUser   = "user"
+	LimitDomain = "domain"
+)
+
+// Permit is the result of Acquire and AcquireConn. An allowed connection
+// permit holds a connection slot of the host until it is released or expires,
+// a permit that is not allowed tells the limit it is over.
+type Permit struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Limit      string
+	rdb        *redis.Client
+	connsKey   string
+	id         string
+	ttl        time.Duration
+}
+
+// Release frees the connection slot of an allowed permit.
+func (p *Permit) Release(ctx context.Context) error {
+	if p == nil || p.id == "" {
+		return nil
+	}
+	return p.rdb.ZRem(ctx, p.connsKey, p.id).Err()
+}
+
+// Refresh keeps the connection slot of an allowed permit for another permit
+// ttl, the slot of a connection that stays open has to be refreshed before it
+// expires.
+func (p *Permit) Refresh(ctx context.Context) error {
+	if p == nil || p.id == "" {
+		return nil
+	}
+	return refreshScript.Run(ctx, p.rdb, []string{p.connsKey}, p.id, p.ttl.Milliseconds()).Err()
+}
+
+// acquireScript takes a token from the buckets of the host, the user and every
+// recipient domain. Nothing is taken unless every bucket has a token, it
+// returns {0} or the milliseconds to wait before trying again and the limit
+// that was reached. Buckets refill at rate tokens per second up to burst
+// tokens, a rate of 0 is unlimited.
+// KEYS[1] = host bucket, KEYS[2] = user bucket, KEYS[3..] = domain buckets;
+// ARGV[1] = rate, ARGV[2] = burst, ARGV[3] = user rate, ARGV[4] = user burst,
+// ARGV[3+2i], ARGV[4+2i] = rate and burst of the domain of KEYS[2+i].
+var acquireScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local function tokens(key, rate, burst)
+	local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+	local n = tonumber(bucket[1]) or burst
+	local ts = tonumber(bucket[2]) or now
+	return math.min(burst, n + math.max(0, now - ts) * rate / 1000)
+end
+
+local function wait(n, rate)
+	if n >= 1 then
+		return 0
+	end
+	return math.ceil((1 - n) * 1000 / rate)
+end
+
+local function take(key, n, rate, burst)
+	redis.call('HSET', key, 'tokens', tostring(n - 1), 'ts', now)
+	redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+
+local buckets = {}
+local rate = tonumber(ARGV[1])
+if rate > 0 then
+	table.insert(buckets, {KEYS[1], rate, math.max(tonumber(ARGV[2]), 1), 'host'})
+end
+local userRate = tonumber(ARGV[3])
+if userRate > 0 then
+	table.insert(buckets, {KEYS[2], userRate, math.max(tonumber(ARGV[4]), 1), 'user'})
+end
+for i = 3, #KEYS do
+	local domainRate = tonumber(ARGV[2 * i - 1])
+	if domainRate > 0 then
+		table.insert(buckets, {KEYS[i], domainRate, math.max(tonumber(ARGV[2 * i]), 1), 'domain'})
+	end
+end
+local delay, limit = 0, ''
+for _, b in ipairs(buckets) do
+	b[5] = tokens(b[1], b[2], b[3])
+	local w = wait(b[5], b[2])
+	if w > delay then
+		delay, limit = w, b[4]
+	end
+end
+if delay > 0 then
+	return {delay, limit}
+end
+for _, b in ipairs(buckets) do
+	take(b[1], b[5], b[2], b[3])
+end
+return {0}
+`)
+
+// connScript takes a connection slot of a host unless max connections slots
+// are held, expired slots of dead pods are dropped first. It returns 1 when
+// the slot was taken.
+// KEYS[1] = host connections;
+// ARGV[1] = max connections, ARGV[2] = permit id, ARGV[3] = permit ttl in ms.
+var connScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// refreshScript moves the expiry of a held connection slot to a permit ttl
+// from now, a slot that already expired is not taken again.
+// KEYS[1] = host connections;
+// ARGV[1] = permit id, ARGV[2] = permit ttl in ms.
+var refreshScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('ZADD', KEYS[1], 'XX', now + tonumber(ARGV[2]), ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+type redisLimiter struct {
+	rdb       *redis.Client
+	prefix    string
+	rate      float64
+	burst     int
+	maxConns  int
+	userRate  float64
+	userBurst int
+	hosts     map[string]HostLimit
+	domain    DomainLimit
+	domains   map[string]DomainLimit
+	permitTTL time.Duration
+	busyDelay time.Duration
+	owner     string
+	seq       uint64
+}
+
+// HostLimit is the mails per second, the burst and the max concurrent
+// connections of a host.
+type HostLimit struct {
+	Rate     float64
+	Burst    int
+	MaxConns int
+}
+
+// DomainLimit is the mails per second and the burst of a recipient domain.
+type DomainLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type Option func(*redisLimiter)
+
+func WithRedisClient(rdb *redis.Client) Option {
+	return func(l *redisLimiter) {
+		l.rdb = rdb
+	}
+}
+
+// WithPrefix sets the prefix of the limiter keys, "ratelimit" by default.
+func WithPrefix(prefix string) Option {
+	return func(l *redisLimiter) {
+		l.prefix = prefix
+	}
+}
+
+// WithHostLimit sets the mails per second, the burst and the max concurrent
+// connections of every host, 0 is unlimited.
+func WithHostLimit(rate float64, burst, maxConns int) Option {
+	return func(l *redisLimiter) {
+		l.rate = rate
+		l.burst = burst
+		l.maxConns = maxConns
+	}
+}
+
+// WithHostRules sets the limits of single hosts, such as smtp.gmail.com.
+// Hosts are matched case-insensitively.
+func WithHostRules(rules map[string]HostLimit) Option {
+	return func(l *redisLimiter) {
+		for host, limit := range rules {
+			l.hosts[strings.ToLower(host)] = limit
+		}
+	}
+}
+
+// WithUserLimit sets the mails per second and the burst of every user, 0 is unlimited.
+func WithUserLimit(rate float64, burst int) Option {
+	return func(l *redisLimiter) {
+		l.userRate = rate
+		l.userBurst = burst
+	}
+}
+
+// WithDomainLimit sets the mails per second and the burst of every recipient
+// domain without a rule, 0 is unlimited.
+func WithDomainLimit(rate float64, burst int) Option {
+	return func(l *redisLimiter) {
+		l.domain = DomainLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// WithDomainRules sets the limits of single recipient domains, such as
+// gmail.com. Domains are matched case-insensitively.
+func WithDomainRules(rules map[string]DomainLimit) Option {
+	return func(l *redisLimiter) {
+		for domain, limit := range rules {
+			l.domains[strings.ToLower(domain)] = limit
+		}
+	}
+}
+
+// WithPermitTTL sets how long a connection slot is held by a permit that is not
+// released or refreshed, for example because its pod died, 1 minute by default.
+func WithPermitTTL(ttl time.Duration) Option {
+	return func(l *redisLimiter) {
+		l.permitTTL = ttl
+	}
+}
+
+// WithBusyDelay sets how long to wait when every connection slot of a host is
+// taken, 1 second by default.
+func WithBusyDelay(d time.Duration) Option {
+	return func(l *redisLimiter) {
+		l.busyDelay = d
+	}
+}
+
+// WithOwner sets the prefix of the permit IDs, the host name by default.
+func WithOwner(owner string) Option {
+	return func(l *redisLimiter) {
+		l.owner = owner
+	}
+}
+
+func New(opts ...Option) Limiter {
+	owner, _ := os.Hostname()
+	l := &redisLimiter{
+		prefix:    "ratelimit",
+		permitTTL: time.Minute,
+		busyDelay: time.Second,
+		owner:     owner,
+		hosts:     make(map[string]HostLimit),
+		domains:   make(map[string]DomainLimit),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// hostLimit returns the limits of a host, its rule or the limits of every host.
+func (l *redisLimiter) hostLimit(host string) HostLimit {
+	if limit, ok := l.hosts[strings.ToLower(host)]; ok {
+		return limit
+	}
+	return HostLimit{Rate: l.rate, Burst: l.burst, MaxConns: l.maxConns}
+}
+
+// Acquire runs the acquire script with the limits of the limiter. Burst
+// defaults to the rate, so a host can take a second of mails at once. A mail
+// takes a single token of every distinct domain of its recipients.
+func (l *redisLimiter) Acquire(ctx context.Context, host string, domains []string, userID uint) (*Permit, error) {
+	keys := []string{
+		fmt.Sprintf("%s:host:%s", l.prefix, host),
+		fmt.Sprintf("%s:user:%d", l.prefix, userID),
+	}
+	hostLimit := l.hostLimit(host)
+	args := []interface{}{
+		formatRate(hostLimit.Rate), burstOf(hostLimit.Rate, hostLimit.Burst),
+		formatRate(l.userRate), burstOf(l.userRate, l.userBurst),
+	}
+	seen := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if domain == "" || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		domainLimit, ok := l.domains[domain]
+		if !ok {
+			domainLimit = l.domain
+		}
+		keys = append(keys, fmt.Sprintf("%s:domain:%s", l.prefix, domain))
+		args = append(args, formatRate(domainLimit.Rate), burstOf(domainLimit.Rate, domainLimit.Burst))
+	}
+	res, err := acquireScript.Run(ctx, l.rdb, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if delay, _ := res[0].(int64); delay > 0 {
+		limit, _ := res[1].(string)
+		return &Permit{RetryAfter: time.Duration(delay) * time.Millisecond, Limit: limit}, nil
+	}
+	return &Permit{Allowed: true}, nil
+}
+
+// AcquireConn runs the conn script with the max connections of the host. A
+// host without a connection limit takes no slot, every slot is taken when
+// the permit waits for the busy delay.
+func (l *redisLimiter) AcquireConn(ctx context.Context, host string) (*Permit, error) {
+	maxConns := l.hostLimit(host).MaxConns
+	if maxConns <= 0 {
+		return &Permit{Allowed: true}, nil
+	}
+	id := fmt.Sprintf("%s:%d", l.owner, atomic.AddUint64(&l.seq, 1))
+	connsKey := fmt.Sprintf("%s:host:%s:conns", l.prefix, host)
+	taken, err := connScript.Run(ctx, l.rdb, []string{connsKey}, maxConns, id, l.permitTTL.Milliseconds()).Int()
+	if err != nil {
+		return nil, err
+	}
+	if taken == 0 {
+		return &Permit{RetryAfter: l.busyDelay, Limit: LimitConns}, nil
+	}
+	return &Permit{Allowed: true, rdb: l.rdb, connsKey: connsKey, id: id, ttl: l.permitTTL}, nil
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
+
+// burstOf returns the burst of a limit, a second of mails when it is not set.
+func burstOf(rate float64, burst int) int {
+	if burst <= 0 {
+		return int(math.Ceil(rate))
+	}
+	return burst
+}
@@ -0,0 +1,245 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"testing"
+	"time"
+)
+
+// ignoreSha matches script calls without comparing the sha of the script.
+func ignoreSha(expected, actual []interface{}) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if i != 1 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	return nil
+}
+
+var acquireKeys = []string{
+	"ratelimit:host:smtp.test.com",
+	"ratelimit:user:1",
+	"ratelimit:domain:example.com",
+}
+
+func Test_redisLimiter_Acquire(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	limiter := ratelimit.New(
+		ratelimit.WithRedisClient(rdb),
+		ratelimit.WithOwner("pod-1"),
+		ratelimit.WithHostLimit(2.5, 0, 4),
+		ratelimit.WithUserLimit(1, 5),
+	)
+	{
+		tc := "Case 1: Redis Error And Return Error"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", acquireKeys,
+			"2.5", 3, "1", 5, "0", 0).
+			SetErr(errors.New("error"))
+		_, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"example.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Limit Reached And Permit Not Allowed With Wait"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", acquireKeys,
+			"2.5", 3, "1", 5, "0", 0).
+			SetVal([]interface{}{int64(400), "host"})
+		permit, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"example.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if permit.Allowed || permit.RetryAfter != 400*time.Millisecond || permit.Limit != ratelimit.LimitHost {
+				t.Errorf("Expected permit not allowed by host limit for 400ms, got %v", permit)
+			}
+			if err := permit.Release(context.Background()); err != nil {
+				t.Errorf("Expected release of permit not allowed to be a no-op, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Permit Allowed Without Connection Slot"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", acquireKeys,
+			"2.5", 3, "1", 5, "0", 0).
+			SetVal([]interface{}{int64(0)})
+		permit, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"example.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if !permit.Allowed {
+				t.Errorf("Expected permit to be allowed, got %v", permit)
+			}
+			if err := permit.Release(context.Background()); err != nil {
+				t.Errorf("Expected release of permit without slot to be a no-op, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Rule Of Recipient Domain Used And Permit Not Allowed By Domain Limit"
+		limiter := ratelimit.New(
+			ratelimit.WithRedisClient(rdb),
+			ratelimit.WithOwner("pod-1"),
+			ratelimit.WithDomainLimit(10, 0),
+			ratelimit.WithDomainRules(map[string]ratelimit.DomainLimit{"Gmail.com": {Rate: 0.5, Burst: 2}}),
+		)
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{
+			"ratelimit:host:smtp.test.com",
+			"ratelimit:user:1",
+			"ratelimit:domain:gmail.com",
+		}, "0", 0, "0", 0, "0.5", 2).
+			SetVal([]interface{}{int64(2000), "domain"})
+		permit, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"GMAIL.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if permit.Allowed || permit.RetryAfter != 2*time.Second || permit.Limit != ratelimit.LimitDomain {
+				t.Errorf("Expected permit not allowed by domain limit for 2s, got %v", permit)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 5: Rule Of Host Used Instead Of Limits Of Every Host"
+		limiter := ratelimit.New(
+			ratelimit.WithRedisClient(rdb),
+			ratelimit.WithHostLimit(2.5, 0, 4),
+			ratelimit.WithHostRules(map[string]ratelimit.HostLimit{"SMTP.test.com": {Rate: 10, Burst: 20}}),
+		)
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", acquireKeys,
+			"10", 20, "0", 0, "0", 0).
+			SetVal([]interface{}{int64(0)})
+		_, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"example.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 6: Token Of Every Distinct Recipient Domain Taken Once"
+		limiter := ratelimit.New(
+			ratelimit.WithRedisClient(rdb),
+			ratelimit.WithDomainLimit(10, 0),
+			ratelimit.WithDomainRules(map[string]ratelimit.DomainLimit{"gmail.com": {Rate: 0.5, Burst: 2}}),
+		)
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", []string{
+			"ratelimit:host:smtp.test.com",
+			"ratelimit:user:1",
+			"ratelimit:domain:example.com",
+			"ratelimit:domain:gmail.com",
+		}, "0", 0, "0", 0, "10", 10, "0.5", 2).
+			SetVal([]interface{}{int64(0)})
+		permit, err := limiter.Acquire(context.Background(), "smtp.test.com", []string{"example.com", "gmail.com", "", "Example.com"}, 1)
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || !permit.Allowed {
+				t.Errorf("Expected permit to be allowed, got %v %v", permit, err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
+
+func Test_redisLimiter_AcquireConn(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	limiter := ratelimit.New(
+		ratelimit.WithRedisClient(rdb),
+		ratelimit.WithOwner("pod-1"),
+		ratelimit.WithHostLimit(0, 0, 4),
+		ratelimit.WithHostRules(map[string]ratelimit.HostLimit{"smtp.free.com": {Rate: 1}}),
+	)
+	connsKeys := []string{"ratelimit:host:smtp.test.com:conns"}
+	{
+		tc := "Case 1: Redis Error And Return Error"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, 4, "pod-1:1", int64(60000)).
+			SetErr(errors.New("error"))
+		_, err := limiter.AcquireConn(context.Background(), "smtp.test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Every Connection Slot Taken And Permit Not Allowed With Busy Delay"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, 4, "pod-1:2", int64(60000)).
+			SetVal(int64(0))
+		permit, err := limiter.AcquireConn(context.Background(), "smtp.test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if permit.Allowed || permit.RetryAfter != time.Second || permit.Limit != ratelimit.LimitConns {
+				t.Errorf("Expected permit not allowed by conns limit for 1s, got %v", permit)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 3: Connection Slot Taken, Refreshed And Released"
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, 4, "pod-1:3", int64(60000)).
+			SetVal(int64(1))
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, "pod-1:3", int64(60000)).
+			SetVal(int64(1))
+		mockClient.ExpectZRem("ratelimit:host:smtp.test.com:conns", "pod-1:3").SetVal(1)
+		permit, err := limiter.AcquireConn(context.Background(), "smtp.test.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			if !permit.Allowed {
+				t.Errorf("Expected permit to be allowed, got %v", permit)
+			}
+			if err := permit.Refresh(context.Background()); err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := permit.Release(context.Background()); err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected all expectations to be met, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 4: Host Without Connection Limit Takes No Slot"
+		permit, err := limiter.AcquireConn(context.Background(), "smtp.free.com")
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || !permit.Allowed {
+				t.Errorf("Expected allowed permit, got %v and %v", permit, err)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected no redis calls, got %v", err)
+			}
+		})
+	}
+}
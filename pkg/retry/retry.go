@@ -0,0 +1,69 @@
+package retry
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"math/rand"
+	"time"
+)
+
+// Policy decides how often and when a failed task is tried again.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Default returns the retry policy of the service.
+func Default() Policy {
+	return Policy{
+		MaxAttempts: constant.MaxTryCount,
+		BaseDelay:   constant.RetryBaseDelay,
+		MaxDelay:    constant.RetryMaxDelay,
+	}
+}
+
+// Override returns the policy with the non-zero values of the given override.
+func (p Policy) Override(o model.RetryPolicy) Policy {
+	if o.MaxAttempts > 0 {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.RetryBaseDelay > 0 {
+		p.BaseDelay = time.Duration(o.RetryBaseDelay) * time.Second
+	}
+	if o.RetryMaxDelay > 0 {
+		p.MaxDelay = time.Duration(o.RetryMaxDelay) * time.Second
+	}
+	return p
+}
+
+// Resolve returns the policy of a task. The overrides of the task take
+// precedence over the ones of its user, which take precedence over Default.
+func Resolve(user, task model.RetryPolicy) Policy {
+	return Default().Override(user).Override(task)
+}
+
+// Exhausted reports whether a task that failed the given number of attempts
+// may not be tried again.
+func (p Policy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// Delay returns how long to wait before the next attempt of a task that failed
+// the given number of attempts. The delay doubles with every attempt up to
+// MaxDelay, and a random half of it is dropped so that tasks failing together
+// do not retry together.
+func (p Policy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return delay - half + time.Duration(rand.Int63n(int64(half)+1))
+}
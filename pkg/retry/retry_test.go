@@ -0,0 +1,78 @@
+package retry_test
+
+import (
+	"fmt"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/model"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/constant"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/retry"
+	"testing"
+	"time"
+)
+
+func Test_Resolve(t *testing.T) {
+	{
+		tc := "Case 1: No Overrides Return Default Policy"
+		policy := retry.Resolve(model.RetryPolicy{}, model.RetryPolicy{})
+		t.Run(tc, func(t *testing.T) {
+			if policy != retry.Default() {
+				t.Errorf("Expected %v, got %v", retry.Default(), policy)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Task Overrides User And User Overrides Default"
+		policy := retry.Resolve(model.RetryPolicy{MaxAttempts: 5, RetryMaxDelay: 60}, model.RetryPolicy{MaxAttempts: 7})
+		want := retry.Policy{MaxAttempts: 7, BaseDelay: constant.RetryBaseDelay, MaxDelay: time.Minute}
+		t.Run(tc, func(t *testing.T) {
+			if policy != want {
+				t.Errorf("Expected %v, got %v", want, policy)
+			}
+		})
+	}
+}
+
+func Test_Policy_Exhausted(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3}
+	{
+		tc := "Case 1: Attempts Below Max Are Not Exhausted"
+		t.Run(tc, func(t *testing.T) {
+			if policy.Exhausted(2) {
+				t.Errorf("Expected 2 attempts not to be exhausted")
+			}
+		})
+	}
+	{
+		tc := "Case 2: Max Attempts Are Exhausted"
+		t.Run(tc, func(t *testing.T) {
+			if !policy.Exhausted(3) {
+				t.Errorf("Expected 3 attempts to be exhausted")
+			}
+		})
+	}
+}
+
+func Test_Policy_Delay(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	cases := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{60, 10 * time.Second},
+	}
+	for i, c := range cases {
+		tc := fmt.Sprintf("Case %d: Delay Of Attempt %d Doubles Up To Max Delay With Jitter", i+1, c.attempts)
+		t.Run(tc, func(t *testing.T) {
+			for n := 0; n < 100; n++ {
+				if delay := policy.Delay(c.attempts); delay < c.max/2 || delay > c.max {
+					t.Errorf("Expected delay within %s and %s, got %s", c.max/2, c.max, delay)
+					return
+				}
+			}
+		})
+	}
+}
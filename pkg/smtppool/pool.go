@@ -0,0 +1,536 @@
+package smtppool
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"gopkg.in/gomail.v2"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by Get when every connection to the server stays in
+// use for the wait timeout.
+var ErrTimeout = errors.New("smtp pool: no connection available")
+
+// ErrClosed is returned by Get once the pool is closed.
+var ErrClosed = errors.New("smtp pool: closed")
+
+// ConnLimitError is returned by Get when every connection slot of the host is
+// held by the sessions of the pods, a new session can be tried after RetryAfter.
+type ConnLimitError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *ConnLimitError) Error() string {
+	return fmt.Sprintf("smtp pool: every connection to %s is taken", e.Host)
+}
+
+// RecipientsError is returned by Send when the server rejected the RCPT TO of
+// some of the recipients. The mail is still sent to the accepted recipients,
+// Sent reports whether there were any.
+type RecipientsError struct {
+	Rejected map[string]error
+	Sent     bool
+}
+
+func (e *RecipientsError) Error() string {
+	addrs := make([]string, 0, len(e.Rejected))
+	for addr := range e.Rejected {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for i, addr := range addrs {
+		addrs[i] = fmt.Sprintf("%s: %v", addr, e.Rejected[addr])
+	}
+	return "smtp pool: recipients rejected: " + strings.Join(addrs, "; ")
+}
+
+// Unwrap returns the rejections of the recipients.
+func (e *RecipientsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Rejected))
+	for _, err := range e.Rejected {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Pool is an interface for sending mail over SMTP sessions that are kept open
+// between mails. Sessions are pooled per sender, that is per host, port and
+// username, and shared by all the workers of a pod.
+type Pool interface {
+	// Get returns an open session of the sender of the dialer. Closing the
+	// session returns it to the pool instead of ending it.
+	Get(d *gomail.Dialer) (gomail.SendCloser, error)
+	Close()
+}
+
+type key struct {
+	host     string
+	port     int
+	username string
+}
+
+// sender holds the sessions of a sender. idle keeps the sessions that are
+// ready to be reused, slots counts the open sessions up to the max.
+type sender struct {
+	idle  chan *session
+	slots chan struct{}
+}
+
+type pool struct {
+	mu          sync.Mutex
+	senders     map[key]*sender
+	maxConns    int
+	idleTimeout time.Duration
+	waitTimeout time.Duration
+	dialTimeout time.Duration
+	limiter     ratelimit.Limiter
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+type Option func(*pool)
+
+// WithMaxConns sets the max number of open sessions per sender, 5 by default.
+func WithMaxConns(n int) Option {
+	return func(p *pool) {
+		p.maxConns = n
+	}
+}
+
+// WithIdleTimeout sets how long an unused session is kept open, 30 seconds by default.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(p *pool) {
+		p.idleTimeout = d
+	}
+}
+
+// WithWaitTimeout sets how long Get waits for a session of a sender that has
+// max sessions open, 10 seconds by default.
+func WithWaitTimeout(d time.Duration) Option {
+	return func(p *pool) {
+		p.waitTimeout = d
+	}
+}
+
+// WithDialTimeout sets the timeout of connecting to a server, 10 seconds by default.
+func WithDialTimeout(d time.Duration) Option {
+	return func(p *pool) {
+		p.dialTimeout = d
+	}
+}
+
+// WithLimiter takes a connection slot of the host from the limiter for every
+// session that is opened, so the sessions of all pods, idle or in use, count
+// against the max connections of the host. The slot is held until the session
+// is ended.
+func WithLimiter(limiter ratelimit.Limiter) Option {
+	return func(p *pool) {
+		p.limiter = limiter
+	}
+}
+
+// New creates a pool and starts closing the sessions that were idle for the
+// idle timeout.
+func New(opts ...Option) Pool {
+	p := &pool{
+		senders:     make(map[key]*sender),
+		maxConns:    5,
+		idleTimeout: 30 * time.Second,
+		waitTimeout: 10 * time.Second,
+		dialTimeout: 10 * time.Second,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	go p.reap()
+	return p
+}
+
+func (p *pool) senderOf(d *gomail.Dialer) *sender {
+	k := key{host: d.Host, port: d.Port, username: d.Username}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.senders[k]
+	if !ok {
+		s = &sender{
+			idle:  make(chan *session, p.maxConns),
+			slots: make(chan struct{}, p.maxConns),
+		}
+		p.senders[k] = s
+	}
+	return s
+}
+
+// Get reuses an idle session of the sender that passes a NOOP health check,
+// idle sessions are preferred over opening a new one. When the sender has max
+// sessions open, Get waits for one of them to be returned. A session is not
+// opened while the host has no connection slot left.
+func (p *pool) Get(d *gomail.Dialer) (gomail.SendCloser, error) {
+	snd := p.senderOf(d)
+	timer := time.NewTimer(p.waitTimeout)
+	defer timer.Stop()
+	for {
+		if p.closed() {
+			return nil, ErrClosed
+		}
+		select {
+		case s := <-snd.idle:
+			if p.healthy(s) {
+				return s, nil
+			}
+			s.discard()
+			continue
+		default:
+		}
+		select {
+		case <-p.done:
+			return nil, ErrClosed
+		case s := <-snd.idle:
+			if p.healthy(s) {
+				return s, nil
+			}
+			s.discard()
+		case snd.slots <- struct{}{}:
+			conn, err := p.acquireConn(d.Host)
+			if err != nil {
+				<-snd.slots
+				return nil, err
+			}
+			s := &session{pool: p, sender: snd, dialer: d, conn: conn}
+			if err := s.connect(); err != nil {
+				s.releaseConn()
+				<-snd.slots
+				return nil, err
+			}
+			return s, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("%w for %s:%d", ErrTimeout, d.Host, d.Port)
+		}
+	}
+}
+
+// acquireConn takes a connection slot of a host from the limiter. Errors of
+// the limiter are logged and let the session open without a slot.
+func (p *pool) acquireConn(host string) (*ratelimit.Permit, error) {
+	if p.limiter == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.dialTimeout)
+	defer cancel()
+	permit, err := p.limiter.AcquireConn(ctx, host)
+	if err != nil {
+		log.Errorf("error acquiring connection slot of %s: %v", host, err)
+		return nil, nil
+	}
+	if !permit.Allowed {
+		return nil, &ConnLimitError{Host: host, RetryAfter: permit.RetryAfter}
+	}
+	return permit, nil
+}
+
+// healthy reports whether an idle session can be reused, the connection slot
+// of a reused session is refreshed.
+func (p *pool) healthy(s *session) bool {
+	if time.Since(s.lastUsed) >= p.idleTimeout || s.client.Noop() != nil {
+		return false
+	}
+	s.refreshConn()
+	return true
+}
+
+// reap closes the sessions that were idle for the idle timeout until the pool
+// is closed, the connection slots of the other idle sessions are refreshed.
+func (p *pool) reap() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.each(func(s *session) bool {
+				if time.Since(s.lastUsed) >= p.idleTimeout {
+					return false
+				}
+				s.refreshConn()
+				return true
+			})
+		}
+	}
+}
+
+// each takes the idle sessions of every sender, keeps the ones keep returns
+// true for and ends the others.
+func (p *pool) each(keep func(s *session) bool) {
+	p.mu.Lock()
+	senders := make([]*sender, 0, len(p.senders))
+	for _, snd := range p.senders {
+		senders = append(senders, snd)
+	}
+	p.mu.Unlock()
+	for _, snd := range senders {
+		for n := len(snd.idle); n > 0; n-- {
+			select {
+			case s := <-snd.idle:
+				if keep(s) {
+					snd.idle <- s
+				} else {
+					s.discard()
+				}
+			default:
+			}
+		}
+	}
+}
+
+// Close ends the idle sessions, sessions in use are ended when they are returned.
+func (p *pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.each(func(*session) bool { return false })
+}
+
+func (p *pool) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// session is a pooled SMTP session. A session is used by one worker at a time.
+type session struct {
+	pool     *pool
+	sender   *sender
+	dialer   *gomail.Dialer
+	client   *smtp.Client
+	conn     *ratelimit.Permit
+	lastUsed time.Time
+	fresh    bool
+	dirty    bool
+	broken   bool
+}
+
+// connect opens the session the way gomail does: implicit TLS on port 465,
+// STARTTLS when the server offers it and AUTH when the dialer has a username.
+func (s *session) connect() error {
+	d := s.dialer
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(d.Host, strconv.Itoa(d.Port)), s.pool.dialTimeout)
+	if err != nil {
+		return err
+	}
+	if d.SSL {
+		conn = tls.Client(conn, tlsConfig(d))
+	}
+	c, err := smtp.NewClient(conn, d.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := s.open(c); err != nil {
+		c.Close()
+		return err
+	}
+	s.client = c
+	s.lastUsed = time.Now()
+	s.fresh = true
+	s.dirty = false
+	s.broken = false
+	return nil
+}
+
+func (s *session) open(c *smtp.Client) error {
+	d := s.dialer
+	if d.LocalName != "" {
+		if err := c.Hello(d.LocalName); err != nil {
+			return err
+		}
+	}
+	if !d.SSL {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig(d)); err != nil {
+				return err
+			}
+		}
+	}
+	if auth := authOf(c, d); auth != nil {
+		return c.Auth(auth)
+	}
+	return nil
+}
+
+func tlsConfig(d *gomail.Dialer) *tls.Config {
+	if d.TLSConfig == nil {
+		return &tls.Config{ServerName: d.Host}
+	}
+	return d.TLSConfig
+}
+
+func authOf(c *smtp.Client, d *gomail.Dialer) smtp.Auth {
+	if d.Auth != nil || d.Username == "" {
+		return d.Auth
+	}
+	ok, auths := c.Extension("AUTH")
+	if !ok {
+		return nil
+	}
+	switch {
+	case strings.Contains(auths, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(d.Username, d.Password)
+	case strings.Contains(auths, "LOGIN") && !strings.Contains(auths, "PLAIN"):
+		return &loginAuth{username: d.Username, password: d.Password}
+	default:
+		return smtp.PlainAuth("", d.Username, d.Password, d.Host)
+	}
+}
+
+// Send sends a mail over the session. A reused session whose connection was
+// closed by the server before DATA is reconnected once before the error is
+// returned. A connection lost after DATA is not retried, the server may have
+// accepted the mail before it was lost.
+func (s *session) Send(from string, to []string, msg io.WriterTo) error {
+	data, err := s.send(from, to, msg)
+	if err != nil && !data && !s.fresh && lostConnection(err) {
+		s.client.Close()
+		if err := s.connect(); err != nil {
+			s.broken = true
+			return err
+		}
+		_, err = s.send(from, to, msg)
+	}
+	s.fresh = false
+	if err != nil {
+		s.broken = lostConnection(err)
+		s.dirty = true
+	}
+	return err
+}
+
+// send sends the mail to the recipients the server accepts. The recipients
+// rejected with a reply are returned in a RecipientsError, the mail is not
+// sent if every recipient is rejected. It reports whether the DATA command was
+// sent, a mail may have been delivered from then on.
+func (s *session) send(from string, to []string, msg io.WriterTo) (bool, error) {
+	if err := s.client.Mail(from); err != nil {
+		return false, err
+	}
+	rejected := make(map[string]error)
+	for _, addr := range to {
+		if err := s.client.Rcpt(addr); err != nil {
+			if lostConnection(err) {
+				return false, err
+			}
+			rejected[addr] = err
+		}
+	}
+	if len(rejected) == len(to) && len(to) > 0 {
+		return false, &RecipientsError{Rejected: rejected}
+	}
+	w, err := s.client.Data()
+	if err != nil {
+		return true, err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return true, err
+	}
+	if err := w.Close(); err != nil {
+		return true, err
+	}
+	if len(rejected) > 0 {
+		return true, &RecipientsError{Rejected: rejected, Sent: true}
+	}
+	return true, nil
+}
+
+// lostConnection reports whether an error ended the connection of a session,
+// the server keeps the connection open after the replies it sends.
+func lostConnection(err error) bool {
+	var protoErr *textproto.Error
+	return !errors.As(err, &protoErr)
+}
+
+// Close returns the session to the pool. A session with an aborted mail is
+// reset with RSET first, broken sessions are ended.
+func (s *session) Close() error {
+	if s.broken || s.pool.closed() || (s.dirty && s.client.Reset() != nil) {
+		s.discard()
+		return nil
+	}
+	s.dirty = false
+	s.lastUsed = time.Now()
+	select {
+	case s.sender.idle <- s:
+	default:
+		s.discard()
+	}
+	return nil
+}
+
+// discard ends the session and frees its slots.
+func (s *session) discard() {
+	if s.client != nil {
+		if s.broken || s.client.Quit() != nil {
+			s.client.Close()
+		}
+	}
+	s.releaseConn()
+	<-s.sender.slots
+}
+
+// refreshConn keeps the connection slot of the host for the open session.
+func (s *session) refreshConn() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.pool.dialTimeout)
+	defer cancel()
+	if err := s.conn.Refresh(ctx); err != nil {
+		log.Errorf("error refreshing connection slot of %s: %v", s.dialer.Host, err)
+	}
+}
+
+// releaseConn frees the connection slot of the host.
+func (s *session) releaseConn() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.pool.dialTimeout)
+	defer cancel()
+	if err := s.conn.Release(ctx); err != nil {
+		log.Errorf("error releasing connection slot of %s: %v", s.dialer.Host, err)
+	}
+}
+
+// loginAuth implements the LOGIN mechanism for servers that do not offer PLAIN.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("smtp: unexpected server challenge: %s", fromServer)
+}
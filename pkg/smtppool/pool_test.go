@@ -0,0 +1,433 @@
+package smtppool_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redismock/v9"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/ratelimit"
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/smtppool"
+	"gopkg.in/gomail.v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an SMTP server that accepts every mail. It counts the
+// connections and commands it received, rejected recipients get a 550 reply
+// and the first connection is dropped after dropAfter mails, or before the
+// reply to the end of the data of mail dropAtDot.
+type fakeServer struct {
+	ln        net.Listener
+	mu        sync.Mutex
+	conns     int
+	commands  []string
+	dropAfter int
+	dropAtDot int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		first := s.conns == 1
+		s.mu.Unlock()
+		go s.handle(conn, first)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn, first bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	sent := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		dropAfter, dropAtDot := s.dropAfter, s.dropAtDot
+		s.mu.Unlock()
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			if first && dropAfter > 0 && sent == dropAfter {
+				return
+			}
+			reply("250 OK")
+		case "RCPT":
+			if strings.Contains(line, "rejected@") {
+				reply("550 5.1.1 User unknown")
+			} else {
+				reply("250 OK")
+			}
+		case "DATA":
+			reply("354 Go ahead")
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+			}
+			sent++
+			if first && sent == dropAtDot {
+				return
+			}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeServer) stats() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, append([]string(nil), s.commands...)
+}
+
+func (s *fakeServer) dialer() *gomail.Dialer {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &gomail.Dialer{Host: host, Port: p}
+}
+
+func message(to string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "test@test.com")
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "Test")
+	m.SetBody("text/plain", "Test")
+	return m
+}
+
+func send(pool smtppool.Pool, d *gomail.Dialer, to string) error {
+	s, err := pool.Get(d)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return gomail.Send(s, message(to))
+}
+
+// ignoreSha matches script calls without comparing the sha of the script.
+func ignoreSha(expected, actual []interface{}) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if i != 1 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	return nil
+}
+
+func countOf(commands []string, cmd string) int {
+	n := 0
+	for _, c := range commands {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_pool_Get(t *testing.T) {
+	{
+		tc := "Case 1: Session Reused For Mails Of Same Sender And Checked With NOOP"
+		server := newFakeServer(t)
+		pool := smtppool.New()
+		for i := 0; i < 3; i++ {
+			if err := send(pool, server.dialer(), "example@ex.com"); err != nil {
+				t.Fatalf("%s: unexpected error %v", tc, err)
+			}
+		}
+		pool.Close()
+		conns, commands := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if conns != 1 {
+				t.Errorf("Expected 1 connection, got %d", conns)
+			}
+			if n := countOf(commands, "NOOP"); n != 2 {
+				t.Errorf("Expected 2 health checks, got %d", n)
+			}
+		})
+	}
+	{
+		tc := "Case 2: Session Idle For Idle Timeout Is Not Reused"
+		server := newFakeServer(t)
+		pool := smtppool.New(smtppool.WithIdleTimeout(50 * time.Millisecond))
+		_ = send(pool, server.dialer(), "example@ex.com")
+		time.Sleep(100 * time.Millisecond)
+		_ = send(pool, server.dialer(), "example@ex.com")
+		pool.Close()
+		conns, commands := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if conns != 2 {
+				t.Errorf("Expected 2 connections, got %d", conns)
+			}
+			if n := countOf(commands, "QUIT"); n != 2 {
+				t.Errorf("Expected both sessions to be ended, got %d QUIT", n)
+			}
+		})
+	}
+	{
+		tc := "Case 3: Get Waits For Session When Sender Has Max Sessions Open"
+		server := newFakeServer(t)
+		pool := smtppool.New(smtppool.WithMaxConns(1), smtppool.WithWaitTimeout(50*time.Millisecond))
+		held, err := pool.Get(server.dialer())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc, err)
+		}
+		_, errTimeout := pool.Get(server.dialer())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			held.Close()
+		}()
+		reused, errReused := pool.Get(server.dialer())
+		if errReused == nil {
+			reused.Close()
+		}
+		pool.Close()
+		conns, _ := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(errTimeout, smtppool.ErrTimeout) {
+				t.Errorf("Expected ErrTimeout, got %v", errTimeout)
+			}
+			if errReused != nil || conns != 1 {
+				t.Errorf("Expected returned session to be reused, got %v with %d connections", errReused, conns)
+			}
+		})
+	}
+	{
+		tc := "Case 4: Rejected Mail Resets Session And Keeps It Open"
+		server := newFakeServer(t)
+		pool := smtppool.New()
+		errRejected := send(pool, server.dialer(), "rejected@ex.com")
+		errSent := send(pool, server.dialer(), "example@ex.com")
+		pool.Close()
+		conns, commands := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if errRejected == nil || !strings.Contains(errRejected.Error(), "550") {
+				t.Errorf("Expected 550 error, got %v", errRejected)
+			}
+			if errSent != nil || conns != 1 || countOf(commands, "RSET") != 1 {
+				t.Errorf("Expected session to be reset and reused, got %v with %d connections", errSent, conns)
+			}
+		})
+	}
+	{
+		tc := "Case 5: Session Closed By Server Reconnected Automatically"
+		server := newFakeServer(t)
+		server.dropAfter = 1
+		pool := smtppool.New()
+		_ = send(pool, server.dialer(), "example@ex.com")
+		s, err := pool.Get(server.dialer())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc, err)
+		}
+		err = gomail.Send(s, message("example@ex.com"))
+		s.Close()
+		pool.Close()
+		conns, _ := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+			if conns != 2 {
+				t.Errorf("Expected 2 connections, got %d", conns)
+			}
+		})
+	}
+	{
+		tc := "Case 6: Sessions Of Different Senders Are Not Shared"
+		server := newFakeServer(t)
+		pool := smtppool.New()
+		d := server.dialer()
+		other := server.dialer()
+		other.Username = "other"
+		_ = send(pool, d, "example@ex.com")
+		_ = send(pool, other, "example@ex.com")
+		pool.Close()
+		conns, _ := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if conns != 2 {
+				t.Errorf("Expected 2 connections, got %d", conns)
+			}
+		})
+	}
+	{
+		tc := "Case 7: Get Fails Once Pool Is Closed"
+		server := newFakeServer(t)
+		pool := smtppool.New(smtppool.WithMaxConns(1))
+		held, _ := pool.Get(server.dialer())
+		pool.Close()
+		_, err := pool.Get(server.dialer())
+		held.Close()
+		t.Run(tc, func(t *testing.T) {
+			if !errors.Is(err, smtppool.ErrClosed) {
+				t.Errorf("Expected ErrClosed, got %v", err)
+			}
+		})
+	}
+	{
+		tc := "Case 8: Mail Sent To Accepted Recipients When Some Are Rejected"
+		server := newFakeServer(t)
+		pool := smtppool.New()
+		s, err := pool.Get(server.dialer())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc, err)
+		}
+		err = s.Send("test@test.com", []string{"example@ex.com", "rejected@ex.com"}, message("example@ex.com"))
+		s.Close()
+		pool.Close()
+		_, commands := server.stats()
+		var recipientsErr *smtppool.RecipientsError
+		t.Run(tc, func(t *testing.T) {
+			if !errors.As(err, &recipientsErr) || !recipientsErr.Sent {
+				t.Fatalf("Expected sent RecipientsError, got %v", err)
+			}
+			if _, ok := recipientsErr.Rejected["rejected@ex.com"]; !ok || len(recipientsErr.Rejected) != 1 {
+				t.Errorf("Expected rejected@ex.com to be rejected, got %v", recipientsErr.Rejected)
+			}
+			if n := countOf(commands, "DATA"); n != 1 {
+				t.Errorf("Expected mail to be sent once, got %d DATA", n)
+			}
+		})
+	}
+	{
+		tc := "Case 9: Mail Not Sent When Every Recipient Is Rejected"
+		server := newFakeServer(t)
+		pool := smtppool.New()
+		s, err := pool.Get(server.dialer())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc, err)
+		}
+		err = s.Send("test@test.com", []string{"rejected@ex.com", "rejected@ex.org"}, message("rejected@ex.com"))
+		s.Close()
+		pool.Close()
+		conns, commands := server.stats()
+		var recipientsErr *smtppool.RecipientsError
+		t.Run(tc, func(t *testing.T) {
+			if !errors.As(err, &recipientsErr) || recipientsErr.Sent || len(recipientsErr.Rejected) != 2 {
+				t.Fatalf("Expected unsent RecipientsError for 2 recipients, got %v", err)
+			}
+			if countOf(commands, "DATA") != 0 || conns != 1 {
+				t.Errorf("Expected no mail and no reconnect, got %d DATA with %d connections", countOf(commands, "DATA"), conns)
+			}
+		})
+	}
+	{
+		tc := "Case 10: Connection Lost After Data Is Not Retried"
+		server := newFakeServer(t)
+		server.dropAtDot = 2
+		pool := smtppool.New()
+		_ = send(pool, server.dialer(), "example@ex.com")
+		s, err := pool.Get(server.dialer())
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc, err)
+		}
+		err = gomail.Send(s, message("example@ex.com"))
+		s.Close()
+		pool.Close()
+		conns, commands := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+			if n := countOf(commands, "DATA"); n != 2 || conns != 1 {
+				t.Errorf("Expected mail not to be resent, got %d DATA with %d connections", n, conns)
+			}
+		})
+	}
+}
+
+func Test_pool_Get_WithLimiter(t *testing.T) {
+	rdb, mockClient := redismock.NewClientMock()
+	limiter := ratelimit.New(
+		ratelimit.WithRedisClient(rdb),
+		ratelimit.WithOwner("pod-1"),
+		ratelimit.WithHostLimit(0, 0, 1),
+	)
+	connsKeys := []string{"ratelimit:host:127.0.0.1:conns"}
+	{
+		tc := "Case 1: Session Not Opened When Every Connection Slot Of Host Is Taken"
+		server := newFakeServer(t)
+		pool := smtppool.New(smtppool.WithLimiter(limiter))
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, 1, "pod-1:1", int64(60000)).
+			SetVal(int64(0))
+		_, err := pool.Get(server.dialer())
+		pool.Close()
+		conns, _ := server.stats()
+		var connErr *smtppool.ConnLimitError
+		t.Run(tc, func(t *testing.T) {
+			if !errors.As(err, &connErr) || connErr.RetryAfter != time.Second {
+				t.Errorf("Expected ConnLimitError with 1s wait, got %v", err)
+			}
+			if conns != 0 {
+				t.Errorf("Expected no connection, got %d", conns)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+	{
+		tc := "Case 2: Connection Slot Held By Idle Session And Released When Session Ends"
+		server := newFakeServer(t)
+		pool := smtppool.New(smtppool.WithLimiter(limiter))
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, 1, "pod-1:2", int64(60000)).
+			SetVal(int64(1))
+		mockClient.CustomMatch(ignoreSha).ExpectEvalSha("sha", connsKeys, "pod-1:2", int64(60000)).
+			SetVal(int64(1))
+		err := send(pool, server.dialer(), "example@ex.com")
+		err2 := send(pool, server.dialer(), "example@ex.com")
+		idleErr := mockClient.ExpectationsWereMet()
+		mockClient.ExpectZRem(connsKeys[0], "pod-1:2").SetVal(1)
+		pool.Close()
+		conns, _ := server.stats()
+		t.Run(tc, func(t *testing.T) {
+			if err != nil || err2 != nil {
+				t.Fatalf("Expected nil, got %v and %v", err, err2)
+			}
+			if conns != 1 {
+				t.Errorf("Expected 1 connection, got %d", conns)
+			}
+			if idleErr != nil {
+				t.Errorf("Expected slot to be taken once and refreshed on reuse, got %v", idleErr)
+			}
+			if err := mockClient.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expected slot to be released, got %v", err)
+			}
+		})
+		mockClient.ClearExpect()
+	}
+}
@@ -0,0 +1,14 @@
+package traceid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// New returns a random id that follows a task through the queue and the logs
+// of the worker that sends it.
+func New() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,22 @@
+package traceid_test
+
+import (
+	"github.com/yigithankarabulut/distributed-mail-queue-service/pkg/traceid"
+	"regexp"
+	"testing"
+)
+
+func Test_New(t *testing.T) {
+	{
+		tc := "Case 1: Trace IDs are 16 hex characters and not repeated"
+		first, second := traceid.New(), traceid.New()
+		t.Run(tc, func(t *testing.T) {
+			if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(first) {
+				t.Errorf("Expected 16 hex characters, got %q", first)
+			}
+			if first == second {
+				t.Errorf("Expected different trace IDs, got %q twice", first)
+			}
+		})
+	}
+}
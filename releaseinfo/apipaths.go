@@ -4,16 +4,42 @@ const (
 	prefix        = "/api/" + Version
 	MailTaskQueue = prefix + "/task"
 	User          = prefix + "/user"
+	Template      = prefix + "/templates"
+	Campaign      = prefix + "/campaigns"
 )
 
 const (
-	RegisterUserApiPath = prefix + "/register"
-	LoginUserApiPath    = prefix + "/login"
-	GetUserApiPath      = User + "/:id"
+	RegisterUserApiPath       = prefix + "/register"
+	LoginUserApiPath          = prefix + "/login"
+	GetUserApiPath            = User + "/:id"
+	UpdateSmtpSettingsApiPath = User + "/smtp"
 )
 
 const (
 	EnqueueMailApiPath            = MailTaskQueue + "/enqueue"
 	GetAllQueuedMailTasksApiPath  = MailTaskQueue + "/queue"
 	GetAllFailedQueuedMailApiPath = MailTaskQueue + "/queue/fail"
+	GetTaskAttemptsApiPath        = MailTaskQueue + "/:id/attempts"
+	UploadAttachmentApiPath       = MailTaskQueue + "/attachments"
+)
+
+const (
+	DeadLettersApiPath       = MailTaskQueue + "/dlq"
+	DeadLetterApiPath        = DeadLettersApiPath + "/:id"
+	ReplayDeadLettersApiPath = DeadLettersApiPath + "/replay"
+	ReplayDeadLetterApiPath  = DeadLetterApiPath + "/replay"
+)
+
+const (
+	TemplatesApiPath        = Template
+	TemplateApiPath         = Template + "/:id"
+	TemplateVersionsApiPath = TemplateApiPath + "/versions"
+)
+
+const (
+	CampaignsApiPath      = Campaign
+	CampaignApiPath       = Campaign + "/:id"
+	PauseCampaignApiPath  = CampaignApiPath + "/pause"
+	ResumeCampaignApiPath = CampaignApiPath + "/resume"
+	CancelCampaignApiPath = CampaignApiPath + "/cancel"
 )